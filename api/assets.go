@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/labstack/echo/v4"
+)
+
+// issueAssetRequest is the JSON body of an asset issuance.
+type issueAssetRequest struct {
+	From       string `json:"from"`       // Address of the issuing wallet
+	PrivateKey string `json:"privateKey"` // Issuer's private key, hex encoded
+	Amount     int    `json:"amount"`     // Fixed supply of the asset, paid to the issuer
+}
+
+// handleIssueAsset issues a new asset carried by UTXOs. The issuing
+// transaction spends one of the wallet's native coin outputs, whose outpoint
+// determines the asset ID (see blockchain.IssuedAsset), returns it as
+// change and pays the whole supply to the wallet. The asset is then
+// transferred with POST /transaction and its asset query parameter.
+// Request Body:
+//   - JSON object with from, privateKey and amount (see issueAssetRequest)
+//
+// Returns:
+//   - 201 Created with the asset ID, amount, transaction ID and block hash
+//   - 400 Bad Request if the request is invalid, the key is wrong or the
+//     wallet has no native coin to spend
+//   - 404 Not Found if the issuing wallet doesn't exist
+//   - 409 Conflict if the chain rejects the transaction, e.g. because its inputs were spent concurrently
+//   - 500 Internal Server Error if the block cannot be stored
+func handleIssueAsset(c echo.Context) error {
+	var req issueAssetRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	wallet, status, err := signingWallet(req.From, req.PrivateKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	utxos := bc.GetUTXOsForAddress(wallet.PublicKey)
+	tx, err := blockchain.NewIssueAssetTransaction(wallet, req.Amount, utxos)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	newBlock, err := bc.AddBlock([]*blockchain.Transaction{tx}, validator)
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	announceTransaction(tx)
+	if err := db.SaveBlock(newBlock); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": "Error saving block to database: " + err.Error(),
+		})
+	}
+	if err := db.IndexBlockNFTs(bc, newBlock); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": "Error indexing NFTs: " + err.Error(),
+		})
+	}
+	announceBlock(newBlock)
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":    "Asset issued successfully",
+		"asset":      blockchain.IssuedAsset(tx),
+		"amount":     req.Amount,
+		"txId":       fmt.Sprintf("%x", tx.ID),
+		"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+	})
+}
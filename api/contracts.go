@@ -0,0 +1,909 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/ignaciocorball/go-blockchain/contracts"
+	"github.com/labstack/echo/v4"
+)
+
+// maxGasLimit caps the gas a single API call may request.
+const maxGasLimit = blockchain.MaxContractGasLimit
+
+// maxLogsPerRequest caps the number of logs returned by GET /logs.
+const maxLogsPerRequest = 1000
+
+// callRequest is the JSON body of a contract call.
+type callRequest struct {
+	Caller   string        `json:"caller"`   // Address of the calling account
+	Value    int64         `json:"value"`    // Amount sent with the call
+	Method   string        `json:"method"`   // Method to call
+	Args     []interface{} `json:"args"`     // Arguments: integers, strings or booleans
+	GasLimit uint64        `json:"gasLimit"` // Gas available; DefaultGasLimit if omitted
+}
+
+// callContext converts the request into a VM call context.
+func (r *callRequest) callContext() (*contracts.CallContext, error) {
+	ctx := &contracts.CallContext{
+		Caller:   r.Caller,
+		Value:    r.Value,
+		Method:   r.Method,
+		GasLimit: r.GasLimit,
+	}
+	if ctx.GasLimit == 0 {
+		ctx.GasLimit = contracts.DefaultGasLimit
+	}
+	if ctx.GasLimit > maxGasLimit {
+		return nil, fmt.Errorf("gas limit exceeds %d", maxGasLimit)
+	}
+
+	for i, raw := range r.Args {
+		arg, err := contracts.ValueFromJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i, err)
+		}
+		ctx.Args = append(ctx.Args, arg)
+	}
+	return ctx, nil
+}
+
+// decodeBody decodes a JSON request body into v, if there is one. Numbers
+// are kept as json.Number so integer arguments are not rounded through float64.
+func decodeBody(c echo.Context, v interface{}) error {
+	if c.Request().ContentLength == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(c.Request().Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// executeRequest is the JSON body of a contract call sent as a transaction.
+// The caller must be a wallet of this node, authorized by its private key.
+type executeRequest struct {
+	callRequest
+	PrivateKey string `json:"privateKey"` // Caller's private key, hex encoded
+}
+
+// deployRequest is a contract deployment. Exactly one of Code, Assembly and
+// Source is expected; they are considered in that order.
+type deployRequest struct {
+	From       string         `json:"from"`       // Address of the deploying wallet
+	PrivateKey string         `json:"privateKey"` // Deployer's private key, hex encoded
+	Code       string         `json:"code"`       // Contract bytecode or WebAssembly module, hex encoded
+	Assembly   string         `json:"assembly"`   // Contract assembly (see contracts.Assemble)
+	Source     string         `json:"source"`     // Contract language source (see contracts.Compile)
+	ABI        *contracts.ABI `json:"abi"`        // ABI of code or assembly; source is compiled with its own
+	Owner      string         `json:"owner"`      // Deploy: owning account or governance contract; the deployer if empty
+}
+
+// contract returns the contract to deploy, assembling or compiling it if
+// needed, and checks that it is valid.
+func (r *deployRequest) contract() (*contracts.SmartContract, error) {
+	var contract *contracts.SmartContract
+	switch {
+	case r.Code != "":
+		contract = contracts.NewSmartContract("", r.Code)
+		contract.ABI = r.ABI
+	case r.Assembly != "":
+		bytecode, err := contracts.Assemble(r.Assembly)
+		if err != nil {
+			return nil, err
+		}
+		contract = contracts.NewSmartContract("", hex.EncodeToString(bytecode))
+		contract.ABI = r.ABI
+	case r.Source != "":
+		var err error
+		if contract, err = contracts.NewSmartContractFromSource("", r.Source); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("contract code is required")
+	}
+	return contract, contract.Validate()
+}
+
+// checkCall checks a call against the contract's ABI, if it has one, so that
+// calls that would be rejected are not submitted.
+func checkCall(contract *contracts.SmartContract, ctx *contracts.CallContext) error {
+	if contract.ABI == nil {
+		return nil
+	}
+	return contract.ABI.CheckCall(ctx.Method, ctx.Args)
+}
+
+// contractTxMu serializes the submission of contract transactions, so that
+// each one is built with its sender's current nonce.
+var contractTxMu sync.Mutex
+
+// signingWallet loads a wallet of this node and checks that privateKeyHex is its key.
+// Returns the HTTP status to respond with if it is not.
+func signingWallet(address string, privateKeyHex string) (*blockchain.Wallet, int, error) {
+	if address == "" || privateKeyHex == "" {
+		return nil, http.StatusBadRequest, errors.New("sender address and privateKey are required")
+	}
+	wallet, err := db.GetWallet(address)
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("sender wallet not found")
+	}
+	privateKeyBytes, err := hex.DecodeString(privateKeyHex)
+	if err != nil || !bytes.Equal(privateKeyBytes, wallet.PrivateKeyBytes) {
+		return nil, http.StatusBadRequest, errors.New("invalid private key for wallet address")
+	}
+	return wallet, http.StatusOK, nil
+}
+
+// submitContractTransaction builds a contract transaction with the sender's
+// next nonce, includes it in a new block, persists and announces the block
+// and returns the block and the transaction's receipt.
+func submitContractTransaction(sender string, build func(nonce uint64) (*blockchain.Transaction, error)) (*blockchain.Block, *blockchain.Receipt, error) {
+	newBlock, receipts, err := submitContractTransactions(sender, func(nonce uint64) ([]*blockchain.Transaction, error) {
+		tx, err := build(nonce)
+		if err != nil {
+			return nil, err
+		}
+		return []*blockchain.Transaction{tx}, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return newBlock, receipts[0], nil
+}
+
+// submitErrorStatus returns the HTTP status for an error returned by
+// submitContractTransaction(s): 400 Bad Request if the sender cannot pay the
+// fee and value, 409 Conflict if the chain rejected the transactions, e.g.
+// because a concurrent request spent the same outputs, and 500 Internal
+// Server Error otherwise.
+func submitErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, blockchain.ErrInsufficientFunds):
+		return http.StatusBadRequest
+	case errors.Is(err, blockchain.ErrTransactionRejected):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// submitContractTransactions is submitContractTransaction for transactions
+// that must be included together, in order, in the same block.
+func submitContractTransactions(sender string, build func(nonce uint64) ([]*blockchain.Transaction, error)) (*blockchain.Block, []*blockchain.Receipt, error) {
+	contractTxMu.Lock()
+	defer contractTxMu.Unlock()
+
+	txs, err := build(bc.ContractNonce(sender))
+	if err != nil {
+		return nil, nil, err
+	}
+	newBlock, err := bc.AddBlock(txs, validator)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tx := range txs {
+		announceTransaction(tx)
+	}
+
+	if err := db.SaveBlock(newBlock); err != nil {
+		return nil, nil, fmt.Errorf("error saving block to database: %v", err)
+	}
+	if err := db.IndexBlockNFTs(bc, newBlock); err != nil {
+		return nil, nil, fmt.Errorf("error indexing NFTs: %v", err)
+	}
+	announceBlock(newBlock)
+
+	var receipts []*blockchain.Receipt
+	for _, tx := range txs {
+		receipt, _ := bc.Receipt(tx.ID)
+		receipts = append(receipts, receipt)
+	}
+	return newBlock, receipts, nil
+}
+
+// handleDeployContract processes smart contract deployment requests.
+// The deployment is a transaction signed by the deploying wallet and
+// included in a new block; the contract's address is derived from the
+// deployer's address and nonce (see blockchain.ContractAddress).
+// Query Parameters (or the same fields in a JSON body):
+//   - from:       Address of the deploying wallet
+//   - privateKey: The wallet's private key, hex encoded
+//   - code:       Contract bytecode or WebAssembly module, hex encoded
+//   - assembly:   Contract assembly, used instead of code (see contracts.Assemble)
+//   - source:     Contract language source, used instead of code (see contracts.Compile)
+//   - abi:        JSON body only: the ABI of code or assembly (see contracts.ABI);
+//     contracts deployed from source get the ABI of their public functions and events
+//   - owner:      Address of the account or governance contract allowed to
+//     upgrade and freeze the contract; the deploying wallet if omitted
+//
+// Returns:
+//   - 201 Created with the contract address, transaction ID and block hash
+//   - 400 Bad Request if the source does not compile, contract or ABI validation fails or the key is wrong
+//   - 404 Not Found if the deploying wallet doesn't exist
+//   - 409 Conflict if the chain rejects the transaction, e.g. because its inputs were spent concurrently
+//   - 500 Internal Server Error if the block cannot be stored
+func handleDeployContract(c echo.Context) error {
+	req := deployRequest{
+		From:       c.QueryParam("from"),
+		PrivateKey: c.QueryParam("privateKey"),
+		Code:       c.QueryParam("code"),
+		Assembly:   c.QueryParam("assembly"),
+		Source:     c.QueryParam("source"),
+		Owner:      c.QueryParam("owner"),
+	}
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	wallet, status, err := signingWallet(req.From, req.PrivateKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	contract, err := req.contract()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	newBlock, receipt, err := submitContractTransaction(wallet.Address, func(nonce uint64) (*blockchain.Transaction, error) {
+		return blockchain.NewDeployTransaction(wallet, nonce, contract.Code, contract.ABI, contract.Source, req.Owner, bc.GetUTXOsForAddress(wallet.PublicKey))
+	})
+	if err != nil {
+		return c.JSON(submitErrorStatus(err), map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]string{
+		"message":    "Contract deployed successfully",
+		"id":         receipt.Contract,
+		"code":       contract.Code,
+		"txId":       receipt.TxID,
+		"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+	})
+}
+
+// handleExecuteContract calls a deployed smart contract.
+// The call is a transaction signed by the caller and included in a new
+// block; every node executes it when applying the block. A failed call is
+// still included, but leaves the state of every contract it called
+// unchanged and does not spend the value sent with it.
+// URL Parameters:
+//   - id: The address of the contract to call
+//
+// Request Body:
+//   - JSON object with caller, privateKey, value, method, args and gasLimit
+//     (see executeRequest); value is paid from the caller's UTXOs
+//
+// Returns:
+//   - 200 OK with the return value, gas used and emitted events
+//   - 400 Bad Request if the request is invalid, does not match the contract's
+//     ABI or the caller has insufficient funds for the value
+//   - 404 Not Found if the contract or the calling wallet doesn't exist
+//   - 422 Unprocessable Entity if execution fails (out of gas, revert, ...)
+//   - 409 Conflict if the chain rejects the transaction, e.g. because its inputs were spent concurrently
+//   - 500 Internal Server Error if the block cannot be stored
+func handleExecuteContract(c echo.Context) error {
+	id := c.Param("id")
+
+	var req executeRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	ctx, err := req.callContext()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	if err := checkCall(contract, ctx); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	wallet, status, err := signingWallet(req.Caller, req.PrivateKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	newBlock, receipt, err := submitContractTransaction(wallet.Address, func(nonce uint64) (*blockchain.Transaction, error) {
+		utxos := bc.GetUTXOsForAddress(wallet.PublicKey)
+		return blockchain.NewCallTransaction(wallet, nonce, id, ctx.Method, ctx.Args, ctx.GasLimit, ctx.Value, utxos)
+	})
+	if err != nil {
+		return c.JSON(submitErrorStatus(err), map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	if !receipt.Succeeded() {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message":    receipt.Error,
+			"id":         id,
+			"gasUsed":    receipt.GasUsed,
+			"txId":       receipt.TxID,
+			"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":     "Contract executed successfully",
+		"id":          id,
+		"returnValue": receipt.ReturnValue,
+		"gasUsed":     receipt.GasUsed,
+		"logs":        receipt.Logs,
+		"txId":        receipt.TxID,
+		"block_hash":  fmt.Sprintf("%x", newBlock.Hash),
+	})
+}
+
+// upgradeRequest is a contract upgrade or freeze. Freezes carry no code.
+type upgradeRequest struct {
+	deployRequest
+	GasLimit uint64 `json:"gasLimit"` // Gas for the governance vote, if the owner is a governance contract
+}
+
+// checkUpgrade checks that sender can upgrade or freeze a contract: the
+// contract must not be frozen, and its owner must be sender or a governance
+// contract, which votes when the transaction is applied.
+// Returns the HTTP status to respond with if it cannot.
+func checkUpgrade(contract *contracts.SmartContract, sender string) (int, error) {
+	if contract.Frozen {
+		return http.StatusConflict, contracts.ErrContractFrozen
+	}
+	if contract.Owner == sender {
+		return http.StatusOK, nil
+	}
+	if _, ok := bc.GetContract(contract.Owner); !ok {
+		return http.StatusForbidden, fmt.Errorf("only the owner %s can change the contract", contract.Owner)
+	}
+	return http.StatusOK, nil
+}
+
+// handleUpgradeContract replaces the code of a deployed contract, keeping its
+// state and balance. The upgrade is a transaction signed by the contract's
+// owner, or by any account if the owner is a governance contract, which must
+// approve the new code (see blockchain.GovernanceApproveUpgrade).
+// URL Parameters:
+//   - id: The address of the contract to upgrade
+//
+// Request Body:
+//   - from, privateKey: The sending wallet and its private key
+//   - code, assembly or source, abi: The new code (see deployRequest)
+//   - gasLimit: Gas for the governance vote (default DefaultGasLimit)
+//
+// Returns:
+//   - 200 OK with the new version, transaction ID and block hash
+//   - 400 Bad Request if the request is invalid or the new code does not validate
+//   - 403 Forbidden if the sender is not the owner and the owner is not a governance contract
+//   - 404 Not Found if the contract or the sending wallet doesn't exist
+//   - 409 Conflict if the contract is frozen or the chain rejects the transaction
+//   - 422 Unprocessable Entity if the governance contract does not approve
+//   - 500 Internal Server Error if the block cannot be stored
+func handleUpgradeContract(c echo.Context) error {
+	return submitUpgrade(c, blockchain.ContractUpgrade)
+}
+
+// handleFreezeContract permanently freezes the code of a deployed contract,
+// which can still be called but no longer upgraded. Like an upgrade, the
+// freeze must be sent by the owner or approved by the governance contract
+// owning the contract (see blockchain.GovernanceApproveFreeze).
+// URL Parameters:
+//   - id: The address of the contract to freeze
+//
+// Request Body:
+//   - from, privateKey: The sending wallet and its private key
+//   - gasLimit: Gas for the governance vote (default DefaultGasLimit)
+//
+// Returns:
+//   - 200 OK with the transaction ID and block hash
+//   - 400 Bad Request if the request is invalid
+//   - 403 Forbidden if the sender is not the owner and the owner is not a governance contract
+//   - 404 Not Found if the contract or the sending wallet doesn't exist
+//   - 409 Conflict if the contract is already frozen or the chain rejects the transaction
+//   - 422 Unprocessable Entity if the governance contract does not approve
+//   - 500 Internal Server Error if the block cannot be stored
+func handleFreezeContract(c echo.Context) error {
+	return submitUpgrade(c, blockchain.ContractFreeze)
+}
+
+// submitUpgrade handles upgrade and freeze requests.
+func submitUpgrade(c echo.Context, kind blockchain.ContractTxKind) error {
+	id := c.Param("id")
+
+	var req upgradeRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if req.GasLimit > maxGasLimit {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": fmt.Sprintf("gas limit exceeds %d", maxGasLimit),
+		})
+	}
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	wallet, status, err := signingWallet(req.From, req.PrivateKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if status, err := checkUpgrade(contract, wallet.Address); err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	gasLimit := voteGasLimit(req.GasLimit, id, wallet.Address)
+
+	var upgraded *contracts.SmartContract
+	if kind == blockchain.ContractUpgrade {
+		if upgraded, err = req.contract(); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+	}
+
+	newBlock, receipt, err := submitContractTransaction(wallet.Address, func(nonce uint64) (*blockchain.Transaction, error) {
+		utxos := bc.GetUTXOsForAddress(wallet.PublicKey)
+		if kind == blockchain.ContractFreeze {
+			return blockchain.NewFreezeTransaction(wallet, nonce, id, gasLimit, utxos)
+		}
+		return blockchain.NewUpgradeTransaction(wallet, nonce, id, upgraded.Code, upgraded.ABI, gasLimit, utxos)
+	})
+	if err != nil {
+		return c.JSON(submitErrorStatus(err), map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	if !receipt.Succeeded() {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message":    receipt.Error,
+			"id":         id,
+			"gasUsed":    receipt.GasUsed,
+			"txId":       receipt.TxID,
+			"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+		})
+	}
+	response := map[string]interface{}{
+		"message":    "Contract frozen successfully",
+		"id":         id,
+		"gasUsed":    receipt.GasUsed,
+		"txId":       receipt.TxID,
+		"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+	}
+	if kind == blockchain.ContractUpgrade {
+		response["message"] = "Contract upgraded successfully"
+		if contract, ok := bc.GetContract(id); ok {
+			response["version"] = contract.Version()
+		}
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// handleGetContractVersions returns the owner of a deployed contract, whether
+// it is frozen and every code it has had.
+// URL Parameters:
+//   - id: The address of the contract
+//
+// Returns:
+//   - 200 OK with the owner, the frozen flag, the current version and the
+//     versions, oldest first
+//   - 404 Not Found if the contract doesn't exist
+func handleGetContractVersions(c echo.Context) error {
+	id := c.Param("id")
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":       id,
+		"owner":    contract.Owner,
+		"frozen":   contract.Frozen,
+		"version":  contract.Version(),
+		"versions": contract.Versions,
+	})
+}
+
+// handleCallContract calls a contract method without a transaction, e.g. to
+// read a view. The call, and any call it makes to other contracts, runs on
+// copies of the contracts' current state as if it were included in the next
+// block; nothing it writes is kept, no nonce is consumed and no signature is
+// required. The call is stopped if it runs for longer than
+// contracts.MaxCallDuration.
+// URL Parameters:
+//   - id: The address of the contract to call
+//
+// Request Body:
+//   - JSON object with caller, value, method, args and gasLimit (see
+//     callRequest); the value is credited to the contract without being
+//     paid, and the gas limit is capped like that of a transaction
+//
+// Returns:
+//   - 200 OK with the return value, gas used and the events the call would emit
+//   - 400 Bad Request if the request is invalid or does not match the contract's ABI
+//   - 404 Not Found if the contract doesn't exist
+//   - 422 Unprocessable Entity if execution fails (out of gas, revert,
+//     timeout, ...)
+func handleCallContract(c echo.Context) error {
+	id := c.Param("id")
+
+	var req callRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	ctx, err := req.callContext()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	if err := checkCall(contract, ctx); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	ctx.BlockHeight = bc.LastBlock().Height + 1
+
+	result, err := bc.CallContract(id, ctx)
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message": err.Error(),
+			"id":      id,
+			"gasUsed": result.GasUsed,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":          id,
+		"returnValue": result.ReturnValue,
+		"gasUsed":     result.GasUsed,
+		"logs":        result.Logs,
+	})
+}
+
+// simulateRequest is a transaction to dry-run. Type selects which of the
+// other fields are used; the transaction is built and signed like the one
+// the matching endpoint would submit.
+type simulateRequest struct {
+	Type string `json:"type"` // "transfer", "deploy", "call", "upgrade" or "freeze"
+	deployRequest
+	callRequest
+	To       string `json:"to"`       // Transfer: address of the receiving wallet
+	Amount   int    `json:"amount"`   // Transfer: amount to send
+	Asset    string `json:"asset"`    // Transfer: ID of the asset to send; empty for the native coin
+	Contract string `json:"contract"` // Call, upgrade, freeze: address of the contract
+}
+
+// voteGasLimit returns the gas for the governance vote of an upgrade or
+// freeze of a contract by sender: gasLimit if set, otherwise none if sender
+// owns the contract, as there is no vote to pay for, and DefaultGasLimit if
+// it does not.
+func voteGasLimit(gasLimit uint64, contract string, sender string) uint64 {
+	if gasLimit != 0 {
+		return gasLimit
+	}
+	if c, ok := bc.GetContract(contract); ok && c.Owner == sender {
+		return 0
+	}
+	return contracts.DefaultGasLimit
+}
+
+// transaction builds and signs the transaction to simulate.
+// Returns the HTTP status to respond with if it cannot be built.
+func (r *simulateRequest) transaction() (*blockchain.Transaction, int, error) {
+	wallet, status, err := signingWallet(r.From, r.PrivateKey)
+	if err != nil {
+		return nil, status, err
+	}
+
+	switch r.Type {
+	case "transfer":
+		toWallet, err := db.GetWallet(r.To)
+		if err != nil {
+			return nil, http.StatusNotFound, errors.New("recipient wallet not found")
+		}
+		utxos := bc.GetUTXOsForAddress(wallet.PublicKey)
+		tx, err := blockchain.NewAssetTransaction(wallet, string(toWallet.PublicKey), r.Asset, r.Amount, utxos)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		return tx, http.StatusOK, nil
+	case "deploy", "upgrade", "freeze":
+		nonce := bc.ContractNonce(wallet.Address)
+		utxos := bc.GetUTXOsForAddress(wallet.PublicKey)
+		contract := &contracts.SmartContract{}
+		if r.Type != "freeze" {
+			if contract, err = r.contract(); err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+		}
+		var tx *blockchain.Transaction
+		switch r.Type {
+		case "deploy":
+			tx, err = blockchain.NewDeployTransaction(wallet, nonce, contract.Code, contract.ABI, contract.Source, r.Owner, utxos)
+		case "upgrade":
+			tx, err = blockchain.NewUpgradeTransaction(wallet, nonce, r.Contract, contract.Code, contract.ABI, voteGasLimit(r.GasLimit, r.Contract, wallet.Address), utxos)
+		case "freeze":
+			tx, err = blockchain.NewFreezeTransaction(wallet, nonce, r.Contract, voteGasLimit(r.GasLimit, r.Contract, wallet.Address), utxos)
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		return tx, http.StatusOK, nil
+	case "call":
+		ctx, err := r.callContext()
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		nonce := bc.ContractNonce(wallet.Address)
+		utxos := bc.GetUTXOsForAddress(wallet.PublicKey)
+		tx, err := blockchain.NewCallTransaction(wallet, nonce, r.Contract, ctx.Method, ctx.Args, ctx.GasLimit, ctx.Value, utxos)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		return tx, http.StatusOK, nil
+	}
+	return nil, http.StatusBadRequest, fmt.Errorf("unknown transaction type %q", r.Type)
+}
+
+// handleSimulateTransaction dry-runs a transfer, deployment, contract call,
+// upgrade or freeze against the current UTXO set and contract state, as if it were included in
+// the next block. Nothing is submitted and the chain is not changed.
+// Request Body:
+//   - type:       "transfer", "deploy", "call", "upgrade" or "freeze"
+//   - from:       Address of the sending wallet
+//   - privateKey: The wallet's private key, hex encoded
+//   - to, amount, asset: Transfer: the receiving wallet, the amount and the
+//     asset, empty for the native coin
+//   - code, assembly or source, owner: Deploy: the contract to deploy (see deployRequest)
+//   - contract, value, method, args, gasLimit: Call: the call to make (see callRequest)
+//   - contract, code, assembly or source, gasLimit: Upgrade: the contract and its new code
+//   - contract, gasLimit: Freeze: the contract to freeze
+//
+// Returns:
+//   - 200 OK with the receipt the transaction would get (status, error, gas
+//     used, return value and logs) and its state diff: spent and created
+//     outputs, consumed nonces and changed contract state
+//   - 400 Bad Request if the request is invalid or the sender has insufficient funds
+//   - 404 Not Found if a wallet doesn't exist
+//   - 422 Unprocessable Entity if the transaction could not be included in a block
+func handleSimulateTransaction(c echo.Context) error {
+	var req simulateRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	tx, status, err := req.transaction()
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	simulation, err := bc.Simulate(tx)
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+			"message": err.Error(),
+			"txId":    hex.EncodeToString(tx.ID),
+		})
+	}
+	return c.JSON(http.StatusOK, simulation)
+}
+
+// handleGetContractABI returns the ABI of a deployed contract: its methods,
+// with their argument and return types, and its events.
+// URL Parameters:
+//   - id: The address of the contract
+//
+// Returns:
+//   - 200 OK with the contract address and its ABI
+//   - 404 Not Found if the contract doesn't exist or was deployed without an ABI
+func handleGetContractABI(c echo.Context) error {
+	id := c.Param("id")
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	if contract.ABI == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "contract was deployed without an ABI",
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":  id,
+		"abi": contract.ABI,
+	})
+}
+
+// handleGetContractStorage returns the value of a contract's storage entry
+// and a proof of it against the state root of the chain's tip, which a light
+// client can check with StateProof.Verify and the root in the block's header.
+// URL Parameters:
+//   - id:  The address of the contract
+//   - key: The storage key
+//
+// Returns:
+//   - 200 OK with the value, null if the entry is unset, and the proof
+//   - 404 Not Found if the contract doesn't exist
+func handleGetContractStorage(c echo.Context) error {
+	id := c.Param("id")
+	key := c.Param("key")
+
+	if _, ok := bc.GetContract(id); !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	value, proof, err := bc.StorageProof(id, key)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":    id,
+		"key":   key,
+		"value": value,
+		"proof": proof,
+	})
+}
+
+// handleGetReceipt returns the receipt of a transaction included in the chain.
+// URL Parameters:
+//   - id: The hex encoded transaction ID
+//
+// Returns:
+//   - 200 OK with the receipt: status, gas used, logs and return value
+//   - 400 Bad Request if the ID is not hex encoded
+//   - 404 Not Found if the transaction is not part of the chain
+func handleGetReceipt(c echo.Context) error {
+	id, err := hex.DecodeString(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid transaction ID format",
+		})
+	}
+
+	receipt, ok := bc.Receipt(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Transaction not found",
+		})
+	}
+	return c.JSON(http.StatusOK, receipt)
+}
+
+// handleTraceTransaction replays a contract transaction of the chain with a
+// tracer, to see why a call failed: the node rebuilds the contract state
+// the transaction executed on and records every step of its execution (see
+// contracts.TraceStep). The chain is not changed.
+// URL Parameters:
+//   - id: The hex encoded transaction ID
+//
+// Returns:
+//   - 200 OK with the receipt of the replay and its steps: contract, call
+//     depth, opcode or host call, gas left and used, stack and locals or
+//     memory size, storage reads and writes, and the error a step failed with
+//   - 400 Bad Request if the ID is not hex encoded or the transaction is a transfer
+//   - 404 Not Found if the transaction is not part of the chain
+func handleTraceTransaction(c echo.Context) error {
+	id, err := hex.DecodeString(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "Invalid transaction ID format",
+		})
+	}
+
+	receipt, tracer, err := bc.TraceTransaction(id)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, blockchain.ErrTransactionNotFound) {
+			status = http.StatusNotFound
+		}
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"txId":      receipt.TxID,
+		"receipt":   receipt,
+		"steps":     tracer.Steps,
+		"truncated": tracer.Truncated,
+	})
+}
+
+// handleGetLogs searches the logs emitted by contracts.
+// Query Parameters:
+//   - contract:  Only logs of the contract at this address
+//   - event:     Only logs of events with this name
+//   - topic:     Only logs with this topic; may be repeated to require several
+//   - fromBlock: First block height to search (default 0)
+//   - toBlock:   Last block height to search (default the tip)
+//   - limit:     Maximum number of logs to return
+//
+// Returns:
+//   - 200 OK with the matching logs in chain order
+//   - 400 Bad Request if a block height or the limit is invalid
+func handleGetLogs(c echo.Context) error {
+	from, limit, err := parseRange(c.QueryParam("fromBlock"), c.QueryParam("limit"), maxLogsPerRequest)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	to := -1
+	if toStr := c.QueryParam("toBlock"); toStr != "" {
+		to, err = strconv.Atoi(toStr)
+		if err != nil || to < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "invalid toBlock parameter",
+			})
+		}
+	}
+
+	filter := blockchain.LogFilter{
+		Contract:  c.QueryParam("contract"),
+		Topics:    c.QueryParams()["topic"],
+		FromBlock: from,
+		ToBlock:   to,
+	}
+	if event := c.QueryParam("event"); event != "" {
+		filter.Topics = append(filter.Topics, contracts.EventTopic(event))
+	}
+
+	logs := bc.Logs(filter, limit)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"logs":  logs,
+		"count": len(logs),
+	})
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/ignaciocorball/go-blockchain/light"
+	"github.com/labstack/echo/v4"
+)
+
+// maxHeadersPerRequest caps the number of headers returned by GET /headers.
+const maxHeadersPerRequest = 1000
+
+// handleGetHeaders returns block headers for light clients.
+// Query Parameters:
+//   - from:  Height of the first header (default 0)
+//   - limit: Maximum number of headers (default and maximum 1000)
+//
+// Returns:
+//   - 200 OK with the list of headers
+//   - 400 Bad Request if the parameters are invalid
+func handleGetHeaders(c echo.Context) error {
+	from, limit, err := parseRange(c.QueryParam("from"), c.QueryParam("limit"), maxHeadersPerRequest)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"headers": bc.Headers(from, limit),
+	})
+}
+
+// handleGetTransactionProof returns the Merkle inclusion proof of a transaction.
+// URL Parameters:
+//   - id: The transaction ID (hex encoded)
+//
+// Returns:
+//   - 200 OK with the proof
+//   - 400 Bad Request if the ID is not valid hex
+//   - 404 Not Found if the transaction is not part of the chain
+func handleGetTransactionProof(c echo.Context) error {
+	id, err := hex.DecodeString(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid transaction ID format",
+		})
+	}
+
+	block, index, err := bc.FindTransaction(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Transaction not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, blockchain.NewMerkleProof(block, index))
+}
+
+// parseRange parses optional "from" and "limit" query parameters.
+func parseRange(fromStr, limitStr string, maxLimit int) (int, int, error) {
+	from, limit := 0, maxLimit
+
+	if fromStr != "" {
+		v, err := strconv.Atoi(fromStr)
+		if err != nil || v < 0 {
+			return 0, 0, errors.New("invalid from parameter")
+		}
+		from = v
+	}
+	if limitStr != "" {
+		v, err := strconv.Atoi(limitStr)
+		if err != nil || v <= 0 {
+			return 0, 0, errors.New("invalid limit parameter")
+		}
+		if v < limit {
+			limit = v
+		}
+	}
+	return from, limit, nil
+}
+
+// lightClient is the light client served by a light node's API.
+var lightClient *light.Client
+
+// StartLightServer starts the HTTP API of a light node.
+// Parameters:
+//   - addr: The address to listen on
+//   - client: The light client whose verified headers are served
+//
+// The server provides the following endpoints:
+//   - GET /headers/tip  - Retrieve the highest verified header
+//   - GET /verify/:id   - Verify that a transaction is included in the chain
+//   - GET /scan         - Find transactions touching public keys using block filters
+func StartLightServer(addr string, client *light.Client) {
+	lightClient = client
+
+	e := echo.New()
+
+	e.GET("/headers/tip", handleGetLightTip)
+	e.GET("/verify/:id", handleVerifyTransaction)
+	e.GET("/scan", handleScan)
+
+	e.Logger.Fatal(e.Start(addr))
+}
+
+// handleGetLightTip returns the highest header verified by the light client.
+func handleGetLightTip(c echo.Context) error {
+	return c.JSON(http.StatusOK, lightClient.Tip())
+}
+
+// handleVerifyTransaction checks a transaction's inclusion with a Merkle proof.
+// URL Parameters:
+//   - id: The transaction ID (hex encoded)
+//
+// Returns:
+//   - 200 OK with the block including the transaction
+//   - 400 Bad Request if the ID is not valid hex
+//   - 404 Not Found if inclusion cannot be proven against the synced headers
+func handleVerifyTransaction(c echo.Context) error {
+	id, err := hex.DecodeString(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid transaction ID format",
+		})
+	}
+
+	proof, err := lightClient.VerifyTransaction(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"verified": false,
+			"error":    err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"verified":   true,
+		"block_hash": hex.EncodeToString(proof.BlockHash),
+		"height":     proof.Height,
+	})
+}
+
+// handleScan finds the transactions touching the given public keys using
+// compact block filters, so the keys are never sent to the full node.
+// Query Parameters:
+//   - keys: Comma separated hex public keys
+//   - from: Height to start scanning at (default 0)
+//
+// Returns:
+//   - 200 OK with the matching transactions
+//   - 400 Bad Request if the parameters are invalid
+//   - 502 Bad Gateway if the full node served data that failed verification
+func handleScan(c echo.Context) error {
+	var keys [][]byte
+	for _, k := range strings.Split(c.QueryParam("keys"), ",") {
+		if k == "" {
+			continue
+		}
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid public key format",
+			})
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Missing required parameter: keys",
+		})
+	}
+
+	from, _, err := parseRange(c.QueryParam("from"), "", 1)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	matches, err := lightClient.Scan(keys, from)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"matches": matches,
+		"count":   len(matches),
+	})
+}
+
+// handleGetFilters returns compact block filters for light clients.
+// Query Parameters:
+//   - from:  Height of the first filter (default 0)
+//   - limit: Maximum number of filters (default and maximum 1000)
+//
+// Returns:
+//   - 200 OK with the list of filters
+//   - 400 Bad Request if the parameters are invalid
+func handleGetFilters(c echo.Context) error {
+	from, limit, err := parseRange(c.QueryParam("from"), c.QueryParam("limit"), maxHeadersPerRequest)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"filters": bc.Filters(from, limit),
+	})
+}
+
+// handleGetFilterHeaders returns the filter header chain.
+// Query Parameters:
+//   - from:  Height of the first filter header (default 0)
+//   - limit: Maximum number of filter headers (default and maximum 1000)
+//
+// Returns:
+//   - 200 OK with the list of filter headers
+//   - 400 Bad Request if the parameters are invalid
+func handleGetFilterHeaders(c echo.Context) error {
+	from, limit, err := parseRange(c.QueryParam("from"), c.QueryParam("limit"), maxHeadersPerRequest)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"headers": bc.FilterHeaders(from, limit),
+	})
+}
+
+// filteredBlocksRequest is the body of POST /blocks/filtered.
+type filteredBlocksRequest struct {
+	From   int                     `json:"from"`
+	Limit  int                     `json:"limit"`
+	Filter *blockchain.BloomFilter `json:"filter"`
+}
+
+// handleGetFilteredBlocks returns block headers with only the transactions
+// matching a client-submitted Bloom filter, each with a Merkle proof.
+// Request Body:
+//   - from:   Height of the first block
+//   - limit:  Maximum number of blocks (maximum 1000)
+//   - filter: The Bloom filter (Bits base64 encoded, HashFuncs, Tweak)
+//
+// Returns:
+//   - 200 OK with the filtered blocks
+//   - 400 Bad Request if the request or the filter is invalid
+func handleGetFilteredBlocks(c echo.Context) error {
+	var req filteredBlocksRequest
+	if err := c.Bind(&req); err != nil || req.Filter == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body: from, limit and filter are required",
+		})
+	}
+	if err := req.Filter.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if req.From < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid from parameter",
+		})
+	}
+	if req.Limit <= 0 || req.Limit > maxHeadersPerRequest {
+		req.Limit = maxHeadersPerRequest
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"blocks": bc.FilteredBlocks(req.From, req.Limit, req.Filter),
+	})
+}
+
+// handleGetBlockByHeight retrieves a block by its height.
+// URL Parameters:
+//   - height: The height of the block
+//
+// Returns:
+//   - 200 OK with block data if found
+//   - 400 Bad Request if the height is invalid
+//   - 404 Not Found if there is no block at that height
+func handleGetBlockByHeight(c echo.Context) error {
+	height, err := strconv.Atoi(c.Param("height"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid height format",
+		})
+	}
+
+	block, err := bc.BlockAt(height)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "Block not found",
+		})
+	}
+	return c.JSON(http.StatusOK, block)
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/ignaciocorball/go-blockchain/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// createCollectionRequest is the JSON body of an NFT collection creation.
+type createCollectionRequest struct {
+	From       string `json:"from"`       // Address of the issuing wallet
+	PrivateKey string `json:"privateKey"` // Issuer's private key, hex encoded
+	Name       string `json:"name"`       // Collection name
+	Symbol     string `json:"symbol"`     // Ticker symbol
+}
+
+// handleCreateCollection creates an NFT collection: it deploys the reference
+// collection (see contracts.NFTCollectionSource) and initializes it in the
+// same block, making the issuing wallet the collection's issuer. Minting,
+// transfers and burns are calls to the collection contract (POST
+// /contract/:id/execute).
+// Request Body:
+//   - JSON object with from, privateKey, name and symbol (see createCollectionRequest)
+//
+// Returns:
+//   - 201 Created with the collection, transaction IDs and block hash
+//   - 400 Bad Request if the request is invalid or the key is wrong
+//   - 404 Not Found if the issuing wallet doesn't exist
+//   - 422 Unprocessable Entity if the collection could not be initialized
+//   - 409 Conflict if the chain rejects the transaction, e.g. because its inputs were spent concurrently
+//   - 500 Internal Server Error if the block cannot be stored
+func handleCreateCollection(c echo.Context) error {
+	var req createCollectionRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if req.Symbol == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "symbol is required",
+		})
+	}
+	wallet, status, err := signingWallet(req.From, req.PrivateKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	newBlock, receipts, err := submitContractTransactions(wallet.Address, func(nonce uint64) ([]*blockchain.Transaction, error) {
+		return blockchain.NewCreateCollectionTransactions(wallet, nonce, req.Name, req.Symbol, bc.GetUTXOsForAddress(wallet.PublicKey))
+	})
+	if err != nil {
+		return c.JSON(submitErrorStatus(err), map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	deploy, initialize := receipts[0], receipts[1]
+	for _, receipt := range receipts {
+		if !receipt.Succeeded() {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"message":    receipt.Error,
+				"id":         deploy.Contract,
+				"txId":       receipt.TxID,
+				"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+			})
+		}
+	}
+	collection, err := bc.NFTCollection(deploy.Contract)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":    "Collection created successfully",
+		"id":         deploy.Contract,
+		"collection": collection,
+		"txIds":      []string{deploy.TxID, initialize.TxID},
+		"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+	})
+}
+
+// handleGetCollections lists the contracts implementing the NFT collection
+// standard (see contracts.IsNFTCollection).
+//
+// Returns:
+//   - 200 OK with the collections, sorted by address
+func handleGetCollections(c echo.Context) error {
+	collections := bc.NFTCollections()
+	if collections == nil {
+		collections = []*blockchain.NFTCollection{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"collections": collections,
+	})
+}
+
+// handleGetCollection describes an NFT collection.
+// URL Parameters:
+//   - collection: The address of the collection contract
+//
+// Returns:
+//   - 200 OK with the collection's name, symbol, issuer and total supply
+//   - 404 Not Found if there is no contract at the address or it is not an NFT collection
+func handleGetCollection(c echo.Context) error {
+	collection, err := bc.NFTCollection(c.Param("collection"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, collection)
+}
+
+// handleGetNFT returns a token of an NFT collection from the node's indexes.
+// URL Parameters:
+//   - collection: The address of the collection contract
+//   - tokenId:    The token ID
+//
+// Returns:
+//   - 200 OK with the token's owner, metadata URI, content hash and mint height
+//   - 400 Bad Request if the token ID is not an integer
+//   - 404 Not Found if the token was never minted
+func handleGetNFT(c echo.Context) error {
+	tokenID, err := strconv.ParseInt(c.Param("tokenId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "Invalid token ID",
+		})
+	}
+
+	nft, err := db.GetNFT(c.Param("collection"), tokenID)
+	if err != nil {
+		return nftError(c, err)
+	}
+	return c.JSON(http.StatusOK, nft)
+}
+
+// handleGetNFTHistory returns the transfers of a token, oldest first, from
+// its mint to its burn.
+// URL Parameters:
+//   - collection: The address of the collection contract
+//   - tokenId:    The token ID
+//
+// Returns:
+//   - 200 OK with the transfers: sender (empty for the mint), recipient
+//     (empty for a burn), transaction ID and block height
+//   - 400 Bad Request if the token ID is not an integer
+//   - 404 Not Found if the token was never minted
+func handleGetNFTHistory(c echo.Context) error {
+	collection := c.Param("collection")
+	tokenID, err := strconv.ParseInt(c.Param("tokenId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "Invalid token ID",
+		})
+	}
+
+	transfers, err := db.GetNFTHistory(collection, tokenID)
+	if err != nil {
+		return nftError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"collection": collection,
+		"tokenId":    tokenID,
+		"transfers":  transfers,
+	})
+}
+
+// handleGetOwnedNFTs returns the tokens an address owns in every NFT collection.
+// URL Parameters:
+//   - address: The owner's address
+//
+// Returns:
+//   - 200 OK with the tokens, sorted by collection and token ID
+//   - 500 Internal Server Error if the indexes cannot be read
+func handleGetOwnedNFTs(c echo.Context) error {
+	address := c.Param("address")
+
+	nfts, err := db.GetNFTsByOwner(address)
+	if err != nil {
+		return nftError(c, err)
+	}
+	if nfts == nil {
+		nfts = []*storage.NFT{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"address": address,
+		"nfts":    nfts,
+	})
+}
+
+// nftError responds with the status matching an NFT index error.
+func nftError(c echo.Context, err error) error {
+	if errors.Is(err, storage.ErrNFTNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"message": err.Error(),
+	})
+}
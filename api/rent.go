@@ -0,0 +1,193 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/ignaciocorball/go-blockchain/contracts"
+	"github.com/labstack/echo/v4"
+)
+
+// restoreRequest is the JSON body of the restoration of a contract's
+// archived state.
+type restoreRequest struct {
+	From       string                  `json:"from"`       // Address of the restoring wallet
+	PrivateKey string                  `json:"privateKey"` // Restorer's private key, hex encoded
+	Value      int64                   `json:"value"`      // Amount sent to the contract for its rent, paid from the restorer's UTXOs
+	State      []blockchain.StateEntry `json:"state"`      // Archived entries; recovered by the node if omitted
+}
+
+// handleGetContractRent returns the storage rent a contract pays: the size of
+// its state, the rent due at each collection and when the next one is.
+// URL Parameters:
+//   - id: The address of the contract
+//
+// Returns:
+//   - 200 OK with the state size, rent, balance, the number of collections
+//     the balance covers, the next collection height and, if the state is
+//     archived, its archive
+//   - 404 Not Found if the contract doesn't exist
+func handleGetContractRent(c echo.Context) error {
+	id := c.Param("id")
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+
+	rent := blockchain.Rent(contract)
+	response := map[string]interface{}{
+		"id":             id,
+		"size":           blockchain.StateSize(contract),
+		"rent":           rent,
+		"rentPerByte":    blockchain.RentPerByte,
+		"period":         blockchain.RentPeriod,
+		"nextCollection": blockchain.NextRentCollection(bc.LastBlock().Height),
+		"balance":        contract.Balance,
+		"archive":        contract.Archive,
+	}
+	if rent > 0 {
+		response["collectionsCovered"] = contract.Balance / rent
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// handleGetArchivedState returns the entries of a contract's archived state,
+// recovered by replaying the chain, to restore it with.
+// URL Parameters:
+//   - id: The address of the contract
+//
+// Returns:
+//   - 200 OK with the archive and the entries, sorted by key, with values
+//     encoded like those of storage proofs
+//   - 404 Not Found if the contract doesn't exist
+//   - 409 Conflict if the contract's state is not archived
+//   - 500 Internal Server Error if the state cannot be recovered
+func handleGetArchivedState(c echo.Context) error {
+	id := c.Param("id")
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	entries, err := bc.ArchivedState(id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, blockchain.ErrNotArchived) {
+			status = http.StatusConflict
+		}
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":      id,
+		"archive": contract.Archive,
+		"state":   entries,
+	})
+}
+
+// handleRestoreContract restores the archived state of a contract, which
+// can then be called again. The restoration is a transaction anyone can
+// sign, carrying every archived entry, which must match the contract's
+// commitment, and value to pay the contract's rent: the contract must hold
+// at least one collection's rent once restored.
+// URL Parameters:
+//   - id: The address of the contract
+//
+// Request Body:
+//   - JSON object with from, privateKey, value and optionally state (see
+//     restoreRequest)
+//
+// Returns:
+//   - 200 OK with the restored state size, the contract's balance, the
+//     transaction ID and block hash
+//   - 400 Bad Request if the request is invalid or the restorer has
+//     insufficient funds for the value
+//   - 404 Not Found if the contract or the restoring wallet doesn't exist
+//   - 409 Conflict if the contract's state is not archived or the chain rejects the transaction
+//   - 422 Unprocessable Entity if the state does not match the archive or the
+//     balance does not cover the rent
+//   - 500 Internal Server Error if the state cannot be recovered or the
+//     block cannot be stored
+func handleRestoreContract(c echo.Context) error {
+	id := c.Param("id")
+
+	var req restoreRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if req.Value < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "value cannot be negative",
+		})
+	}
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	if contract.Archive == nil {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"message": blockchain.ErrNotArchived.Error(),
+		})
+	}
+	wallet, status, err := signingWallet(req.From, req.PrivateKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if balance := int64(bc.GetBalance(wallet.PublicKey)); balance < req.Value {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": fmt.Sprintf("insufficient funds: have %d, need %d", balance, req.Value),
+		})
+	}
+	if req.State == nil {
+		if req.State, err = bc.ArchivedState(id); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": err.Error(),
+			})
+		}
+	}
+
+	newBlock, receipt, err := submitContractTransaction(wallet.Address, func(nonce uint64) (*blockchain.Transaction, error) {
+		utxos := bc.GetUTXOsForAddress(wallet.PublicKey)
+		return blockchain.NewRestoreTransaction(wallet, nonce, id, req.State, req.Value, utxos)
+	})
+	if err != nil {
+		return c.JSON(submitErrorStatus(err), map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	if !receipt.Succeeded() {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message":    receipt.Error,
+			"id":         id,
+			"txId":       receipt.TxID,
+			"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+		})
+	}
+	response := map[string]interface{}{
+		"message":    "Contract state restored successfully",
+		"id":         id,
+		"txId":       receipt.TxID,
+		"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+	}
+	if contract, ok := bc.GetContract(id); ok {
+		response["size"] = blockchain.StateSize(contract)
+		response["balance"] = contract.Balance
+	}
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/ignaciocorball/go-blockchain/contracts"
+	"github.com/labstack/echo/v4"
+)
+
+// scheduleRequest is the JSON body of a schedule registration.
+type scheduleRequest struct {
+	From       string        `json:"from"`       // Address of the contract's owner
+	PrivateKey string        `json:"privateKey"` // Owner's private key, hex encoded
+	Method     string        `json:"method"`     // Method to call
+	Args       []interface{} `json:"args"`       // Arguments: integers, strings or booleans
+	GasLimit   uint64        `json:"gasLimit"`   // Gas of each run; DefaultGasLimit if omitted
+	Height     int           `json:"height"`     // Height of the first run; the block after the one including the schedule if omitted
+	Interval   int           `json:"interval"`   // Blocks between runs; 0 for a single run
+	Runs       int           `json:"runs"`       // Number of runs; 1 if omitted
+}
+
+// validate checks the runs of the request, filling in the defaults, so
+// that an invalid schedule transaction is not built.
+func (r *scheduleRequest) validate() error {
+	if r.Runs == 0 {
+		r.Runs = 1
+	}
+	// The schedule is included in the next block and runs after it
+	including := bc.LastBlock().Height + 1
+	if r.Height == 0 {
+		r.Height = including + 1
+	}
+	switch {
+	case r.Height <= including:
+		return fmt.Errorf("height must be above %d, the height of the block including the schedule", including)
+	case r.Interval < 0:
+		return errors.New("interval cannot be negative")
+	case r.Runs < 0 || r.Runs > blockchain.MaxScheduleRuns:
+		return fmt.Errorf("runs must be between 1 and %d", blockchain.MaxScheduleRuns)
+	case r.Interval == 0 && r.Runs != 1:
+		return errors.New("schedules without an interval run once")
+	}
+	return nil
+}
+
+// cancelScheduleRequest is the JSON body of a schedule cancellation.
+type cancelScheduleRequest struct {
+	From       string `json:"from"`       // Address of the contract's owner
+	PrivateKey string `json:"privateKey"` // Owner's private key, hex encoded
+}
+
+// handleScheduleContract schedules calls of a contract method that the chain
+// makes by itself at the start of future blocks (see blockchain.Schedule):
+// once, or every interval blocks for a number of runs. The schedule is a
+// transaction signed by the contract's owner; the contract prepays the gas
+// of every run from its balance and gets back what the runs do not use.
+// URL Parameters:
+//   - id: The address of the contract to call
+//
+// Request Body:
+//   - JSON object with from, privateKey, method, args, gasLimit, height,
+//     interval and runs (see scheduleRequest)
+//
+// Returns:
+//   - 201 Created with the schedule, its deposit, transaction ID and block hash
+//   - 400 Bad Request if the request is invalid or does not match the contract's ABI
+//   - 403 Forbidden if the sender is not the contract's owner
+//   - 404 Not Found if the contract or the sending wallet doesn't exist
+//   - 422 Unprocessable Entity if the contract cannot pay for the gas
+//   - 409 Conflict if the chain rejects the transaction, e.g. because its inputs were spent concurrently
+//   - 500 Internal Server Error if the block cannot be stored
+func handleScheduleContract(c echo.Context) error {
+	id := c.Param("id")
+
+	var req scheduleRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	call := callRequest{Caller: id, Method: req.Method, Args: req.Args, GasLimit: req.GasLimit}
+	ctx, err := call.callContext()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	if err := checkCall(contract, ctx); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	wallet, status, err := signingWallet(req.From, req.PrivateKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if contract.Owner != wallet.Address {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"message": fmt.Sprintf("only the owner %s can schedule calls of the contract", contract.Owner),
+		})
+	}
+
+	newBlock, receipt, err := submitContractTransaction(wallet.Address, func(nonce uint64) (*blockchain.Transaction, error) {
+		return blockchain.NewScheduleTransaction(wallet, nonce, id, ctx.Method, ctx.Args, ctx.GasLimit, req.Height, req.Interval, req.Runs, bc.GetUTXOsForAddress(wallet.PublicKey))
+	})
+	if err != nil {
+		return c.JSON(submitErrorStatus(err), map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	if !receipt.Succeeded() {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message":    receipt.Error,
+			"id":         id,
+			"txId":       receipt.TxID,
+			"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+		})
+	}
+	schedule, _ := bc.GetSchedule(receipt.TxID)
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":    "Contract call scheduled successfully",
+		"id":         id,
+		"schedule":   schedule,
+		"deposit":    schedule.Deposit(),
+		"txId":       receipt.TxID,
+		"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+	})
+}
+
+// handleCancelSchedule cancels a pending schedule of a contract, refunding
+// the gas prepaid for its runs left to the contract. The cancellation is a
+// transaction signed by the contract's owner.
+// URL Parameters:
+//   - id: The address of the contract
+//   - scheduleId: The ID of the schedule
+//
+// Request Body:
+//   - JSON object with from and privateKey (see cancelScheduleRequest)
+//
+// Returns:
+//   - 200 OK with the contract's balance after the refund, the transaction ID
+//     and block hash
+//   - 400 Bad Request if the request is invalid
+//   - 403 Forbidden if the sender is not the contract's owner
+//   - 404 Not Found if the contract, the schedule or the sending wallet doesn't exist
+//   - 422 Unprocessable Entity if the schedule's last run executed first
+//   - 409 Conflict if the chain rejects the transaction, e.g. because its inputs were spent concurrently
+//   - 500 Internal Server Error if the block cannot be stored
+func handleCancelSchedule(c echo.Context) error {
+	id := c.Param("id")
+	scheduleID := c.Param("scheduleId")
+
+	var req cancelScheduleRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	if schedule, ok := bc.GetSchedule(scheduleID); !ok || schedule.Contract != id {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": blockchain.ErrScheduleNotFound.Error(),
+		})
+	}
+	wallet, status, err := signingWallet(req.From, req.PrivateKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if contract.Owner != wallet.Address {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"message": fmt.Sprintf("only the owner %s can cancel schedules of the contract", contract.Owner),
+		})
+	}
+
+	newBlock, receipt, err := submitContractTransaction(wallet.Address, func(nonce uint64) (*blockchain.Transaction, error) {
+		return blockchain.NewCancelScheduleTransaction(wallet, nonce, id, scheduleID, bc.GetUTXOsForAddress(wallet.PublicKey))
+	})
+	if err != nil {
+		return c.JSON(submitErrorStatus(err), map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	if !receipt.Succeeded() {
+		// The schedule ran its last run in the block, before the cancellation
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message":    receipt.Error,
+			"id":         id,
+			"txId":       receipt.TxID,
+			"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+		})
+	}
+	response := map[string]interface{}{
+		"message":    "Schedule cancelled successfully",
+		"id":         id,
+		"schedule":   scheduleID,
+		"txId":       receipt.TxID,
+		"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+	}
+	if contract, ok := bc.GetContract(id); ok {
+		response["balance"] = contract.Balance
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// handleGetSchedules returns the pending schedules of a contract.
+// URL Parameters:
+//   - id: The address of the contract
+//
+// Returns:
+//   - 200 OK with the contract's balance and its schedules, in the order
+//     their next runs execute, with the gas prepaid for each
+//   - 404 Not Found if the contract doesn't exist
+func handleGetSchedules(c echo.Context) error {
+	id := c.Param("id")
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+
+	type scheduleResponse struct {
+		*blockchain.Schedule
+		Deposit int64 `json:"deposit"`
+	}
+	schedules := []scheduleResponse{}
+	for _, schedule := range bc.Schedules(id) {
+		schedules = append(schedules, scheduleResponse{Schedule: schedule, Deposit: schedule.Deposit()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":        id,
+		"balance":   contract.Balance,
+		"schedules": schedules,
+		"count":     len(schedules),
+		"gasPrice":  blockchain.ScheduledGasPrice,
+	})
+}
@@ -12,7 +12,7 @@ import (
 	"strconv"
 
 	"github.com/ignaciocorball/go-blockchain/blockchain"
-	"github.com/ignaciocorball/go-blockchain/contracts"
+	"github.com/ignaciocorball/go-blockchain/p2p"
 	"github.com/ignaciocorball/go-blockchain/storage"
 	"github.com/labstack/echo/v4"
 )
@@ -27,24 +27,66 @@ import (
 // These are initialized when the server starts and used across all handlers
 var bc *blockchain.Blockchain
 var db *storage.BlockchainDB
+var node *p2p.Node
+var validator *blockchain.Wallet
 
 // StartServer initializes and starts the HTTP server for the blockchain API.
 // Parameters:
+//   - addr: The address to listen on (e.g. ":1323")
 //   - bcInstance: The blockchain instance to use for operations
 //   - dbInstance: The database instance for persistent storage
+//   - nodeInstance: The p2p node used to announce new blocks (may be nil)
+//   - validatorWallet: The wallet used to sign the blocks created by this node
 //
 // The server provides the following endpoints:
-//   - POST /transaction    - Create new transactions
+//   - POST /transaction    - Create new transactions of the native coin or an issued asset
 //   - GET  /block/:hash   - Retrieve block information
 //   - GET  /blocks         - Retrieve all blocks
 //   - POST /contract      - Deploy new smart contracts
 //   - POST /contract/:id/execute - Execute deployed contracts
+//   - POST /contract/:id/call    - Call a contract read-only, without a transaction
+//   - POST /contract/:id/upgrade - Replace a contract's code, keeping its state
+//   - POST /contract/:id/freeze  - Permanently freeze a contract's code
+//   - GET  /contract/:id/versions - Retrieve a contract's owner and code history
+//   - GET  /contract/:id/abi     - Retrieve a contract's methods and events
+//   - GET  /contract/:id/storage/:key - Retrieve a contract storage entry with its state proof
+//   - POST /contract/:id/schedule - Schedule calls of a contract method at future block heights
+//   - POST /contract/:id/schedule/:scheduleId/cancel - Cancel a schedule, refunding its prepaid gas
+//   - GET  /contract/:id/schedules - Retrieve a contract's pending schedules
+//   - GET  /contract/:id/rent    - Retrieve a contract's state size and storage rent
+//   - GET  /contract/:id/archive - Retrieve the archived state of a contract
+//   - POST /contract/:id/restore - Restore the archived state of a contract
+//   - POST /contract/:id/verify  - Verify a contract's source by recompiling it
+//   - GET  /contract/:id         - Retrieve a contract with its verified source, ABI and metadata
+//   - POST /tokens         - Create a fungible token
+//   - GET  /tokens         - List fungible tokens
+//   - GET  /tokens/:id     - Retrieve a fungible token
+//   - POST /nfts           - Create an NFT collection
+//   - GET  /nfts           - List NFT collections
+//   - GET  /nfts/:collection - Retrieve an NFT collection
+//   - GET  /nfts/:collection/:tokenId - Retrieve an NFT
+//   - GET  /nfts/:collection/:tokenId/history - Retrieve the transfers of an NFT
+//   - POST /assets         - Issue a new asset carried by UTXOs
+//   - POST /tx/simulate  - Dry-run a transaction and report its receipt and state diff
 //   - POST /wallet         - Create a new wallet
-//   - GET  /wallet/:address/balance - Get wallet balance
+//   - GET  /wallet/:address/balance - Get wallet balance, including issued assets
 //   - POST /wallet/:address/mint    - Mint new tokens to a wallet
-func StartServer(bcInstance *blockchain.Blockchain, dbInstance *storage.BlockchainDB) {
+//   - GET  /wallet/:address/tokens  - Get an address's fungible token balances
+//   - GET  /wallet/:address/nfts    - Get the NFTs an address owns
+//   - GET  /headers        - Retrieve block headers (for light clients)
+//   - GET  /tx/:id/proof   - Retrieve a Merkle inclusion proof for a transaction
+//   - GET  /blocks/:height - Retrieve a block by height
+//   - GET  /filters        - Retrieve compact block filters
+//   - GET  /filters/headers - Retrieve the block filter header chain
+//   - POST /blocks/filtered - Retrieve blocks filtered by a client Bloom filter
+//   - GET  /tx/:id/receipt - Retrieve the receipt of a transaction
+//   - GET  /logs           - Search contract logs by contract, topic and block range
+//   - POST /debug/trace/tx/:id - Replay a contract transaction with an execution tracer
+func StartServer(addr string, bcInstance *blockchain.Blockchain, dbInstance *storage.BlockchainDB, nodeInstance *p2p.Node, validatorWallet *blockchain.Wallet) {
 	bc = bcInstance
 	db = dbInstance
+	node = nodeInstance
+	validator = validatorWallet
 
 	e := echo.New()
 
@@ -53,11 +95,46 @@ func StartServer(bcInstance *blockchain.Blockchain, dbInstance *storage.Blockcha
 	e.GET("/blocks", handleGetAllBlocks)
 	e.POST("/contract", handleDeployContract)
 	e.POST("/contract/:id/execute", handleExecuteContract)
+	e.POST("/contract/:id/call", handleCallContract)
+	e.POST("/contract/:id/upgrade", handleUpgradeContract)
+	e.POST("/contract/:id/freeze", handleFreezeContract)
+	e.GET("/contract/:id/versions", handleGetContractVersions)
+	e.GET("/contract/:id/abi", handleGetContractABI)
+	e.GET("/contract/:id/storage/:key", handleGetContractStorage)
+	e.POST("/contract/:id/schedule", handleScheduleContract)
+	e.POST("/contract/:id/schedule/:scheduleId/cancel", handleCancelSchedule)
+	e.GET("/contract/:id/schedules", handleGetSchedules)
+	e.GET("/contract/:id/rent", handleGetContractRent)
+	e.GET("/contract/:id/archive", handleGetArchivedState)
+	e.POST("/contract/:id/restore", handleRestoreContract)
+	e.POST("/contract/:id/verify", handleVerifyContract)
+	e.GET("/contract/:id", handleGetContract)
+	e.POST("/tokens", handleCreateToken)
+	e.GET("/tokens", handleGetTokens)
+	e.GET("/tokens/:id", handleGetToken)
+	e.POST("/nfts", handleCreateCollection)
+	e.GET("/nfts", handleGetCollections)
+	e.GET("/nfts/:collection", handleGetCollection)
+	e.GET("/nfts/:collection/:tokenId", handleGetNFT)
+	e.GET("/nfts/:collection/:tokenId/history", handleGetNFTHistory)
+	e.POST("/assets", handleIssueAsset)
+	e.POST("/tx/simulate", handleSimulateTransaction)
 	e.POST("/wallet", handleCreateWallet)
 	e.GET("/wallet/:address/balance", handleGetWalletBalance)
 	e.POST("/wallet/:address/mint", handleMintTokens)
-
-	e.Logger.Fatal(e.Start(":1323"))
+	e.GET("/wallet/:address/tokens", handleGetTokenBalances)
+	e.GET("/wallet/:address/nfts", handleGetOwnedNFTs)
+	e.GET("/headers", handleGetHeaders)
+	e.GET("/tx/:id/proof", handleGetTransactionProof)
+	e.GET("/blocks/:height", handleGetBlockByHeight)
+	e.GET("/filters", handleGetFilters)
+	e.GET("/filters/headers", handleGetFilterHeaders)
+	e.POST("/blocks/filtered", handleGetFilteredBlocks)
+	e.GET("/tx/:id/receipt", handleGetReceipt)
+	e.GET("/logs", handleGetLogs)
+	e.POST("/debug/trace/tx/:id", handleTraceTransaction)
+
+	e.Logger.Fatal(e.Start(addr))
 }
 
 // handleTransaction processes incoming transaction requests and creates a new block.
@@ -66,17 +143,20 @@ func StartServer(bcInstance *blockchain.Blockchain, dbInstance *storage.Blockcha
 //   - to:     Recipient's address
 //   - amount: Transaction amount
 //   - privateKey: Sender's private key (hex encoded)
+//   - asset:  ID of the asset to transfer (optional, defaults to the native coin)
 //
 // Returns a JSON response with transaction details, block hash, and status.
 // Possible errors:
 //   - 400 Bad Request: Invalid parameters or insufficient funds
 //   - 404 Not Found: Wallet not found
+//   - 409 Conflict if the chain rejects the transaction, e.g. because its inputs were spent concurrently
 //   - 500 Internal Server Error: Database or blockchain errors
 func handleTransaction(c echo.Context) error {
 	from := c.QueryParam("from")
 	to := c.QueryParam("to")
 	amountStr := c.QueryParam("amount")
 	privateKeyHex := c.QueryParam("privateKey")
+	asset := c.QueryParam("asset")
 
 	// Validate required parameters
 	if from == "" || to == "" || amountStr == "" || privateKeyHex == "" {
@@ -85,9 +165,9 @@ func handleTransaction(c echo.Context) error {
 		})
 	}
 
-	// Convert amount to integer
+	// Convert amount to a positive integer
 	amount, err := strconv.Atoi(amountStr)
-	if err != nil {
+	if err != nil || amount <= 0 {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "Invalid amount format",
 		})
@@ -118,8 +198,10 @@ func handleTransaction(c echo.Context) error {
 	}
 
 	// Verify that the private key corresponds to the wallet
-	if !bytes.Equal(privateKey.PublicKey.X.Bytes(), fromWallet.PublicKey[:32]) ||
-		!bytes.Equal(privateKey.PublicKey.Y.Bytes(), fromWallet.PublicKey[32:]) {
+	publicKey := make([]byte, 64)
+	privateKey.PublicKey.X.FillBytes(publicKey[:32])
+	privateKey.PublicKey.Y.FillBytes(publicKey[32:])
+	if !bytes.Equal(publicKey, fromWallet.PublicKey) {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "Private key does not match wallet address",
 		})
@@ -133,8 +215,8 @@ func handleTransaction(c echo.Context) error {
 		})
 	}
 
-	// Verify sufficient balance
-	balance := fromWallet.GetBalance(bc)
+	// Verify sufficient balance of the asset
+	balance := bc.GetAssetBalance(fromWallet.PublicKey, asset)
 	if balance < amount {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{
 			"error":   "Insufficient funds",
@@ -144,20 +226,28 @@ func handleTransaction(c echo.Context) error {
 	}
 
 	// Get available UTXOs for the sender
-	utxos := bc.UTXOs.GetUTXOsForAddress(fromWallet.PublicKey)
+	utxos := bc.GetUTXOsForAddress(fromWallet.PublicKey)
 
 	// Create the transaction using the NewTransaction function
 	// We pass the recipient's public key directly
-	tx, err := blockchain.NewTransaction(fromWallet, string(toWallet.PublicKey), amount, utxos)
+	tx, err := blockchain.NewAssetTransaction(fromWallet, string(toWallet.PublicKey), asset, amount, utxos)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": err.Error(),
 		})
 	}
 
-	// Create a new block with the transaction
-	// We use a test validator for now
-	newBlock := bc.AddBlock([]*blockchain.Transaction{tx}, []byte("test-validator"))
+	// Create a new block with the transaction, signed by the node's validator
+	newBlock, err := bc.AddBlock([]*blockchain.Transaction{tx}, validator)
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	// Gossip the transaction before the block so peers can rebuild the
+	// compact block announcement from their mempools
+	announceTransaction(tx)
 
 	// Save the block to the database
 	err = db.SaveBlock(newBlock)
@@ -167,16 +257,39 @@ func handleTransaction(c echo.Context) error {
 			"error":   err.Error(),
 		})
 	}
+	// Scheduled contract runs may have minted or transferred NFTs
+	if err := db.IndexBlockNFTs(bc, newBlock); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": "Error indexing NFTs",
+			"error":   err.Error(),
+		})
+	}
+	announceBlock(newBlock)
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"message":    "Transaction created and block added successfully",
 		"from":       from,
 		"to":         to,
 		"amount":     amount,
+		"asset":      asset,
 		"block_hash": fmt.Sprintf("%x", newBlock.Hash),
 	})
 }
 
+// announceBlock gossips a locally created block to connected peers, if networking is enabled.
+func announceBlock(block *blockchain.Block) {
+	if node != nil {
+		node.BroadcastBlock(block)
+	}
+}
+
+// announceTransaction gossips a transaction to connected peers, if networking is enabled.
+func announceTransaction(tx *blockchain.Transaction) {
+	if node != nil {
+		node.BroadcastTransaction(tx)
+	}
+}
+
 // handleGetBlock retrieves a block from the blockchain by its hash.
 // URL Parameters:
 //   - hash: The hash of the block to retrieve (in base64)
@@ -208,56 +321,10 @@ func handleGetBlock(c echo.Context) error {
 // handleGetAllBlocks retrieves all blocks from the blockchain.
 // Returns a JSON response with the list of all blocks.
 func handleGetAllBlocks(c echo.Context) error {
+	blocks := bc.AllBlocks()
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"blocks": bc.Blocks,
-		"count":  len(bc.Blocks),
-	})
-}
-
-// handleDeployContract processes smart contract deployment requests.
-// Query Parameters:
-//   - id:   Unique identifier for the contract
-//   - code: Smart contract code to deploy
-//
-// Returns:
-//   - 201 Created if deployment successful
-//   - 400 Bad Request if contract validation fails
-func handleDeployContract(c echo.Context) error {
-	id := c.QueryParam("id")
-	code := c.QueryParam("code")
-
-	contract := contracts.NewSmartContract(id, code)
-	err := contract.Validate()
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"message": err.Error(),
-		})
-	}
-
-	return c.JSON(http.StatusCreated, map[string]string{
-		"message": "Contract deployed successfully",
-		"id":      id,
-	})
-}
-
-// handleExecuteContract executes a deployed smart contract.
-// URL Parameters:
-//   - id: The identifier of the contract to execute
-//
-// Request Body:
-//   - input: Map of input parameters for contract execution
-//
-// Returns:
-//   - 200 OK with execution results
-//   - 404 Not Found if contract doesn't exist
-func handleExecuteContract(c echo.Context) error {
-	id := c.Param("id")
-	input := map[string]interface{}{}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"message": "Contract executed successfully",
-		"id":      id,
-		"input":   input,
+		"blocks": blocks,
+		"count":  len(blocks),
 	})
 }
 
@@ -285,7 +352,8 @@ func handleCreateWallet(c echo.Context) error {
 	})
 }
 
-// handleGetWalletBalance gets the balance of a wallet
+// handleGetWalletBalance gets the balance of a wallet: its native coin
+// balance and its balances of every issued asset it holds, sorted by asset ID
 func handleGetWalletBalance(c echo.Context) error {
 	address := c.Param("address")
 
@@ -297,10 +365,15 @@ func handleGetWalletBalance(c echo.Context) error {
 	}
 
 	balance := wallet.GetBalance(bc)
+	assets := bc.GetAssetBalances(wallet.PublicKey)
+	if assets == nil {
+		assets = []blockchain.AssetBalance{}
+	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"address": address,
 		"balance": balance,
+		"assets":  assets,
 	})
 }
 
@@ -316,6 +389,7 @@ func handleGetWalletBalance(c echo.Context) error {
 //   - 201 Created if generation was successful
 //   - 400 Bad Request if parameters are invalid
 //   - 404 Not Found if wallet doesn't exist
+//   - 409 Conflict if the chain rejects the transaction, e.g. because its inputs were spent concurrently
 //   - 500 Internal Server Error if there are internal errors
 func handleMintTokens(c echo.Context) error {
 	address := c.Param("address")
@@ -329,9 +403,9 @@ func handleMintTokens(c echo.Context) error {
 		})
 	}
 
-	// Convert amount to integer
+	// Convert amount to a positive integer
 	amount, err := strconv.Atoi(amountStr)
-	if err != nil {
+	if err != nil || amount <= 0 {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "Invalid amount format",
 		})
@@ -366,12 +440,18 @@ func handleMintTokens(c echo.Context) error {
 			Value:     amount,
 			PublicKey: wallet.PublicKey,
 		}},
+		// The block the transaction goes into, which makes its ID unique
+		MintHeight: bc.LastBlock().Height + 1,
 	}
 	tx.ID = tx.HashTransaction()
 
-	// Create a new block with the generation transaction
-	// We use the wallet's public key as validator
-	newBlock := bc.AddBlock([]*blockchain.Transaction{tx}, wallet.PublicKey)
+	// Create a new block with the generation transaction, signed by the node's validator
+	newBlock, err := bc.AddBlock([]*blockchain.Transaction{tx}, validator)
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
 
 	// Save the block to the database
 	err = db.SaveBlock(newBlock)
@@ -381,6 +461,14 @@ func handleMintTokens(c echo.Context) error {
 			"error":   err.Error(),
 		})
 	}
+	// Scheduled contract runs may have minted or transferred NFTs
+	if err := db.IndexBlockNFTs(bc, newBlock); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": "Error indexing NFTs",
+			"error":   err.Error(),
+		})
+	}
+	announceBlock(newBlock)
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"message":    "Tokens minted successfully",
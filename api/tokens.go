@@ -0,0 +1,157 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/labstack/echo/v4"
+)
+
+// createTokenRequest is the JSON body of a fungible token creation.
+type createTokenRequest struct {
+	From          string `json:"from"`          // Address of the issuing wallet
+	PrivateKey    string `json:"privateKey"`    // Issuer's private key, hex encoded
+	Name          string `json:"name"`          // Token name
+	Symbol        string `json:"symbol"`        // Ticker symbol
+	Decimals      int64  `json:"decimals"`      // Decimal places wallets display, 0 to 18
+	Metadata      string `json:"metadata"`      // Free-form description, e.g. a URI
+	InitialSupply int64  `json:"initialSupply"` // Amount minted to the issuer
+}
+
+// validate checks the request before any transaction is built, so that a
+// token that would fail to initialize is not deployed.
+func (r *createTokenRequest) validate() error {
+	switch {
+	case r.Symbol == "":
+		return errors.New("symbol is required")
+	case r.Decimals < 0 || r.Decimals > 18:
+		return errors.New("decimals must be between 0 and 18")
+	case r.InitialSupply < 0:
+		return errors.New("initial supply cannot be negative")
+	}
+	return nil
+}
+
+// handleCreateToken creates a fungible token: it deploys the reference token
+// (see contracts.FungibleTokenSource) and initializes it in the same block,
+// making the issuing wallet the token's issuer. Transfers, approvals,
+// minting and burning are calls to the token contract (POST /contract/:id/execute).
+// Request Body:
+//   - JSON object with from, privateKey, name, symbol, decimals, metadata
+//     and initialSupply (see createTokenRequest)
+//
+// Returns:
+//   - 201 Created with the token, transaction IDs and block hash
+//   - 400 Bad Request if the request is invalid or the key is wrong
+//   - 404 Not Found if the issuing wallet doesn't exist
+//   - 422 Unprocessable Entity if the token could not be initialized
+//   - 409 Conflict if the chain rejects the transaction, e.g. because its inputs were spent concurrently
+//   - 500 Internal Server Error if the block cannot be stored
+func handleCreateToken(c echo.Context) error {
+	var req createTokenRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	wallet, status, err := signingWallet(req.From, req.PrivateKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	newBlock, receipts, err := submitContractTransactions(wallet.Address, func(nonce uint64) ([]*blockchain.Transaction, error) {
+		return blockchain.NewCreateTokenTransactions(wallet, nonce, req.Name, req.Symbol, req.Decimals, req.Metadata, req.InitialSupply, bc.GetUTXOsForAddress(wallet.PublicKey))
+	})
+	if err != nil {
+		return c.JSON(submitErrorStatus(err), map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	deploy, initialize := receipts[0], receipts[1]
+	for _, receipt := range receipts {
+		if !receipt.Succeeded() {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"message":    receipt.Error,
+				"id":         deploy.Contract,
+				"txId":       receipt.TxID,
+				"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+			})
+		}
+	}
+	token, err := bc.FungibleToken(deploy.Contract)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":    "Token created successfully",
+		"id":         deploy.Contract,
+		"token":      token,
+		"txIds":      []string{deploy.TxID, initialize.TxID},
+		"block_hash": fmt.Sprintf("%x", newBlock.Hash),
+	})
+}
+
+// handleGetTokens lists the contracts implementing the fungible token
+// standard (see contracts.IsFungibleToken), whether created with POST
+// /tokens or deployed directly.
+//
+// Returns:
+//   - 200 OK with the tokens, sorted by address
+func handleGetTokens(c echo.Context) error {
+	tokens := bc.FungibleTokens()
+	if tokens == nil {
+		tokens = []*blockchain.FungibleToken{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tokens": tokens,
+	})
+}
+
+// handleGetToken describes a fungible token.
+// URL Parameters:
+//   - id: The address of the token contract
+//
+// Returns:
+//   - 200 OK with the token's name, symbol, decimals, metadata, issuer and total supply
+//   - 404 Not Found if there is no contract at the address or it is not a fungible token
+func handleGetToken(c echo.Context) error {
+	token, err := bc.FungibleToken(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, token)
+}
+
+// handleGetTokenBalances returns the balances an address holds in every
+// fungible token. The address may be a wallet or a contract.
+// URL Parameters:
+//   - address: The account address
+//
+// Returns:
+//   - 200 OK with the nonzero balances, sorted by token address
+func handleGetTokenBalances(c echo.Context) error {
+	address := c.Param("address")
+
+	balances := bc.TokenBalances(address)
+	if balances == nil {
+		balances = []blockchain.TokenBalance{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"address":  address,
+		"balances": balances,
+	})
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+	"github.com/ignaciocorball/go-blockchain/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// Limits of the source and metadata submitted for verification.
+const (
+	maxSourceSize       = 256 * 1024 // Maximum size of verified source in bytes
+	maxMetadataEntries  = 32         // Maximum number of metadata entries
+	maxMetadataValueLen = 1024       // Maximum length of a metadata key or value
+)
+
+// verifyRequest is the JSON body of a contract source verification.
+type verifyRequest struct {
+	Source          string            `json:"source"`          // Contract language source
+	CompilerVersion string            `json:"compilerVersion"` // Compiler version the source was compiled with
+	Metadata        map[string]string `json:"metadata"`        // Optional metadata, e.g. license, author or description
+}
+
+// validate checks the size of the request's source and metadata.
+func (r *verifyRequest) validate() error {
+	switch {
+	case r.Source == "":
+		return errors.New("source is required")
+	case r.CompilerVersion == "":
+		return errors.New("compilerVersion is required")
+	case len(r.Source) > maxSourceSize:
+		return fmt.Errorf("source exceeds %d bytes", maxSourceSize)
+	case len(r.Metadata) > maxMetadataEntries:
+		return fmt.Errorf("metadata has more than %d entries", maxMetadataEntries)
+	}
+	for key, value := range r.Metadata {
+		if len(key) > maxMetadataValueLen || len(value) > maxMetadataValueLen {
+			return fmt.Errorf("metadata %q exceeds %d bytes", key, maxMetadataValueLen)
+		}
+	}
+	return nil
+}
+
+// handleVerifyContract verifies the source of a contract: the node
+// recompiles it with the given compiler version and checks that it produces
+// the contract's current code and ABI. Verified source is stored with its
+// ABI and metadata and served by GET /contract/:id. Verification is local to
+// this node and does not create a transaction.
+// URL Parameters:
+//   - id: The address of the contract
+//
+// Request Body:
+//   - JSON object with source, compilerVersion and optionally metadata (see
+//     verifyRequest)
+//
+// Returns:
+//   - 201 Created with the verified source
+//   - 400 Bad Request if the request is invalid, the compiler version is not
+//     this node's or the source does not compile
+//   - 404 Not Found if the contract doesn't exist
+//   - 422 Unprocessable Entity if the source does not compile to the
+//     contract's code or ABI
+//   - 500 Internal Server Error if the verified source cannot be stored
+func handleVerifyContract(c echo.Context) error {
+	id := c.Param("id")
+
+	var req verifyRequest
+	if err := decodeBody(c, &req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+	compiled, err := contract.VerifySource(req.Source, req.CompilerVersion)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, contracts.ErrSourceMismatch) {
+			status = http.StatusUnprocessableEntity
+		}
+		return c.JSON(status, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	verified := &storage.VerifiedSource{
+		Contract:        id,
+		Version:         contract.Version(),
+		CodeHash:        contracts.CodeHash(contract.Code),
+		Name:            compiled.Name,
+		Source:          req.Source,
+		CompilerVersion: req.CompilerVersion,
+		ABI:             compiled.ABI,
+		Metadata:        req.Metadata,
+		VerifiedAt:      time.Now(),
+	}
+	if err := db.SaveVerifiedSource(verified); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":  "Contract source verified successfully",
+		"id":       id,
+		"verified": verified,
+	})
+}
+
+// handleGetContract returns a contract: its owner, balance, current code
+// version and, if its source has been verified for the current code, the
+// verified source, ABI and metadata.
+// URL Parameters:
+//   - id: The address of the contract
+//
+// Returns:
+//   - 200 OK with the contract and whether its source is verified
+//   - 404 Not Found if the contract doesn't exist
+//   - 500 Internal Server Error if the verified source cannot be read
+func handleGetContract(c echo.Context) error {
+	id := c.Param("id")
+
+	contract, ok := bc.GetContract(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": contracts.ErrContractNotFound.Error(),
+		})
+	}
+
+	codeHash := contracts.CodeHash(contract.Code)
+	response := map[string]interface{}{
+		"id":        id,
+		"owner":     contract.Owner,
+		"balance":   contract.Balance,
+		"version":   contract.Version(),
+		"codeHash":  codeHash,
+		"frozen":    contract.Frozen,
+		"archive":   contract.Archive,
+		"abi":       contract.ABI,
+		"createdAt": contract.CreatedAt,
+		"verified":  false,
+	}
+	if contract.Source != "" {
+		// Source deployed with the code was checked to compile to it
+		response["verified"] = true
+		response["source"] = contract.Source
+	}
+	verified, err := db.GetVerifiedSource(id, codeHash)
+	switch {
+	case err == nil:
+		response["verified"] = true
+		response["name"] = verified.Name
+		response["source"] = verified.Source
+		response["compilerVersion"] = verified.CompilerVersion
+		response["abi"] = verified.ABI
+		response["metadata"] = verified.Metadata
+		response["verifiedAt"] = verified.VerifiedAt
+	case !errors.Is(err, storage.ErrSourceNotVerified):
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// NativeAsset is the asset ID of the chain's native coin, carried by outputs
+// without an asset ID.
+const NativeAsset = ""
+
+// AssetBalance is an address's balance of an issued asset.
+type AssetBalance struct {
+	Asset  string `json:"asset"`  // Asset ID (see IssuedAsset)
+	Amount int    `json:"amount"` // Sum of the address's unspent outputs of the asset
+}
+
+// AssetID returns the ID of the asset issued by the transaction whose first
+// input spends output outputIndex of transaction txID: the hex-encoded
+// SHA-256 hash of that outpoint. As an output can only be spent once, every
+// issuance has a distinct asset ID.
+func AssetID(txID []byte, outputIndex int) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("asset:%x_%d", txID, outputIndex)))
+	return hex.EncodeToString(hash[:])
+}
+
+// IssuedAsset returns the ID of the asset a transaction may issue, derived
+// from its first input (see AssetID). Its outputs of that asset create the
+// asset's whole supply, which no later transaction can increase. Contract
+// transactions and transactions without inputs issue nothing and
+// IssuedAsset returns NativeAsset for them.
+func IssuedAsset(tx *Transaction) string {
+	if tx.Contract != nil || len(tx.Input) == 0 {
+		return NativeAsset
+	}
+	return AssetID(tx.Input[0].TransactionID, tx.Input[0].OutputIndex)
+}
+
+// NewIssueAssetTransaction creates a transaction issuing a new asset with a
+// fixed supply of amount, all of it paid to the issuing wallet. It spends
+// one of the wallet's native coin UTXOs, whose outpoint determines the
+// asset ID (see IssuedAsset), and returns its value to the wallet as change.
+// Returns nil and an error if the wallet has no native coin UTXO.
+func NewIssueAssetTransaction(wallet *Wallet, amount int, utxos []*UTXO) (*Transaction, error) {
+	if amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+
+	for _, utxo := range utxos {
+		if !bytes.Equal(utxo.PublicKey, wallet.PublicKey) || utxo.Asset != NativeAsset {
+			continue
+		}
+		tx := &Transaction{
+			Input: []TxInput{{
+				TransactionID: utxo.TransactionID,
+				OutputIndex:   utxo.OutputIndex,
+				PublicKey:     wallet.PublicKey,
+			}},
+		}
+		tx.Output = []TxOutput{
+			{Value: amount, PublicKey: wallet.PublicKey, Asset: IssuedAsset(tx)},
+			{Value: utxo.Value, PublicKey: wallet.PublicKey},
+		}
+
+		tx.ID = tx.HashTransaction()
+		tx.Input[0].Signature = tx.Sign(wallet.GetPrivateKey())
+		return tx, nil
+	}
+	return nil, errors.New("issuing an asset requires an unspent output of the native coin")
+}
@@ -5,71 +5,178 @@ package blockchain
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"crypto/sha256"
 	"encoding/gob"
+	"fmt"
 	"time"
 )
 
+// GenesisTimestamp is the fixed timestamp of the genesis block. It is constant
+// so that every node, and every light client, derives the same genesis hash.
+const GenesisTimestamp = "2025-01-01T00:00:00Z"
+
+// TimestampFormat is the layout of Block.Timestamp.
+const TimestampFormat = time.RFC3339Nano
+
 // Block represents a single block in the blockchain. Each block contains:
 // - Timestamp: When the block was created
+// - Height: Position of the block in the chain (the genesis block has height 0)
 // - Transactions: List of transactions included in this block
-// - Hash: The cryptographic hash of this block
+// - MerkleRoot: Root of the Merkle tree built over the transaction IDs
+// - StateRoot: Root of the contract state tree after applying the block
+// - Hash: The cryptographic hash of this block's header
 // - PrevHash: The hash of the previous block in the chain
 // - Validator: The public key of the validator who created this block
+// - Signature: The validator's signature over the block hash
 // - Nonce: A number used in the proof-of-work/proof-of-stake mechanism
 type Block struct {
 	Timestamp    string
+	Height       int
 	Transactions []*Transaction
+	MerkleRoot   []byte
+	StateRoot    []byte
 	Hash         []byte
 	PrevHash     []byte
 	Validator    []byte
+	Signature    []byte
 	Nonce        int
 }
 
+// BlockHeader is a block without its transactions. Headers are all a light
+// client needs to follow the chain: the Merkle root commits to the
+// transactions, the state root to the contract storage (see StateProof),
+// and the hash and signature commit to the header itself.
+type BlockHeader struct {
+	Timestamp  string
+	Height     int
+	MerkleRoot []byte
+	StateRoot  []byte
+	Hash       []byte
+	PrevHash   []byte
+	Validator  []byte
+	Signature  []byte
+	Nonce      int
+}
+
 // NewBlock creates and returns a new block in the blockchain.
 // Parameters:
 //   - transactions: List of transactions to be included in the block
 //   - prevHash: Hash of the previous block in the chain
+//   - height: Height of the new block
 //   - validator: Public key of the validator creating this block
+//   - stateRoot: Root of the contract state tree after applying the block
 //
 // The function initializes a new block with the current timestamp,
-// calculates its hash, and returns the complete block structure.
-func NewBlock(transactions []*Transaction, prevHash []byte, validator []byte) *Block {
+// calculates its Merkle root and hash, and returns the complete block
+// structure. The block still has to be signed by its validator.
+func NewBlock(transactions []*Transaction, prevHash []byte, height int, validator []byte, stateRoot []byte) *Block {
+	return newBlockAt(time.Now(), transactions, prevHash, height, validator, stateRoot)
+}
+
+// newBlockAt creates a new block with the given timestamp.
+func newBlockAt(timestamp time.Time, transactions []*Transaction, prevHash []byte, height int, validator []byte, stateRoot []byte) *Block {
 	block := &Block{
-		Timestamp:    time.Now().String(),
+		Timestamp:    timestamp.UTC().Format(TimestampFormat),
+		Height:       height,
 		Transactions: transactions,
+		StateRoot:    stateRoot,
 		PrevHash:     prevHash,
 		Validator:    validator,
 		Nonce:        0,
 	}
 
+	block.MerkleRoot = block.calculateMerkleRoot()
+	block.Hash = block.calculateHash()
+	return block
+}
+
+// NewGenesisBlock returns the genesis block shared by every node.
+// It has no transactions, no previous block hash, a fixed timestamp and a
+// special genesis validator, so its hash is identical everywhere.
+func NewGenesisBlock() *Block {
+	block := &Block{
+		Timestamp:    GenesisTimestamp,
+		Height:       0,
+		Transactions: []*Transaction{},
+		StateRoot:    EmptyStateRoot(),
+		PrevHash:     []byte{},
+		Validator:    []byte("genesis-validator"),
+	}
+
+	block.MerkleRoot = block.calculateMerkleRoot()
 	block.Hash = block.calculateHash()
 	return block
 }
 
+// Header returns the header of the block.
+func (b *Block) Header() *BlockHeader {
+	return &BlockHeader{
+		Timestamp:  b.Timestamp,
+		Height:     b.Height,
+		MerkleRoot: b.MerkleRoot,
+		StateRoot:  b.StateRoot,
+		Hash:       b.Hash,
+		PrevHash:   b.PrevHash,
+		Validator:  b.Validator,
+		Signature:  b.Signature,
+		Nonce:      b.Nonce,
+	}
+}
+
+// Time parses the block timestamp.
+func (b *Block) Time() (time.Time, error) {
+	return time.Parse(TimestampFormat, b.Timestamp)
+}
+
+// Sign signs the block hash with the validator's private key.
+func (b *Block) Sign(privateKey *ecdsa.PrivateKey) {
+	b.Signature = signDigest(privateKey, b.Hash)
+}
+
+// calculateMerkleRoot computes the Merkle root of the block's transactions.
+func (b *Block) calculateMerkleRoot() []byte {
+	ids := make([][]byte, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		ids[i] = tx.ID
+	}
+	return MerkleRoot(ids)
+}
+
 // calculateHash generates the cryptographic hash of the block.
+// The hash covers the header only; transactions are committed to through
+// the Merkle root, so headers can be verified without the transactions.
+func (b *Block) calculateHash() []byte {
+	return b.Header().CalculateHash()
+}
+
+// CalculateHash generates the cryptographic hash of the header.
 // The hash is calculated by combining:
 // - The previous block's hash
-// - All transaction IDs in the block
+// - The Merkle root of the block's transactions
 // - The block's timestamp
+// - The block's height and validator
+// - The state root
 //
 // Returns a SHA-256 hash of the combined data as a byte slice.
-func (b *Block) calculateHash() []byte {
-	var txHashes []byte
-
-	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.ID...)
-	}
-
+func (h *BlockHeader) CalculateHash() []byte {
 	hash := sha256.Sum256(bytes.Join([][]byte{
-		b.PrevHash,
-		txHashes,
-		[]byte(b.Timestamp),
+		h.PrevHash,
+		h.MerkleRoot,
+		[]byte(h.Timestamp),
+		[]byte(fmt.Sprintf("%d", h.Height)),
+		h.Validator,
+		h.StateRoot,
 	}, []byte{}))
 
 	return hash[:]
 }
 
+// VerifySignature reports whether the header is signed by its validator.
+func (h *BlockHeader) VerifySignature() bool {
+	return verifySignature(h.Validator, h.Hash, h.Signature)
+}
+
 // Serialize converts the block into a byte array for storage or transmission.
 // Uses gob encoding to serialize the entire block structure.
 // Returns the serialized block as a byte slice.
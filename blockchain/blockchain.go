@@ -5,15 +5,61 @@ package blockchain
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
+)
+
+// MaxFutureBlockTime is how far ahead of the local clock a block's timestamp
+// may be before the block is rejected.
+const MaxFutureBlockTime = 2 * time.Minute
+
+var (
+	// ErrKnownBlock is returned by AcceptBlock when the block is already part of the chain.
+	ErrKnownBlock = errors.New("block already known")
+
+	// ErrInvalidBlockHash is returned by AcceptBlock when a block's hash does not match its contents.
+	ErrInvalidBlockHash = errors.New("block hash does not match its contents")
+
+	// ErrInvalidMerkleRoot is returned by AcceptBlock when a block's Merkle root does not match its transactions.
+	ErrInvalidMerkleRoot = errors.New("block merkle root does not match its transactions")
+
+	// ErrInvalidStateRoot is returned by AcceptBlock when a block's state root does not match the state after applying it.
+	ErrInvalidStateRoot = errors.New("block state root does not match the contract state")
+
+	// ErrInvalidTransactionID is returned by AcceptBlock when a transaction's ID is not the hash of its contents,
+	// or is the ID of another transaction.
+	ErrInvalidTransactionID = errors.New("transaction ID does not match its contents")
+
+	// ErrNotExtendingTip is returned by AcceptBlock when a block does not build on the current tip.
+	// The block may belong to a competing fork; see ImportBlocks.
+	ErrNotExtendingTip = errors.New("block does not extend the current tip")
+
+	// ErrUnknownValidator is returned by AcceptBlock and AddBlock when a block is signed by a key outside the validator set.
+	ErrUnknownValidator = errors.New("block is not signed by a trusted validator")
+
+	// ErrTransactionRejected is returned by AddBlock when a transaction cannot be included in the next block.
+	ErrTransactionRejected = errors.New("transaction rejected")
 )
 
 // Blockchain represents the main blockchain structure.
 // It maintains an ordered list of blocks, where each block is linked to its
 // previous block through cryptographic hashes, forming an immutable chain.
 type Blockchain struct {
-	Blocks []*Block // Ordered list of blocks in the chain
-	UTXOs  *UTXOSet
+	Blocks    []*Block // Ordered list of blocks in the chain
+	UTXOs     *UTXOSet
+	Contracts *ContractSet // Deployed contracts and their state
+
+	// Clock returns the current time. It defaults to time.Now and can be
+	// replaced, e.g. by a network simulator, to control block timestamps.
+	Clock func() time.Time
+
+	filterHeaders [][]byte           // Filter header of every block, indexed by height
+	undo          map[int]*blockUndo // Changes of the last MaxReorgDepth blocks, key = block height
+	validators    map[string]bool    // Public keys allowed to sign blocks, key = hex public key; empty trusts any signer
+	mu            sync.RWMutex       // Guards the chain state against concurrent API and peer updates
 }
 
 // GetBlock retrieves a block from the blockchain by its hash.
@@ -28,6 +74,9 @@ type Blockchain struct {
 // future implementations that might support multiple blocks with the same hash
 // (though this is not currently supported).
 func (bc *Blockchain) GetBlock(hash []byte) ([]*Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
 	for _, block := range bc.Blocks {
 		if bytes.Equal(block.Hash, hash) {
 			return []*Block{block}, nil
@@ -45,62 +94,414 @@ func (bc *Blockchain) GetBlock(hash []byte) ([]*Block, error) {
 // contains initial system state or configuration.
 func NewBlockchain(genesisBlock *Block) *Blockchain {
 	bc := &Blockchain{
-		Blocks: []*Block{genesisBlock},
-		UTXOs:  NewUTXOSet(),
+		UTXOs:     NewUTXOSet(),
+		Contracts: NewContractSet(NewMemoryNodeStore()),
+		Clock:     time.Now,
+		undo:      make(map[int]*blockUndo),
 	}
 
 	// Process the genesis block
-	bc.UpdateUTXOs(genesisBlock)
+	bc.appendBlock(genesisBlock)
 
 	return bc
 }
 
-// UpdateUTXOs updates the UTXO set based on a new block
+// SetStateStore makes the contract state tree keep its nodes in nodes, e.g.
+// a database, instead of memory. It must be called before any block is
+// added after the genesis block.
+func (bc *Blockchain) SetStateStore(nodes NodeStore) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.Contracts.State = NewStateTree(nodes)
+}
+
+// SetValidators restricts the keys allowed to sign blocks after the genesis
+// block to validators. With no validators, blocks signed by any key are
+// accepted.
+func (bc *Blockchain) SetValidators(validators [][]byte) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.validators = make(map[string]bool, len(validators))
+	for _, v := range validators {
+		bc.validators[hex.EncodeToString(v)] = true
+	}
+}
+
+// trusts reports whether blocks signed by validator are accepted.
+// The caller must hold the lock.
+func (bc *Blockchain) trusts(validator []byte) bool {
+	return len(bc.validators) == 0 || bc.validators[hex.EncodeToString(validator)]
+}
+
+// UpdateUTXOs updates the UTXO set based on a new block.
+// Contract transactions also pay their fee to the block's validator and,
+// if they failed according to their receipts, refund their value to the
+// sender (see contractOutputs); so do the block's scheduled runs for the gas
+// they used (see runOutputs).
 func (bc *Blockchain) UpdateUTXOs(block *Block) {
+	// Output keys are unique, so adding every output before removing the
+	// spent ones leaves the same set as going transaction by transaction
+	for _, utxo := range bc.createdBy(block) {
+		bc.UTXOs.AddUTXO(utxo.TransactionID, utxo.OutputIndex, utxo.Value, utxo.PublicKey, utxo.Asset)
+	}
 	for _, tx := range block.Transactions {
-		// Remove spent UTXOs
 		for _, input := range tx.Input {
 			bc.UTXOs.RemoveUTXO(input.TransactionID, input.OutputIndex)
 		}
+	}
+}
 
-		// Add new UTXOs
+// createdBy returns the outputs a block creates: those of its scheduled
+// runs and transactions, and the fees and refunds of its contract
+// transactions, which must have been executed.
+func (bc *Blockchain) createdBy(block *Block) []*UTXO {
+	created := bc.Contracts.runOutputs(block)
+	for _, tx := range block.Transactions {
 		for i, output := range tx.Output {
-			bc.UTXOs.AddUTXO(tx.ID, i, output.Value, output.PublicKey)
+			created = append(created, &UTXO{
+				TransactionID: tx.ID,
+				OutputIndex:   i,
+				Value:         output.Value,
+				PublicKey:     output.PublicKey,
+				Asset:         output.Asset,
+			})
+		}
+		if tx.Contract != nil {
+			receipt := bc.Contracts.Receipts[hex.EncodeToString(tx.ID)]
+			created = append(created, contractOutputs(tx, block.Validator, receipt.Succeeded())...)
 		}
 	}
+	return created
 }
 
 // AddBlock creates and adds a new block to the blockchain.
 // Parameters:
 //   - transactions: List of transactions to be included in the new block
-//   - validator: Public key of the validator who created this block
+//   - validator: Wallet of the validator creating and signing this block
 //
 // The function:
 // 1. Gets the previous block (last block in the chain)
 // 2. Creates a new block with the provided transactions
 // 3. Links it to the previous block using the previous block's hash
-// 4. Adds the new block to the chain
+// 4. Commits to the contract state root the block's transactions lead to
+// 5. Signs it with the validator's private key
+// 6. Adds the new block to the chain, executing its contract transactions
 //
-// Returns the newly created block.
-func (bc *Blockchain) AddBlock(transactions []*Transaction, validator []byte) *Block {
+// Returns the newly created block, ErrUnknownValidator if validator is not in
+// the validator set (see SetValidators), or an error wrapping ErrTransactionRejected
+// if a transaction has an invalid signature or ID, a contract transaction is
+// invalid or the transactions spend outputs they cannot spend.
+func (bc *Blockchain) AddBlock(transactions []*Transaction, validator *Wallet) (*Block, error) {
 	// Verify all transactions
 	for _, tx := range transactions {
 		if !tx.Verify() {
-			panic("Invalid transaction signature")
+			return nil, fmt.Errorf("%w: transaction %x has an invalid signature", ErrTransactionRejected, tx.ID)
 		}
 	}
 
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if !bc.trusts(validator.PublicKey) {
+		return nil, ErrUnknownValidator
+	}
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := NewBlock(transactions, prevBlock.Hash, validator)
+	if err := bc.checkTransactionIDs(transactions, prevBlock.Height+1); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTransactionRejected, err)
+	}
+	if err := bc.Contracts.Check(transactions); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTransactionRejected, err)
+	}
+	if err := bc.UTXOs.checkSpending(transactions); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTransactionRejected, err)
+	}
 
-	// Update UTXOs before adding the block
-	bc.UpdateUTXOs(newBlock)
+	newBlock := newBlockAt(bc.Clock(), transactions, prevBlock.Hash, prevBlock.Height+1, validator.PublicKey, nil)
+	newBlock.StateRoot = bc.Contracts.rootAfter(newBlock)
+	newBlock.Hash = newBlock.calculateHash()
+	newBlock.Sign(validator.GetPrivateKey())
 
-	bc.Blocks = append(bc.Blocks, newBlock)
-	return newBlock
+	bc.appendBlock(newBlock)
+	return newBlock, nil
+}
+
+// AcceptBlock appends a block produced elsewhere (typically received from a peer)
+// to the chain.
+// Parameters:
+//   - block: The block to append
+//
+// The block is rejected if it does not extend the current tip, if its hash
+// or Merkle root does not match its contents, if it is not signed by its
+// validator or the validator is outside the validator set, or if any of its transactions fails signature verification or
+// is not identified by the hash of its contents (see checkTransactionIDs).
+//
+// Returns:
+//   - nil if the block was appended
+//   - ErrKnownBlock if the block is already part of the chain
+//   - ErrNotExtendingTip if the block does not build on the current tip
+//   - An error describing why the block was rejected otherwise
+func (bc *Blockchain) AcceptBlock(block *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for _, existing := range bc.Blocks {
+		if bytes.Equal(existing.Hash, block.Hash) {
+			return ErrKnownBlock
+		}
+	}
+
+	if err := bc.validateNext(block); err != nil {
+		return err
+	}
+
+	bc.appendBlock(block)
+	return nil
+}
+
+// validateNext checks that a block is a valid successor of the current tip.
+// The caller must hold the lock.
+func (bc *Blockchain) validateNext(block *Block) error {
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	if !bytes.Equal(block.PrevHash, tip.Hash) || block.Height != tip.Height+1 {
+		return ErrNotExtendingTip
+	}
+	if err := bc.checkTransactionIDs(block.Transactions, block.Height); err != nil {
+		return fmt.Errorf("%w: block %x: %v", ErrInvalidTransactionID, block.Hash, err)
+	}
+	if !bytes.Equal(block.MerkleRoot, block.calculateMerkleRoot()) {
+		return ErrInvalidMerkleRoot
+	}
+	if !bytes.Equal(block.Hash, block.calculateHash()) {
+		return ErrInvalidBlockHash
+	}
+	if !bc.trusts(block.Validator) {
+		return fmt.Errorf("%w: block %x is signed by %x", ErrUnknownValidator, block.Hash, block.Validator)
+	}
+	if !block.Header().VerifySignature() {
+		return fmt.Errorf("block %x has an invalid validator signature", block.Hash)
+	}
+
+	timestamp, err := block.Time()
+	if err != nil {
+		return fmt.Errorf("block %x has an invalid timestamp: %v", block.Hash, err)
+	}
+	if timestamp.After(bc.Clock().Add(MaxFutureBlockTime)) {
+		return fmt.Errorf("block %x is too far in the future", block.Hash)
+	}
+
+	for _, tx := range block.Transactions {
+		if !tx.Verify() {
+			return fmt.Errorf("block %x contains transaction %x with an invalid signature", block.Hash, tx.ID)
+		}
+	}
+	if err := bc.Contracts.Check(block.Transactions); err != nil {
+		return fmt.Errorf("block %x: %v", block.Hash, err)
+	}
+	if err := bc.UTXOs.checkSpending(block.Transactions); err != nil {
+		return fmt.Errorf("block %x: %v", block.Hash, err)
+	}
+	if !bytes.Equal(block.StateRoot, bc.Contracts.rootAfter(block)) {
+		return ErrInvalidStateRoot
+	}
+	return nil
+}
+
+// checkTransactionIDs verifies that the transactions of a block at the given
+// height are identified by the hash of their contents and that no ID repeats,
+// within the block or from a transaction already in the chain, since UTXOs and
+// receipts are keyed by transaction ID. Token generation transactions, which
+// have no inputs to make them unique, must carry the block's height.
+// The caller must hold the lock.
+func (bc *Blockchain) checkTransactionIDs(transactions []*Transaction, height int) error {
+	seen := make(map[string]bool, len(transactions))
+	for _, tx := range transactions {
+		if !bytes.Equal(tx.ID, tx.HashTransaction()) {
+			return fmt.Errorf("transaction %x does not hash to its ID", tx.ID)
+		}
+		id := hex.EncodeToString(tx.ID)
+		if _, ok := bc.Contracts.Receipts[id]; ok || seen[id] {
+			return fmt.Errorf("transaction %x is already part of the chain", tx.ID)
+		}
+		seen[id] = true
+
+		if len(tx.Input) == 0 && tx.Contract == nil {
+			if tx.MintHeight != height {
+				return fmt.Errorf("token generation transaction %x has mint height %d, expected %d", tx.ID, tx.MintHeight, height)
+			}
+		} else if tx.MintHeight != 0 {
+			return fmt.Errorf("transaction %x has a mint height but is not a token generation transaction", tx.ID)
+		}
+	}
+	return nil
+}
+
+// appendBlock adds a validated block to the chain and updates the derived
+// state: the contract state, the UTXO set and the block filter header chain.
+// Contracts are executed first, so that failed calls do not spend the
+// outputs that pay for them.
+// What the block changes is recorded so that a reorganization can
+// disconnect it again (see disconnectTip).
+// The caller must hold the write lock (or own bc exclusively).
+func (bc *Blockchain) appendBlock(block *Block) {
+	before := bc.Contracts.overlay()
+	bc.Contracts.Apply(block)
+	if err := bc.Contracts.State.Commit(); err != nil {
+		panic(fmt.Sprintf("error storing the state tree: %v", err))
+	}
+
+	undo := &blockUndo{contracts: newContractUndo(before, bc.Contracts, block)}
+	for _, tx := range block.Transactions {
+		for _, input := range tx.Input {
+			if utxo, ok := bc.UTXOs.UTXOs[fmt.Sprintf("%x_%d", input.TransactionID, input.OutputIndex)]; ok {
+				undo.spent = append(undo.spent, utxo)
+			}
+		}
+	}
+	for _, utxo := range bc.createdBy(block) {
+		undo.created = append(undo.created, fmt.Sprintf("%x_%d", utxo.TransactionID, utxo.OutputIndex))
+	}
+	bc.UpdateUTXOs(block)
+	bc.undo[block.Height] = undo
+	delete(bc.undo, block.Height-MaxReorgDepth)
+	if block.Height > 0 && block.Height%StatePruneInterval == 0 {
+		if err := bc.pruneStateNodes(); err != nil {
+			panic(fmt.Sprintf("error pruning the state tree: %v", err))
+		}
+	}
+
+	prevHeader := make([]byte, 32)
+	if len(bc.filterHeaders) > 0 {
+		prevHeader = bc.filterHeaders[len(bc.filterHeaders)-1]
+	}
+	bc.filterHeaders = append(bc.filterHeaders, FilterHeader(NewBlockFilter(block), prevHeader))
+
+	bc.Blocks = append(bc.Blocks, block)
+}
+
+// LastBlock returns the block at the tip of the chain.
+func (bc *Blockchain) LastBlock() *Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.Blocks[len(bc.Blocks)-1]
+}
+
+// AllBlocks returns the blocks of the chain, from the genesis block to the tip.
+func (bc *Blockchain) AllBlocks() []*Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return append([]*Block(nil), bc.Blocks...)
+}
+
+// BlockAt returns the block at the given height.
+func (bc *Blockchain) BlockAt(height int) (*Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if height < 0 || height >= len(bc.Blocks) {
+		return nil, fmt.Errorf("block not found")
+	}
+	return bc.Blocks[height], nil
+}
+
+// Headers returns up to limit block headers starting at height from.
+func (bc *Blockchain) Headers(from int, limit int) []*BlockHeader {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var headers []*BlockHeader
+	for height := from; height >= 0 && height < len(bc.Blocks) && len(headers) < limit; height++ {
+		headers = append(headers, bc.Blocks[height].Header())
+	}
+	return headers
+}
+
+// Filters returns up to limit block filters starting at height from.
+func (bc *Blockchain) Filters(from int, limit int) []*BlockFilter {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var filters []*BlockFilter
+	for height := from; height >= 0 && height < len(bc.Blocks) && len(filters) < limit; height++ {
+		filters = append(filters, NewBlockFilter(bc.Blocks[height]))
+	}
+	return filters
+}
+
+// FilterHeaders returns up to limit filter headers starting at height from.
+func (bc *Blockchain) FilterHeaders(from int, limit int) [][]byte {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var headers [][]byte
+	for height := from; height >= 0 && height < len(bc.filterHeaders) && len(headers) < limit; height++ {
+		headers = append(headers, bc.filterHeaders[height])
+	}
+	return headers
+}
+
+// FilteredBlocks returns, for up to limit blocks starting at height from,
+// the header and the transactions matching a client-submitted Bloom filter.
+func (bc *Blockchain) FilteredBlocks(from int, limit int, filter *BloomFilter) []*FilteredBlock {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var blocks []*FilteredBlock
+	for height := from; height >= 0 && height < len(bc.Blocks) && len(blocks) < limit; height++ {
+		blocks = append(blocks, NewFilteredBlock(bc.Blocks[height], filter))
+	}
+	return blocks
+}
+
+// FindTransaction looks up a transaction by ID.
+// Returns the block including it and its index within that block, or an
+// error if the transaction is not part of the chain.
+func (bc *Blockchain) FindTransaction(id []byte) (*Block, int, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	for _, block := range bc.Blocks {
+		for i, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, id) {
+				return block, i, nil
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("transaction not found")
 }
 
 // GetBalance returns the balance of an address
 func (bc *Blockchain) GetBalance(address []byte) int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
 	return bc.UTXOs.GetBalance(address)
 }
+
+// GetUTXOsForAddress returns the unspent outputs owned by an address
+func (bc *Blockchain) GetUTXOsForAddress(address []byte) []*UTXO {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.UTXOs.GetUTXOsForAddress(address)
+}
+
+// GetAssetBalance returns the balance of an asset of an address
+func (bc *Blockchain) GetAssetBalance(address []byte, asset string) int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.UTXOs.GetAssetBalance(address, asset)
+}
+
+// GetAssetBalances returns the balances of the issued assets an address
+// holds, sorted by asset ID
+func (bc *Blockchain) GetAssetBalances(address []byte) []AssetBalance {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.UTXOs.GetAssetBalances(address)
+}
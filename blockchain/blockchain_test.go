@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// newTestChain creates a chain holding only the genesis block.
+func newTestChain() *Blockchain {
+	return NewBlockchain(NewGenesisBlock())
+}
+
+// mintTx creates a token generation transaction for the block at height.
+func mintTx(to *Wallet, amount int, height int) *Transaction {
+	tx := &Transaction{
+		Output:     []TxOutput{{Value: amount, PublicKey: to.PublicKey}},
+		MintHeight: height,
+	}
+	tx.ID = tx.HashTransaction()
+	return tx
+}
+
+// nextBlock builds and signs a block with the given transactions on top of
+// the chain's tip, committing to the state root they lead to.
+func nextBlock(bc *Blockchain, validator *Wallet, transactions []*Transaction) *Block {
+	tip := bc.LastBlock()
+	block := newBlockAt(bc.Clock(), transactions, tip.Hash, tip.Height+1, validator.PublicKey, nil)
+	block.StateRoot = bc.Contracts.rootAfter(block)
+	block.Hash = block.calculateHash()
+	block.Sign(validator.GetPrivateKey())
+	return block
+}
+
+func TestAcceptBlockRejectsMismatchedTransactionIDs(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+
+	bc := newTestChain()
+	funding := mintTx(alice, 100, 1)
+	if _, err := bc.AddBlock([]*Transaction{funding}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		tx   func() *Transaction
+	}{
+		{"ID of another transaction", func() *Transaction {
+			tx := mintTx(alice, 1000, 2)
+			tx.ID = funding.ID
+			return tx
+		}},
+		{"ID not matching the outputs", func() *Transaction {
+			tx := mintTx(alice, 100, 2)
+			tx.Output[0].Value = 1000
+			return tx
+		}},
+		{"replayed transaction", func() *Transaction {
+			return funding
+		}},
+		{"mint without height", func() *Transaction {
+			return mintTx(alice, 100, 0)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := nextBlock(bc, validator, []*Transaction{tt.tx()})
+			if err := bc.AcceptBlock(block); !errors.Is(err, ErrInvalidTransactionID) {
+				t.Fatalf("AcceptBlock = %v, want %v", err, ErrInvalidTransactionID)
+			}
+		})
+	}
+
+	if got := bc.GetBalance(alice.PublicKey); got != 100 {
+		t.Fatalf("balance = %d, want 100", got)
+	}
+}
+
+func TestAddBlockRejectsConflictingTransactions(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bob := NewWallet()
+
+	bc := newTestChain()
+	if _, err := bc.AddBlock([]*Transaction{mintTx(alice, 100, 1)}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+
+	utxos := bc.GetUTXOsForAddress(alice.PublicKey)
+	first, err := NewTransaction(alice, string(bob.PublicKey), 60, utxos)
+	if err != nil {
+		t.Fatalf("NewTransaction: %v", err)
+	}
+	second, err := NewTransaction(alice, string(bob.PublicKey), 70, utxos)
+	if err != nil {
+		t.Fatalf("NewTransaction: %v", err)
+	}
+
+	if _, err := bc.AddBlock([]*Transaction{first}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	if _, err := bc.AddBlock([]*Transaction{second}, validator); !errors.Is(err, ErrTransactionRejected) {
+		t.Fatalf("AddBlock of a double spend = %v, want %v", err, ErrTransactionRejected)
+	}
+	if _, err := bc.AddBlock([]*Transaction{mintTx(bob, 5, 1)}, validator); !errors.Is(err, ErrTransactionRejected) {
+		t.Fatalf("AddBlock of a mint for another height = %v, want %v", err, ErrTransactionRejected)
+	}
+
+	if got := bc.GetBalance(bob.PublicKey); got != 60 {
+		t.Fatalf("balance = %d, want 60", got)
+	}
+}
+
+func TestAddBlockRejectsOverflowingOutputs(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bob := NewWallet()
+
+	bc := newTestChain()
+	if _, err := bc.AddBlock([]*Transaction{mintTx(alice, 100, 1)}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+
+	// Two outputs of MaxInt64 wrap around to a negative total below the 100 spent
+	utxo := bc.GetUTXOsForAddress(alice.PublicKey)[0]
+	tx := &Transaction{
+		Input: []TxInput{{TransactionID: utxo.TransactionID, OutputIndex: utxo.OutputIndex, PublicKey: alice.PublicKey}},
+		Output: []TxOutput{
+			{Value: math.MaxInt64, PublicKey: bob.PublicKey},
+			{Value: math.MaxInt64, PublicKey: bob.PublicKey},
+		},
+	}
+	tx.ID = tx.HashTransaction()
+	tx.Input[0].Signature = tx.Sign(alice.GetPrivateKey())
+
+	if _, err := bc.AddBlock([]*Transaction{tx}, validator); !errors.Is(err, ErrTransactionRejected) {
+		t.Fatalf("AddBlock of overflowing outputs = %v, want %v", err, ErrTransactionRejected)
+	}
+	if _, err := bc.Simulate(tx); err == nil {
+		t.Fatal("Simulate of overflowing outputs succeeded")
+	}
+	if got := bc.GetBalance(bob.PublicKey); got != 0 {
+		t.Fatalf("balance = %d, want 0", got)
+	}
+}
@@ -0,0 +1,107 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Limits on client-submitted Bloom filters, so a filter cannot be used to
+// make the node do unbounded work.
+const (
+	MaxBloomFilterSize = 36000 // Maximum filter size in bytes
+	MaxBloomHashFuncs  = 50    // Maximum number of hash functions
+)
+
+// BloomFilter is a client-submitted probabilistic set of public keys.
+// A light client loads the keys it is interested in (plus, optionally, decoys)
+// and the node only returns the transactions matching the filter.
+type BloomFilter struct {
+	Bits      []byte // Filter bit array
+	HashFuncs uint32 // Number of hash functions
+	Tweak     uint32 // Random value chosen by the client to vary bit positions
+}
+
+// NewBloomFilter creates an empty filter of size bytes using hashFuncs hash functions.
+func NewBloomFilter(size int, hashFuncs uint32, tweak uint32) *BloomFilter {
+	return &BloomFilter{
+		Bits:      make([]byte, size),
+		HashFuncs: hashFuncs,
+		Tweak:     tweak,
+	}
+}
+
+// Validate checks that the filter respects the size limits.
+func (bf *BloomFilter) Validate() error {
+	if len(bf.Bits) == 0 || len(bf.Bits) > MaxBloomFilterSize {
+		return errors.New("invalid bloom filter size")
+	}
+	if bf.HashFuncs == 0 || bf.HashFuncs > MaxBloomHashFuncs {
+		return errors.New("invalid number of bloom filter hash functions")
+	}
+	return nil
+}
+
+// bitIndex returns the bit selected by the i-th hash function for data.
+func (bf *BloomFilter) bitIndex(i uint32, data []byte) uint32 {
+	seed := make([]byte, 8)
+	binary.BigEndian.PutUint32(seed[:4], bf.Tweak)
+	binary.BigEndian.PutUint32(seed[4:], i)
+
+	sum := sha256.Sum256(append(seed, data...))
+	return binary.BigEndian.Uint32(sum[:4]) % uint32(len(bf.Bits)*8)
+}
+
+// Add inserts data into the filter.
+func (bf *BloomFilter) Add(data []byte) {
+	for i := uint32(0); i < bf.HashFuncs; i++ {
+		index := bf.bitIndex(i, data)
+		bf.Bits[index/8] |= 1 << (index % 8)
+	}
+}
+
+// Contains reports whether data may have been added to the filter.
+func (bf *BloomFilter) Contains(data []byte) bool {
+	for i := uint32(0); i < bf.HashFuncs; i++ {
+		index := bf.bitIndex(i, data)
+		if bf.Bits[index/8]&(1<<(index%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchTransaction reports whether any public key touched by tx matches the filter.
+func (bf *BloomFilter) MatchTransaction(tx *Transaction) bool {
+	for _, input := range tx.Input {
+		if bf.Contains(input.PublicKey) {
+			return true
+		}
+	}
+	for _, output := range tx.Output {
+		if bf.Contains(output.PublicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilteredBlock is a block header together with only the transactions that
+// matched a Bloom filter, each with its Merkle inclusion proof.
+type FilteredBlock struct {
+	Header       *BlockHeader
+	Transactions []*Transaction
+	Proofs       []*MerkleProof
+}
+
+// NewFilteredBlock filters the transactions of a block.
+func NewFilteredBlock(block *Block, filter *BloomFilter) *FilteredBlock {
+	fb := &FilteredBlock{Header: block.Header()}
+	for i, tx := range block.Transactions {
+		if filter.MatchTransaction(tx) {
+			fb.Transactions = append(fb.Transactions, tx)
+			fb.Proofs = append(fb.Proofs, NewMerkleProof(block, i))
+		}
+	}
+	return fb
+}
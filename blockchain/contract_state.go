@@ -0,0 +1,590 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// Receipt statuses.
+const (
+	ReceiptFailed  = 0 // The transaction was included but had no effect
+	ReceiptSuccess = 1
+)
+
+// Receipt records the outcome of a transaction included in the chain, or of
+// a scheduled run (see Schedule). Every transaction has one; transfers
+// always succeed and use no gas. A
+// failed contract transaction is still part of its block: its nonce is
+// consumed, but it has no effect on contract state and emits no logs.
+type Receipt struct {
+	TxID        string           `json:"txId"` // Hex encoded transaction ID
+	BlockHash   string           `json:"blockHash"`
+	BlockHeight int              `json:"blockHeight"`
+	Index       int              `json:"index"`              // Position of the transaction in its block, or of the run among the block's scheduled runs
+	Schedule    string           `json:"schedule,omitempty"` // For a scheduled run, the ID of its schedule
+	Status      int              `json:"status"`             // ReceiptSuccess or ReceiptFailed
+	Contract    string           `json:"contract,omitempty"` // Address of the deployed or called contract
+	Touched     []string         `json:"touched,omitempty"`  // Contracts the transaction changed, including through nested calls
+	Error       string           `json:"error,omitempty"`    // Why the transaction failed
+	GasUsed     uint64           `json:"gasUsed"`
+	Fee         int64            `json:"fee,omitempty"` // Fee the transaction or scheduled run paid the validator (see ContractTx.Fee and Schedule)
+	ReturnValue *contracts.Value `json:"returnValue"`
+	Logs        []contracts.Log  `json:"logs"`
+}
+
+// Succeeded reports whether the transaction took effect.
+func (r *Receipt) Succeeded() bool {
+	return r.Status == ReceiptSuccess
+}
+
+// ContractSet is the contract state derived from the chain: every deployed
+// contract, the nonce of every account that sent contract transactions, the
+// pending schedules, the receipt of every transaction and scheduled run and
+// the rent charged at every collection. Like the UTXO set, it is reverted
+// block by block when the chain reorganizes (see Blockchain.disconnectTip).
+//
+// The storage and account of every contract are also kept in a state tree,
+// whose root each block header commits to.
+type ContractSet struct {
+	Contracts   map[string]*contracts.SmartContract // key = contract address
+	Nonces      map[string]uint64                   // key = sender address
+	Receipts    map[string]*Receipt                 // key = hex transaction ID, or ScheduledRunID
+	Schedules   map[string]*Schedule                // key = schedule ID
+	Runs        map[int][]string                    // key = block height; receipt IDs of the block's scheduled runs, in order
+	RentCharges map[int][]RentCharge                // key = block height of a rent collection
+	State       *StateTree                          // Contract storage and accounts
+
+	accounts map[string][]byte // Account leaf of every contract in State, key = contract address
+	tracer   *contracts.Tracer // Traces the contract calls executed; nil if not tracing
+	deadline time.Time         // Wall-clock deadline of the contract calls executed; zero outside simulations
+}
+
+// NewContractSet creates an empty contract set whose state tree keeps its
+// nodes in nodes.
+func NewContractSet(nodes NodeStore) *ContractSet {
+	return &ContractSet{
+		Contracts:   make(map[string]*contracts.SmartContract),
+		Nonces:      make(map[string]uint64),
+		Receipts:    make(map[string]*Receipt),
+		Schedules:   make(map[string]*Schedule),
+		Runs:        make(map[int][]string),
+		RentCharges: make(map[int][]RentCharge),
+		State:       NewStateTree(nodes),
+		accounts:    make(map[string][]byte),
+	}
+}
+
+// Check verifies that the contract transactions of a block can be applied in
+// order: each one must carry its sender's next nonce.
+func (cs *ContractSet) Check(transactions []*Transaction) error {
+	nonces := make(map[string]uint64)
+	for _, tx := range transactions {
+		if tx.Contract == nil {
+			continue
+		}
+		sender := tx.Contract.SenderAddress()
+		expected, ok := nonces[sender]
+		if !ok {
+			expected = cs.Nonces[sender]
+		}
+		if tx.Contract.Nonce != expected {
+			return fmt.Errorf("transaction %x has nonce %d, expected %d", tx.ID, tx.Contract.Nonce, expected)
+		}
+		nonces[sender] = expected + 1
+	}
+	return nil
+}
+
+// Apply collects rent if the block is at a collection height, executes the
+// scheduled runs due at the block, then its contract transactions, which
+// must have passed Check, and records the receipt of every run and
+// transaction of the block. A contract transaction or run that fails leaves
+// contract state unchanged, except for the gas a run uses. Finally the
+// account leaves of the contracts the block changed are updated.
+func (cs *ContractSet) Apply(block *Block) {
+	cs.collectRent(block)
+	cs.runSchedules(block)
+	timestamp, _ := block.Time()
+	for i, tx := range block.Transactions {
+		receipt := newReceipt(block, i)
+		cs.Receipts[receipt.TxID] = receipt
+		cs.execute(tx, block.Height, timestamp, receipt)
+	}
+	if err := cs.updateAccounts(); err != nil {
+		panic(fmt.Sprintf("error updating the account leaves: %v", err))
+	}
+}
+
+// updateAccounts updates the account leaf of every contract whose balance,
+// code, owner, frozen flag, archive or schedules changed (see AccountSlot).
+func (cs *ContractSet) updateAccounts() error {
+	schedules := make(map[string][]byte)
+	for _, id := range slices.Sorted(maps.Keys(cs.Schedules)) {
+		schedule := cs.Schedules[id]
+		data, err := json.Marshal(schedule)
+		if err != nil {
+			return err
+		}
+		schedules[schedule.Contract] = append(schedules[schedule.Contract], data...)
+	}
+
+	for _, address := range slices.Sorted(maps.Keys(cs.Contracts)) {
+		var schedulesHash []byte
+		if data, ok := schedules[address]; ok {
+			hash := sha256.Sum256(data)
+			schedulesHash = hash[:]
+		}
+		account := encodeAccount(cs.Contracts[address], schedulesHash)
+		if bytes.Equal(account, cs.accounts[address]) {
+			continue
+		}
+		if err := cs.State.Update(AccountSlot(address), account); err != nil {
+			return err
+		}
+		cs.accounts[address] = account
+	}
+	return nil
+}
+
+// newReceipt creates the receipt of the transaction at index i of a block,
+// successful until execution says otherwise.
+func newReceipt(block *Block, i int) *Receipt {
+	return &Receipt{
+		TxID:        hex.EncodeToString(block.Transactions[i].ID),
+		BlockHash:   hex.EncodeToString(block.Hash),
+		BlockHeight: block.Height,
+		Index:       i,
+		Status:      ReceiptSuccess,
+	}
+}
+
+// execute runs a contract transaction included at the given height and
+// fills in its receipt. Other transactions are left alone.
+func (cs *ContractSet) execute(tx *Transaction, height int, timestamp time.Time, receipt *Receipt) {
+	if tx.Contract == nil {
+		return
+	}
+
+	sender := tx.Contract.SenderAddress()
+	nonce := cs.Nonces[sender]
+	cs.Nonces[sender] = nonce + 1
+	receipt.Fee = tx.Contract.Fee()
+
+	var err error
+	switch tx.Contract.Kind {
+	case ContractDeploy:
+		receipt.Contract = ContractAddress(sender, nonce)
+		err = cs.deploy(receipt.Contract, tx.Contract, sender, height, timestamp, receipt.TxID)
+		if err == nil {
+			receipt.Touched = []string{receipt.Contract}
+		}
+	case ContractCall:
+		receipt.Contract = tx.Contract.Contract
+		err = cs.call(tx.Contract, sender, height, receipt)
+	case ContractUpgrade, ContractFreeze:
+		receipt.Contract = tx.Contract.Contract
+		err = cs.upgrade(tx.Contract, sender, height, receipt)
+		if err == nil {
+			receipt.Touched = []string{receipt.Contract}
+		}
+	case ContractSchedule:
+		receipt.Contract = tx.Contract.Contract
+		err = cs.schedule(tx.Contract, sender, height, receipt.TxID)
+		if err == nil {
+			receipt.Touched = []string{receipt.Contract}
+		}
+	case ContractCancelSchedule:
+		receipt.Contract = tx.Contract.Contract
+		err = cs.cancelSchedule(tx.Contract, sender)
+		if err == nil {
+			receipt.Touched = []string{receipt.Contract}
+		}
+	case ContractRestore:
+		receipt.Contract = tx.Contract.Contract
+		err = cs.restore(tx.Contract)
+		if err == nil {
+			receipt.Touched = []string{receipt.Contract}
+		}
+	}
+
+	if err != nil {
+		receipt.Status = ReceiptFailed
+		receipt.Error = err.Error()
+		receipt.Logs = nil
+	}
+}
+
+// deploy creates a contract at address, owned by the payload's owner or,
+// if it has none, by the sender.
+func (cs *ContractSet) deploy(address string, payload *ContractTx, sender string, height int, timestamp time.Time, txID string) error {
+	if _, ok := cs.Contracts[address]; ok {
+		return fmt.Errorf("a contract is already deployed at %s", address)
+	}
+	contract := contracts.NewSmartContract(address, payload.Code)
+	contract.CreatedAt = timestamp
+	contract.ABI = payload.ABI
+	contract.Source = payload.Source
+	contract.Owner = payload.Owner
+	if contract.Owner == "" {
+		contract.Owner = sender
+	}
+	if err := contract.Validate(); err != nil {
+		return err
+	}
+	contract.Versions = []contracts.ContractVersion{{
+		Version:  1,
+		Code:     payload.Code,
+		CodeHash: contracts.CodeHash(payload.Code),
+		ABI:      payload.ABI,
+		Height:   height,
+		TxID:     txID,
+	}}
+	cs.Contracts[address] = contract
+	return nil
+}
+
+// upgrade replaces the code of a contract or freezes it, if the sender is
+// authorized to (see authorize).
+func (cs *ContractSet) upgrade(payload *ContractTx, sender string, height int, receipt *Receipt) error {
+	contract, ok := cs.Contracts[payload.Contract]
+	if !ok {
+		return fmt.Errorf("no contract deployed at %s", payload.Contract)
+	}
+	if contract.Frozen {
+		return contracts.ErrContractFrozen
+	}
+	if err := cs.authorize(contract, payload, sender, height, receipt); err != nil {
+		return err
+	}
+
+	upgraded := contract.Copy()
+	if payload.Kind == ContractFreeze {
+		upgraded.Frozen = true
+	} else if err := upgraded.Upgrade(payload.Code, payload.ABI, height, receipt.TxID); err != nil {
+		return err
+	}
+	cs.Contracts[payload.Contract] = upgraded
+	return nil
+}
+
+// authorize checks that the sender may upgrade or freeze a contract: either
+// the sender is its owner, or its owner is a governance contract that
+// approves the change. The governance contract votes in a call to
+// GovernanceApproveUpgrade or GovernanceApproveFreeze made by the sender,
+// with the payload's gas limit, on a copy of its state that is discarded.
+func (cs *ContractSet) authorize(contract *contracts.SmartContract, payload *ContractTx, sender string, height int, receipt *Receipt) error {
+	if sender == contract.Owner {
+		return nil
+	}
+	if _, ok := cs.Contracts[contract.Owner]; !ok {
+		return fmt.Errorf("only the owner %s can change contract %s", contract.Owner, contract.ID)
+	}
+
+	ctx := &contracts.CallContext{
+		Caller:      sender,
+		Method:      GovernanceApproveFreeze,
+		Args:        []contracts.Value{contracts.BytesValue([]byte(contract.ID))},
+		GasLimit:    payload.GasLimit,
+		BlockHeight: height,
+		Tracer:      cs.tracer,
+		Deadline:    cs.deadline,
+	}
+	if payload.Kind == ContractUpgrade {
+		ctx.Method = GovernanceApproveUpgrade
+		ctx.Args = append(ctx.Args, contracts.BytesValue([]byte(contracts.CodeHash(payload.Code))))
+	}
+	vote, err := contracts.NewCallTree(cs.lookup).Call(contract.Owner, ctx)
+	receipt.GasUsed = vote.GasUsed
+	if err != nil {
+		return fmt.Errorf("governance vote of %s failed: %w", contract.Owner, err)
+	}
+	if vote.ReturnValue == nil || vote.ReturnValue.IsBytes || vote.ReturnValue.Int == 0 {
+		return fmt.Errorf("governance contract %s did not approve the change", contract.Owner)
+	}
+	return nil
+}
+
+// call executes a contract call, together with the calls it makes to other
+// contracts. The contracts it changes are only updated if the whole call
+// succeeds.
+func (cs *ContractSet) call(payload *ContractTx, sender string, height int, receipt *Receipt) error {
+	return cs.callTree(payload.Contract, &contracts.CallContext{
+		Caller:      sender,
+		Value:       payload.Value,
+		Method:      payload.Method,
+		Args:        payload.Args,
+		GasLimit:    payload.GasLimit,
+		BlockHeight: height,
+		Tracer:      cs.tracer,
+		Deadline:    cs.deadline,
+	}, receipt)
+}
+
+// callTree executes the root call of a call tree on the contract at address
+// and fills in the receipt's gas, return value, logs and touched contracts.
+// If the call succeeds, the schedules the contracts registered are added
+// with IDs derived from the receipt's (see ContractScheduleID).
+func (cs *ContractSet) callTree(address string, ctx *contracts.CallContext, receipt *Receipt) error {
+	tree := contracts.NewCallTree(cs.lookup)
+	execution, err := tree.Call(address, ctx)
+	receipt.GasUsed = execution.GasUsed
+	receipt.ReturnValue = execution.ReturnValue
+	receipt.Logs = execution.Logs
+	if err != nil {
+		return err
+	}
+
+	root := cs.State.root
+	for _, contract := range tree.Touched() {
+		if err := cs.updateState(cs.Contracts[contract.ID], contract); err != nil {
+			cs.State.root = root
+			return err
+		}
+	}
+	for _, contract := range tree.Touched() {
+		cs.Contracts[contract.ID] = contract
+		receipt.Touched = append(receipt.Touched, contract.ID)
+	}
+	for i, call := range tree.Scheduled() {
+		id := ContractScheduleID(receipt.TxID, i)
+		cs.Schedules[id] = &Schedule{
+			ID:         id,
+			Contract:   call.Contract,
+			Method:     call.Method,
+			Args:       call.Args,
+			GasLimit:   call.GasLimit,
+			NextHeight: call.Height,
+			Interval:   call.Interval,
+			RunsLeft:   call.Runs,
+		}
+	}
+	return nil
+}
+
+// updateState records the storage changes of a contract in the state tree.
+func (cs *ContractSet) updateState(before, after *contracts.SmartContract) error {
+	for _, change := range contracts.DiffState(before.State, after.State) {
+		var value []byte
+		if change.After != nil {
+			value = encodeStateValue(*change.After)
+		}
+		if err := cs.State.Update(StorageSlot(after.ID, change.Key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rootAfter returns the state root the contract set would have after
+// applying a block, which must have passed Check, without changing cs.
+func (cs *ContractSet) rootAfter(block *Block) []byte {
+	next := cs.overlay()
+	next.Apply(block)
+	return next.State.Root()
+}
+
+// lookup returns the contract deployed at address.
+func (cs *ContractSet) lookup(address string) (*contracts.SmartContract, bool) {
+	contract, ok := cs.Contracts[address]
+	return contract, ok
+}
+
+// checkSpending verifies the outputs the transactions of a block spend:
+// every transaction must spend unspent outputs of its own keys, in the chain
+// or created by an earlier transaction of the block, and conserve every
+// asset (see spentBy), no output may be spent twice in the block, and
+// contract transactions must pay their fee and value exactly: their native
+// coin inputs must be worth the fee and value more than their change.
+func (us *UTXOSet) checkSpending(transactions []*Transaction) error {
+	spentInBlock := make(map[string]bool)
+	created := make(map[string]*UTXO)
+	for _, tx := range transactions {
+		spent, err := us.spentBy(tx, created)
+		if err != nil {
+			return fmt.Errorf("transaction %x: %v", tx.ID, err)
+		}
+
+		for _, utxo := range spent {
+			key := fmt.Sprintf("%x_%d", utxo.TransactionID, utxo.OutputIndex)
+			if spentInBlock[key] {
+				return fmt.Errorf("transaction %x spends output %s, already spent in the block", tx.ID, key)
+			}
+			spentInBlock[key] = true
+		}
+		for i, output := range tx.Output {
+			created[fmt.Sprintf("%x_%d", tx.ID, i)] = &UTXO{
+				TransactionID: tx.ID,
+				OutputIndex:   i,
+				Value:         output.Value,
+				PublicKey:     output.PublicKey,
+				Asset:         output.Asset,
+			}
+		}
+
+		if tx.Contract == nil {
+			continue
+		}
+		if paid, due := nativePaid(tx, spent), tx.Contract.Value+tx.Contract.Fee(); paid != due {
+			return fmt.Errorf("transaction %x pays %d for a fee and value of %d", tx.ID, paid, due)
+		}
+	}
+	return nil
+}
+
+// nativePaid returns the value of the native coin outputs a transaction
+// spends less that of its native coin outputs.
+func nativePaid(tx *Transaction, spent []*UTXO) int64 {
+	var paid int64
+	for _, utxo := range spent {
+		if utxo.Asset == NativeAsset {
+			paid += int64(utxo.Value)
+		}
+	}
+	for _, output := range tx.Output {
+		if output.Asset == NativeAsset {
+			paid -= int64(output.Value)
+		}
+	}
+	return paid
+}
+
+// CallContract executes a contract call, including the calls it makes to
+// other contracts, against the current chain state without changing it.
+// ctx.Value is credited to the contract without being paid for. A call
+// without a deadline is given contracts.MaxCallDuration to run.
+func (bc *Blockchain) CallContract(address string, ctx *contracts.CallContext) (*contracts.ExecutionResult, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if ctx.Deadline.IsZero() {
+		ctx.Deadline = time.Now().Add(contracts.MaxCallDuration)
+	}
+	return contracts.NewCallTree(bc.Contracts.lookup).Call(address, ctx)
+}
+
+// ContractNonce returns the nonce the next contract transaction of an account must carry.
+func (bc *Blockchain) ContractNonce(address string) uint64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.Contracts.Nonces[address]
+}
+
+// GetContract returns a copy of the contract deployed at address, or false
+// if there is none.
+func (bc *Blockchain) GetContract(address string) (*contracts.SmartContract, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	contract, ok := bc.Contracts.Contracts[address]
+	if !ok {
+		return nil, false
+	}
+	return contract.Copy(), true
+}
+
+// StorageProof returns the value of a contract's storage entry, nil if the
+// entry is unset, and a proof of it against the state root of the chain's tip.
+// Returns an error if no contract is deployed at address.
+func (bc *Blockchain) StorageProof(address string, key string) (*contracts.Value, *StateProof, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	contract, ok := bc.Contracts.Contracts[address]
+	if !ok {
+		return nil, nil, fmt.Errorf("no contract deployed at %s", address)
+	}
+	value, err := contract.Load(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	proof := &StateProof{Address: address, Key: key, BlockHash: tip.Hash, Height: tip.Height}
+	if value != nil {
+		proof.Value = encodeStateValue(*value)
+	}
+	if proof.Bitmap, proof.Siblings, err = bc.Contracts.State.Prove(StorageSlot(address, key)); err != nil {
+		return nil, nil, err
+	}
+	if !proof.Verify(tip.StateRoot) {
+		return nil, nil, errors.New("contract storage does not match the state root")
+	}
+	return value, proof, nil
+}
+
+// Receipt returns the receipt of a transaction included in the chain.
+func (bc *Blockchain) Receipt(txID []byte) (*Receipt, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	receipt, ok := bc.Contracts.Receipts[hex.EncodeToString(txID)]
+	return receipt, ok
+}
+
+// LogFilter selects contract logs. Empty fields match everything.
+type LogFilter struct {
+	Contract  string   // Address of the emitting contract
+	Topics    []string // Topics the log must all have, in any position
+	FromBlock int      // First block height to search
+	ToBlock   int      // Last block height to search; -1 for the tip
+}
+
+// LogEntry is a log together with where it was emitted.
+type LogEntry struct {
+	contracts.Log
+	BlockHeight int    `json:"blockHeight"`
+	TxID        string `json:"txId"`     // Transaction ID, or ScheduledRunID for a scheduled run
+	LogIndex    int    `json:"logIndex"` // Position of the log in its transaction's receipt
+}
+
+// Logs returns up to limit logs matching filter, in chain order.
+func (bc *Blockchain) Logs(filter LogFilter, limit int) []*LogEntry {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	to := filter.ToBlock
+	if to < 0 || to >= len(bc.Blocks) {
+		to = len(bc.Blocks) - 1
+	}
+
+	var entries []*LogEntry
+	for height := max(filter.FromBlock, 0); height <= to; height++ {
+		for _, receipt := range bc.Contracts.blockReceipts(bc.Blocks[height]) {
+			for i, log := range receipt.Logs {
+				if (filter.Contract != "" && log.Contract != filter.Contract) || !hasTopics(log, filter.Topics) {
+					continue
+				}
+				if len(entries) >= limit {
+					return entries
+				}
+				entries = append(entries, &LogEntry{
+					Log:         log,
+					BlockHeight: height,
+					TxID:        receipt.TxID,
+					LogIndex:    i,
+				})
+			}
+		}
+	}
+	return entries
+}
+
+// hasTopics reports whether a log has every one of topics.
+func hasTopics(log contracts.Log, topics []string) bool {
+	for _, topic := range topics {
+		if !slices.Contains(log.Topics, topic) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,498 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// MaxContractGasLimit caps the gas a single contract transaction may request.
+const MaxContractGasLimit = contracts.MaxGasLimit
+
+// Gas fees of contract transactions (see ContractTx.Fee).
+const (
+	GasPrice          = 1    // Native coin paid for each unit of gas
+	ContractTxGas     = 1000 // Intrinsic gas of every contract transaction
+	ContractTxByteGas = 1    // Intrinsic gas per byte of deployed code and restored state
+)
+
+// ErrInsufficientFunds is returned when a wallet's UTXOs cannot pay for a
+// contract transaction's fee and value.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// ContractTxKind distinguishes contract deployments, calls, upgrades,
+// schedules and restorations.
+type ContractTxKind int
+
+// Contract transaction kinds.
+const (
+	ContractDeploy         ContractTxKind = iota + 1 // Deploys Code at a new address
+	ContractCall                                     // Calls Method on the contract at Contract
+	ContractUpgrade                                  // Replaces the code of the contract at Contract with Code
+	ContractFreeze                                   // Makes the code of the contract at Contract final
+	ContractSchedule                                 // Schedules calls of Method on the contract at Contract
+	ContractCancelSchedule                           // Cancels the schedule Schedule of the contract at Contract
+	ContractRestore                                  // Restores the archived state of the contract at Contract from State
+)
+
+// Methods a governance contract owning other contracts implements to vote
+// on their upgrades (see ContractSet.authorize). Both take the address of
+// the owned contract; approveUpgrade also takes the hex hash of the new code
+// (see contracts.CodeHash). They return a nonzero integer to approve.
+const (
+	GovernanceApproveUpgrade = "approveUpgrade"
+	GovernanceApproveFreeze  = "approveFreeze"
+)
+
+// ContractTx is the contract payload of a transaction. Contract transactions
+// are authorized by the sender's signature and ordered by the sender's nonce,
+// which must match the number of contract transactions the sender has
+// already had included in the chain.
+//
+// Every contract transaction pays its fee (see Fee), and calls and
+// restorations the value they send to the contract, with the transaction's
+// inputs, which must be the sender's outputs and be worth exactly Value plus
+// the fee more than the outputs, which can only return change to the
+// sender. The fee goes to the validator of the block including the
+// transaction, even if it fails; the value of a failed transaction is
+// refunded to the sender (see Blockchain.UpdateUTXOs).
+type ContractTx struct {
+	Kind      ContractTxKind
+	Sender    []byte            // Public key of the sending account (X || Y)
+	Nonce     uint64            // Sender's account nonce
+	Code      string            // Deploy, upgrade: bytecode or WebAssembly module, hex encoded
+	ABI       *contracts.ABI    // Deploy, upgrade: the contract's methods and events; may be nil
+	Source    string            // Deploy: contract language source Code was compiled from; may be empty
+	Owner     string            // Deploy: the contract's owner; the sender if empty
+	Contract  string            // Call, upgrade, freeze, schedule, cancel, restore: address of the contract
+	Method    string            // Call, schedule: method to call
+	Args      []contracts.Value // Call, schedule: arguments
+	GasLimit  uint64            // Call, schedule: maximum gas each call may consume; upgrade, freeze: gas for the governance vote
+	Value     int64             // Call, restore: amount sent to the contract
+	Height    int               // Schedule: height of the block of the first run
+	Interval  int               // Schedule: blocks between runs; 0 for a single run
+	Runs      int               // Schedule: number of runs
+	Schedule  string            // Cancel: ID of the schedule to cancel
+	State     []StateEntry      // Restore: every entry of the archived state
+	Signature []byte            // Sender's signature over the transaction
+}
+
+// NewDeployTransaction creates a signed transaction deploying code.
+// Parameters:
+//   - wallet: The deploying account
+//   - nonce: The account's current nonce (see Blockchain.ContractNonce)
+//   - code: Contract bytecode or WebAssembly module, hex encoded
+//   - abi: The contract's ABI, or nil to deploy the contract without one
+//   - source: The contract language source code was compiled from, or "";
+//     it must compile to code and abi (see contracts.SmartContract.VerifySource)
+//   - owner: Address of the account or governance contract that may
+//     upgrade the contract, or "" for the wallet
+//   - utxos: The wallet's UTXOs to pay the fee with, in the native coin
+//
+// The contract is deployed at ContractAddress(wallet.Address, nonce).
+// Returns nil and an error wrapping ErrInsufficientFunds if the UTXOs do not
+// cover the fee; the same holds for every contract transaction constructor.
+func NewDeployTransaction(wallet *Wallet, nonce uint64, code string, abi *contracts.ABI, source string, owner string, utxos []*UTXO) (*Transaction, error) {
+	return newContractTransaction(wallet, &ContractTx{
+		Kind:   ContractDeploy,
+		Nonce:  nonce,
+		Code:   code,
+		ABI:    abi,
+		Source: source,
+		Owner:  owner,
+	}, utxos)
+}
+
+// NewUpgradeTransaction creates a signed transaction replacing the code of a
+// contract, which keeps its state and balance.
+// Parameters:
+//   - wallet: The contract's owner, or any account if the owner is a
+//     governance contract
+//   - nonce: The account's current nonce (see Blockchain.ContractNonce)
+//   - contract: Address of the contract to upgrade
+//   - code, abi: The new code, hex encoded, and its ABI, which may be nil
+//   - gasLimit: Gas for the governance contract's vote; unused if wallet
+//     is the owner, but paid for like any gas limit
+//   - utxos: The wallet's UTXOs to pay the fee with, in the native coin
+func NewUpgradeTransaction(wallet *Wallet, nonce uint64, contract string, code string, abi *contracts.ABI, gasLimit uint64, utxos []*UTXO) (*Transaction, error) {
+	return newContractTransaction(wallet, &ContractTx{
+		Kind:     ContractUpgrade,
+		Nonce:    nonce,
+		Contract: contract,
+		Code:     code,
+		ABI:      abi,
+		GasLimit: gasLimit,
+	}, utxos)
+}
+
+// NewFreezeTransaction creates a signed transaction permanently freezing the
+// code of a contract. Its parameters are those of NewUpgradeTransaction.
+func NewFreezeTransaction(wallet *Wallet, nonce uint64, contract string, gasLimit uint64, utxos []*UTXO) (*Transaction, error) {
+	return newContractTransaction(wallet, &ContractTx{
+		Kind:     ContractFreeze,
+		Nonce:    nonce,
+		Contract: contract,
+		GasLimit: gasLimit,
+	}, utxos)
+}
+
+// NewScheduleTransaction creates a signed transaction scheduling calls of a
+// contract method at future block heights (see Schedule). The contract
+// prepays the gas of every run from its balance.
+// Parameters:
+//   - wallet: The contract's owner
+//   - nonce: The account's current nonce (see Blockchain.ContractNonce)
+//   - contract: Address of the contract to call
+//   - method, args: The method to call and its arguments
+//   - gasLimit: Maximum gas each run may consume
+//   - height: Height of the block of the first run, above the chain's tip
+//   - interval: Blocks between runs, or 0 for a single run
+//   - runs: Number of runs; 1 if interval is 0
+//   - utxos: The wallet's UTXOs to pay the transaction's fee with, in the
+//     native coin
+func NewScheduleTransaction(wallet *Wallet, nonce uint64, contract string, method string, args []contracts.Value, gasLimit uint64, height int, interval int, runs int, utxos []*UTXO) (*Transaction, error) {
+	return newContractTransaction(wallet, &ContractTx{
+		Kind:     ContractSchedule,
+		Nonce:    nonce,
+		Contract: contract,
+		Method:   method,
+		Args:     args,
+		GasLimit: gasLimit,
+		Height:   height,
+		Interval: interval,
+		Runs:     runs,
+	}, utxos)
+}
+
+// NewCancelScheduleTransaction creates a signed transaction cancelling a
+// schedule of a contract, whose prepaid gas is refunded to the contract.
+// wallet must be the contract's owner; it pays the fee from utxos.
+func NewCancelScheduleTransaction(wallet *Wallet, nonce uint64, contract string, schedule string, utxos []*UTXO) (*Transaction, error) {
+	return newContractTransaction(wallet, &ContractTx{
+		Kind:     ContractCancelSchedule,
+		Nonce:    nonce,
+		Contract: contract,
+		Schedule: schedule,
+	}, utxos)
+}
+
+// NewRestoreTransaction creates a signed transaction restoring the archived
+// state of a contract (see Blockchain.ArchivedState), which anyone can send.
+// Parameters:
+//   - wallet: The restoring account
+//   - nonce: The account's current nonce (see Blockchain.ContractNonce)
+//   - contract: Address of the archived contract
+//   - state: Every entry of the archived state
+//   - value: Amount to send to the contract for its rent
+//   - utxos: The wallet's UTXOs to pay the fee and value with, in the native coin
+//
+// Returns nil and an error wrapping ErrInsufficientFunds if the UTXOs do not
+// cover the fee and value.
+func NewRestoreTransaction(wallet *Wallet, nonce uint64, contract string, state []StateEntry, value int64, utxos []*UTXO) (*Transaction, error) {
+	return newContractTransaction(wallet, &ContractTx{
+		Kind:     ContractRestore,
+		Nonce:    nonce,
+		Contract: contract,
+		State:    state,
+		Value:    value,
+	}, utxos)
+}
+
+// NewCallTransaction creates a signed transaction calling a contract method.
+// Parameters:
+//   - wallet: The calling account
+//   - nonce: The account's current nonce (see Blockchain.ContractNonce)
+//   - contract: Address of the contract to call
+//   - method, args: The method to call and its arguments
+//   - gasLimit: Maximum gas the call may consume
+//   - value: Amount to send to the contract
+//   - utxos: The wallet's UTXOs to pay the fee and value with, in the native coin
+//
+// Returns nil and an error wrapping ErrInsufficientFunds if the UTXOs do not
+// cover the fee and value.
+func NewCallTransaction(wallet *Wallet, nonce uint64, contract string, method string, args []contracts.Value, gasLimit uint64, value int64, utxos []*UTXO) (*Transaction, error) {
+	return newContractTransaction(wallet, &ContractTx{
+		Kind:     ContractCall,
+		Nonce:    nonce,
+		Contract: contract,
+		Method:   method,
+		Args:     args,
+		GasLimit: gasLimit,
+		Value:    value,
+	}, utxos)
+}
+
+// IntrinsicGas returns the gas a contract transaction pays for besides its
+// gas limit: ContractTxGas, plus ContractTxByteGas for each byte of code and
+// source it deploys and of state it restores.
+func (ct *ContractTx) IntrinsicGas() uint64 {
+	size := len(ct.Code)/2 + len(ct.Source)
+	for _, entry := range ct.State {
+		size += len(entry.Key) + len(entry.Value)
+	}
+	return ContractTxGas + uint64(size)*ContractTxByteGas
+}
+
+// Fee returns the fee a contract transaction pays to the validator of the
+// block including it: its intrinsic gas and gas limit at GasPrice. The whole
+// gas limit is paid for, whether the transaction uses it or not.
+func (ct *ContractTx) Fee() int64 {
+	return int64(ct.IntrinsicGas()+ct.GasLimit) * GasPrice
+}
+
+// contractOutputs returns the outputs a contract transaction creates besides
+// its change: the fee, paid to validator, and for a failed transaction the
+// value, refunded to the sender. They follow the transaction's own outputs,
+// at indices len(tx.Output) and len(tx.Output)+1, and are spent like them.
+func contractOutputs(tx *Transaction, validator []byte, succeeded bool) []*UTXO {
+	var outputs []*UTXO
+	if fee := tx.Contract.Fee(); fee > 0 {
+		outputs = append(outputs, &UTXO{
+			TransactionID: tx.ID,
+			OutputIndex:   len(tx.Output),
+			Value:         int(fee),
+			PublicKey:     validator,
+			Asset:         NativeAsset,
+		})
+	}
+	if !succeeded && tx.Contract.Value > 0 {
+		outputs = append(outputs, &UTXO{
+			TransactionID: tx.ID,
+			OutputIndex:   len(tx.Output) + 1,
+			Value:         int(tx.Contract.Value),
+			PublicKey:     tx.Contract.Sender,
+			Asset:         NativeAsset,
+		})
+	}
+	return outputs
+}
+
+// payValue adds inputs spending the wallet's native coin UTXOs to a contract
+// transaction paying value, and an output returning the change.
+// Returns an error wrapping ErrInsufficientFunds if the UTXOs do not cover value.
+func payValue(wallet *Wallet, tx *Transaction, value int64, utxos []*UTXO) error {
+	var total int64
+	for _, utxo := range utxos {
+		if total >= value {
+			break
+		}
+		if bytes.Equal(utxo.PublicKey, wallet.PublicKey) && utxo.Asset == NativeAsset {
+			total += int64(utxo.Value)
+			tx.Input = append(tx.Input, TxInput{
+				TransactionID: utxo.TransactionID,
+				OutputIndex:   utxo.OutputIndex,
+				PublicKey:     wallet.PublicKey,
+			})
+		}
+	}
+	if total < value {
+		return fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, total, value)
+	}
+	if total > value {
+		tx.Output = append(tx.Output, TxOutput{Value: int(total - value), PublicKey: wallet.PublicKey})
+	}
+	return nil
+}
+
+// newContractTransaction wraps a contract payload in a transaction signed by
+// wallet, paying its fee and value from utxos.
+func newContractTransaction(wallet *Wallet, payload *ContractTx, utxos []*UTXO) (*Transaction, error) {
+	tx := &Transaction{Contract: payload}
+	if err := payValue(wallet, tx, payload.Value+payload.Fee(), utxos); err != nil {
+		return nil, err
+	}
+	return signContractTransaction(wallet, tx), nil
+}
+
+// signContractTransaction signs a contract transaction and its inputs, which
+// must all belong to wallet.
+func signContractTransaction(wallet *Wallet, tx *Transaction) *Transaction {
+	tx.Contract.Sender = wallet.PublicKey
+	tx.ID = tx.HashTransaction()
+	tx.Contract.Signature = tx.Sign(wallet.GetPrivateKey())
+	for i := range tx.Input {
+		tx.Input[i].Signature = tx.Sign(wallet.GetPrivateKey())
+	}
+	return tx
+}
+
+// SenderAddress returns the address of the account sending the transaction.
+func (ct *ContractTx) SenderAddress() string {
+	return generateAddress(ct.Sender)
+}
+
+// ContractAddress derives the address of the contract deployed by deployer
+// with the given nonce. Addresses are deterministic, so every node assigns
+// the same address, and they have the same format as wallet addresses.
+func ContractAddress(deployer string, nonce uint64) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", deployer, nonce)))
+	return "0x" + hex.EncodeToString(hash[len(hash)-20:])
+}
+
+// hashData returns the fields of the payload covered by the transaction ID.
+func (ct *ContractTx) hashData() [][]byte {
+	data := [][]byte{
+		[]byte(fmt.Sprintf("%d", ct.Kind)),
+		ct.Sender,
+		[]byte(fmt.Sprintf("%d", ct.Nonce)),
+		[]byte(ct.Code),
+		[]byte(ct.Contract),
+		[]byte(ct.Method),
+	}
+	for _, arg := range ct.Args {
+		// Prefix each argument with its kind so that 1 and "1" differ
+		kind := "i"
+		if arg.IsBytes {
+			kind = "b"
+		}
+		data = append(data, []byte(fmt.Sprintf("%s%d:", kind, len(arg.Encode()))), arg.Encode())
+	}
+	data = append(data, []byte(fmt.Sprintf("%d", ct.GasLimit)))
+	if ct.Value != 0 {
+		data = append(data, []byte(fmt.Sprintf("value:%d", ct.Value)))
+	}
+	if ct.ABI != nil {
+		data = append(data, []byte("abi:"), ct.ABI.Encode())
+	}
+	if ct.Source != "" {
+		data = append(data, []byte("source:"), []byte(ct.Source))
+	}
+	if ct.Owner != "" {
+		data = append(data, []byte("owner:"+ct.Owner))
+	}
+	if ct.Height != 0 || ct.Interval != 0 || ct.Runs != 0 {
+		data = append(data, []byte(fmt.Sprintf("schedule:%d/%d/%d", ct.Height, ct.Interval, ct.Runs)))
+	}
+	if ct.Schedule != "" {
+		data = append(data, []byte("cancel:"+ct.Schedule))
+	}
+	for _, entry := range ct.State {
+		data = append(data, []byte(fmt.Sprintf("entry%d:%d:", len(entry.Key), len(entry.Value))), []byte(entry.Key), entry.Value)
+	}
+	return data
+}
+
+// trimmed returns a copy of the payload without its signature.
+func (ct *ContractTx) trimmed() *ContractTx {
+	trimmed := *ct
+	trimmed.Signature = nil
+	return &trimmed
+}
+
+// check performs the stateless validation of a contract transaction.
+func (ct *ContractTx) check(tx *Transaction) error {
+	if ct.Value < 0 {
+		return errors.New("call value cannot be negative")
+	}
+	if ct.Value > MaxValue-ct.Fee() {
+		return fmt.Errorf("call value and fee cannot exceed %d", MaxValue)
+	}
+	if ct.GasLimit > MaxContractGasLimit {
+		return fmt.Errorf("gas limit cannot exceed %d", MaxContractGasLimit)
+	}
+	if ct.Owner != "" && ct.Kind != ContractDeploy {
+		return errors.New("only deployments can set an owner")
+	}
+	if ct.Source != "" && ct.Kind != ContractDeploy {
+		return errors.New("only deployments can carry source")
+	}
+	if (ct.Height != 0 || ct.Interval != 0 || ct.Runs != 0) && ct.Kind != ContractSchedule {
+		return errors.New("only schedules can set a height, interval or runs")
+	}
+	if ct.Schedule != "" && ct.Kind != ContractCancelSchedule {
+		return errors.New("only cancellations can name a schedule")
+	}
+	if len(ct.State) != 0 && ct.Kind != ContractRestore {
+		return errors.New("only restorations can carry state")
+	}
+	if ct.Value > 0 && ct.Kind != ContractCall && ct.Kind != ContractRestore {
+		return errors.New("only calls and restorations can send value")
+	}
+	if len(tx.Input) == 0 {
+		return errors.New("contract transactions must spend outputs to pay their fee")
+	}
+	for i, input := range tx.Input {
+		if !bytes.Equal(input.PublicKey, ct.Sender) {
+			return fmt.Errorf("input %d does not belong to the sender", i)
+		}
+	}
+	for i, output := range tx.Output {
+		if !bytes.Equal(output.PublicKey, ct.Sender) {
+			return fmt.Errorf("output %d does not return change to the sender", i)
+		}
+	}
+	switch ct.Kind {
+	case ContractDeploy:
+		if ct.Code == "" {
+			return errors.New("contract code is required")
+		}
+		if ct.Source != "" {
+			deployed := &contracts.SmartContract{Code: ct.Code, ABI: ct.ABI}
+			if _, err := deployed.VerifySource(ct.Source, contracts.CompilerVersion); err != nil {
+				return fmt.Errorf("source: %w", err)
+			}
+		}
+	case ContractUpgrade, ContractFreeze:
+		if ct.Contract == "" {
+			return errors.New("contract is required")
+		}
+		if ct.Kind == ContractUpgrade && ct.Code == "" {
+			return errors.New("contract code is required")
+		}
+		if ct.Kind == ContractFreeze && (ct.Code != "" || ct.ABI != nil) {
+			return errors.New("freezes cannot carry code")
+		}
+	case ContractCall, ContractSchedule:
+		if ct.Contract == "" || ct.Method == "" {
+			return errors.New("contract and method are required")
+		}
+		if ct.GasLimit == 0 || ct.GasLimit > MaxContractGasLimit {
+			return fmt.Errorf("gas limit must be between 1 and %d", MaxContractGasLimit)
+		}
+		if ct.ABI != nil {
+			return errors.New("only deployments and upgrades can carry an ABI")
+		}
+		if ct.Kind == ContractSchedule {
+			return ct.checkSchedule()
+		}
+	case ContractCancelSchedule:
+		if ct.Contract == "" || ct.Schedule == "" {
+			return errors.New("contract and schedule are required")
+		}
+		if ct.Code != "" || ct.ABI != nil || ct.Method != "" || len(ct.Args) != 0 || ct.GasLimit != 0 {
+			return errors.New("cancellations carry no code, call or gas")
+		}
+	case ContractRestore:
+		if ct.Contract == "" {
+			return errors.New("contract is required")
+		}
+		if ct.Code != "" || ct.ABI != nil || ct.Method != "" || len(ct.Args) != 0 || ct.GasLimit != 0 {
+			return errors.New("restorations carry no code, call or gas")
+		}
+	default:
+		return fmt.Errorf("unknown contract transaction kind %d", ct.Kind)
+	}
+	return nil
+}
+
+// checkSchedule validates the runs of a schedule. Whether its first run is
+// above the chain's tip is checked when it is applied.
+func (ct *ContractTx) checkSchedule() error {
+	if ct.Height <= 0 {
+		return errors.New("schedule height must be positive")
+	}
+	if ct.Interval < 0 {
+		return errors.New("schedule interval cannot be negative")
+	}
+	if ct.Runs < 1 || ct.Runs > MaxScheduleRuns {
+		return fmt.Errorf("schedule runs must be between 1 and %d", MaxScheduleRuns)
+	}
+	if ct.Interval == 0 && ct.Runs != 1 {
+		return errors.New("schedules without an interval run once")
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// revertingCode succeeds when called with 0 and reverts otherwise.
+const revertingCode = "ARG 0\nJUMPI fail\nPUSH 7\nRETURN\nfail:\nPUSHB \"fail\"\nREVERT"
+
+// deployTestContract funds wallet and deploys revertingCode from it.
+// Returns the contract's address.
+func deployTestContract(t *testing.T, bc *Blockchain, validator *Wallet, wallet *Wallet) string {
+	t.Helper()
+	if _, err := bc.AddBlock([]*Transaction{mintTx(wallet, 10_000_000, bc.LastBlock().Height+1)}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+
+	code, err := contracts.Assemble(revertingCode)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	deploy, err := NewDeployTransaction(wallet, bc.ContractNonce(wallet.Address), hex.EncodeToString(code), nil, "", "", bc.GetUTXOsForAddress(wallet.PublicKey))
+	if err != nil {
+		t.Fatalf("NewDeployTransaction: %v", err)
+	}
+	if _, err := bc.AddBlock([]*Transaction{deploy}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	receipt, _ := bc.Receipt(deploy.ID)
+	return receipt.Contract
+}
+
+func TestContractTransactionsPayFees(t *testing.T) {
+	tests := []struct {
+		name      string
+		arg       int64
+		succeeded bool
+	}{
+		{"successful call", 0, true},
+		{"failed call", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := NewWallet()
+			alice := NewWallet()
+			bc := newTestChain()
+			address := deployTestContract(t, bc, validator, alice)
+
+			fees := bc.GetBalance(validator.PublicKey)
+			balance := bc.GetBalance(alice.PublicKey)
+			if fees == 0 || fees+balance != 10_000_000 {
+				t.Fatalf("deployment fee = %d, alice's balance = %d", fees, balance)
+			}
+
+			call, err := NewCallTransaction(alice, bc.ContractNonce(alice.Address), address, "run", []contracts.Value{contracts.IntValue(tt.arg)}, 5000, 300, bc.GetUTXOsForAddress(alice.PublicKey))
+			if err != nil {
+				t.Fatalf("NewCallTransaction: %v", err)
+			}
+			fee := call.Contract.Fee()
+			if fee != (ContractTxGas+5000)*GasPrice {
+				t.Fatalf("Fee = %d, want %d", fee, (ContractTxGas+5000)*GasPrice)
+			}
+			if _, err := bc.AddBlock([]*Transaction{call}, validator); err != nil {
+				t.Fatalf("AddBlock: %v", err)
+			}
+
+			receipt, _ := bc.Receipt(call.ID)
+			if receipt.Succeeded() != tt.succeeded || receipt.Fee != fee {
+				t.Fatalf("receipt = %+v, want success %v and fee %d", receipt, tt.succeeded, fee)
+			}
+			if got := bc.GetBalance(validator.PublicKey); got != fees+int(fee) {
+				t.Fatalf("validator balance = %d, want %d", got, fees+int(fee))
+			}
+			want := balance - int(fee)
+			if tt.succeeded {
+				want -= 300
+			}
+			if got := bc.GetBalance(alice.PublicKey); got != want {
+				t.Fatalf("caller balance = %d, want %d", got, want)
+			}
+
+			// The fee and refund outputs can be spent
+			spend, err := NewTransaction(validator, string(alice.PublicKey), int(fee), bc.GetUTXOsForAddress(validator.PublicKey))
+			if err != nil {
+				t.Fatalf("NewTransaction: %v", err)
+			}
+			if _, err := bc.AddBlock([]*Transaction{spend}, validator); err != nil {
+				t.Fatalf("AddBlock spending the fee: %v", err)
+			}
+		})
+	}
+}
+
+func TestContractTransactionsMustPayFees(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bc := newTestChain()
+	address := deployTestContract(t, bc, validator, alice)
+	nonce := bc.ContractNonce(alice.Address)
+	utxos := bc.GetUTXOsForAddress(alice.PublicKey)
+
+	unpaid := &Transaction{Contract: &ContractTx{Kind: ContractCall, Nonce: nonce, Contract: address, Method: "run", Args: []contracts.Value{contracts.IntValue(0)}, GasLimit: 5000}}
+	if _, err := bc.AddBlock([]*Transaction{signContractTransaction(alice, unpaid)}, validator); !errors.Is(err, ErrTransactionRejected) {
+		t.Fatalf("AddBlock of a call without fee = %v, want %v", err, ErrTransactionRejected)
+	}
+
+	underpaid := &Transaction{Contract: &ContractTx{Kind: ContractCall, Nonce: nonce, Contract: address, Method: "run", Args: []contracts.Value{contracts.IntValue(0)}, GasLimit: 5000}}
+	if err := payValue(alice, underpaid, underpaid.Contract.Fee()-1, utxos); err != nil {
+		t.Fatalf("payValue: %v", err)
+	}
+	if _, err := bc.AddBlock([]*Transaction{signContractTransaction(alice, underpaid)}, validator); !errors.Is(err, ErrTransactionRejected) {
+		t.Fatalf("AddBlock of an underpaid call = %v, want %v", err, ErrTransactionRejected)
+	}
+
+	poor := NewWallet()
+	if _, err := NewCallTransaction(poor, 0, address, "run", nil, 5000, 0, nil); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("NewCallTransaction without UTXOs = %v, want %v", err, ErrInsufficientFunds)
+	}
+}
+
+func TestCreateTokenPaysFeesFromOneOutput(t *testing.T) {
+	validator := NewWallet()
+	issuer := NewWallet()
+	bc := newTestChain()
+	if _, err := bc.AddBlock([]*Transaction{mintTx(issuer, 10_000_000, 1)}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+
+	// The init call spends the change of the deployment in the same block
+	txs, err := NewCreateTokenTransactions(issuer, 0, "Test", "TST", 0, "", 50, bc.GetUTXOsForAddress(issuer.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCreateTokenTransactions: %v", err)
+	}
+	if _, err := bc.AddBlock(txs, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	fees := txs[0].Contract.Fee() + txs[1].Contract.Fee()
+	if got := bc.GetBalance(validator.PublicKey); got != int(fees) {
+		t.Fatalf("validator balance = %d, want %d", got, fees)
+	}
+	if got := bc.GetBalance(issuer.PublicKey); got != 10_000_000-int(fees) {
+		t.Fatalf("issuer balance = %d, want %d", got, 10_000_000-int(fees))
+	}
+
+	// Reversed, the call would spend an output that does not exist yet
+	again, err := NewCreateTokenTransactions(issuer, 2, "Test", "TST", 0, "", 50, bc.GetUTXOsForAddress(issuer.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCreateTokenTransactions: %v", err)
+	}
+	if _, err := bc.AddBlock([]*Transaction{again[1], again[0]}, validator); !errors.Is(err, ErrTransactionRejected) {
+		t.Fatalf("AddBlock spending a later output = %v, want %v", err, ErrTransactionRejected)
+	}
+}
+
+func TestContractTransactionIDSeparatesFields(t *testing.T) {
+	alice := NewWallet()
+	original := signContractTransaction(alice, &Transaction{Contract: &ContractTx{Kind: ContractCall, Contract: "0xabcd", Method: "run", GasLimit: 5000}})
+
+	// Moving bytes from the contract to the method changes the ID and
+	// invalidates the signature
+	moved := *original
+	payload := *original.Contract
+	payload.Contract, payload.Method = "0xab", "cdrun"
+	moved.Contract = &payload
+	if bytes.Equal(moved.HashTransaction(), original.ID) {
+		t.Fatal("moving bytes between contract and method kept the transaction ID")
+	}
+	if !verifySignature(alice.PublicKey, original.TrimmedCopy().ID, original.Contract.Signature) {
+		t.Fatal("the original signature does not verify")
+	}
+	if verifySignature(alice.PublicKey, moved.TrimmedCopy().ID, original.Contract.Signature) {
+		t.Fatal("the signature of the original transaction verifies the altered one")
+	}
+}
+
+func TestDeployTransactionCarriesSource(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bc := newTestChain()
+	address := deployTicker(t, bc, validator, alice)
+	if contract, _ := bc.GetContract(address); contract.Source != tickerSource {
+		t.Fatalf("deployed source = %q, want the ticker's", contract.Source)
+	}
+
+	// Source that does not compile to the deployed code is rejected
+	compiled, err := contracts.Compile(tickerSource)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	other := strings.Replace(tickerSource, "ticks += by", "ticks -= by", 1)
+	deploy, err := NewDeployTransaction(alice, bc.ContractNonce(alice.Address), hex.EncodeToString(compiled.Bytecode), compiled.ABI, other, "", bc.GetUTXOsForAddress(alice.PublicKey))
+	if err != nil {
+		t.Fatalf("NewDeployTransaction: %v", err)
+	}
+	if err := deploy.Contract.check(deploy); !errors.Is(err, contracts.ErrSourceMismatch) {
+		t.Fatalf("check of mismatched source = %v, want %v", err, contracts.ErrSourceMismatch)
+	}
+	if _, err := bc.AddBlock([]*Transaction{deploy}, validator); err == nil {
+		t.Fatal("AddBlock of mismatched source succeeded")
+	}
+}
@@ -0,0 +1,202 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+// Golomb-coded set parameters. A false positive rate of 1/filterM for each
+// queried item keeps filters around 20 bits per item.
+const (
+	filterP = 19
+	filterM = 784931
+)
+
+// BlockFilter is a compact probabilistic summary of the public keys a block
+// touches (every output recipient and every input owner). Light clients
+// download filters and test their own keys locally, so the full node never
+// learns which addresses a client is interested in.
+//
+// The filter is a Golomb-Rice coded set: item hashes are mapped to
+// [0, N*filterM), sorted, and their deltas Golomb-Rice encoded.
+type BlockFilter struct {
+	Height    int    // Height of the block the filter summarizes
+	BlockHash []byte // Hash of the block the filter summarizes
+	Data      []byte // 4-byte big-endian item count followed by the encoded set
+}
+
+// blockFilterItems collects the distinct public keys touched by a block.
+func blockFilterItems(block *Block) [][]byte {
+	seen := make(map[string]bool)
+	var items [][]byte
+	add := func(key []byte) {
+		if len(key) == 0 || seen[string(key)] {
+			return
+		}
+		seen[string(key)] = true
+		items = append(items, key)
+	}
+
+	for _, tx := range block.Transactions {
+		for _, input := range tx.Input {
+			add(input.PublicKey)
+		}
+		for _, output := range tx.Output {
+			add(output.PublicKey)
+		}
+	}
+	return items
+}
+
+// NewBlockFilter builds the filter of a block.
+func NewBlockFilter(block *Block) *BlockFilter {
+	items := blockFilterItems(block)
+	n := uint64(len(items))
+
+	values := make([]uint64, len(items))
+	for i, item := range items {
+		values[i] = filterHash(block.Hash, item, n*filterM)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	w := &bitWriter{}
+	var last uint64
+	for _, v := range values {
+		delta := v - last
+		last = v
+
+		// Quotient in unary, remainder in filterP bits
+		for q := delta >> filterP; q > 0; q-- {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		w.writeBits(delta, filterP)
+	}
+
+	data := make([]byte, 4, 4+len(w.bytes))
+	binary.BigEndian.PutUint32(data, uint32(n))
+	return &BlockFilter{Height: block.Height, BlockHash: block.Hash, Data: append(data, w.bytes...)}
+}
+
+// filterHash maps an item uniformly onto [0, limit), keyed by the block hash
+// so that false positives are not correlated across blocks.
+func filterHash(blockHash []byte, item []byte, limit uint64) uint64 {
+	sum := sha256.Sum256(bytes.Join([][]byte{blockHash, item}, nil))
+	hi, _ := bits.Mul64(binary.BigEndian.Uint64(sum[:8]), limit)
+	return hi
+}
+
+// MatchAny reports whether any of the items may be in the filter.
+// False positives are possible; false negatives are not.
+func (f *BlockFilter) MatchAny(items [][]byte) (bool, error) {
+	if len(f.Data) < 4 {
+		return false, errors.New("truncated block filter")
+	}
+	n := uint64(binary.BigEndian.Uint32(f.Data[:4]))
+	if n == 0 || len(items) == 0 {
+		return false, nil
+	}
+
+	queries := make([]uint64, len(items))
+	for i, item := range items {
+		queries[i] = filterHash(f.BlockHash, item, n*filterM)
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i] < queries[j] })
+
+	// Walk the encoded set and the sorted queries together
+	r := &bitReader{data: f.Data[4:]}
+	var value uint64
+	qi := 0
+	for i := uint64(0); i < n; i++ {
+		var q uint64
+		for {
+			bit, err := r.readBit()
+			if err != nil {
+				return false, err
+			}
+			if bit == 0 {
+				break
+			}
+			q++
+		}
+		rem, err := r.readBits(filterP)
+		if err != nil {
+			return false, err
+		}
+		value += q<<filterP | rem
+
+		for qi < len(queries) && queries[qi] < value {
+			qi++
+		}
+		if qi == len(queries) {
+			return false, nil
+		}
+		if queries[qi] == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FilterHeader chains a filter to the header of the previous block's filter,
+// so a client can check that the filters it receives are consistent with
+// the filter header chain announced by the node. The genesis filter is
+// chained to 32 zero bytes.
+func FilterHeader(filter *BlockFilter, prevHeader []byte) []byte {
+	filterHash := sha256.Sum256(filter.Data)
+	header := sha256.Sum256(append(filterHash[:], prevHeader...))
+	return header[:]
+}
+
+// bitWriter accumulates a big-endian bit stream.
+type bitWriter struct {
+	bytes []byte
+	n     uint // Number of bits written
+}
+
+func (w *bitWriter) writeBit(bit uint64) {
+	if w.n%8 == 0 {
+		w.bytes = append(w.bytes, 0)
+	}
+	if bit != 0 {
+		w.bytes[len(w.bytes)-1] |= 0x80 >> (w.n % 8)
+	}
+	w.n++
+}
+
+func (w *bitWriter) writeBits(v uint64, count uint) {
+	for i := count; i > 0; i-- {
+		w.writeBit(v >> (i - 1) & 1)
+	}
+}
+
+// bitReader consumes a big-endian bit stream.
+type bitReader struct {
+	data []byte
+	n    uint // Number of bits read
+}
+
+func (r *bitReader) readBit() (uint64, error) {
+	if r.n/8 >= uint(len(r.data)) {
+		return 0, errors.New("truncated block filter")
+	}
+	bit := uint64(r.data[r.n/8]>>(7-r.n%8)) & 1
+	r.n++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(count uint) (uint64, error) {
+	var v uint64
+	for i := uint(0); i < count; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | bit
+	}
+	return v, nil
+}
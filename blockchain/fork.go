@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// MaxReorgDepth is the largest number of blocks a reorganization may
+// disconnect from the chain. Blocks deeper than that are final: a fork
+// branching off before them is rejected, however long it is.
+const MaxReorgDepth = 100
+
+// ErrReorgTooDeep is returned by ImportBlocks when adopting a fork would
+// disconnect more than MaxReorgDepth blocks.
+var ErrReorgTooDeep = errors.New("fork branches off deeper than the maximum reorganization depth")
+
+// IsBetterTip reports whether a chain ending at (height, hash) is preferred
+// over one ending at (otherHeight, otherHash).
+// The longest chain wins; between chains of equal length the one whose tip
+// has the lowest hash wins, so every node picks the same fork.
+func IsBetterTip(height int, hash []byte, otherHeight int, otherHash []byte) bool {
+	if height != otherHeight {
+		return height > otherHeight
+	}
+	return bytes.Compare(hash, otherHash) < 0
+}
+
+// Locator returns block hashes describing the chain to a peer, from the tip
+// backwards: the last ten blocks one by one, then exponentially sparser, and
+// always ending with the genesis block. A peer uses it to find the most
+// recent block both chains have in common.
+func (bc *Blockchain) Locator() [][]byte {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var locator [][]byte
+	step := 1
+	for height := len(bc.Blocks) - 1; height > 0; height -= step {
+		locator = append(locator, bc.Blocks[height].Hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, bc.Blocks[0].Hash)
+}
+
+// BlocksAfter returns up to limit blocks following the first locator hash
+// found in the chain. If no locator hash is known, blocks are returned from
+// height 1, right after the shared genesis block.
+func (bc *Blockchain) BlocksAfter(locator [][]byte, limit int) []*Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	start := 1
+	for _, hash := range locator {
+		if height := bc.heightOf(hash); height >= 0 {
+			start = height + 1
+			break
+		}
+	}
+
+	var blocks []*Block
+	for height := start; height < len(bc.Blocks) && len(blocks) < limit; height++ {
+		blocks = append(blocks, bc.Blocks[height])
+	}
+	return blocks
+}
+
+// heightOf returns the height of the block with the given hash in the chain,
+// or -1 if it is not part of it. The caller must hold the lock.
+func (bc *Blockchain) heightOf(hash []byte) int {
+	for height, block := range bc.Blocks {
+		if bytes.Equal(block.Hash, hash) {
+			return height
+		}
+	}
+	return -1
+}
+
+// ImportBlocks imports a consecutive sequence of blocks received from a peer,
+// reorganizing the chain if they belong to a better fork.
+// Parameters:
+//   - blocks: Consecutive blocks, the first of which builds on a block of
+//     the current chain
+//
+// Blocks already part of the chain are skipped. If the remaining blocks
+// extend the tip they are appended; otherwise they replace the blocks after
+// their fork point, provided the resulting chain is better according to
+// IsBetterTip and disconnects at most MaxReorgDepth blocks. In that case the
+// disconnected blocks' changes to the UTXO set, contract state and filter
+// headers are reverted back to the fork point, and every new block is
+// validated and applied on top.
+// On error the chain is left unchanged, except for the blocks that extended
+// the tip before the invalid one.
+//
+// Returns the blocks that became part of the chain and the blocks that were
+// disconnected from it, whose transactions are no longer included unless the
+// new blocks include them too.
+func (bc *Blockchain) ImportBlocks(blocks []*Block) (imported []*Block, disconnected []*Block, err error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	// Skip the prefix we already have
+	for len(blocks) > 0 {
+		height := blocks[0].Height
+		if height < 0 || height >= len(bc.Blocks) || !bytes.Equal(bc.Blocks[height].Hash, blocks[0].Hash) {
+			break
+		}
+		blocks = blocks[1:]
+	}
+	if len(blocks) == 0 {
+		return nil, nil, nil
+	}
+
+	forkHeight := blocks[0].Height - 1
+	if forkHeight < 0 || forkHeight >= len(bc.Blocks) || !bytes.Equal(bc.Blocks[forkHeight].Hash, blocks[0].PrevHash) {
+		return nil, nil, fmt.Errorf("blocks do not connect to the chain")
+	}
+
+	// Plain extension of the tip: no need to rebuild the state
+	if forkHeight == len(bc.Blocks)-1 {
+		for i, block := range blocks {
+			if err := bc.validateNext(block); err != nil {
+				return blocks[:i], nil, err
+			}
+			bc.appendBlock(block)
+		}
+		return blocks, nil, nil
+	}
+
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	if tip.Height-forkHeight > MaxReorgDepth {
+		return nil, nil, fmt.Errorf("%w: fork at height %d, tip at height %d", ErrReorgTooDeep, forkHeight, tip.Height)
+	}
+	last := blocks[len(blocks)-1]
+	if !IsBetterTip(last.Height, last.Hash, tip.Height, tip.Hash) {
+		return nil, nil, fmt.Errorf("fork ending at %x is not better than the current chain", last.Hash)
+	}
+
+	// Roll the chain back to the fork point, then validate the new blocks on top
+	for len(bc.Blocks)-1 > forkHeight {
+		disconnected = append(disconnected, bc.disconnectTip())
+	}
+	slices.Reverse(disconnected)
+	for i, block := range blocks {
+		if err := bc.validateNext(block); err != nil {
+			// Restore the original chain
+			for range blocks[:i] {
+				bc.disconnectTip()
+			}
+			for _, block := range disconnected {
+				bc.appendBlock(block)
+			}
+			return nil, nil, err
+		}
+		bc.appendBlock(block)
+	}
+	return blocks, disconnected, nil
+}
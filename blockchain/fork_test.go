@@ -0,0 +1,196 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// mintBlocks adds n blocks to bc, each minting amount to the wallet.
+func mintBlocks(t *testing.T, bc *Blockchain, validator *Wallet, to *Wallet, amount int, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		tx := mintTx(to, amount, bc.LastBlock().Height+1)
+		if _, err := bc.AddBlock([]*Transaction{tx}, validator); err != nil {
+			t.Fatalf("AddBlock: %v", err)
+		}
+	}
+}
+
+func TestImportBlocksReorganizes(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bob := NewWallet()
+
+	local := newTestChain()
+	mintBlocks(t, local, validator, alice, 10, 3)
+	remote := newTestChain()
+	mintBlocks(t, remote, validator, bob, 10, 5)
+
+	imported, disconnected, err := local.ImportBlocks(remote.AllBlocks()[1:])
+	if err != nil {
+		t.Fatalf("ImportBlocks: %v", err)
+	}
+	if len(imported) != 5 || len(disconnected) != 3 {
+		t.Fatalf("imported %d and disconnected %d blocks, want 5 and 3", len(imported), len(disconnected))
+	}
+	if tip := local.LastBlock(); !bytes.Equal(tip.Hash, remote.LastBlock().Hash) {
+		t.Fatalf("tip = %x, want %x", tip.Hash, remote.LastBlock().Hash)
+	}
+	if got := local.GetBalance(alice.PublicKey); got != 0 {
+		t.Fatalf("balance of the orphaned mints = %d, want 0", got)
+	}
+	if got := local.GetBalance(bob.PublicKey); got != 50 {
+		t.Fatalf("balance = %d, want 50", got)
+	}
+
+	// The shorter fork is not adopted back
+	if _, _, err := local.ImportBlocks(disconnected); err == nil {
+		t.Fatal("ImportBlocks of a worse fork succeeded")
+	}
+}
+
+// requireSameState fails the test if the UTXO set or contract state of got
+// differs from that of want.
+func requireSameState(t *testing.T, got, want *Blockchain) {
+	t.Helper()
+	if !reflect.DeepEqual(got.UTXOs.UTXOs, want.UTXOs.UTXOs) {
+		t.Fatal("UTXO sets differ")
+	}
+	for name, equal := range map[string]bool{
+		"contracts":    reflect.DeepEqual(got.Contracts.Contracts, want.Contracts.Contracts),
+		"nonces":       reflect.DeepEqual(got.Contracts.Nonces, want.Contracts.Nonces),
+		"receipts":     reflect.DeepEqual(got.Contracts.Receipts, want.Contracts.Receipts),
+		"schedules":    reflect.DeepEqual(got.Contracts.Schedules, want.Contracts.Schedules),
+		"runs":         reflect.DeepEqual(got.Contracts.Runs, want.Contracts.Runs),
+		"accounts":     reflect.DeepEqual(got.Contracts.accounts, want.Contracts.accounts),
+		"state root":   bytes.Equal(got.Contracts.State.Root(), want.Contracts.State.Root()),
+		"filter chain": reflect.DeepEqual(got.filterHeaders, want.filterHeaders),
+	} {
+		if !equal {
+			t.Fatalf("%s differ", name)
+		}
+	}
+}
+
+func TestImportBlocksRevertsToForkPoint(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bob := NewWallet()
+
+	local := newTestChain()
+	ticker := deployTicker(t, local, validator, alice)
+	remote := newTestChain()
+	if _, _, err := remote.ImportBlocks(local.AllBlocks()[1:]); err != nil {
+		t.Fatalf("ImportBlocks: %v", err)
+	}
+	forkHeight := local.LastBlock().Height
+
+	// The local branch schedules runs, which execute, and spends alice's coin
+	start, err := NewCallTransaction(alice, local.ContractNonce(alice.Address), ticker, "start",
+		[]contracts.Value{contracts.IntValue(int64(forkHeight + 2)), contracts.IntValue(1), contracts.IntValue(5)}, 50000, 200000, local.GetUTXOsForAddress(alice.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCallTransaction: %v", err)
+	}
+	if _, err := local.AddBlock([]*Transaction{start}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	transfer, err := NewTransaction(alice, string(bob.PublicKey), 1000, local.GetUTXOsForAddress(alice.PublicKey))
+	if err != nil {
+		t.Fatalf("NewTransaction: %v", err)
+	}
+	if _, err := local.AddBlock([]*Transaction{transfer}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	mintBlocks(t, local, validator, alice, 10, 1)
+	if runs := local.Contracts.Runs[forkHeight+3]; len(runs) != 1 {
+		t.Fatalf("runs at %d = %v, want one", forkHeight+3, runs)
+	}
+
+	// The remote branch is longer and only mints
+	mintBlocks(t, remote, validator, bob, 10, 4)
+	original := local.AllBlocks()
+	imported, disconnected, err := local.ImportBlocks(remote.AllBlocks()[forkHeight+1:])
+	if err != nil {
+		t.Fatalf("ImportBlocks: %v", err)
+	}
+	if len(imported) != 4 || len(disconnected) != 3 || disconnected[0] != original[forkHeight+1] {
+		t.Fatalf("imported %d and disconnected %d blocks, want 4 and the 3 after the fork in order", len(imported), len(disconnected))
+	}
+	requireSameState(t, local, remote)
+	if _, ok := local.Receipt(start.ID); ok {
+		t.Fatal("the disconnected call still has a receipt")
+	}
+	if len(local.undo) != len(local.Blocks) {
+		t.Fatalf("%d undo records for %d blocks", len(local.undo), len(local.Blocks))
+	}
+
+	// A fork with an invalid block leaves the chain as it was
+	fork := newTestChain()
+	if _, _, err := fork.ImportBlocks(local.AllBlocks()[1 : forkHeight+1]); err != nil {
+		t.Fatalf("ImportBlocks: %v", err)
+	}
+	mintBlocks(t, fork, validator, alice, 10, 6)
+	blocks := fork.AllBlocks()[forkHeight+1:]
+	invalid := *blocks[len(blocks)-1]
+	invalid.Signature = nil
+	blocks[len(blocks)-1] = &invalid
+	if _, _, err := local.ImportBlocks(blocks); err == nil {
+		t.Fatal("ImportBlocks of a fork with an invalid block succeeded")
+	}
+	requireSameState(t, local, remote)
+	if tip := local.LastBlock(); !bytes.Equal(tip.Hash, remote.LastBlock().Hash) {
+		t.Fatalf("tip = %x, want %x", tip.Hash, remote.LastBlock().Hash)
+	}
+}
+
+func TestImportBlocksRejectsDeepReorg(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+
+	local := newTestChain()
+	mintBlocks(t, local, validator, alice, 1, MaxReorgDepth+1)
+	remote := newTestChain()
+	mintBlocks(t, remote, validator, alice, 2, MaxReorgDepth+5)
+
+	tip := local.LastBlock()
+	if _, _, err := local.ImportBlocks(remote.AllBlocks()[1:]); !errors.Is(err, ErrReorgTooDeep) {
+		t.Fatalf("ImportBlocks = %v, want %v", err, ErrReorgTooDeep)
+	}
+	if local.LastBlock() != tip {
+		t.Fatal("a rejected reorganization changed the tip")
+	}
+}
+
+func TestValidatorSet(t *testing.T) {
+	trusted := NewWallet()
+	stranger := NewWallet()
+	alice := NewWallet()
+
+	bc := newTestChain()
+	bc.SetValidators([][]byte{trusted.PublicKey})
+
+	if _, err := bc.AddBlock([]*Transaction{mintTx(alice, 10, 1)}, stranger); !errors.Is(err, ErrUnknownValidator) {
+		t.Fatalf("AddBlock by an untrusted validator = %v, want %v", err, ErrUnknownValidator)
+	}
+	block := nextBlock(bc, stranger, []*Transaction{mintTx(alice, 10, 1)})
+	if err := bc.AcceptBlock(block); !errors.Is(err, ErrUnknownValidator) {
+		t.Fatalf("AcceptBlock of a block by an untrusted validator = %v, want %v", err, ErrUnknownValidator)
+	}
+
+	fork := newTestChain()
+	mintBlocks(t, fork, stranger, alice, 10, 2)
+	if _, _, err := bc.ImportBlocks(fork.AllBlocks()[1:]); !errors.Is(err, ErrUnknownValidator) {
+		t.Fatalf("ImportBlocks of an untrusted fork = %v, want %v", err, ErrUnknownValidator)
+	}
+
+	if _, err := bc.AddBlock([]*Transaction{mintTx(alice, 10, 1)}, trusted); err != nil {
+		t.Fatalf("AddBlock by a trusted validator: %v", err)
+	}
+	if got := bc.GetBalance(alice.PublicKey); got != 10 {
+		t.Fatalf("balance = %d, want 10", got)
+	}
+}
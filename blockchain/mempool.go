@@ -0,0 +1,246 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMempoolSize is the default maximum number of pending transactions.
+const DefaultMempoolSize = 10000
+
+// MaxPendingAge is how long a transaction may wait in the mempool for a
+// block to include it before it is dropped.
+const MaxPendingAge = time.Hour
+
+// ErrMempoolFull is returned when the mempool cannot accept more transactions.
+var ErrMempoolFull = errors.New("mempool is full")
+
+// Mempool holds transactions that have been seen on the network but are not
+// yet included in a block. Only transactions that could be included in the
+// next block are accepted: their inputs must spend unspent outputs, of the
+// chain or of other pending transactions, that no pending transaction
+// spends. It is safe for concurrent use.
+type Mempool struct {
+	mu      sync.RWMutex
+	chain   *Blockchain
+	txs     map[string]*pendingTx // Pending transactions, key = hex transaction ID
+	spent   map[string]string     // Outputs spent by pending transactions, key = output key, value = hex ID of the spending transaction
+	created map[string]*UTXO      // Outputs of pending transactions, key = output key
+	maxSize int
+}
+
+// pendingTx is a transaction in the mempool.
+type pendingTx struct {
+	tx    *Transaction
+	fee   int64     // Native coin the transaction pays beyond its outputs
+	added time.Time // When the transaction entered the mempool, by the chain's clock
+}
+
+// NewMempool creates an empty mempool holding at most maxSize transactions
+// that spend the outputs of chain.
+func NewMempool(chain *Blockchain, maxSize int) *Mempool {
+	return &Mempool{
+		chain:   chain,
+		txs:     make(map[string]*pendingTx),
+		spent:   make(map[string]string),
+		created: make(map[string]*UTXO),
+		maxSize: maxSize,
+	}
+}
+
+// Add verifies a transaction against the chain and the other pending
+// transactions and stores it in the mempool. Transactions pending for more
+// than MaxPendingAge are dropped first. If the mempool is full, the pending
+// transaction paying the lowest fee, the oldest of those paying the same, is
+// evicted with the transactions spending its outputs, if tx pays more.
+// Returns false if the transaction was already pending, or an error if it
+// is invalid, conflicts with a pending transaction or does not pay enough to
+// enter a full mempool (ErrMempoolFull).
+func (mp *Mempool) Add(tx *Transaction) (bool, error) {
+	if !tx.Verify() {
+		return false, fmt.Errorf("transaction %x has an invalid signature", tx.ID)
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	key := fmt.Sprintf("%x", tx.ID)
+	if _, ok := mp.txs[key]; ok {
+		return false, nil
+	}
+	now := mp.chain.Clock()
+	mp.expire(now)
+
+	for i, input := range tx.Input {
+		if spender, ok := mp.spent[fmt.Sprintf("%x_%d", input.TransactionID, input.OutputIndex)]; ok {
+			return false, fmt.Errorf("transaction %x: input %d is spent by pending transaction %s", tx.ID, i, spender)
+		}
+	}
+	fee, err := mp.chain.checkPending(tx, mp.created)
+	if err != nil {
+		return false, fmt.Errorf("transaction %x: %w", tx.ID, err)
+	}
+	if len(mp.txs) >= mp.maxSize && !mp.evict(fee) {
+		return false, ErrMempoolFull
+	}
+
+	mp.txs[key] = &pendingTx{tx: tx, fee: fee, added: now}
+	for _, input := range tx.Input {
+		mp.spent[fmt.Sprintf("%x_%d", input.TransactionID, input.OutputIndex)] = key
+	}
+	for i, output := range tx.Output {
+		mp.created[fmt.Sprintf("%x_%d", tx.ID, i)] = &UTXO{
+			TransactionID: tx.ID,
+			OutputIndex:   i,
+			Value:         output.Value,
+			PublicKey:     output.PublicKey,
+			Asset:         output.Asset,
+		}
+	}
+	return true, nil
+}
+
+// checkPending checks a transaction for the mempool against the chain's
+// state: it must spend unspent outputs of the chain or of created, the
+// outputs of pending transactions, and conserve every asset (see spentBy).
+// A contract transaction must also pay exactly its fee and value and use a
+// nonce its sender has not used yet. Transactions without inputs are only
+// valid in the block that mints them and are rejected.
+// Returns the native coin the transaction pays beyond its outputs.
+func (bc *Blockchain) checkPending(tx *Transaction, created map[string]*UTXO) (int64, error) {
+	if len(tx.Input) == 0 {
+		return 0, errors.New("transactions without inputs are only valid in the block minting them")
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	spent, err := bc.UTXOs.spentBy(tx, created)
+	if err != nil {
+		return 0, err
+	}
+	paid := nativePaid(tx, spent)
+	if tx.Contract == nil {
+		return paid, nil
+	}
+	if nonce := bc.Contracts.Nonces[tx.Contract.SenderAddress()]; tx.Contract.Nonce < nonce {
+		return 0, fmt.Errorf("nonce %d is already used, the sender's next nonce is %d", tx.Contract.Nonce, nonce)
+	}
+	if due := tx.Contract.Value + tx.Contract.Fee(); paid != due {
+		return 0, fmt.Errorf("pays %d for a fee and value of %d", paid, due)
+	}
+	return paid, nil
+}
+
+// expire drops the transactions pending for more than MaxPendingAge, with
+// the transactions spending their outputs.
+func (mp *Mempool) expire(now time.Time) {
+	for key, pending := range mp.txs {
+		if now.Sub(pending.added) > MaxPendingAge {
+			mp.remove(key)
+		}
+	}
+}
+
+// evict drops the pending transaction paying the lowest fee, the oldest of
+// those paying the same, with the transactions spending its outputs, if it
+// pays less than fee.
+// Returns whether a transaction was dropped.
+func (mp *Mempool) evict(fee int64) bool {
+	var victim string
+	var lowest *pendingTx
+	for key, pending := range mp.txs {
+		if lowest == nil || pending.fee < lowest.fee || pending.fee == lowest.fee && pending.added.Before(lowest.added) {
+			victim, lowest = key, pending
+		}
+	}
+	if lowest == nil || lowest.fee >= fee {
+		return false
+	}
+	mp.remove(victim)
+	return true
+}
+
+// remove drops a pending transaction, which can no longer be included, and
+// the pending transactions spending its outputs.
+func (mp *Mempool) remove(key string) {
+	pending, ok := mp.txs[key]
+	if !ok {
+		return
+	}
+	mp.drop(key)
+	for i := range pending.tx.Output {
+		if spender, ok := mp.spent[fmt.Sprintf("%x_%d", pending.tx.ID, i)]; ok {
+			mp.remove(spender)
+		}
+	}
+}
+
+// drop deletes a pending transaction, the outputs it spends and those it
+// creates from the mempool's indexes.
+func (mp *Mempool) drop(key string) {
+	pending := mp.txs[key]
+	delete(mp.txs, key)
+	for _, input := range pending.tx.Input {
+		delete(mp.spent, fmt.Sprintf("%x_%d", input.TransactionID, input.OutputIndex))
+	}
+	for i := range pending.tx.Output {
+		delete(mp.created, fmt.Sprintf("%x_%d", pending.tx.ID, i))
+	}
+}
+
+// Get returns a pending transaction by ID, or nil if it is not in the mempool.
+func (mp *Mempool) Get(id []byte) *Transaction {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	if pending, ok := mp.txs[fmt.Sprintf("%x", id)]; ok {
+		return pending.tx
+	}
+	return nil
+}
+
+// Transactions returns every pending transaction.
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	txs := make([]*Transaction, 0, len(mp.txs))
+	for _, pending := range mp.txs {
+		txs = append(txs, pending.tx)
+	}
+	return txs
+}
+
+// RemoveBlock drops every transaction included in block from the mempool,
+// and the pending transactions spending the same outputs, which can no
+// longer be included, with the transactions spending their outputs.
+// Transactions spending the outputs of included ones stay pending.
+func (mp *Mempool) RemoveBlock(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		key := fmt.Sprintf("%x", tx.ID)
+		if _, ok := mp.txs[key]; ok {
+			mp.drop(key)
+		}
+	}
+	for _, tx := range block.Transactions {
+		for _, input := range tx.Input {
+			if spender, ok := mp.spent[fmt.Sprintf("%x_%d", input.TransactionID, input.OutputIndex)]; ok {
+				mp.remove(spender)
+			}
+		}
+	}
+}
+
+// Size returns the number of pending transactions.
+func (mp *Mempool) Size() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.txs)
+}
@@ -0,0 +1,138 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+func TestMempoolRejectsJunk(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bob := NewWallet()
+	bc := newTestChain()
+	address := deployTestContract(t, bc, validator, alice)
+	nonce := bc.ContractNonce(alice.Address)
+	utxos := bc.GetUTXOsForAddress(alice.PublicKey)
+	pool := NewMempool(bc, DefaultMempoolSize)
+
+	tests := []struct {
+		name string
+		tx   func() (*Transaction, error)
+	}{
+		{"mint", func() (*Transaction, error) {
+			return mintTx(alice, 100, bc.LastBlock().Height+1), nil
+		}},
+		{"unknown output", func() (*Transaction, error) {
+			return NewTransaction(alice, string(bob.PublicKey), 10, []*UTXO{{TransactionID: []byte("missing"), Value: 10, PublicKey: alice.PublicKey}})
+		}},
+		{"output of another owner", func() (*Transaction, error) {
+			return NewTransaction(bob, string(bob.PublicKey), 10, []*UTXO{{TransactionID: utxos[0].TransactionID, OutputIndex: utxos[0].OutputIndex, Value: utxos[0].Value, PublicKey: bob.PublicKey}})
+		}},
+		{"used nonce", func() (*Transaction, error) {
+			return NewCallTransaction(alice, nonce-1, address, "run", []contracts.Value{contracts.IntValue(0)}, 5000, 0, utxos)
+		}},
+		{"underpaid fee", func() (*Transaction, error) {
+			tx := &Transaction{Contract: &ContractTx{Kind: ContractCall, Nonce: nonce, Contract: address, Method: "run", Args: []contracts.Value{contracts.IntValue(0)}, GasLimit: 5000}}
+			if err := payValue(alice, tx, tx.Contract.Fee()-1, utxos); err != nil {
+				return nil, err
+			}
+			return signContractTransaction(alice, tx), nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := tt.tx()
+			if err != nil {
+				t.Fatalf("creating the transaction: %v", err)
+			}
+			if added, err := pool.Add(tx); added || err == nil {
+				t.Fatalf("Add = %v, %v, want an error", added, err)
+			}
+		})
+	}
+
+	// Pending transactions can be spent, but not spent twice
+	transfer, err := NewTransaction(alice, string(bob.PublicKey), 10, utxos)
+	if err != nil {
+		t.Fatalf("NewTransaction: %v", err)
+	}
+	if added, err := pool.Add(transfer); !added || err != nil {
+		t.Fatalf("Add of a transfer = %v, %v", added, err)
+	}
+	received := &UTXO{TransactionID: transfer.ID, Value: 10, PublicKey: bob.PublicKey}
+	spend, err := NewTransaction(bob, string(alice.PublicKey), 10, []*UTXO{received})
+	if err != nil {
+		t.Fatalf("NewTransaction: %v", err)
+	}
+	if added, err := pool.Add(spend); !added || err != nil {
+		t.Fatalf("Add of a spend of a pending output = %v, %v", added, err)
+	}
+	double, err := NewTransaction(alice, string(alice.PublicKey), 20, utxos)
+	if err != nil {
+		t.Fatalf("NewTransaction: %v", err)
+	}
+	if added, err := pool.Add(double); added || err == nil {
+		t.Fatalf("Add of a double spend = %v, %v, want an error", added, err)
+	}
+	if pool.Size() != 2 {
+		t.Fatalf("Size = %d, want 2", pool.Size())
+	}
+
+	// A block spending the same output drops the transfer and its spend
+	if _, err := bc.AddBlock([]*Transaction{double}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	pool.RemoveBlock(bc.LastBlock())
+	if pool.Size() != 0 {
+		t.Fatalf("Size after a conflicting block = %d, want 0", pool.Size())
+	}
+}
+
+func TestMempoolEvictsByFeeAndAge(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bob := NewWallet()
+	bc := newTestChain()
+	address := deployTestContract(t, bc, validator, alice)
+	if _, err := bc.AddBlock([]*Transaction{mintTx(bob, 100, bc.LastBlock().Height+1)}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	now := time.Now()
+	bc.Clock = func() time.Time { return now }
+	pool := NewMempool(bc, 1)
+
+	transfer, err := NewTransaction(bob, string(alice.PublicKey), 10, bc.GetUTXOsForAddress(bob.PublicKey))
+	if err != nil {
+		t.Fatalf("NewTransaction: %v", err)
+	}
+	if added, err := pool.Add(transfer); !added || err != nil {
+		t.Fatalf("Add = %v, %v", added, err)
+	}
+
+	// A call paying a fee evicts the transfer, which pays none
+	call, err := NewCallTransaction(alice, bc.ContractNonce(alice.Address), address, "run", []contracts.Value{contracts.IntValue(0)}, 5000, 0, bc.GetUTXOsForAddress(alice.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCallTransaction: %v", err)
+	}
+	if added, err := pool.Add(call); !added || err != nil {
+		t.Fatalf("Add of a call = %v, %v", added, err)
+	}
+	if pool.Get(transfer.ID) != nil {
+		t.Fatal("the transfer was not evicted")
+	}
+	if _, err := pool.Add(transfer); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("Add of the transfer to a full mempool = %v, want %v", err, ErrMempoolFull)
+	}
+
+	// Once the call is too old, the transfer takes its place
+	now = now.Add(MaxPendingAge + time.Second)
+	if added, err := pool.Add(transfer); !added || err != nil {
+		t.Fatalf("Add after the call expired = %v, %v", added, err)
+	}
+	if pool.Get(call.ID) != nil {
+		t.Fatal("the expired call is still pending")
+	}
+}
@@ -0,0 +1,118 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Domain separation prefixes, so that an inner node can never be passed off
+// as a leaf (or vice versa) when verifying a proof.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleStep is one level of a Merkle inclusion proof.
+type MerkleStep struct {
+	Hash []byte // Hash of the sibling subtree
+	Left bool   // Whether the sibling is the left child
+}
+
+// MerkleProof proves that a transaction is included in a block.
+// It contains:
+//   - TxID: The ID of the proven transaction
+//   - BlockHash: The hash of the block including the transaction
+//   - Height: The height of that block
+//   - Path: Sibling hashes from the leaf up to the Merkle root
+type MerkleProof struct {
+	TxID      []byte
+	BlockHash []byte
+	Height    int
+	Path      []MerkleStep
+}
+
+// merkleLeaf hashes a transaction ID into a leaf of the tree.
+func merkleLeaf(id []byte) []byte {
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, id...))
+	return hash[:]
+}
+
+// merkleNode hashes two children into their parent.
+func merkleNode(left, right []byte) []byte {
+	hash := sha256.Sum256(bytes.Join([][]byte{{merkleNodePrefix}, left, right}, nil))
+	return hash[:]
+}
+
+// merkleLevels builds every level of the tree, leaves first.
+// A node without a sibling is promoted unchanged to the next level instead
+// of being paired with a copy of itself.
+func merkleLevels(ids [][]byte) [][][]byte {
+	level := make([][]byte, len(ids))
+	for i, id := range ids {
+		level[i] = merkleLeaf(id)
+	}
+	levels := [][][]byte{level}
+
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleNode(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// MerkleRoot returns the root of the Merkle tree built over the given IDs.
+// The root of an empty list is the hash of nothing.
+func MerkleRoot(ids [][]byte) []byte {
+	if len(ids) == 0 {
+		hash := sha256.Sum256(nil)
+		return hash[:]
+	}
+	levels := merkleLevels(ids)
+	return levels[len(levels)-1][0]
+}
+
+// NewMerkleProof builds the inclusion proof of the transaction at index
+// within the block.
+func NewMerkleProof(block *Block, index int) *MerkleProof {
+	ids := make([][]byte, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		ids[i] = tx.ID
+	}
+
+	proof := &MerkleProof{
+		TxID:      block.Transactions[index].ID,
+		BlockHash: block.Hash,
+		Height:    block.Height,
+	}
+
+	levels := merkleLevels(ids)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Path = append(proof.Path, MerkleStep{Hash: level[sibling], Left: sibling < index})
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// Verify reports whether the proof links its transaction to the given Merkle root.
+func (p *MerkleProof) Verify(root []byte) bool {
+	hash := merkleLeaf(p.TxID)
+	for _, step := range p.Path {
+		if step.Left {
+			hash = merkleNode(step.Hash, hash)
+		} else {
+			hash = merkleNode(hash, step.Hash)
+		}
+	}
+	return bytes.Equal(hash, root)
+}
@@ -0,0 +1,262 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// RentPeriod is the number of blocks between rent collections: rent is
+// collected at the start of every block whose height is a multiple of it.
+const RentPeriod = 100
+
+// RentPerByte is the rent a contract pays from its balance at each
+// collection for every byte of its state (see StateSize).
+const RentPerByte = 1
+
+// ErrNotArchived is returned when reading or restoring the archived state of
+// a contract whose state is not archived.
+var ErrNotArchived = errors.New("contract state is not archived")
+
+// StateEntry is an entry of a contract's storage, with its value encoded
+// like a StateProof's: a kind byte, 0 for integers and 1 for byte strings,
+// followed by the value.
+type StateEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// RentCharge is the rent a contract was charged at a collection.
+type RentCharge struct {
+	Contract string `json:"contract"`
+	Size     int    `json:"size"`     // Bytes of state
+	Rent     int64  `json:"rent"`     // Rent due; not paid if the contract was archived
+	Archived bool   `json:"archived"` // Whether the contract could not pay and its state was archived
+}
+
+// StateSize returns the size of a contract's state in bytes, on which its
+// rent is charged: the length of every key and of its encoded value.
+func StateSize(contract *contracts.SmartContract) int {
+	size := 0
+	for key := range contract.State {
+		value, err := contract.Load(key)
+		if err != nil {
+			continue
+		}
+		size += len(key) + len(encodeStateValue(*value))
+	}
+	return size
+}
+
+// Rent returns the rent a contract owes at each collection.
+func Rent(contract *contracts.SmartContract) int64 {
+	return int64(StateSize(contract)) * RentPerByte
+}
+
+// NextRentCollection returns the height of the first rent collection after
+// a block height.
+func NextRentCollection(height int) int {
+	return (height/RentPeriod + 1) * RentPeriod
+}
+
+// collectRent charges every contract with state its rent, in address order,
+// if a block is at a collection height. Contracts that cannot pay have their
+// state archived: it is removed from the contract and the state tree, and
+// the contract keeps a commitment to it (see contracts.StateArchive).
+// Archived contracts keep their balance and owe no rent.
+func (cs *ContractSet) collectRent(block *Block) {
+	if block.Height == 0 || block.Height%RentPeriod != 0 {
+		return
+	}
+
+	var charges []RentCharge
+	for _, address := range slices.Sorted(maps.Keys(cs.Contracts)) {
+		contract := cs.Contracts[address]
+		if contract.Archive != nil || len(contract.State) == 0 {
+			continue
+		}
+
+		charge := RentCharge{Contract: address, Size: StateSize(contract), Rent: Rent(contract)}
+		charged := contract.Copy()
+		if contract.Balance >= charge.Rent {
+			charged.Balance -= charge.Rent
+		} else if err := cs.archive(contract, charged, block.Height); err != nil {
+			// The state tree could not be updated: the contract keeps its
+			// state until the next collection
+			continue
+		} else {
+			charge.Archived = true
+		}
+		cs.Contracts[address] = charged
+		charges = append(charges, charge)
+	}
+	cs.RentCharges[block.Height] = charges
+}
+
+// archive moves the state of a contract out of it and of the state tree.
+// archived is a copy of contract that receives the commitment.
+func (cs *ContractSet) archive(contract, archived *contracts.SmartContract, height int) error {
+	root, err := contractStateRoot(contract)
+	if err != nil {
+		return err
+	}
+	archived.Archive = &contracts.StateArchive{
+		Height:    height,
+		StateRoot: hex.EncodeToString(root),
+		Entries:   len(contract.State),
+		Size:      StateSize(contract),
+	}
+	archived.State = make(map[string]interface{})
+
+	treeRoot := cs.State.root
+	if err := cs.updateState(contract, archived); err != nil {
+		cs.State.root = treeRoot
+		return err
+	}
+	return nil
+}
+
+// restore puts back the archived state of a contract, crediting it the
+// value sent with the transaction. The entries must be exactly the archived
+// ones, and the contract must then hold at least one collection's rent.
+func (cs *ContractSet) restore(payload *ContractTx) error {
+	contract, ok := cs.Contracts[payload.Contract]
+	if !ok {
+		return fmt.Errorf("no contract deployed at %s", payload.Contract)
+	}
+	if contract.Archive == nil {
+		return fmt.Errorf("%w: %s", ErrNotArchived, contract.ID)
+	}
+
+	restored := contract.Copy()
+	for _, entry := range payload.State {
+		if _, ok := restored.State[entry.Key]; ok {
+			return fmt.Errorf("duplicate state entry %q", entry.Key)
+		}
+		value, err := decodeStateValue(entry.Value)
+		if err != nil {
+			return fmt.Errorf("state entry %q: %v", entry.Key, err)
+		}
+		if value.IsBytes {
+			restored.State[entry.Key] = value.Bytes
+		} else {
+			restored.State[entry.Key] = value.Int
+		}
+	}
+	root, err := contractStateRoot(restored)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(root) != contract.Archive.StateRoot {
+		return fmt.Errorf("state does not match the archived state root %s", contract.Archive.StateRoot)
+	}
+
+	restored.Archive = nil
+	restored.Balance += payload.Value
+	if rent := Rent(restored); restored.Balance < rent {
+		return fmt.Errorf("%w: restoring %s needs a balance of at least its rent %d, it would hold %d", contracts.ErrInsufficientBalance, contract.ID, rent, restored.Balance)
+	}
+
+	treeRoot := cs.State.root
+	if err := cs.updateState(contract, restored); err != nil {
+		cs.State.root = treeRoot
+		return err
+	}
+	cs.Contracts[contract.ID] = restored
+	return nil
+}
+
+// contractStateRoot returns the root of a state tree holding only the
+// entries of a contract's state, which commits to the state when it is
+// archived.
+func contractStateRoot(contract *contracts.SmartContract) ([]byte, error) {
+	tree := NewStateTree(NewMemoryNodeStore())
+	for _, key := range slices.Sorted(maps.Keys(contract.State)) {
+		value, err := contract.Load(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := tree.Update(StorageSlot(contract.ID, key), encodeStateValue(*value)); err != nil {
+			return nil, err
+		}
+	}
+	return tree.Root(), nil
+}
+
+// decodeStateValue decodes a storage value encoded by encodeStateValue.
+func decodeStateValue(data []byte) (contracts.Value, error) {
+	if len(data) == 0 {
+		return contracts.Value{}, errors.New("empty value")
+	}
+	switch data[0] {
+	case 0:
+		n, err := strconv.ParseInt(string(data[1:]), 10, 64)
+		if err != nil || strconv.FormatInt(n, 10) != string(data[1:]) {
+			return contracts.Value{}, fmt.Errorf("invalid integer %q", data[1:])
+		}
+		return contracts.IntValue(n), nil
+	case 1:
+		return contracts.BytesValue(data[1:]), nil
+	default:
+		return contracts.Value{}, fmt.Errorf("unknown value kind %d", data[0])
+	}
+}
+
+// RentCharges returns the rent charged at a block, if it was a collection.
+func (bc *Blockchain) RentCharges(block *Block) []RentCharge {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.Contracts.RentCharges[block.Height]
+}
+
+// ArchivedState returns the entries of a contract's archived state, sorted
+// by key, to restore it with (see NewRestoreTransaction).
+//
+// The chain only keeps the current contract state, so the state is
+// recovered by replaying the chain up to the block that archived it, like a
+// trace (see TraceTransaction), and checked against the contract's
+// commitment. Returns ErrNotArchived if the contract's state is not archived.
+func (bc *Blockchain) ArchivedState(address string) ([]StateEntry, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	contract, ok := bc.Contracts.Contracts[address]
+	if !ok {
+		return nil, fmt.Errorf("no contract deployed at %s", address)
+	}
+	if contract.Archive == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotArchived, address)
+	}
+
+	replayed := NewContractSet(NewMemoryNodeStore())
+	for _, block := range bc.Blocks[:contract.Archive.Height] {
+		replayed.Apply(block)
+	}
+	archived, ok := replayed.Contracts[address]
+	if !ok {
+		return nil, fmt.Errorf("contract %s not found in the replayed chain", address)
+	}
+	root, err := contractStateRoot(archived)
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(root) != contract.Archive.StateRoot {
+		return nil, fmt.Errorf("replayed state of %s does not match its archive", address)
+	}
+
+	var entries []StateEntry
+	for _, key := range slices.Sorted(maps.Keys(archived.State)) {
+		value, err := archived.Load(key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, StateEntry{Key: key, Value: encodeStateValue(*value)})
+	}
+	return entries, nil
+}
@@ -0,0 +1,288 @@
+package blockchain
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// MaxScheduleRuns caps the number of runs of a single schedule.
+const MaxScheduleRuns = contracts.MaxScheduleRuns
+
+// MaxScheduledRunsPerBlock caps the number of scheduled runs a block
+// executes. Runs due past it are postponed to the following blocks.
+const MaxScheduledRunsPerBlock = 100
+
+// ScheduledGasPrice is what a contract pays from its balance for each unit
+// of gas of its scheduled runs. The gas a run uses is paid to the validator
+// of the block executing it.
+const ScheduledGasPrice = contracts.ScheduledGasPrice
+
+// ErrScheduleNotFound is returned when cancelling a schedule that does not
+// exist, e.g. because all its runs are done.
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule is a call of a contract method that the chain makes by itself at
+// block heights set by the contract's owner in a schedule transaction, or by
+// the contract itself during a call (see contracts.CallTree.Scheduled): once
+// at NextHeight, or every Interval blocks from NextHeight on until RunsLeft
+// runs are done.
+//
+// Due runs execute at the start of a block, before its transactions, in the
+// order of NextHeight and then ID, so every node runs them identically. The
+// caller of a run is the contract itself, so a method can check that it is
+// only run by its schedules. A run that fails, e.g. out of gas, has no effect
+// other than using gas, and the schedule goes on.
+//
+// The gas of every run, GasLimit at ScheduledGasPrice, is prepaid from the
+// contract's balance when the schedule is registered. The gas a run uses is
+// paid to the block's validator in an output of the run (see runOutputs);
+// what it does not use is refunded to the contract after it, and the owner
+// can cancel the schedule to get back the gas of the runs left.
+type Schedule struct {
+	ID         string            `json:"id"` // Hex ID of the transaction that registered it, or see ContractScheduleID
+	Contract   string            `json:"contract"`
+	Method     string            `json:"method"`
+	Args       []contracts.Value `json:"args"`
+	GasLimit   uint64            `json:"gasLimit"`          // Maximum gas of each run
+	NextHeight int               `json:"nextHeight"`        // Height of the next run
+	Interval   int               `json:"interval"`          // Blocks between runs; 0 for a single run
+	RunsLeft   int               `json:"runsLeft"`          // Runs not yet executed
+	LastRun    string            `json:"lastRun,omitempty"` // ID of the receipt of the latest run
+}
+
+// Deposit returns the gas still prepaid for the runs left.
+func (s *Schedule) Deposit() int64 {
+	return int64(s.GasLimit) * int64(s.RunsLeft) * ScheduledGasPrice
+}
+
+// ScheduledRunID returns the ID of the receipt of the run of a schedule at a
+// block height. Receipts of runs are looked up like those of transactions
+// (see Blockchain.Receipt).
+func ScheduledRunID(schedule string, height int) []byte {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("run:%s/%d", schedule, height)))
+	return hash[:]
+}
+
+// ContractScheduleID returns the ID of the index-th schedule a contract
+// registered during the call of a transaction or scheduled run, given the ID
+// of its receipt.
+func ContractScheduleID(receipt string, index int) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("schedule:%s/%d", receipt, index)))
+	return hex.EncodeToString(hash[:])
+}
+
+// schedule registers the calls of a schedule transaction, paying their gas
+// from the contract's balance. Only the contract's owner may schedule calls.
+func (cs *ContractSet) schedule(payload *ContractTx, sender string, height int, id string) error {
+	contract, ok := cs.Contracts[payload.Contract]
+	if !ok {
+		return fmt.Errorf("no contract deployed at %s", payload.Contract)
+	}
+	if sender != contract.Owner {
+		return fmt.Errorf("only the owner %s can schedule calls of contract %s", contract.Owner, contract.ID)
+	}
+	if payload.Height <= height {
+		return fmt.Errorf("first run at height %d is not above the block's height %d", payload.Height, height)
+	}
+	if contract.ABI != nil {
+		if err := contract.ABI.CheckCall(payload.Method, payload.Args); err != nil {
+			return err
+		}
+	}
+
+	schedule := &Schedule{
+		ID:         id,
+		Contract:   payload.Contract,
+		Method:     payload.Method,
+		Args:       payload.Args,
+		GasLimit:   payload.GasLimit,
+		NextHeight: payload.Height,
+		Interval:   payload.Interval,
+		RunsLeft:   payload.Runs,
+	}
+	if contract.Balance < schedule.Deposit() {
+		return fmt.Errorf("%w: %s holds %d, the schedule's gas costs %d", contracts.ErrInsufficientBalance, contract.ID, contract.Balance, schedule.Deposit())
+	}
+
+	paying := contract.Copy()
+	paying.Balance -= schedule.Deposit()
+	cs.Contracts[contract.ID] = paying
+	cs.Schedules[id] = schedule
+	return nil
+}
+
+// cancelSchedule removes a schedule of a contract at its owner's request and
+// refunds the gas of the runs left.
+func (cs *ContractSet) cancelSchedule(payload *ContractTx, sender string) error {
+	schedule, ok := cs.Schedules[payload.Schedule]
+	if !ok || schedule.Contract != payload.Contract {
+		return fmt.Errorf("%w: %s of contract %s", ErrScheduleNotFound, payload.Schedule, payload.Contract)
+	}
+	contract := cs.Contracts[schedule.Contract]
+	if sender != contract.Owner {
+		return fmt.Errorf("only the owner %s can cancel schedules of contract %s", contract.Owner, contract.ID)
+	}
+
+	refunded := contract.Copy()
+	refunded.Balance += schedule.Deposit()
+	cs.Contracts[contract.ID] = refunded
+	delete(cs.Schedules, schedule.ID)
+	return nil
+}
+
+// runSchedules executes the scheduled runs due at a block, at most
+// MaxScheduledRunsPerBlock, and records their receipts.
+func (cs *ContractSet) runSchedules(block *Block) {
+	var due []*Schedule
+	for _, schedule := range cs.Schedules {
+		if schedule.NextHeight <= block.Height {
+			due = append(due, schedule)
+		}
+	}
+	slices.SortFunc(due, func(a, b *Schedule) int {
+		return cmp.Or(cmp.Compare(a.NextHeight, b.NextHeight), cmp.Compare(a.ID, b.ID))
+	})
+
+	for i, schedule := range due[:min(len(due), MaxScheduledRunsPerBlock)] {
+		receipt := &Receipt{
+			TxID:        hex.EncodeToString(ScheduledRunID(schedule.ID, block.Height)),
+			BlockHash:   hex.EncodeToString(block.Hash),
+			BlockHeight: block.Height,
+			Index:       i,
+			Status:      ReceiptSuccess,
+			Schedule:    schedule.ID,
+			Contract:    schedule.Contract,
+		}
+		cs.Receipts[receipt.TxID] = receipt
+		cs.Runs[block.Height] = append(cs.Runs[block.Height], receipt.TxID)
+		cs.run(schedule, block.Height, receipt)
+	}
+}
+
+// run executes a run of a schedule, records the fee of the gas it used,
+// refunds the gas it did not use and advances the schedule, which is
+// removed after its last run.
+func (cs *ContractSet) run(schedule *Schedule, height int, receipt *Receipt) {
+	err := cs.callTree(schedule.Contract, &contracts.CallContext{
+		Caller:      schedule.Contract,
+		Method:      schedule.Method,
+		Args:        schedule.Args,
+		GasLimit:    schedule.GasLimit,
+		BlockHeight: height,
+		Tracer:      cs.tracer,
+		Deadline:    cs.deadline,
+	}, receipt)
+	if err != nil {
+		receipt.Status = ReceiptFailed
+		receipt.Error = err.Error()
+		receipt.Logs = nil
+	}
+
+	used := min(receipt.GasUsed, schedule.GasLimit)
+	receipt.Fee = int64(used) * ScheduledGasPrice
+	if unused := schedule.GasLimit - used; unused > 0 {
+		refunded := cs.Contracts[schedule.Contract].Copy()
+		refunded.Balance += int64(unused) * ScheduledGasPrice
+		cs.Contracts[schedule.Contract] = refunded
+		if !slices.Contains(receipt.Touched, schedule.Contract) {
+			receipt.Touched = append(receipt.Touched, schedule.Contract)
+			slices.Sort(receipt.Touched)
+		}
+	}
+
+	if schedule.RunsLeft == 1 {
+		delete(cs.Schedules, schedule.ID)
+		return
+	}
+	next := *schedule
+	next.NextHeight = height + schedule.Interval
+	next.RunsLeft--
+	next.LastRun = receipt.TxID
+	cs.Schedules[schedule.ID] = &next
+}
+
+// runOutputs returns the outputs paying the validator of a block the gas
+// used by the runs of schedules the block executed. The output of a run is
+// the first output of its receipt's ID (see ScheduledRunID).
+func (cs *ContractSet) runOutputs(block *Block) []*UTXO {
+	var outputs []*UTXO
+	for _, id := range cs.Runs[block.Height] {
+		receipt := cs.Receipts[id]
+		if receipt.Fee <= 0 {
+			continue
+		}
+		txID, _ := hex.DecodeString(id)
+		outputs = append(outputs, &UTXO{
+			TransactionID: txID,
+			OutputIndex:   0,
+			Value:         int(receipt.Fee),
+			PublicKey:     block.Validator,
+			Asset:         NativeAsset,
+		})
+	}
+	return outputs
+}
+
+// blockReceipts returns the receipts of a block in execution order: those of
+// its scheduled runs, then those of its transactions.
+func (cs *ContractSet) blockReceipts(block *Block) []*Receipt {
+	var receipts []*Receipt
+	for _, id := range cs.Runs[block.Height] {
+		receipts = append(receipts, cs.Receipts[id])
+	}
+	for _, tx := range block.Transactions {
+		if receipt, ok := cs.Receipts[hex.EncodeToString(tx.ID)]; ok {
+			receipts = append(receipts, receipt)
+		}
+	}
+	return receipts
+}
+
+// BlockReceipts returns the receipts of a block of the chain in execution
+// order: those of the runs of schedules it executed, then those of its
+// transactions.
+func (bc *Blockchain) BlockReceipts(block *Block) []*Receipt {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.Contracts.blockReceipts(block)
+}
+
+// Schedules returns the pending schedules of a contract, in the order their
+// next runs execute.
+func (bc *Blockchain) Schedules(contract string) []*Schedule {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var schedules []*Schedule
+	for _, id := range slices.Sorted(maps.Keys(bc.Contracts.Schedules)) {
+		if schedule := bc.Contracts.Schedules[id]; schedule.Contract == contract {
+			copied := *schedule
+			schedules = append(schedules, &copied)
+		}
+	}
+	slices.SortStableFunc(schedules, func(a, b *Schedule) int {
+		return cmp.Compare(a.NextHeight, b.NextHeight)
+	})
+	return schedules
+}
+
+// GetSchedule returns a copy of a pending schedule, or false if there is none.
+func (bc *Blockchain) GetSchedule(id string) (*Schedule, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	schedule, ok := bc.Contracts.Schedules[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *schedule
+	return &copied, true
+}
@@ -0,0 +1,145 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// tickerSource schedules calls of its own tick method.
+const tickerSource = `contract Ticker {
+    int ticks;
+
+    public function start(int height, int interval, int runs) returns int {
+        return schedule("tick", height, interval, runs, 20000, 2);
+    }
+
+    public function tick(int by) {
+        require(msg.sender == this.address, "only scheduled runs can tick");
+        ticks += by;
+    }
+
+    public function get() returns int {
+        return ticks;
+    }
+}`
+
+// deployTicker funds wallet and deploys tickerSource from it.
+func deployTicker(t *testing.T, bc *Blockchain, validator *Wallet, wallet *Wallet) string {
+	t.Helper()
+	if _, err := bc.AddBlock([]*Transaction{mintTx(wallet, 10_000_000, bc.LastBlock().Height+1)}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	compiled, err := contracts.Compile(tickerSource)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	deploy, err := NewDeployTransaction(wallet, bc.ContractNonce(wallet.Address), hex.EncodeToString(compiled.Bytecode), compiled.ABI, tickerSource, "", bc.GetUTXOsForAddress(wallet.PublicKey))
+	if err != nil {
+		t.Fatalf("NewDeployTransaction: %v", err)
+	}
+	if _, err := bc.AddBlock([]*Transaction{deploy}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	receipt, _ := bc.Receipt(deploy.ID)
+	return receipt.Contract
+}
+
+func TestContractsScheduleTheirOwnCalls(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bc := newTestChain()
+	ticker := deployTicker(t, bc, validator, alice)
+
+	// Runs at heights 5, 7 and 9, paid from the value of the call
+	start, err := NewCallTransaction(alice, bc.ContractNonce(alice.Address), ticker, "start",
+		[]contracts.Value{contracts.IntValue(5), contracts.IntValue(2), contracts.IntValue(3)}, 50000, 100000, bc.GetUTXOsForAddress(alice.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCallTransaction: %v", err)
+	}
+	if _, err := bc.AddBlock([]*Transaction{start}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	receipt, _ := bc.Receipt(start.ID)
+	if !receipt.Succeeded() || receipt.ReturnValue == nil || receipt.ReturnValue.Int != 60000 {
+		t.Fatalf("start receipt = %+v, want a deposit of 60000", receipt)
+	}
+	id := ContractScheduleID(receipt.TxID, 0)
+	if schedules := bc.Schedules(ticker); len(schedules) != 1 || schedules[0].ID != id || schedules[0].RunsLeft != 3 {
+		t.Fatalf("Schedules = %+v, want three runs of %s", schedules, id)
+	}
+	if contract, _ := bc.GetContract(ticker); contract.Balance != 40000 {
+		t.Fatalf("balance after scheduling = %d, want 40000", contract.Balance)
+	}
+
+	for bc.LastBlock().Height < 9 {
+		if _, err := bc.AddBlock(nil, validator); err != nil {
+			t.Fatalf("AddBlock: %v", err)
+		}
+	}
+	result, err := bc.CallContract(ticker, &contracts.CallContext{Method: "get", GasLimit: contracts.DefaultGasLimit})
+	if err != nil || result.ReturnValue.Int != 6 {
+		t.Fatalf("get = %v, %v, want 6 after three runs", result.ReturnValue, err)
+	}
+	if schedules := bc.Schedules(ticker); len(schedules) != 0 {
+		t.Fatalf("Schedules = %+v after the last run, want none", schedules)
+	}
+
+	// The gas the runs used went to the validator; no coin was lost
+	runFees := 0
+	for _, height := range []int{5, 7, 9} {
+		run, ok := bc.Receipt(ScheduledRunID(id, height))
+		if !ok || !run.Succeeded() || run.Fee == 0 || run.Fee != int64(run.GasUsed)*ScheduledGasPrice {
+			t.Fatalf("receipt of the run at %d = %+v, want a successful run paying its gas", height, run)
+		}
+		runFees += int(run.Fee)
+	}
+	contract, _ := bc.GetContract(ticker)
+	if contract.Balance != 100000-int64(runFees) {
+		t.Fatalf("balance after the runs = %d, want %d", contract.Balance, 100000-runFees)
+	}
+	fees := bc.GetBalance(validator.PublicKey)
+	if total := fees + bc.GetBalance(alice.PublicKey) + int(contract.Balance); total != 10_000_000 {
+		t.Fatalf("validator %d + alice %d + contract %d = %d, want the 10000000 minted", fees, bc.GetBalance(alice.PublicKey), contract.Balance, total)
+	}
+}
+
+func TestContractScheduleFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		height   int64
+		interval int64
+		runs     int64
+	}{
+		{"first run at the current height", 3, 0, 1},
+		{"deposit above the balance", 10, 1, 6},
+		{"repeated without an interval", 10, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := NewWallet()
+			alice := NewWallet()
+			bc := newTestChain()
+			ticker := deployTicker(t, bc, validator, alice)
+
+			start, err := NewCallTransaction(alice, bc.ContractNonce(alice.Address), ticker, "start",
+				[]contracts.Value{contracts.IntValue(tt.height), contracts.IntValue(tt.interval), contracts.IntValue(tt.runs)}, 50000, 100000, bc.GetUTXOsForAddress(alice.PublicKey))
+			if err != nil {
+				t.Fatalf("NewCallTransaction: %v", err)
+			}
+			if _, err := bc.AddBlock([]*Transaction{start}, validator); err != nil {
+				t.Fatalf("AddBlock: %v", err)
+			}
+			if receipt, _ := bc.Receipt(start.ID); receipt.Succeeded() {
+				t.Fatal("start succeeded")
+			}
+			if schedules := bc.Schedules(ticker); len(schedules) != 0 {
+				t.Fatalf("Schedules = %+v, want none", schedules)
+			}
+			if contract, _ := bc.GetContract(ticker); contract.Balance != 0 {
+				t.Fatalf("balance = %d, want the value refunded", contract.Balance)
+			}
+		})
+	}
+}
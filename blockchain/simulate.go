@@ -0,0 +1,262 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// Simulation is the outcome of dry-running a transaction against the current
+// chain state: the receipt it would get if it were included in the next block
+// and the state changes it would make.
+type Simulation struct {
+	Receipt *Receipt   `json:"receipt"`
+	Diff    *StateDiff `json:"diff"`
+}
+
+// StateDiff lists the changes a transaction makes to the chain state.
+type StateDiff struct {
+	Spent     []*UTXO        `json:"spent"`     // Outputs consumed by the transaction
+	Created   []*UTXO        `json:"created"`   // Outputs created by the transaction, including a contract transaction's fee, without an owner, and refund
+	Nonces    []NonceChange  `json:"nonces"`    // Account nonces consumed
+	Contracts []ContractDiff `json:"contracts"` // Contracts deployed, upgraded, frozen or whose state changed
+}
+
+// NonceChange is the change of an account's contract transaction nonce.
+type NonceChange struct {
+	Account string `json:"account"`
+	Before  uint64 `json:"before"`
+	After   uint64 `json:"after"`
+}
+
+// ContractDiff is the change of a contract's state, balance and code.
+type ContractDiff struct {
+	Address       string                  `json:"address"`
+	Deployed      bool                    `json:"deployed"` // Whether the transaction deploys the contract
+	Storage       []contracts.StateChange `json:"storage"`  // Changed state entries, sorted by key
+	BalanceBefore int64                   `json:"balanceBefore"`
+	BalanceAfter  int64                   `json:"balanceAfter"`
+	VersionBefore int                     `json:"versionBefore"`
+	VersionAfter  int                     `json:"versionAfter"`
+	Frozen        bool                    `json:"frozen"` // Whether the transaction freezes the contract
+}
+
+// Simulate executes a transaction as if it were included in the next block,
+// without changing the chain. Its contract calls are given
+// contracts.MaxCallDuration to run.
+// Parameters:
+//   - tx: A signed transfer or contract transaction
+//
+// Returns:
+//   - The receipt and state diff of the transaction; a contract transaction
+//     that would fail still has a receipt, with the error and gas used
+//   - An error if the transaction could not be included in a block: an
+//     invalid signature, a wrong nonce, an input that spends an unknown,
+//     foreign or already spent output, outputs worth more than its inputs,
+//     or a contract transaction whose inputs do not pay exactly its fee and value
+func (bc *Blockchain) Simulate(tx *Transaction) (*Simulation, error) {
+	if tx.Contract != nil {
+		if err := tx.Contract.check(tx); err != nil {
+			return nil, err
+		}
+	}
+	if !tx.Verify() {
+		return nil, errors.New("invalid transaction signature")
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if err := bc.checkTransactionIDs([]*Transaction{tx}, bc.Blocks[len(bc.Blocks)-1].Height+1); err != nil {
+		return nil, err
+	}
+	if err := bc.Contracts.Check([]*Transaction{tx}); err != nil {
+		return nil, err
+	}
+	spent, err := bc.UTXOs.spentBy(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.UTXOs.checkSpending([]*Transaction{tx}); err != nil {
+		return nil, err
+	}
+
+	diff := &StateDiff{Spent: spent}
+	for i, output := range tx.Output {
+		diff.Created = append(diff.Created, &UTXO{
+			TransactionID: tx.ID,
+			OutputIndex:   i,
+			Value:         output.Value,
+			PublicKey:     output.PublicKey,
+			Asset:         output.Asset,
+		})
+	}
+
+	height := bc.Blocks[len(bc.Blocks)-1].Height + 1
+	receipt := &Receipt{
+		TxID:        hex.EncodeToString(tx.ID),
+		BlockHeight: height,
+		Status:      ReceiptSuccess,
+	}
+	if tx.Contract != nil {
+		overlay := bc.Contracts.overlay()
+		overlay.deadline = time.Now().Add(contracts.MaxCallDuration)
+		overlay.execute(tx, height, bc.Clock(), receipt)
+		diff.Nonces, diff.Contracts = bc.Contracts.diff(overlay)
+		// The validator of the next block, who gets the fee, is not known yet
+		diff.Created = append(diff.Created, contractOutputs(tx, nil, receipt.Succeeded())...)
+	}
+
+	return &Simulation{Receipt: receipt, Diff: diff}, nil
+}
+
+// MaxValue is the largest value of a single output, and of the inputs or
+// outputs of a transaction in one asset added together.
+const MaxValue = math.MaxInt64
+
+// addValue adds a positive value to a total of the same asset.
+// Returns an error if the sum exceeds MaxValue.
+func addValue(total, value int) (int, error) {
+	if value > MaxValue-total {
+		return 0, fmt.Errorf("values add up to more than %d", MaxValue)
+	}
+	return total + value, nil
+}
+
+// spentBy returns the outputs a transaction's inputs spend. Every input must
+// spend an unspent output of its own key, at most once, and the transaction
+// must conserve every asset: it may not create more of the native coin than
+// it spends, and its outputs of an issued asset must be worth exactly what
+// it spends of it, except for the asset the transaction issues (see
+// IssuedAsset). No output may be worth more than MaxValue, nor the inputs or
+// outputs of one asset together. Transactions without inputs create new native coin and are
+// not limited, but cannot create issued assets.
+// Inputs may also spend the outputs in created, which earlier transactions
+// of the same block create; created may be nil.
+func (us *UTXOSet) spentBy(tx *Transaction, created map[string]*UTXO) ([]*UTXO, error) {
+	var spent []*UTXO
+	inputValues := make(map[string]int)
+	seen := make(map[string]bool)
+	for i, input := range tx.Input {
+		key := fmt.Sprintf("%x_%d", input.TransactionID, input.OutputIndex)
+		utxo, ok := us.UTXOs[key]
+		if !ok {
+			utxo, ok = created[key]
+		}
+		if !ok || seen[key] {
+			return nil, fmt.Errorf("input %d spends an unknown or spent output %s", i, key)
+		}
+		if !bytes.Equal(utxo.PublicKey, input.PublicKey) {
+			return nil, fmt.Errorf("input %d spends output %s of another owner", i, key)
+		}
+		seen[key] = true
+		spent = append(spent, utxo)
+		total, err := addValue(inputValues[utxo.Asset], utxo.Value)
+		if err != nil {
+			return nil, fmt.Errorf("inputs of asset %s: %v", utxo.Asset, err)
+		}
+		inputValues[utxo.Asset] = total
+	}
+
+	outputValues := make(map[string]int)
+	for i, output := range tx.Output {
+		if output.Value <= 0 || output.Value > MaxValue {
+			return nil, fmt.Errorf("output %d has a value outside 1 to %d", i, MaxValue)
+		}
+		if len(tx.Input) == 0 && output.Asset != NativeAsset {
+			return nil, fmt.Errorf("output %d creates asset %s without spending any input", i, output.Asset)
+		}
+		total, err := addValue(outputValues[output.Asset], output.Value)
+		if err != nil {
+			return nil, fmt.Errorf("outputs of asset %s: %v", output.Asset, err)
+		}
+		outputValues[output.Asset] = total
+	}
+	if len(tx.Input) == 0 {
+		return spent, nil
+	}
+
+	if outputValue, inputValue := outputValues[NativeAsset], inputValues[NativeAsset]; outputValue > inputValue {
+		return nil, fmt.Errorf("outputs are worth %d, more than the %d spent", outputValue, inputValue)
+	}
+	issued := IssuedAsset(tx)
+	assets := make(map[string]bool)
+	for asset := range inputValues {
+		assets[asset] = true
+	}
+	for asset := range outputValues {
+		assets[asset] = true
+	}
+	for _, asset := range slices.Sorted(maps.Keys(assets)) {
+		if asset == NativeAsset || asset == issued {
+			continue
+		}
+		if outputValue, inputValue := outputValues[asset], inputValues[asset]; outputValue != inputValue {
+			return nil, fmt.Errorf("outputs of asset %s are worth %d, but %d are spent", asset, outputValue, inputValue)
+		}
+	}
+	return spent, nil
+}
+
+// overlay returns a contract set to execute transactions on without
+// changing cs. It holds copies of the nonces and of the state tree and
+// shares the contracts of cs, which execution only reads: deployments and
+// calls add or replace contracts in the overlay instead of changing them.
+// Schedules are replaced rather than changed, like contracts. Receipts,
+// runs and rent charges are not copied.
+func (cs *ContractSet) overlay() *ContractSet {
+	return &ContractSet{
+		Contracts:   maps.Clone(cs.Contracts),
+		Nonces:      maps.Clone(cs.Nonces),
+		Receipts:    make(map[string]*Receipt),
+		Schedules:   maps.Clone(cs.Schedules),
+		Runs:        make(map[int][]string),
+		RentCharges: make(map[int][]RentCharge),
+		State:       cs.State.copy(),
+		accounts:    maps.Clone(cs.accounts),
+	}
+}
+
+// diff compares an overlay of cs with cs.
+func (cs *ContractSet) diff(overlay *ContractSet) ([]NonceChange, []ContractDiff) {
+	var nonces []NonceChange
+	for _, account := range slices.Sorted(maps.Keys(overlay.Nonces)) {
+		if before, after := cs.Nonces[account], overlay.Nonces[account]; before != after {
+			nonces = append(nonces, NonceChange{Account: account, Before: before, After: after})
+		}
+	}
+
+	var changed []ContractDiff
+	for _, address := range slices.Sorted(maps.Keys(overlay.Contracts)) {
+		contract := overlay.Contracts[address]
+		original, ok := cs.Contracts[address]
+		if ok && original == contract {
+			continue
+		}
+
+		contractDiff := ContractDiff{Address: address, BalanceAfter: contract.Balance, VersionAfter: contract.Version()}
+		var before map[string]interface{}
+		if ok {
+			before = original.State
+			contractDiff.BalanceBefore = original.Balance
+			contractDiff.VersionBefore = original.Version()
+			contractDiff.Frozen = contract.Frozen && !original.Frozen
+		} else {
+			contractDiff.Deployed = true
+		}
+		contractDiff.Storage = contracts.DiffState(before, contract.State)
+
+		if contractDiff.Deployed || len(contractDiff.Storage) > 0 || contractDiff.BalanceBefore != contractDiff.BalanceAfter ||
+			contractDiff.VersionBefore != contractDiff.VersionAfter || contractDiff.Frozen {
+			changed = append(changed, contractDiff)
+		}
+	}
+	return nonces, changed
+}
@@ -0,0 +1,395 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// stateTreeDepth is the number of levels of the state tree: one per bit of
+// a storage slot.
+const stateTreeDepth = 256
+
+// Domain separation prefixes of the state tree, like those of the
+// transaction Merkle tree.
+const (
+	stateLeafPrefix = 0x00
+	stateNodePrefix = 0x01
+)
+
+// emptyStateHashes[d] is the hash of an empty subtree rooted at depth d:
+// emptyStateHashes[stateTreeDepth] is an empty leaf and
+// emptyStateHashes[0] the root of an empty tree.
+var emptyStateHashes = func() [][]byte {
+	hashes := make([][]byte, stateTreeDepth+1)
+	hashes[stateTreeDepth] = make([]byte, sha256.Size)
+	for d := stateTreeDepth - 1; d >= 0; d-- {
+		hashes[d] = stateNodeHash(hashes[d+1], hashes[d+1])
+	}
+	return hashes
+}()
+
+// EmptyStateRoot returns the state root of a chain without contract storage.
+func EmptyStateRoot() []byte {
+	return bytes.Clone(emptyStateHashes[0])
+}
+
+// NodeStore holds the inner nodes of state trees, keyed by their hash. A
+// node's data is the concatenation of its children's hashes. Updates leave
+// the nodes of earlier roots in place; the chain periodically prunes those
+// that none of the roots it may still revert to reach (see
+// Blockchain.pruneStateNodes).
+type NodeStore interface {
+	// GetNode returns the data of the node with the given hash, or false
+	// if the store does not have it.
+	GetNode(hash []byte) ([]byte, bool, error)
+	// PutNodes stores nodes, keyed by their hash.
+	PutNodes(nodes map[string][]byte) error
+	// PruneNodes removes every node whose hash keep rejects.
+	PruneNodes(keep func(hash []byte) bool) error
+}
+
+// MemoryNodeStore is a NodeStore kept in memory.
+type MemoryNodeStore struct {
+	nodes map[string][]byte
+	mu    sync.RWMutex
+}
+
+// NewMemoryNodeStore creates an empty in-memory node store.
+func NewMemoryNodeStore() *MemoryNodeStore {
+	return &MemoryNodeStore{nodes: make(map[string][]byte)}
+}
+
+// GetNode implements NodeStore.
+func (s *MemoryNodeStore) GetNode(hash []byte) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.nodes[string(hash)]
+	return data, ok, nil
+}
+
+// PutNodes implements NodeStore.
+func (s *MemoryNodeStore) PutNodes(nodes map[string][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, data := range nodes {
+		s.nodes[hash] = data
+	}
+	return nil
+}
+
+// PruneNodes implements NodeStore.
+func (s *MemoryNodeStore) PruneNodes(keep func(hash []byte) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash := range s.nodes {
+		if !keep([]byte(hash)) {
+			delete(s.nodes, hash)
+		}
+	}
+	return nil
+}
+
+// StateTree is a sparse Merkle tree committing to the storage of every
+// contract. Each storage entry is a leaf at the position given by the bits
+// of its slot (see StorageSlot); the leaf hashes the slot and the entry's
+// value. Subtrees without entries have fixed hashes and are not stored.
+//
+// Updates create new nodes and leave the old ones in place, so the tree can
+// be copied cheaply (see copy) to try changes out. New nodes are kept in
+// memory until Commit writes them to the node store.
+type StateTree struct {
+	root  []byte
+	nodes NodeStore
+	dirty map[string][]byte // Nodes created since the last Commit
+}
+
+// NewStateTree creates an empty state tree whose nodes are kept in nodes.
+func NewStateTree(nodes NodeStore) *StateTree {
+	return &StateTree{
+		root:  EmptyStateRoot(),
+		nodes: nodes,
+		dirty: make(map[string][]byte),
+	}
+}
+
+// Root returns the root hash of the tree.
+func (t *StateTree) Root() []byte {
+	return bytes.Clone(t.root)
+}
+
+// StorageSlot returns the position in the state tree of a contract's storage key.
+func StorageSlot(address string, key string) []byte {
+	slot := sha256.Sum256([]byte(address + "/" + key))
+	return slot[:]
+}
+
+// AccountSlot returns the position in the state tree of a contract's
+// account leaf (see encodeAccount). The "account:" prefix keeps account
+// slots apart from storage slots.
+func AccountSlot(address string) []byte {
+	slot := sha256.Sum256([]byte("account:" + address))
+	return slot[:]
+}
+
+// encodeAccount encodes the account leaf of a contract: its balance, the
+// hash and version of its code, whether it is frozen, the hash of its owner,
+// the root of its archived state and the hash of its pending schedules, the
+// last two zero if there are none.
+func encodeAccount(contract *contracts.SmartContract, schedules []byte) []byte {
+	codeHash := contracts.CodeHash(contract.Code)
+	if len(contract.Versions) > 0 {
+		codeHash = contract.Versions[len(contract.Versions)-1].CodeHash
+	}
+	archiveRoot := make([]byte, sha256.Size)
+	if contract.Archive != nil {
+		archiveRoot, _ = hex.DecodeString(contract.Archive.StateRoot)
+	}
+	if schedules == nil {
+		schedules = make([]byte, sha256.Size)
+	}
+	owner := sha256.Sum256([]byte(contract.Owner))
+
+	data := binary.BigEndian.AppendUint64(nil, uint64(contract.Balance))
+	code, _ := hex.DecodeString(codeHash)
+	data = append(data, code...)
+	data = binary.BigEndian.AppendUint64(data, uint64(contract.Version()))
+	if contract.Frozen {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	data = append(data, owner[:]...)
+	data = append(data, archiveRoot...)
+	return append(data, schedules...)
+}
+
+// encodeStateValue encodes a storage value for its leaf: a kind byte,
+// 0 for integers and 1 for byte strings, followed by the value's encoding.
+func encodeStateValue(v contracts.Value) []byte {
+	kind := byte(0)
+	if v.IsBytes {
+		kind = 1
+	}
+	return append([]byte{kind}, v.Encode()...)
+}
+
+// Update sets the value of a slot; a nil value removes the slot's entry.
+func (t *StateTree) Update(slot []byte, value []byte) error {
+	if len(slot) != sha256.Size {
+		return fmt.Errorf("state slot must be %d bytes", sha256.Size)
+	}
+
+	siblings, err := t.path(slot)
+	if err != nil {
+		return err
+	}
+	hash := emptyStateHashes[stateTreeDepth]
+	if value != nil {
+		hash = stateLeafHash(slot, value)
+	}
+	for d := stateTreeDepth - 1; d >= 0; d-- {
+		left, right := hash, siblings[d]
+		if slotBit(slot, d) {
+			left, right = siblings[d], hash
+		}
+		hash = t.node(left, right, d)
+	}
+	t.root = hash
+	return nil
+}
+
+// Prove returns the siblings of the path to a slot's leaf in the compressed
+// form of a StateProof: a bitmap of the non-empty siblings and their hashes.
+func (t *StateTree) Prove(slot []byte) (bitmap []byte, siblings [][]byte, err error) {
+	path, err := t.path(slot)
+	if err != nil {
+		return nil, nil, err
+	}
+	bitmap = make([]byte, stateTreeDepth/8)
+	for d, sibling := range path {
+		if !bytes.Equal(sibling, emptyStateHashes[d+1]) {
+			bitmap[d/8] |= 0x80 >> (d % 8)
+			siblings = append(siblings, sibling)
+		}
+	}
+	return bitmap, siblings, nil
+}
+
+// Commit writes the nodes created since the last Commit to the node store.
+func (t *StateTree) Commit() error {
+	if len(t.dirty) == 0 {
+		return nil
+	}
+	if err := t.nodes.PutNodes(t.dirty); err != nil {
+		return err
+	}
+	t.dirty = make(map[string][]byte)
+	return nil
+}
+
+// mark adds the hashes of the nodes reachable from root to live.
+func (t *StateTree) mark(root []byte, live map[string]bool) error {
+	var walk func(hash []byte, d int) error
+	walk = func(hash []byte, d int) error {
+		if d == stateTreeDepth || bytes.Equal(hash, emptyStateHashes[d]) || live[string(hash)] {
+			return nil
+		}
+		live[string(hash)] = true
+		left, right, err := t.children(hash, d)
+		if err != nil {
+			return err
+		}
+		if err := walk(left, d+1); err != nil {
+			return err
+		}
+		return walk(right, d+1)
+	}
+	return walk(root, 0)
+}
+
+// copy returns a copy of the tree that can be updated without changing t.
+func (t *StateTree) copy() *StateTree {
+	dirty := make(map[string][]byte, len(t.dirty))
+	for hash, data := range t.dirty {
+		dirty[hash] = data
+	}
+	return &StateTree{root: t.root, nodes: t.nodes, dirty: dirty}
+}
+
+// path returns the siblings of the nodes on the path from the root to a
+// slot's leaf, root level first: siblings[d] is the sibling of the node at
+// depth d+1.
+func (t *StateTree) path(slot []byte) ([][]byte, error) {
+	siblings := make([][]byte, stateTreeDepth)
+	hash := t.root
+	for d := 0; d < stateTreeDepth; d++ {
+		left, right, err := t.children(hash, d)
+		if err != nil {
+			return nil, err
+		}
+		if slotBit(slot, d) {
+			siblings[d], hash = left, right
+		} else {
+			siblings[d], hash = right, left
+		}
+	}
+	return siblings, nil
+}
+
+// children returns the children of the node with the given hash at depth d.
+func (t *StateTree) children(hash []byte, d int) ([]byte, []byte, error) {
+	if bytes.Equal(hash, emptyStateHashes[d]) {
+		return emptyStateHashes[d+1], emptyStateHashes[d+1], nil
+	}
+	data, ok := t.dirty[string(hash)]
+	if !ok {
+		var err error
+		data, ok, err = t.nodes.GetNode(hash)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if !ok || len(data) != 2*sha256.Size {
+		return nil, nil, fmt.Errorf("state tree node %x is missing", hash)
+	}
+	return data[:sha256.Size], data[sha256.Size:], nil
+}
+
+// node returns the hash of the node at depth d with the given children,
+// recording the node unless its subtree is empty.
+func (t *StateTree) node(left, right []byte, d int) []byte {
+	if bytes.Equal(left, emptyStateHashes[d+1]) && bytes.Equal(right, emptyStateHashes[d+1]) {
+		return emptyStateHashes[d]
+	}
+	hash := stateNodeHash(left, right)
+	t.dirty[string(hash)] = append(bytes.Clone(left), right...)
+	return hash
+}
+
+// StateProof proves the value of a contract's storage entry in the state
+// committed to by a block header's state root.
+// It contains:
+//   - Address, Key: The contract and the storage key
+//   - Value: The entry's encoded value, a kind byte (0 for integers, 1 for
+//     byte strings) followed by the value; nil proves the entry is unset
+//   - BlockHash, Height: The block whose state root the proof is for
+//   - Bitmap: One bit per tree level, root level first (most significant
+//     bit first), set if the sibling at that level is not an empty subtree
+//   - Siblings: The hashes of the non-empty siblings, root level first
+type StateProof struct {
+	Address   string
+	Key       string
+	Value     []byte
+	BlockHash []byte
+	Height    int
+	Bitmap    []byte
+	Siblings  [][]byte
+}
+
+// Verify reports whether the proof is valid for the given state root.
+func (p *StateProof) Verify(root []byte) bool {
+	if len(p.Bitmap) != stateTreeDepth/8 {
+		return false
+	}
+
+	siblings := make([][]byte, stateTreeDepth)
+	next := 0
+	for d := range siblings {
+		siblings[d] = emptyStateHashes[d+1]
+		if p.Bitmap[d/8]&(0x80>>(d%8)) != 0 {
+			if next >= len(p.Siblings) || len(p.Siblings[next]) != sha256.Size {
+				return false
+			}
+			siblings[d] = p.Siblings[next]
+			next++
+		}
+	}
+	if next != len(p.Siblings) {
+		return false
+	}
+
+	slot := StorageSlot(p.Address, p.Key)
+	hash := emptyStateHashes[stateTreeDepth]
+	if p.Value != nil {
+		hash = stateLeafHash(slot, p.Value)
+	}
+	for d := stateTreeDepth - 1; d >= 0; d-- {
+		left, right := hash, siblings[d]
+		if slotBit(slot, d) {
+			left, right = siblings[d], hash
+		}
+		hash = stateNodeHash(left, right)
+		if bytes.Equal(left, emptyStateHashes[d+1]) && bytes.Equal(right, emptyStateHashes[d+1]) {
+			hash = emptyStateHashes[d]
+		}
+	}
+	return bytes.Equal(hash, root)
+}
+
+// slotBit returns the bit of slot that selects the child at depth d: false
+// for the left child and true for the right one.
+func slotBit(slot []byte, d int) bool {
+	return slot[d/8]&(0x80>>(d%8)) != 0
+}
+
+// stateLeafHash hashes a slot and its encoded value into a leaf.
+func stateLeafHash(slot, value []byte) []byte {
+	valueHash := sha256.Sum256(value)
+	hash := sha256.Sum256(bytes.Join([][]byte{{stateLeafPrefix}, slot, valueHash[:]}, nil))
+	return hash[:]
+}
+
+// stateNodeHash hashes two children into their parent.
+func stateNodeHash(left, right []byte) []byte {
+	hash := sha256.Sum256(bytes.Join([][]byte{{stateNodePrefix}, left, right}, nil))
+	return hash[:]
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+func TestStateRootCommitsToAccounts(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bc := newTestChain()
+	address := deployTestContract(t, bc, validator, alice)
+	deployed := bc.LastBlock().StateRoot
+
+	// A call that writes no storage still changes the contract's balance
+	call, err := NewCallTransaction(alice, bc.ContractNonce(alice.Address), address, "run", []contracts.Value{contracts.IntValue(0)}, 5000, 300, bc.GetUTXOsForAddress(alice.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCallTransaction: %v", err)
+	}
+	if _, err := bc.AddBlock([]*Transaction{call}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	if bytes.Equal(bc.LastBlock().StateRoot, deployed) {
+		t.Fatal("state root unchanged by a balance change")
+	}
+
+	// A node whose contract accounts diverge rejects the next block
+	tampered := newTestChain()
+	if _, _, err := tampered.ImportBlocks(bc.AllBlocks()[1:]); err != nil {
+		t.Fatalf("ImportBlocks: %v", err)
+	}
+	contract := tampered.Contracts.Contracts[address].Copy()
+	contract.Owner = alice.Address + "x"
+	tampered.Contracts.Contracts[address] = contract
+
+	block, err := bc.AddBlock(nil, validator)
+	if err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	if err := tampered.AcceptBlock(block); !errors.Is(err, ErrInvalidStateRoot) {
+		t.Fatalf("AcceptBlock with a different contract owner = %v, want %v", err, ErrInvalidStateRoot)
+	}
+}
+
+func TestPruneStateNodesKeepsRevertibleRoots(t *testing.T) {
+	validator := NewWallet()
+	alice := NewWallet()
+	bc := newTestChain()
+	ticker := deployTicker(t, bc, validator, alice)
+
+	// Every run rewrites the ticker's storage, leaving the old nodes behind
+	first := bc.LastBlock().Height + 2
+	start, err := NewCallTransaction(alice, bc.ContractNonce(alice.Address), ticker, "start",
+		[]contracts.Value{contracts.IntValue(int64(first)), contracts.IntValue(1), contracts.IntValue(5)}, 50000, 200000, bc.GetUTXOsForAddress(alice.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCallTransaction: %v", err)
+	}
+	if _, err := bc.AddBlock([]*Transaction{start}, validator); err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	mintBlocks(t, bc, validator, alice, 10, 6)
+
+	store := bc.Contracts.State.nodes.(*MemoryNodeStore)
+	if err := bc.pruneStateNodes(); err != nil {
+		t.Fatalf("pruneStateNodes: %v", err)
+	}
+	for height, undo := range bc.undo {
+		if err := bc.Contracts.State.mark(undo.contracts.root, make(map[string]bool)); err != nil {
+			t.Fatalf("root before block %d after pruning: %v", height, err)
+		}
+	}
+	before := len(store.nodes)
+
+	// Once the roots are too old to revert to, their nodes go
+	bc.undo = map[int]*blockUndo{bc.LastBlock().Height: bc.undo[bc.LastBlock().Height]}
+	if err := bc.pruneStateNodes(); err != nil {
+		t.Fatalf("pruneStateNodes: %v", err)
+	}
+	if len(store.nodes) >= before {
+		t.Fatalf("%d nodes after pruning, want fewer than %d", len(store.nodes), before)
+	}
+	if _, _, err := bc.StorageProof(ticker, "ticks"); err != nil {
+		t.Fatalf("StorageProof after pruning: %v", err)
+	}
+	bc.disconnectTip()
+	if _, _, err := bc.StorageProof(ticker, "ticks"); err != nil {
+		t.Fatalf("StorageProof after reverting a block: %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// FungibleToken describes a contract implementing the fungible token
+// standard (see contracts.IsFungibleToken).
+type FungibleToken struct {
+	Address     string `json:"address"`
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Decimals    int64  `json:"decimals"`
+	Metadata    string `json:"metadata"`
+	Issuer      string `json:"issuer"`
+	TotalSupply int64  `json:"totalSupply"`
+}
+
+// TokenBalance is an account's balance of a fungible token, in the token's
+// smallest unit.
+type TokenBalance struct {
+	Token    string `json:"token"` // Address of the token contract
+	Symbol   string `json:"symbol"`
+	Decimals int64  `json:"decimals"`
+	Balance  int64  `json:"balance"`
+}
+
+// NFTCollection describes a contract implementing the NFT collection
+// standard (see contracts.IsNFTCollection).
+type NFTCollection struct {
+	Address     string `json:"address"`
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Issuer      string `json:"issuer"`
+	TotalSupply int64  `json:"totalSupply"`
+}
+
+// NewCreateTokenTransactions creates the signed transactions creating a
+// fungible token: the deployment of the reference token (see
+// contracts.FungibleTokenSource) and the call to its init method, which must
+// be included in that order in the same block so that no other account can
+// initialize the token first.
+// Parameters:
+//   - wallet: The token's issuer
+//   - nonce: The account's current nonce (see Blockchain.ContractNonce)
+//   - name, symbol, decimals, metadata: The token's description
+//   - initialSupply: Amount minted to the issuer
+//   - utxos: The wallet's UTXOs to pay the fees of both transactions with
+//
+// The token is deployed at ContractAddress(wallet.Address, nonce).
+// Returns an error if the reference token does not compile, or one wrapping
+// ErrInsufficientFunds if the UTXOs do not cover the fees.
+func NewCreateTokenTransactions(wallet *Wallet, nonce uint64, name, symbol string, decimals int64, metadata string, initialSupply int64, utxos []*UTXO) ([]*Transaction, error) {
+	return newReferenceContractTransactions(wallet, nonce, utxos, contracts.FungibleTokenSource,
+		contracts.BytesValue([]byte(name)),
+		contracts.BytesValue([]byte(symbol)),
+		contracts.IntValue(decimals),
+		contracts.BytesValue([]byte(metadata)),
+		contracts.IntValue(initialSupply),
+	)
+}
+
+// NewCreateCollectionTransactions creates the signed transactions creating
+// an NFT collection from the reference collection (see
+// contracts.NFTCollectionSource), with wallet as its issuer. Like those of
+// NewCreateTokenTransactions, they must be included in order in the same
+// block; the collection is deployed at ContractAddress(wallet.Address, nonce)
+// and the fees are paid from utxos.
+func NewCreateCollectionTransactions(wallet *Wallet, nonce uint64, name, symbol string, utxos []*UTXO) ([]*Transaction, error) {
+	return newReferenceContractTransactions(wallet, nonce, utxos, contracts.NFTCollectionSource,
+		contracts.BytesValue([]byte(name)),
+		contracts.BytesValue([]byte(symbol)),
+	)
+}
+
+// newReferenceContractTransactions creates the transactions deploying a
+// reference contract and calling its init method with args. The call pays
+// its fee from the UTXOs the deployment leaves, including its change, which
+// it can spend in the same block.
+func newReferenceContractTransactions(wallet *Wallet, nonce uint64, utxos []*UTXO, source string, args ...contracts.Value) ([]*Transaction, error) {
+	compiled, err := contracts.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	address := ContractAddress(wallet.Address, nonce)
+	deploy, err := NewDeployTransaction(wallet, nonce, hex.EncodeToString(compiled.Bytecode), compiled.ABI, source, "", utxos)
+	if err != nil {
+		return nil, err
+	}
+	initialize, err := NewCallTransaction(wallet, nonce+1, address, "init", args, contracts.DefaultGasLimit, 0, unspentAfter(deploy, utxos))
+	if err != nil {
+		return nil, err
+	}
+	return []*Transaction{deploy, initialize}, nil
+}
+
+// unspentAfter returns the UTXOs that remain after tx: its change outputs
+// and the UTXOs it does not spend.
+func unspentAfter(tx *Transaction, utxos []*UTXO) []*UTXO {
+	spent := make(map[string]bool)
+	for _, input := range tx.Input {
+		spent[fmt.Sprintf("%x_%d", input.TransactionID, input.OutputIndex)] = true
+	}
+
+	var remaining []*UTXO
+	for i, output := range tx.Output {
+		remaining = append(remaining, &UTXO{
+			TransactionID: tx.ID,
+			OutputIndex:   i,
+			Value:         output.Value,
+			PublicKey:     output.PublicKey,
+			Asset:         output.Asset,
+		})
+	}
+	for _, utxo := range utxos {
+		if !spent[fmt.Sprintf("%x_%d", utxo.TransactionID, utxo.OutputIndex)] {
+			remaining = append(remaining, utxo)
+		}
+	}
+	return remaining
+}
+
+// FungibleTokens returns the contracts implementing the fungible token
+// standard, sorted by address.
+func (bc *Blockchain) FungibleTokens() []*FungibleToken {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var tokens []*FungibleToken
+	for _, address := range slices.Sorted(maps.Keys(bc.Contracts.Contracts)) {
+		if !contracts.IsFungibleToken(bc.Contracts.Contracts[address].ABI) {
+			continue
+		}
+		if token, err := bc.fungibleToken(address); err == nil {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// FungibleToken describes the fungible token at address.
+// Returns an error if there is no contract at address or it does not
+// implement the fungible token standard.
+func (bc *Blockchain) FungibleToken(address string) (*FungibleToken, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	contract, ok := bc.Contracts.Contracts[address]
+	if !ok {
+		return nil, fmt.Errorf("no contract deployed at %s", address)
+	}
+	if !contracts.IsFungibleToken(contract.ABI) {
+		return nil, fmt.Errorf("contract %s is not a fungible token", address)
+	}
+	return bc.fungibleToken(address)
+}
+
+// TokenBalances returns the nonzero balances of an account in every
+// fungible token, sorted by token address.
+func (bc *Blockchain) TokenBalances(account string) []TokenBalance {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var balances []TokenBalance
+	for _, address := range slices.Sorted(maps.Keys(bc.Contracts.Contracts)) {
+		if !contracts.IsFungibleToken(bc.Contracts.Contracts[address].ABI) {
+			continue
+		}
+		balance, err := bc.view(address, "balanceOf", contracts.BytesValue([]byte(account)))
+		if err != nil || balance.Int == 0 {
+			continue
+		}
+		token, err := bc.fungibleToken(address)
+		if err != nil {
+			continue
+		}
+		balances = append(balances, TokenBalance{
+			Token:    address,
+			Symbol:   token.Symbol,
+			Decimals: token.Decimals,
+			Balance:  balance.Int,
+		})
+	}
+	return balances
+}
+
+// fungibleToken reads the description of a fungible token. The caller must
+// hold bc.mu.
+func (bc *Blockchain) fungibleToken(address string) (*FungibleToken, error) {
+	token := &FungibleToken{Address: address}
+	err := bc.readViews(address, []viewField{
+		{method: "name", str: &token.Name},
+		{method: "symbol", str: &token.Symbol},
+		{method: "decimals", int: &token.Decimals},
+		{method: "metadata", str: &token.Metadata},
+		{method: "issuer", str: &token.Issuer},
+		{method: "totalSupply", int: &token.TotalSupply},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// NFTCollections returns the contracts implementing the NFT collection
+// standard, sorted by address.
+func (bc *Blockchain) NFTCollections() []*NFTCollection {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var collections []*NFTCollection
+	for _, address := range slices.Sorted(maps.Keys(bc.Contracts.Contracts)) {
+		if !contracts.IsNFTCollection(bc.Contracts.Contracts[address].ABI) {
+			continue
+		}
+		if collection, err := bc.nftCollection(address); err == nil {
+			collections = append(collections, collection)
+		}
+	}
+	return collections
+}
+
+// NFTCollection describes the NFT collection at address.
+// Returns an error if there is no contract at address or it does not
+// implement the NFT collection standard.
+func (bc *Blockchain) NFTCollection(address string) (*NFTCollection, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	contract, ok := bc.Contracts.Contracts[address]
+	if !ok {
+		return nil, fmt.Errorf("no contract deployed at %s", address)
+	}
+	if !contracts.IsNFTCollection(contract.ABI) {
+		return nil, fmt.Errorf("contract %s is not an NFT collection", address)
+	}
+	return bc.nftCollection(address)
+}
+
+// nftCollection reads the description of an NFT collection. The caller must
+// hold bc.mu.
+func (bc *Blockchain) nftCollection(address string) (*NFTCollection, error) {
+	collection := &NFTCollection{Address: address}
+	err := bc.readViews(address, []viewField{
+		{method: "name", str: &collection.Name},
+		{method: "symbol", str: &collection.Symbol},
+		{method: "issuer", str: &collection.Issuer},
+		{method: "totalSupply", int: &collection.TotalSupply},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+// viewField is a method without arguments whose result readViews stores in
+// str, for strings, or int, for integers.
+type viewField struct {
+	method string
+	str    *string
+	int    *int64
+}
+
+// readViews calls the methods of a contract and stores their results. The
+// caller must hold bc.mu.
+func (bc *Blockchain) readViews(address string, fields []viewField) error {
+	for _, field := range fields {
+		value, err := bc.view(address, field.method)
+		if err != nil {
+			return err
+		}
+		if field.str != nil {
+			*field.str = string(value.Bytes)
+		} else {
+			*field.int = value.Int
+		}
+	}
+	return nil
+}
+
+// view calls a method of a contract that returns a value, against the
+// current chain state without changing it. The caller must hold bc.mu.
+func (bc *Blockchain) view(address string, method string, args ...contracts.Value) (*contracts.Value, error) {
+	result, err := contracts.NewCallTree(bc.Contracts.lookup).Call(address, &contracts.CallContext{
+		Method:      method,
+		Args:        args,
+		GasLimit:    contracts.DefaultGasLimit,
+		BlockHeight: bc.Blocks[len(bc.Blocks)-1].Height + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.ReturnValue == nil {
+		return nil, fmt.Errorf("%s of %s returned no value", method, address)
+	}
+	return result.ReturnValue, nil
+}
@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+var (
+	// ErrTransactionNotFound is returned when tracing a transaction that is
+	// not part of the chain.
+	ErrTransactionNotFound = errors.New("transaction not found")
+
+	// ErrNotContractTransaction is returned when tracing a transfer, which
+	// executes no contract code.
+	ErrNotContractTransaction = errors.New("not a contract transaction")
+)
+
+// TraceTransaction replays a contract transaction of the chain with a
+// tracer recording every step of its execution (see contracts.Tracer),
+// including the calls it makes to other contracts and, for upgrades and
+// freezes, the governance vote.
+//
+// The chain only keeps the current contract state, so the transaction is
+// replayed on a contract set rebuilt from the genesis block up to the
+// transaction's position in its block; the cost of a trace grows with the
+// chain. The chain itself is not changed.
+//
+// Returns the receipt of the replay, which matches the transaction's
+// receipt, and the tracer, or ErrTransactionNotFound or
+// ErrNotContractTransaction.
+func (bc *Blockchain) TraceTransaction(id []byte) (*Receipt, *contracts.Tracer, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	for height, block := range bc.Blocks {
+		for i, tx := range block.Transactions {
+			if !bytes.Equal(tx.ID, id) {
+				continue
+			}
+			if tx.Contract == nil {
+				return nil, nil, fmt.Errorf("transaction %x: %w", id, ErrNotContractTransaction)
+			}
+			receipt, tracer := bc.replay(height, i)
+			return receipt, tracer, nil
+		}
+	}
+	return nil, nil, ErrTransactionNotFound
+}
+
+// replay rebuilds the contract state before the transaction at index i of
+// the block at height, after the block's rent collection and scheduled runs, then executes the transaction with a tracer.
+// The caller must hold the read lock.
+func (bc *Blockchain) replay(height int, i int) (*Receipt, *contracts.Tracer) {
+	replayed := NewContractSet(NewMemoryNodeStore())
+	for _, block := range bc.Blocks[:height] {
+		replayed.Apply(block)
+	}
+
+	block := bc.Blocks[height]
+	replayed.collectRent(block)
+	replayed.runSchedules(block)
+	timestamp, _ := block.Time()
+	for j, tx := range block.Transactions[:i] {
+		replayed.execute(tx, block.Height, timestamp, newReceipt(block, j))
+	}
+
+	tracer := contracts.NewTracer()
+	replayed.tracer = tracer
+	receipt := newReceipt(block, i)
+	replayed.execute(block.Transactions[i], block.Height, timestamp, receipt)
+	return receipt, tracer
+}
@@ -9,6 +9,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"log"
@@ -20,10 +21,15 @@ import (
 //   - ID: A unique identifier (hash) of the transaction
 //   - Input: The source of the transaction (previous unspent output)
 //   - Output: The destination and amount of the transfer
+//   - Contract: For contract deployments and calls, the contract payload
+//   - MintHeight: For token generation transactions, the height of the block
+//     minting the outputs, so that repeated mints have distinct IDs
 type Transaction struct {
-	ID     []byte     // Transaction hash
-	Input  []TxInput  // Transaction inputs (sources)
-	Output []TxOutput // Transaction outputs (destinations)
+	ID         []byte      // Transaction hash
+	Input      []TxInput   // Transaction inputs (sources)
+	Output     []TxOutput  // Transaction outputs (destinations)
+	Contract   *ContractTx // Contract deployment or call; nil for transfers
+	MintHeight int         // Height of the minting block; 0 for transactions with inputs
 }
 
 // TxInput represents the source of a transaction.
@@ -43,12 +49,14 @@ type TxInput struct {
 // It contains:
 //   - Value: The amount being transferred
 //   - PublicKey: The public key of the recipient
+//   - Asset: The ID of the asset being transferred; empty for the native coin
 type TxOutput struct {
 	Value     int    // Amount to transfer
 	PublicKey []byte // Recipient's public key
+	Asset     string // Asset ID (see IssuedAsset); NativeAsset for the native coin
 }
 
-// NewTransaction creates a new transaction in the blockchain.
+// NewTransaction creates a new transaction of the native coin in the blockchain.
 // Parameters:
 //   - fromWallet: The sender's wallet
 //   - toPublicKey: The recipient's public key (as a string)
@@ -63,13 +71,20 @@ type TxOutput struct {
 //
 // Returns nil and an error if the transaction cannot be created.
 func NewTransaction(fromWallet *Wallet, toPublicKey string, amount int, utxos []*UTXO) (*Transaction, error) {
+	return NewAssetTransaction(fromWallet, toPublicKey, NativeAsset, amount, utxos)
+}
+
+// NewAssetTransaction creates a transaction of an asset, spending only the
+// sender's UTXOs of that asset and returning the change in it.
+// Returns nil and an error if the transaction cannot be created.
+func NewAssetTransaction(fromWallet *Wallet, toPublicKey string, asset string, amount int, utxos []*UTXO) (*Transaction, error) {
 	var inputs []TxInput
 	var outputs []TxOutput
 	var totalInput int
 
 	// Verify that there are enough UTXOs to cover the amount
 	for _, utxo := range utxos {
-		if bytes.Equal(utxo.PublicKey, fromWallet.PublicKey) {
+		if bytes.Equal(utxo.PublicKey, fromWallet.PublicKey) && utxo.Asset == asset {
 			totalInput += utxo.Value
 			input := TxInput{
 				TransactionID: utxo.TransactionID,
@@ -92,6 +107,7 @@ func NewTransaction(fromWallet *Wallet, toPublicKey string, amount int, utxos []
 	outputs = append(outputs, TxOutput{
 		Value:     amount,
 		PublicKey: []byte(toPublicKey), // The public key is already in the correct format
+		Asset:     asset,
 	})
 
 	// Create change output if necessary
@@ -99,6 +115,7 @@ func NewTransaction(fromWallet *Wallet, toPublicKey string, amount int, utxos []
 		outputs = append(outputs, TxOutput{
 			Value:     totalInput - amount,
 			PublicKey: fromWallet.PublicKey,
+			Asset:     asset,
 		})
 	}
 
@@ -122,54 +139,69 @@ func (tx *Transaction) Sign(privateKey *ecdsa.PrivateKey) []byte {
 	txCopy := tx.TrimmedCopy()
 
 	// Sign the transaction hash
-	r, s, err := ecdsa.Sign(rand.Reader, privateKey, txCopy.ID)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	// Concatenate r and s in DER format
-	signature := append(r.Bytes(), s.Bytes()...)
-	return signature
+	return signDigest(privateKey, txCopy.ID)
 }
 
 // Verify verifies the transaction signature
 func (tx *Transaction) Verify() bool {
 	// Create a copy of the transaction without signatures
 	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
 
-	for _, input := range tx.Input {
-		// The public key is already in the correct format (X || Y)
-		if len(input.PublicKey) != 64 { // 32 bytes for X + 32 bytes for Y
+	if tx.Contract != nil {
+		if tx.Contract.check(tx) != nil {
 			return false
 		}
-
-		// Extract X and Y from the public key
-		x := new(big.Int).SetBytes(input.PublicKey[:32])
-		y := new(big.Int).SetBytes(input.PublicKey[32:])
-
-		// Create the public key
-		publicKey := &ecdsa.PublicKey{
-			Curve: curve,
-			X:     x,
-			Y:     y,
-		}
-
-		// Split the signature into r and s
-		if len(input.Signature) != 64 { // 32 bytes for r + 32 bytes for s
+		if !verifySignature(tx.Contract.Sender, txCopy.ID, tx.Contract.Signature) {
 			return false
 		}
-		r := new(big.Int).SetBytes(input.Signature[:32])
-		s := new(big.Int).SetBytes(input.Signature[32:])
-
-		// Verify the signature
-		if !ecdsa.Verify(publicKey, txCopy.ID, r, s) {
+	}
+	for _, input := range tx.Input {
+		if !verifySignature(input.PublicKey, txCopy.ID, input.Signature) {
 			return false
 		}
 	}
 	return true
 }
 
+// signDigest signs a digest with ECDSA and returns the signature as r || s,
+// each left-padded to 32 bytes.
+func signDigest(privateKey *ecdsa.PrivateKey, digest []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature
+}
+
+// verifySignature checks an r || s signature over digest against a
+// P-256 public key encoded as X || Y.
+func verifySignature(publicKeyBytes, digest, signature []byte) bool {
+	// The public key is in the format X || Y
+	if len(publicKeyBytes) != 64 { // 32 bytes for X + 32 bytes for Y
+		return false
+	}
+
+	// Extract X and Y from the public key
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(publicKeyBytes[:32]),
+		Y:     new(big.Int).SetBytes(publicKeyBytes[32:]),
+	}
+
+	// Split the signature into r and s
+	if len(signature) != 64 { // 32 bytes for r + 32 bytes for s
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+
+	return ecdsa.Verify(publicKey, digest, r, s)
+}
+
 // TrimmedCopy creates a trimmed copy of the transaction without signatures
 func (tx *Transaction) TrimmedCopy() *Transaction {
 	var inputs []TxInput
@@ -187,12 +219,17 @@ func (tx *Transaction) TrimmedCopy() *Transaction {
 		outputs = append(outputs, TxOutput{
 			Value:     output.Value,
 			PublicKey: output.PublicKey,
+			Asset:     output.Asset,
 		})
 	}
 
 	txCopy := &Transaction{
-		Input:  inputs,
-		Output: outputs,
+		Input:      inputs,
+		Output:     outputs,
+		MintHeight: tx.MintHeight,
+	}
+	if tx.Contract != nil {
+		txCopy.Contract = tx.Contract.trimmed()
 	}
 	txCopy.ID = txCopy.HashTransaction()
 	return txCopy
@@ -205,6 +242,12 @@ func (tx *Transaction) TrimmedCopy() *Transaction {
 //   - Sender's public key
 //   - Recipient's public key
 //   - Transaction amount
+//   - The asset transferred, unless it is the native coin
+//   - The contract payload, if any, except for its signature
+//   - The mint height, if any
+//
+// Each field is prefixed with its length (see encodeFields), so bytes cannot
+// be moved between adjacent fields without changing the hash.
 //
 // Returns a SHA-256 hash of the combined data.
 func (tx *Transaction) HashTransaction() []byte {
@@ -220,12 +263,32 @@ func (tx *Transaction) HashTransaction() []byte {
 	for _, output := range tx.Output {
 		data = append(data, output.PublicKey)
 		data = append(data, []byte(fmt.Sprintf("%d", output.Value)))
+		if output.Asset != NativeAsset {
+			data = append(data, []byte("asset:"+output.Asset))
+		}
+	}
+	if tx.Contract != nil {
+		data = append(data, tx.Contract.hashData()...)
+	}
+	if tx.MintHeight != 0 {
+		data = append(data, []byte(fmt.Sprintf("mint:%d", tx.MintHeight)))
 	}
 
-	hash = sha256.Sum256(bytes.Join(data, []byte{}))
+	hash = sha256.Sum256(encodeFields(data))
 	return hash[:]
 }
 
+// encodeFields concatenates fields, each preceded by its length as a 4-byte
+// big-endian integer.
+func encodeFields(fields [][]byte) []byte {
+	var encoded []byte
+	for _, field := range fields {
+		encoded = binary.BigEndian.AppendUint32(encoded, uint32(len(field)))
+		encoded = append(encoded, field...)
+	}
+	return encoded
+}
+
 // Serialize converts the transaction into a byte array for storage or transmission.
 // Uses gob encoding to serialize the entire transaction structure.
 // Returns the serialized transaction as a byte slice.
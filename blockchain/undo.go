@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// blockUndo records what appending a block changed in the chain's state,
+// so that a reorganization can disconnect the block without replaying the
+// chain (see disconnectTip). The chain keeps the records of its last
+// MaxReorgDepth blocks.
+type blockUndo struct {
+	spent     []*UTXO  // Outputs of earlier blocks the block spent
+	created   []string // Keys of the outputs the block created
+	contracts *contractUndo
+}
+
+// contractUndo records what applying a block changed in a contract set.
+// Contracts and schedules are replaced rather than changed, so the values
+// they had before the block are kept as they were.
+type contractUndo struct {
+	height    int
+	root      []byte   // State root before the block
+	receipts  []string // Receipts the block added
+	contracts mapUndo[string, *contracts.SmartContract]
+	nonces    mapUndo[string, uint64]
+	schedules mapUndo[string, *Schedule]
+	accounts  mapUndo[string, []byte]
+}
+
+// mapUndo records the entries of a map that a block changed: the values
+// they had before the block, and the keys it added.
+type mapUndo[K comparable, V any] struct {
+	old   map[K]V
+	added []K
+}
+
+// diffMap returns the changes from before to after.
+func diffMap[K comparable, V any](before, after map[K]V, equal func(V, V) bool) mapUndo[K, V] {
+	undo := mapUndo[K, V]{old: make(map[K]V)}
+	for key, value := range before {
+		if current, ok := after[key]; !ok || !equal(value, current) {
+			undo.old[key] = value
+		}
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			undo.added = append(undo.added, key)
+		}
+	}
+	return undo
+}
+
+// revert undoes the changes in m.
+func (u mapUndo[K, V]) revert(m map[K]V) {
+	for _, key := range u.added {
+		delete(m, key)
+	}
+	for key, value := range u.old {
+		m[key] = value
+	}
+}
+
+// same reports whether two pointers are the same.
+func same[T any](a, b *T) bool {
+	return a == b
+}
+
+// newContractUndo records the changes a block made to cs, given an overlay
+// of cs taken before the block was applied (see overlay).
+func newContractUndo(before *ContractSet, cs *ContractSet, block *Block) *contractUndo {
+	undo := &contractUndo{
+		height:    block.Height,
+		root:      before.State.Root(),
+		contracts: diffMap(before.Contracts, cs.Contracts, same),
+		nonces:    diffMap(before.Nonces, cs.Nonces, func(a, b uint64) bool { return a == b }),
+		schedules: diffMap(before.Schedules, cs.Schedules, same),
+		accounts:  diffMap(before.accounts, cs.accounts, bytes.Equal),
+	}
+	for _, tx := range block.Transactions {
+		undo.receipts = append(undo.receipts, hex.EncodeToString(tx.ID))
+	}
+	undo.receipts = append(undo.receipts, cs.Runs[block.Height]...)
+	return undo
+}
+
+// revert undoes the changes of a block, which must be the last one applied.
+func (cs *ContractSet) revert(undo *contractUndo) {
+	for _, id := range undo.receipts {
+		delete(cs.Receipts, id)
+	}
+	delete(cs.Runs, undo.height)
+	delete(cs.RentCharges, undo.height)
+	undo.contracts.revert(cs.Contracts)
+	undo.nonces.revert(cs.Nonces)
+	undo.schedules.revert(cs.Schedules)
+	undo.accounts.revert(cs.accounts)
+	// The nodes of earlier roots stay in the node store
+	cs.State.root = undo.root
+}
+
+// disconnectTip removes the block at the tip from the chain and reverts the
+// changes it made to the derived state.
+// The caller must hold the write lock.
+func (bc *Blockchain) disconnectTip() *Block {
+	block := bc.Blocks[len(bc.Blocks)-1]
+	undo, ok := bc.undo[block.Height]
+	if !ok {
+		panic(fmt.Sprintf("no undo record for the block at height %d", block.Height))
+	}
+	for _, key := range undo.created {
+		delete(bc.UTXOs.UTXOs, key)
+	}
+	for _, utxo := range undo.spent {
+		bc.UTXOs.UTXOs[fmt.Sprintf("%x_%d", utxo.TransactionID, utxo.OutputIndex)] = utxo
+	}
+	bc.Contracts.revert(undo.contracts)
+	delete(bc.undo, block.Height)
+
+	bc.filterHeaders = bc.filterHeaders[:len(bc.filterHeaders)-1]
+	bc.Blocks = bc.Blocks[:len(bc.Blocks)-1]
+	return block
+}
+
+// StatePruneInterval is the number of blocks between two prunings of the
+// state tree's node store.
+const StatePruneInterval = MaxReorgDepth
+
+// pruneStateNodes removes the state tree nodes reachable neither from the
+// current state root nor from the roots a reorganization may revert to,
+// those before each of the last MaxReorgDepth blocks. Rent and archiving
+// shrink the live state; pruning is what lets the node store shrink with it.
+// The caller must hold the write lock.
+func (bc *Blockchain) pruneStateNodes() error {
+	live := make(map[string]bool)
+	if err := bc.Contracts.State.mark(bc.Contracts.State.root, live); err != nil {
+		return err
+	}
+	for _, undo := range bc.undo {
+		if err := bc.Contracts.State.mark(undo.contracts.root, live); err != nil {
+			return err
+		}
+	}
+	return bc.Contracts.State.nodes.PruneNodes(func(hash []byte) bool {
+		return live[string(hash)]
+	})
+}
@@ -3,6 +3,8 @@ package blockchain
 import (
 	"bytes"
 	"fmt"
+	"maps"
+	"slices"
 )
 
 // UTXO represents an unspent transaction output
@@ -11,6 +13,7 @@ type UTXO struct {
 	OutputIndex   int    // Index of the output in the transaction
 	Value         int    // Amount of tokens
 	PublicKey     []byte // Public key of the owner
+	Asset         string // Asset ID; NativeAsset for the native coin
 }
 
 // UTXOSet manages the set of unspent UTXOs
@@ -26,13 +29,14 @@ func NewUTXOSet() *UTXOSet {
 }
 
 // AddUTXO adds a new UTXO to the set
-func (us *UTXOSet) AddUTXO(txID []byte, outputIndex int, value int, publicKey []byte) {
+func (us *UTXOSet) AddUTXO(txID []byte, outputIndex int, value int, publicKey []byte, asset string) {
 	key := fmt.Sprintf("%x_%d", txID, outputIndex)
 	us.UTXOs[key] = &UTXO{
 		TransactionID: txID,
 		OutputIndex:   outputIndex,
 		Value:         value,
 		PublicKey:     publicKey,
+		Asset:         asset,
 	}
 }
 
@@ -53,11 +57,35 @@ func (us *UTXOSet) GetUTXOsForAddress(address []byte) []*UTXO {
 	return utxos
 }
 
-// GetBalance calculates the total balance for an address
+// GetBalance calculates the total balance of the native coin for an address
 func (us *UTXOSet) GetBalance(address []byte) int {
+	return us.GetAssetBalance(address, NativeAsset)
+}
+
+// GetAssetBalance calculates the total balance of an asset for an address
+func (us *UTXOSet) GetAssetBalance(address []byte, asset string) int {
 	var balance int
 	for _, utxo := range us.GetUTXOsForAddress(address) {
-		balance += utxo.Value
+		if utxo.Asset == asset {
+			balance += utxo.Value
+		}
 	}
 	return balance
 }
+
+// GetAssetBalances returns the balances of the issued assets an address
+// holds, sorted by asset ID. The native coin is not included.
+func (us *UTXOSet) GetAssetBalances(address []byte) []AssetBalance {
+	amounts := make(map[string]int)
+	for _, utxo := range us.GetUTXOsForAddress(address) {
+		if utxo.Asset != NativeAsset {
+			amounts[utxo.Asset] += utxo.Value
+		}
+	}
+
+	var balances []AssetBalance
+	for _, asset := range slices.Sorted(maps.Keys(amounts)) {
+		balances = append(balances, AssetBalance{Asset: asset, Amount: amounts[asset]})
+	}
+	return balances
+}
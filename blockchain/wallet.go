@@ -36,8 +36,10 @@ func NewWallet() *Wallet {
 		log.Panic(err)
 	}
 
-	// Get public key in bytes format
-	publicKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	// Get public key in bytes format (X || Y, each padded to 32 bytes)
+	publicKey := make([]byte, 64)
+	private.PublicKey.X.FillBytes(publicKey[:32])
+	private.PublicKey.Y.FillBytes(publicKey[32:])
 
 	// Generate address from public key
 	address := generateAddress(publicKey)
@@ -0,0 +1,79 @@
+// Command ufcc compiles UFChain contract language source files to contract
+// VM bytecode.
+//
+// Usage:
+//
+//	ufcc [-asm | -abi] [-o output] file.ufc
+//
+// By default the hex-encoded bytecode, ready to be deployed with
+// POST /contract, is written to standard output. With -asm the bytecode is
+// disassembled instead, and with -abi the contract's ABI is written as JSON.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+func main() {
+	asm := flag.Bool("asm", false, "print the disassembled bytecode instead of hex")
+	abi := flag.Bool("abi", false, "print the contract's ABI as JSON instead of hex")
+	output := flag.String("o", "", "write the output to a file instead of standard output")
+	version := flag.Bool("version", false, "print the compiler version")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: ufcc [-asm | -abi] [-o output] file.ufc\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *version {
+		fmt.Println(contracts.CompilerVersion)
+		return
+	}
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	source, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ufcc: %v\n", err)
+		os.Exit(1)
+	}
+
+	compiled, err := contracts.Compile(string(source))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s:%v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+
+	out := hex.EncodeToString(compiled.Bytecode) + "\n"
+	switch {
+	case *asm:
+		if out, err = contracts.Disassemble(compiled.Bytecode); err != nil {
+			fmt.Fprintf(os.Stderr, "ufcc: %v\n", err)
+			os.Exit(1)
+		}
+	case *abi:
+		data, err := json.MarshalIndent(compiled.ABI, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ufcc: %v\n", err)
+			os.Exit(1)
+		}
+		out = string(data) + "\n"
+	}
+
+	if *output == "" {
+		fmt.Print(out)
+		return
+	}
+	if err := os.WriteFile(*output, []byte(out), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "ufcc: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,219 @@
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnknownMethod is returned when a call names a method its contract's ABI does not declare.
+	ErrUnknownMethod = errors.New("unknown method")
+
+	// ErrInvalidArguments is returned when call arguments do not match the declared parameters.
+	ErrInvalidArguments = errors.New("invalid arguments")
+)
+
+// ABI describes the interface of a contract: the methods it can be called
+// with and the events it emits. Contracts compiled from source get the ABI
+// of their public functions and events; contracts deployed as bytecode or
+// WebAssembly may be deployed with one.
+//
+// When a contract has an ABI, calls are only dispatched to the methods it
+// declares, with arguments of the declared types, and a method's return
+// value must be of its declared type.
+type ABI struct {
+	Name    string      `json:"name,omitempty"` // Contract name
+	Methods []ABIMethod `json:"methods"`
+	Events  []ABIEvent  `json:"events"`
+}
+
+// ABIParam is a method or event parameter.
+type ABIParam struct {
+	Name    string `json:"name"`
+	Type    Type   `json:"type"`
+	Indexed bool   `json:"indexed,omitempty"` // Event parameters only: whether the value is a log topic
+}
+
+// ABIMethod is a method that can be called on a contract.
+type ABIMethod struct {
+	Name         string     `json:"name"`
+	Inputs       []ABIParam `json:"inputs"`
+	Returns      Type       `json:"returns,omitempty"`      // TypeVoid if the method returns nothing
+	NonReentrant bool       `json:"nonReentrant,omitempty"` // Whether the method rejects calls while its contract has a call in progress
+}
+
+// ABIEvent is an event a contract emits.
+type ABIEvent struct {
+	Name   string     `json:"name"`
+	Topic  string     `json:"topic"` // The event's log topic (see EventTopic)
+	Inputs []ABIParam `json:"inputs"`
+}
+
+// Method returns the method with the given name.
+func (a *ABI) Method(name string) (*ABIMethod, bool) {
+	for i := range a.Methods {
+		if a.Methods[i].Name == name {
+			return &a.Methods[i], true
+		}
+	}
+	return nil, false
+}
+
+// event returns the event with the given name.
+func (a *ABI) event(name string) (*ABIEvent, bool) {
+	for i := range a.Events {
+		if a.Events[i].Name == name {
+			return &a.Events[i], true
+		}
+	}
+	return nil, false
+}
+
+// CheckCall checks that method is declared and that args match its parameters.
+// Returns an error wrapping ErrUnknownMethod or ErrInvalidArguments otherwise.
+func (a *ABI) CheckCall(method string, args []Value) error {
+	m, ok := a.Method(method)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownMethod, method)
+	}
+	if len(args) != len(m.Inputs) {
+		return fmt.Errorf("%w: %s expects %d arguments, got %d", ErrInvalidArguments, method, len(m.Inputs), len(args))
+	}
+	for i, param := range m.Inputs {
+		if !param.Type.accepts(args[i]) {
+			return fmt.Errorf("%w: argument %d (%s) of %s must be of type %s", ErrInvalidArguments, i, param.Name, method, param.Type)
+		}
+	}
+	return nil
+}
+
+// CheckReturn checks that a method's return value is of its declared return type.
+func (a *ABI) CheckReturn(method string, value *Value) error {
+	m, ok := a.Method(method)
+	if !ok || m.Returns == TypeVoid {
+		return nil
+	}
+	if value == nil || !m.Returns.accepts(*value) {
+		return fmt.Errorf("%w: %s must return a value of type %s", ErrTypeMismatch, method, m.Returns)
+	}
+	return nil
+}
+
+// Validate checks that the ABI is well-formed: names are unique and not
+// empty, types are types of the contract language and events have at most
+// MaxIndexedArgs indexed parameters, among the first eight.
+func (a *ABI) Validate() error {
+	methods := make(map[string]bool)
+	for _, m := range a.Methods {
+		if m.Name == "" || methods[m.Name] {
+			return fmt.Errorf("ABI method names must be unique and not empty: %q", m.Name)
+		}
+		methods[m.Name] = true
+		if err := validateParams(m.Name, m.Inputs, false); err != nil {
+			return err
+		}
+		if m.Returns != TypeVoid && !m.Returns.valid() {
+			return fmt.Errorf("ABI method %s returns unknown type %q", m.Name, m.Returns)
+		}
+	}
+
+	events := make(map[string]bool)
+	for _, e := range a.Events {
+		if e.Name == "" || events[e.Name] {
+			return fmt.Errorf("ABI event names must be unique and not empty: %q", e.Name)
+		}
+		events[e.Name] = true
+		if err := validateParams(e.Name, e.Inputs, true); err != nil {
+			return err
+		}
+		if e.Topic != EventTopic(e.Name) {
+			return fmt.Errorf("ABI event %s has topic %s, expected %s", e.Name, e.Topic, EventTopic(e.Name))
+		}
+	}
+	return nil
+}
+
+// validateParams checks the parameters of a method or event.
+func validateParams(owner string, params []ABIParam, event bool) error {
+	indexed := 0
+	for i, p := range params {
+		if !p.Type.valid() {
+			return fmt.Errorf("parameter %d of %s has unknown type %q", i, owner, p.Type)
+		}
+		if !p.Indexed {
+			continue
+		}
+		if !event {
+			return fmt.Errorf("parameter %d of method %s cannot be indexed", i, owner)
+		}
+		if indexed++; indexed > MaxIndexedArgs || i >= 8 {
+			return fmt.Errorf("event %s has more than %d indexed parameters among the first 8", owner, MaxIndexedArgs)
+		}
+	}
+	return nil
+}
+
+// Encode returns the canonical encoding of the ABI, as JSON.
+// Missing and empty lists encode the same, so that an ABI encodes alike
+// after a round trip through an encoding that does not tell them apart,
+// such as gob.
+func (a *ABI) Encode() []byte {
+	canonical := *a
+	canonical.Methods = append(make([]ABIMethod, 0, len(a.Methods)), a.Methods...)
+	canonical.Events = append(make([]ABIEvent, 0, len(a.Events)), a.Events...)
+	for i := range canonical.Methods {
+		canonical.Methods[i].Inputs = append(make([]ABIParam, 0, len(a.Methods[i].Inputs)), a.Methods[i].Inputs...)
+	}
+	for i := range canonical.Events {
+		canonical.Events[i].Inputs = append(make([]ABIParam, 0, len(a.Events[i].Inputs)), a.Events[i].Inputs...)
+	}
+	data, _ := json.Marshal(&canonical)
+	return data
+}
+
+// valid reports whether t is a value type of the contract language.
+func (t Type) valid() bool {
+	switch t {
+	case TypeInt, TypeBool, TypeBytes, TypeString:
+		return true
+	}
+	return false
+}
+
+// accepts reports whether v is a value of type t: integers for int, 0 or 1
+// for bool and byte strings for bytes and string.
+func (t Type) accepts(v Value) bool {
+	switch t {
+	case TypeInt:
+		return !v.IsBytes
+	case TypeBool:
+		return !v.IsBytes && (v.Int == 0 || v.Int == 1)
+	case TypeBytes, TypeString:
+		return v.IsBytes
+	}
+	return false
+}
+
+// abi returns the ABI of a parsed contract: its public functions and its events.
+func (contract *contractDecl) abi() *ABI {
+	abi := &ABI{Name: contract.name, Methods: []ABIMethod{}, Events: []ABIEvent{}}
+	for _, fn := range contract.funcs {
+		if fn.public {
+			abi.Methods = append(abi.Methods, ABIMethod{Name: fn.name, Inputs: abiParams(fn.params), Returns: fn.returns, NonReentrant: fn.nonReentrant})
+		}
+	}
+	for _, e := range contract.events {
+		abi.Events = append(abi.Events, ABIEvent{Name: e.name, Topic: EventTopic(e.name), Inputs: abiParams(e.params)})
+	}
+	return abi
+}
+
+// abiParams converts parsed parameters.
+func abiParams(params []param) []ABIParam {
+	converted := []ABIParam{}
+	for _, p := range params {
+		converted = append(converted, ABIParam{Name: p.name, Type: p.typ, Indexed: p.indexed})
+	}
+	return converted
+}
@@ -0,0 +1,278 @@
+package contracts
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// codeBuilder emits bytecode and resolves jumps to named labels.
+type codeBuilder struct {
+	code   []byte
+	labels map[string]int // Label name -> offset
+	fixups map[int]string // Offset of a jump operand -> target label
+}
+
+func newCodeBuilder() *codeBuilder {
+	return &codeBuilder{
+		labels: make(map[string]int),
+		fixups: make(map[int]string),
+	}
+}
+
+// op emits an instruction without operand.
+func (b *codeBuilder) op(op Opcode) {
+	b.code = append(b.code, byte(op))
+}
+
+// pushInt emits a PUSH of an integer.
+func (b *codeBuilder) pushInt(n int64) {
+	b.code = append(b.code, byte(OpPush))
+	b.code = binary.BigEndian.AppendUint64(b.code, uint64(n))
+}
+
+// pushBytes emits a PUSHB of a byte string.
+func (b *codeBuilder) pushBytes(data []byte) error {
+	if len(data) > math.MaxUint16 {
+		return fmt.Errorf("byte string of %d bytes is too long to push", len(data))
+	}
+	b.code = append(b.code, byte(OpPushBytes))
+	b.code = binary.BigEndian.AppendUint16(b.code, uint16(len(data)))
+	b.code = append(b.code, data...)
+	return nil
+}
+
+// opByte emits an instruction with a 1-byte operand.
+func (b *codeBuilder) opByte(op Opcode, n int) error {
+	if n < 0 || n > math.MaxUint8 {
+		return fmt.Errorf("%s operand %d out of range", op, n)
+	}
+	b.code = append(b.code, byte(op), byte(n))
+	return nil
+}
+
+// opLogIndexed emits a LOGI of n arguments, the ones in mask indexed.
+func (b *codeBuilder) opLogIndexed(n int, mask byte) error {
+	if n < 0 || n > math.MaxUint8 {
+		return fmt.Errorf("%s operand %d out of range", OpLogIndexed, n)
+	}
+	b.code = append(b.code, byte(OpLogIndexed), byte(n), mask)
+	return nil
+}
+
+// jump emits a JUMP, JUMPI or CALL to a label, which may be defined later.
+func (b *codeBuilder) jump(op Opcode, label string) {
+	b.code = append(b.code, byte(op))
+	b.fixups[len(b.code)] = label
+	b.code = append(b.code, 0, 0, 0, 0)
+}
+
+// label defines a label at the current offset.
+func (b *codeBuilder) label(name string) error {
+	if _, ok := b.labels[name]; ok {
+		return fmt.Errorf("label %q defined twice", name)
+	}
+	b.labels[name] = len(b.code)
+	return nil
+}
+
+// bytecode resolves the jumps and returns the finished bytecode.
+func (b *codeBuilder) bytecode() ([]byte, error) {
+	for offset, name := range b.fixups {
+		target, ok := b.labels[name]
+		if !ok {
+			return nil, fmt.Errorf("undefined label %q", name)
+		}
+		binary.BigEndian.PutUint32(b.code[offset:], uint32(target))
+	}
+	return b.code, nil
+}
+
+// Assemble translates assembly text into bytecode.
+// Each line holds one instruction, written as its mnemonic and operand, or
+// a label definition ("name:"). Everything after ';' is a comment.
+//
+// Operands:
+//   - PUSH takes an integer, a quoted string or 0x-prefixed hex; strings and
+//     hex push a byte string (PUSHB)
+//   - DUP, SWAP, ARG, LOAD, STORE and LOG take a small integer
+//   - JUMP, JUMPI and CALL take a label
+//
+// Example:
+//
+//	PUSH "count"
+//	DUP 0
+//	SLOAD
+//	PUSH 1
+//	ADD
+//	SSTORE
+//	STOP
+func Assemble(source string) ([]byte, error) {
+	mnemonics := make(map[string]Opcode, len(opcodes))
+	for op, info := range opcodes {
+		mnemonics[info.name] = op
+	}
+
+	b := newCodeBuilder()
+	scanner := bufio.NewScanner(strings.NewReader(source))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+
+		if name, ok := strings.CutSuffix(text, ":"); ok {
+			if err := b.label(name); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			continue
+		}
+
+		mnemonic, operand := text, ""
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			mnemonic, operand = text[:i], strings.TrimSpace(text[i:])
+		}
+		op, ok := mnemonics[strings.ToUpper(mnemonic)]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown instruction %q", line, mnemonic)
+		}
+		if err := assembleInstruction(b, op, operand); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return b.bytecode()
+}
+
+// assembleInstruction emits a single instruction with its textual operand.
+func assembleInstruction(b *codeBuilder, op Opcode, operand string) error {
+	switch op {
+	case OpPush, OpPushBytes:
+		switch {
+		case strings.HasPrefix(operand, `"`):
+			s, err := strconv.Unquote(operand)
+			if err != nil {
+				return fmt.Errorf("invalid string %s", operand)
+			}
+			return b.pushBytes([]byte(s))
+		case strings.HasPrefix(operand, "0x"):
+			data, err := hex.DecodeString(operand[2:])
+			if err != nil {
+				return fmt.Errorf("invalid hex %s", operand)
+			}
+			return b.pushBytes(data)
+		case op == OpPush:
+			n, err := strconv.ParseInt(operand, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid integer %q", operand)
+			}
+			b.pushInt(n)
+			return nil
+		default:
+			return fmt.Errorf("PUSHB needs a string or hex operand")
+		}
+
+	case OpDup, OpSwap, OpArg, OpLoad, OpStore, OpLog, OpCallContract, OpSchedule:
+		n, err := strconv.Atoi(operand)
+		if err != nil {
+			return fmt.Errorf("invalid %s operand %q", op, operand)
+		}
+		return b.opByte(op, n)
+
+	case OpLogIndexed:
+		fields := strings.Fields(operand)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s needs an argument count and an index mask", op)
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid %s operand %q", op, operand)
+		}
+		mask, err := strconv.ParseUint(fields[1], 0, 8)
+		if err != nil {
+			return fmt.Errorf("invalid %s operand %q", op, operand)
+		}
+		return b.opLogIndexed(n, byte(mask))
+
+	case OpJump, OpJumpIf, OpCall:
+		if operand == "" {
+			return fmt.Errorf("%s needs a label", op)
+		}
+		b.jump(op, operand)
+		return nil
+	}
+
+	if operand != "" {
+		return fmt.Errorf("%s takes no operand", op)
+	}
+	b.op(op)
+	return nil
+}
+
+// Disassemble renders bytecode as one instruction per line, prefixed with
+// its offset. Jump targets are shown as offsets.
+func Disassemble(code []byte) (string, error) {
+	var sb strings.Builder
+	for pc := 0; pc < len(code); {
+		op := Opcode(code[pc])
+		info, ok := opcodes[op]
+		if !ok {
+			return "", fmt.Errorf("%w 0x%02x at %d", ErrInvalidOpcode, code[pc], pc)
+		}
+		operand, next, err := readOperand(code, pc, info)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&sb, "%04d %s", pc, info.name)
+		switch op {
+		case OpPush:
+			fmt.Fprintf(&sb, " %d", int64(binary.BigEndian.Uint64(operand)))
+		case OpPushBytes:
+			fmt.Fprintf(&sb, " %s", BytesValue(operand).quoted())
+		case OpDup, OpSwap, OpArg, OpLoad, OpStore, OpLog, OpCallContract, OpSchedule:
+			fmt.Fprintf(&sb, " %d", operand[0])
+		case OpLogIndexed:
+			fmt.Fprintf(&sb, " %d 0b%b", operand[0], operand[1])
+		case OpJump, OpJumpIf, OpCall:
+			fmt.Fprintf(&sb, " %d", binary.BigEndian.Uint32(operand))
+		}
+		sb.WriteByte('\n')
+		pc = next
+	}
+	return sb.String(), nil
+}
+
+// quoted renders a byte string as an assembler operand.
+func (v Value) quoted() string {
+	if utf8.Valid(v.Bytes) {
+		return strconv.Quote(string(v.Bytes))
+	}
+	return "0x" + hex.EncodeToString(v.Bytes)
+}
+
+// stripComment removes a ';' comment that is not inside a quoted string.
+func stripComment(line string) string {
+	inString, escaped := false, false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && r == '\\':
+			escaped = true
+		case r == '"':
+			inString = !inString
+		case r == ';' && !inString:
+			return line[:i]
+		}
+	}
+	return line
+}
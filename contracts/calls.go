@@ -0,0 +1,237 @@
+package contracts
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// MaxCallDepth is the maximum nesting of contract-to-contract calls below a
+// transaction's call.
+const MaxCallDepth = 8
+
+// gasContractCall is the base cost of a call to another contract, on top of
+// the gas the called contract uses.
+const gasContractCall = 700
+
+var (
+	// ErrCallDepthExceeded is returned when a call would nest deeper than MaxCallDepth.
+	ErrCallDepthExceeded = errors.New("contract call depth exceeded")
+
+	// ErrReentrantCall is returned when a nonreentrant method is called while
+	// its contract already has a call in progress.
+	ErrReentrantCall = errors.New("reentrant call")
+
+	// ErrInsufficientBalance is returned when a contract sends more value than it holds.
+	ErrInsufficientBalance = errors.New("insufficient contract balance")
+
+	// errNoCallTree is returned by contract calls and balance queries of a
+	// call executed outside a CallTree.
+	errNoCallTree = errors.New("contract calls are not available")
+)
+
+// CallTree executes a transaction's call together with the calls it makes
+// to other contracts. Every contract the tree touches is executed on a
+// working copy, so a failure anywhere in the tree, which fails the whole
+// call, leaves the original contracts unchanged; if the call succeeds, the
+// copies replace them (see Touched).
+//
+// Value moves between contract balances along with nested calls. Methods
+// declared nonreentrant in a contract's ABI cannot be entered while the
+// contract already has a call in progress further up the tree. Contracts
+// may also schedule calls of their own methods (see Scheduled).
+type CallTree struct {
+	lookup    func(address string) (*SmartContract, bool)
+	touched   map[string]*SmartContract // Working copies, by address
+	running   []string                  // Contracts with a call in progress, outermost first
+	scheduled []*ScheduledCall          // Schedules registered by the calls, in order
+}
+
+// NewCallTree creates a call tree over the contracts returned by lookup,
+// which are only read.
+func NewCallTree(lookup func(address string) (*SmartContract, bool)) *CallTree {
+	return &CallTree{
+		lookup:  lookup,
+		touched: make(map[string]*SmartContract),
+	}
+}
+
+// Call executes the root call of the tree: a call from an account to the
+// contract at address. ctx.Value is credited to the contract, the caller
+// having paid it outside the tree.
+//
+// The call is given at most MaxGasLimit gas, and the tree may write at most
+// MaxCallStateSize bytes of state; a call that writes more fails.
+//
+// Returns the execution result, including the gas used and the logs of
+// every call in the tree, and any error that failed the call.
+func (t *CallTree) Call(address string, ctx *CallContext) (*ExecutionResult, error) {
+	if ctx.Value < 0 {
+		return &ExecutionResult{}, errors.New("call value cannot be negative")
+	}
+	if ctx.GasLimit > MaxGasLimit {
+		return &ExecutionResult{}, fmt.Errorf("%w: %d, the maximum is %d", ErrGasLimitTooHigh, ctx.GasLimit, MaxGasLimit)
+	}
+	contract, err := t.contract(address)
+	if err != nil {
+		return &ExecutionResult{}, err
+	}
+
+	contract.Balance += ctx.Value
+	ctx.Depth = 0
+	result, err := t.execute(contract, ctx)
+	if err != nil {
+		return result, err
+	}
+	if size := t.stateWriteSize(); size > MaxCallStateSize {
+		result.Logs = nil
+		return result, fmt.Errorf("%w: the call writes %d bytes of state, the maximum is %d", ErrStateLimit, size, MaxCallStateSize)
+	}
+	return result, nil
+}
+
+// stateWriteSize returns the size of the state entries the tree wrote to
+// every contract it touched.
+func (t *CallTree) stateWriteSize() int {
+	size := 0
+	for address, contract := range t.touched {
+		if original, ok := t.lookup(address); ok {
+			size += stateWriteSize(original.State, contract.State)
+		}
+	}
+	return size
+}
+
+// Touched returns the working copies of the contracts the tree executed or
+// sent value to, sorted by address. They only hold a consistent state if
+// Call succeeded.
+func (t *CallTree) Touched() []*SmartContract {
+	var touched []*SmartContract
+	for _, address := range slices.Sorted(maps.Keys(t.touched)) {
+		touched = append(touched, t.touched[address])
+	}
+	return touched
+}
+
+// contract returns the working copy of the contract at address.
+func (t *CallTree) contract(address string) (*SmartContract, error) {
+	if contract, ok := t.touched[address]; ok {
+		return contract, nil
+	}
+	contract, ok := t.lookup(address)
+	if !ok {
+		return nil, fmt.Errorf("no contract deployed at %s", address)
+	}
+	contract = contract.Copy()
+	t.touched[address] = contract
+	return contract, nil
+}
+
+// execute runs a call on a working copy, enforcing the reentrancy guard.
+func (t *CallTree) execute(contract *SmartContract, ctx *CallContext) (*ExecutionResult, error) {
+	if contract.ABI != nil && slices.Contains(t.running, contract.ID) {
+		if method, ok := contract.ABI.Method(ctx.Method); ok && method.NonReentrant {
+			return &ExecutionResult{}, fmt.Errorf("%w to %s of %s", ErrReentrantCall, ctx.Method, contract.ID)
+		}
+	}
+
+	ctx.Address = contract.ID
+	ctx.tree = t
+	t.running = append(t.running, contract.ID)
+	defer func() { t.running = t.running[:len(t.running)-1] }()
+
+	return contract.Execute(ctx)
+}
+
+// nestedCall executes a call made by the running call caller to another
+// contract, sending it value and at most gas.
+//
+// pending are the caller's storage writes that have not reached its state
+// yet. They are applied first, so that the called contract, and any call
+// back into the caller, sees them; since a failed nested call fails the
+// caller too, they are discarded with the rest of the tree if anything fails.
+func (t *CallTree) nestedCall(caller *CallContext, pending map[string]Value, address string, method string, args []Value, value int64, gas uint64) (*ExecutionResult, error) {
+	if caller.Depth >= MaxCallDepth {
+		return &ExecutionResult{}, ErrCallDepthExceeded
+	}
+	if value < 0 {
+		return &ExecutionResult{}, errors.New("call value cannot be negative")
+	}
+
+	from, err := t.contract(caller.Address)
+	if err != nil {
+		return &ExecutionResult{}, err
+	}
+	for key, v := range pending {
+		from.State[key] = v.stateValue()
+	}
+	clear(pending)
+
+	to, err := t.contract(address)
+	if err != nil {
+		return &ExecutionResult{}, err
+	}
+	if from.Balance < value {
+		return &ExecutionResult{}, fmt.Errorf("%w: %s holds %d, sends %d", ErrInsufficientBalance, from.ID, from.Balance, value)
+	}
+	from.Balance -= value
+	to.Balance += value
+
+	return t.execute(to, &CallContext{
+		Caller:      caller.Address,
+		Value:       value,
+		Method:      method,
+		Args:        args,
+		GasLimit:    gas,
+		BlockHeight: caller.BlockHeight,
+		Depth:       caller.Depth + 1,
+		Tracer:      caller.Tracer,
+		Deadline:    caller.Deadline,
+	})
+}
+
+// balance returns the balance of the running contract.
+func (t *CallTree) balance(address string) int64 {
+	if contract, ok := t.touched[address]; ok {
+		return contract.Balance
+	}
+	return 0
+}
+
+// callError describes a failed nested call.
+func callError(address, method string, err error) error {
+	return fmt.Errorf("call to %s of %s: %w", method, address, err)
+}
+
+// decodeCallArgs decodes the arguments of a WebAssembly call_contract host
+// call: each argument is a tag byte, 0 for an integer followed by its 8-byte
+// big-endian value or 1 for a byte string followed by its 4-byte big-endian
+// length and its bytes.
+func decodeCallArgs(data []byte) ([]Value, error) {
+	var args []Value
+	for len(data) > 0 {
+		switch data[0] {
+		case 0:
+			if len(data) < 9 {
+				return nil, errors.New("truncated integer argument")
+			}
+			args = append(args, IntValue(int64(binary.BigEndian.Uint64(data[1:9]))))
+			data = data[9:]
+		case 1:
+			if len(data) < 5 {
+				return nil, errors.New("truncated byte string argument")
+			}
+			n := binary.BigEndian.Uint32(data[1:5])
+			if uint64(len(data)-5) < uint64(n) {
+				return nil, errors.New("truncated byte string argument")
+			}
+			args = append(args, BytesValue(data[5:5+n]))
+			data = data[5+n:]
+		default:
+			return nil, fmt.Errorf("unknown argument tag %d", data[0])
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,993 @@
+package contracts
+
+import (
+	"fmt"
+)
+
+// CompilerVersion identifies the contract language compiler.
+const CompilerVersion = "0.1.0"
+
+// maxLocals is the number of local variable slots of a frame (see OpLoad).
+const maxLocals = 256
+
+// CompiledContract is the output of the compiler.
+type CompiledContract struct {
+	Name     string // Contract name from the source
+	Bytecode []byte // VM bytecode
+	ABI      *ABI   // Public functions and events of the contract
+}
+
+// Compile translates contract source into VM bytecode.
+//
+// A contract declares state variables, events and functions:
+//
+//	contract Counter {
+//	    int count;
+//	    map[string]int perCaller;
+//	    event Incremented(indexed string by, int count);
+//
+//	    public function increment(int by) returns int {
+//	        require(by > 0, "by must be positive");
+//	        count += by;
+//	        perCaller[msg.sender] += by;
+//	        emit Incremented(msg.sender, count);
+//	        return count;
+//	    }
+//	}
+//
+// Types are int, bool, bytes and string. Public functions can be called as
+// contract methods; their arguments are checked against the declared types
+// at runtime. The other functions are only callable from within the contract.
+// The public functions and the events make up the contract's ABI.
+//
+// call(contract, method, value, args...) calls a method of another contract
+// that returns an int, sending it value from this contract's balance
+// (this.balance); callBytes does the same for methods returning bytes or a
+// string. A public function declared "public nonreentrant function" cannot
+// be called while its contract already has a call in progress.
+//
+// schedule(method, height, interval, runs, gas, args...) schedules calls of
+// a public function of this contract with the given arguments: at height,
+// then every interval blocks until runs calls are done, with at most gas
+// each. The gas of every run is paid from this.balance, and schedule returns
+// the amount paid.
+//
+// Storage layout: a state variable is stored under its name and a map entry
+// under "<name>/<key>", with integer keys in decimal. Variables that were
+// never written read as 0, false or empty.
+//
+// Event parameters marked indexed (at most MaxIndexedArgs, among the first
+// eight) are added to the emitted log's topics, so logs can be searched by
+// their value.
+//
+// require(cond, message) reverts with message if cond is false and is meant
+// for checking inputs; assert(cond) reverts with the failing position and is
+// meant for internal invariants.
+func Compile(source string) (*CompiledContract, error) {
+	contract, err := parseContract(source)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiler{
+		b:      newCodeBuilder(),
+		vars:   make(map[string]*stateVarDecl),
+		events: make(map[string]*eventDecl),
+		funcs:  make(map[string]*funcDecl),
+	}
+	if err := c.declare(contract); err != nil {
+		return nil, err
+	}
+	if err := c.contract(contract); err != nil {
+		return nil, err
+	}
+
+	code, err := c.b.bytecode()
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyBytecode(code); err != nil {
+		return nil, fmt.Errorf("compiler produced invalid bytecode: %v", err)
+	}
+	return &CompiledContract{Name: contract.name, Bytecode: code, ABI: contract.abi()}, nil
+}
+
+// builtins are the functions provided by the language.
+var builtins = map[string]bool{
+	"sha256": true, "keccak256": true, "fromHex": true, "len": true, "bytes": true, "string": true,
+	"call": true, "callBytes": true, "verifySignature": true, "verifyMerkle": true, "schedule": true,
+}
+
+// local is a local variable or parameter of the function being compiled.
+type local struct {
+	slot int
+	typ  Type
+}
+
+// compiler generates bytecode for a parsed contract.
+type compiler struct {
+	b      *codeBuilder
+	vars   map[string]*stateVarDecl
+	events map[string]*eventDecl
+	funcs  map[string]*funcDecl
+
+	fn       *funcDecl          // Function being compiled
+	scopes   []map[string]local // Nested block scopes of fn
+	nextSlot int
+	labels   int
+}
+
+// declare collects the contract's declarations, rejecting duplicates.
+func (c *compiler) declare(contract *contractDecl) error {
+	names := make(map[string]bool)
+	claim := func(tok token, name string) error {
+		if names[name] || builtins[name] {
+			return fmt.Errorf("%s: %s is already declared", tok.pos(), name)
+		}
+		names[name] = true
+		return nil
+	}
+
+	for _, v := range contract.vars {
+		if err := claim(v.tok, v.name); err != nil {
+			return err
+		}
+		c.vars[v.name] = v
+	}
+	for _, e := range contract.events {
+		if err := claim(e.tok, e.name); err != nil {
+			return err
+		}
+		if len(e.params) > maxLocals-1 {
+			return fmt.Errorf("%s: event %s has too many parameters", e.tok.pos(), e.name)
+		}
+		indexed := 0
+		for i, p := range e.params {
+			if !p.indexed {
+				continue
+			}
+			if indexed++; indexed > MaxIndexedArgs {
+				return fmt.Errorf("%s: event %s has more than %d indexed parameters", e.tok.pos(), e.name, MaxIndexedArgs)
+			}
+			if i >= 8 {
+				return fmt.Errorf("%s: indexed parameter %s of event %s must be among the first 8", e.tok.pos(), p.name, e.name)
+			}
+		}
+		c.events[e.name] = e
+	}
+	for _, fn := range contract.funcs {
+		if err := claim(fn.tok, fn.name); err != nil {
+			return err
+		}
+		if len(fn.params) > maxLocals {
+			return fmt.Errorf("%s: function %s has too many parameters", fn.tok.pos(), fn.name)
+		}
+		c.funcs[fn.name] = fn
+	}
+	return nil
+}
+
+// contract emits the method dispatcher, the entry points of the public
+// functions and the body of every function.
+func (c *compiler) contract(contract *contractDecl) error {
+	for _, fn := range contract.funcs {
+		if !fn.public {
+			continue
+		}
+		c.b.op(OpMethod)
+		if err := c.b.pushBytes([]byte(fn.name)); err != nil {
+			return err
+		}
+		c.b.op(OpEq)
+		c.b.jump(OpJumpIf, "entry_"+fn.name)
+	}
+	if err := c.revert("unknown method"); err != nil {
+		return err
+	}
+
+	for _, fn := range contract.funcs {
+		if fn.public {
+			if err := c.entry(fn); err != nil {
+				return err
+			}
+		}
+	}
+	for _, fn := range contract.funcs {
+		if err := c.function(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry emits the entry point of a public function: it checks the number
+// and types of the call arguments, calls the function and halts with its
+// return value.
+func (c *compiler) entry(fn *funcDecl) error {
+	if err := c.b.label("entry_" + fn.name); err != nil {
+		return err
+	}
+
+	ok := c.newLabel()
+	c.b.op(OpArgCount)
+	c.b.pushInt(int64(len(fn.params)))
+	c.b.op(OpEq)
+	c.b.jump(OpJumpIf, ok)
+	if err := c.revert(fmt.Sprintf("wrong number of arguments for %s: expected %d", fn.name, len(fn.params))); err != nil {
+		return err
+	}
+	if err := c.b.label(ok); err != nil {
+		return err
+	}
+
+	for i, p := range fn.params {
+		if err := c.b.opByte(OpArg, i); err != nil {
+			return err
+		}
+		if err := c.checkArgument(fn, i, p.typ); err != nil {
+			return err
+		}
+	}
+
+	c.b.jump(OpCall, "func_"+fn.name)
+	if fn.returns == TypeVoid {
+		c.b.op(OpStop)
+	} else {
+		c.b.op(OpReturn)
+	}
+	return nil
+}
+
+// checkArgument emits a runtime check that the value on top of the stack is
+// of the given type, leaving the value on the stack. Integer instructions
+// fail on byte strings and LEN fails on integers.
+func (c *compiler) checkArgument(fn *funcDecl, index int, typ Type) error {
+	c.b.opByte(OpDup, 0)
+	switch typ {
+	case TypeInt:
+		c.b.pushInt(0)
+		c.b.op(OpAdd)
+		c.b.op(OpPop)
+	case TypeBool:
+		ok := c.newLabel()
+		c.b.pushInt(2)
+		c.b.op(OpLt)
+		c.b.opByte(OpDup, 1)
+		c.b.pushInt(0)
+		c.b.op(OpLt)
+		c.b.op(OpNot)
+		c.b.op(OpAnd)
+		c.b.jump(OpJumpIf, ok)
+		if err := c.revert(fmt.Sprintf("argument %d of %s must be a bool", index, fn.name)); err != nil {
+			return err
+		}
+		return c.b.label(ok)
+	default:
+		c.b.op(OpLen)
+		c.b.op(OpPop)
+	}
+	return nil
+}
+
+// function emits the body of a function. Callers push the arguments in
+// order and CALL the function; it returns with exactly one value on the
+// stack, 0 for functions without a return type.
+func (c *compiler) function(fn *funcDecl) error {
+	c.fn = fn
+	c.scopes = []map[string]local{{}}
+	c.nextSlot = 0
+
+	if err := c.b.label("func_" + fn.name); err != nil {
+		return err
+	}
+	for _, p := range fn.params {
+		if err := c.declareLocal(fn.tok, p.name, p.typ); err != nil {
+			return err
+		}
+	}
+	for i := len(fn.params) - 1; i >= 0; i-- {
+		if err := c.b.opByte(OpStore, i); err != nil {
+			return err
+		}
+	}
+
+	if err := c.statements(fn.body); err != nil {
+		return err
+	}
+
+	if fn.returns == TypeVoid {
+		c.b.pushInt(0)
+		c.b.op(OpRet)
+		return nil
+	}
+	return c.revert(fmt.Sprintf("%s did not return a value", fn.name))
+}
+
+// statements compiles a block in a new scope.
+func (c *compiler) statements(stmts []stmt) error {
+	c.scopes = append(c.scopes, map[string]local{})
+	defer func() { c.scopes = c.scopes[:len(c.scopes)-1] }()
+
+	for _, s := range stmts {
+		if err := c.statement(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) statement(s stmt) error {
+	switch s := s.(type) {
+	case *declStmt:
+		if s.value != nil {
+			if err := c.expectType(s.value, s.typ); err != nil {
+				return err
+			}
+		} else if err := c.zeroValue(s.typ); err != nil {
+			return err
+		}
+		if err := c.declareLocal(s.tok, s.name, s.typ); err != nil {
+			return err
+		}
+		return c.b.opByte(OpStore, c.nextSlot-1)
+
+	case *assignStmt:
+		return c.assign(s)
+
+	case *ifStmt:
+		els, end := c.newLabel(), c.newLabel()
+		if err := c.expectType(s.cond, TypeBool); err != nil {
+			return err
+		}
+		c.b.op(OpNot)
+		c.b.jump(OpJumpIf, els)
+		if err := c.statements(s.then); err != nil {
+			return err
+		}
+		c.b.jump(OpJump, end)
+		if err := c.b.label(els); err != nil {
+			return err
+		}
+		if err := c.statements(s.els); err != nil {
+			return err
+		}
+		return c.b.label(end)
+
+	case *whileStmt:
+		top, end := c.newLabel(), c.newLabel()
+		if err := c.b.label(top); err != nil {
+			return err
+		}
+		if err := c.expectType(s.cond, TypeBool); err != nil {
+			return err
+		}
+		c.b.op(OpNot)
+		c.b.jump(OpJumpIf, end)
+		if err := c.statements(s.body); err != nil {
+			return err
+		}
+		c.b.jump(OpJump, top)
+		return c.b.label(end)
+
+	case *returnStmt:
+		switch {
+		case c.fn.returns == TypeVoid && s.value != nil:
+			return fmt.Errorf("%s: %s does not return a value", s.tok.pos(), c.fn.name)
+		case c.fn.returns == TypeVoid:
+			c.b.pushInt(0)
+		case s.value == nil:
+			return fmt.Errorf("%s: %s must return a %s", s.tok.pos(), c.fn.name, c.fn.returns)
+		default:
+			if err := c.expectType(s.value, c.fn.returns); err != nil {
+				return err
+			}
+		}
+		c.b.op(OpRet)
+		return nil
+
+	case *requireStmt:
+		ok := c.newLabel()
+		if err := c.expectType(s.cond, TypeBool); err != nil {
+			return err
+		}
+		c.b.jump(OpJumpIf, ok)
+		switch {
+		case s.assert:
+			if err := c.revert(fmt.Sprintf("assertion failed at %s", s.tok.pos())); err != nil {
+				return err
+			}
+		case s.message != nil:
+			typ, err := c.expression(s.message)
+			if err != nil {
+				return err
+			}
+			if !typ.isByteString() {
+				return fmt.Errorf("%s: require message must be a string", s.tok.pos())
+			}
+			c.b.op(OpRevert)
+		default:
+			if err := c.revert("requirement failed"); err != nil {
+				return err
+			}
+		}
+		return c.b.label(ok)
+
+	case *emitStmt:
+		event, ok := c.events[s.event]
+		if !ok {
+			return fmt.Errorf("%s: unknown event %s", s.tok.pos(), s.event)
+		}
+		if len(s.args) != len(event.params) {
+			return fmt.Errorf("%s: event %s takes %d arguments", s.tok.pos(), event.name, len(event.params))
+		}
+		if err := c.b.pushBytes([]byte(event.name)); err != nil {
+			return err
+		}
+		for i, arg := range s.args {
+			if err := c.expectType(arg, event.params[i].typ); err != nil {
+				return err
+			}
+		}
+		var mask byte
+		for i, p := range event.params {
+			if p.indexed {
+				mask |= 1 << i
+			}
+		}
+		if mask == 0 {
+			return c.b.opByte(OpLog, len(s.args))
+		}
+		return c.b.opLogIndexed(len(s.args), mask)
+
+	case *exprStmt:
+		if _, ok := s.x.(*callExpr); !ok {
+			return fmt.Errorf("%s: expression is not a statement", s.tok.pos())
+		}
+		if _, err := c.expression(s.x); err != nil {
+			return err
+		}
+		c.b.op(OpPop)
+		return nil
+	}
+
+	return fmt.Errorf("unsupported statement %T", s)
+}
+
+// assign compiles an assignment to a local, a state variable or a map entry.
+func (c *compiler) assign(s *assignStmt) error {
+	// Emit the store target: a local slot, or a storage key on the stack
+	var typ Type
+	slot := -1
+	switch target := s.target.(type) {
+	case *identExpr:
+		if l, ok := c.lookupLocal(target.name); ok {
+			typ, slot = l.typ, l.slot
+			break
+		}
+		v, ok := c.vars[target.name]
+		if !ok {
+			return fmt.Errorf("%s: undefined: %s", target.tok.pos(), target.name)
+		}
+		if v.keyType != TypeVoid {
+			return fmt.Errorf("%s: cannot assign to map %s", target.tok.pos(), v.name)
+		}
+		typ = v.typ
+		if err := c.b.pushBytes([]byte(v.name)); err != nil {
+			return err
+		}
+	case *indexExpr:
+		v, err := c.mapKey(target)
+		if err != nil {
+			return err
+		}
+		typ = v.typ
+	default:
+		return fmt.Errorf("%s: cannot assign to this expression", s.tok.pos())
+	}
+
+	if s.op != "=" {
+		if typ != TypeInt {
+			return fmt.Errorf("%s: %s needs an int", s.tok.pos(), s.op)
+		}
+		// Load the current value
+		if slot >= 0 {
+			if err := c.b.opByte(OpLoad, slot); err != nil {
+				return err
+			}
+		} else {
+			c.b.opByte(OpDup, 0)
+			c.b.op(OpSLoad)
+		}
+	}
+
+	if err := c.expectType(s.value, typ); err != nil {
+		return err
+	}
+	switch s.op {
+	case "+=":
+		c.b.op(OpAdd)
+	case "-=":
+		c.b.op(OpSub)
+	}
+
+	if slot >= 0 {
+		return c.b.opByte(OpStore, slot)
+	}
+	c.b.op(OpSStore)
+	return nil
+}
+
+// expectType compiles an expression and checks its type.
+func (c *compiler) expectType(x expr, want Type) error {
+	typ, err := c.expression(x)
+	if err != nil {
+		return err
+	}
+	if typ != want {
+		return fmt.Errorf("%s: expected %s, found %s", exprToken(x).pos(), want, typeName(typ))
+	}
+	return nil
+}
+
+// expression compiles an expression, leaving its value on the stack.
+// Returns the type of the expression.
+func (c *compiler) expression(x expr) (Type, error) {
+	switch x := x.(type) {
+	case *intLit:
+		c.b.pushInt(x.value)
+		return TypeInt, nil
+
+	case *bytesLit:
+		return x.typ, c.b.pushBytes(x.value)
+
+	case *boolLit:
+		if x.value {
+			c.b.pushInt(1)
+		} else {
+			c.b.pushInt(0)
+		}
+		return TypeBool, nil
+
+	case *identExpr:
+		if l, ok := c.lookupLocal(x.name); ok {
+			return l.typ, c.b.opByte(OpLoad, l.slot)
+		}
+		v, ok := c.vars[x.name]
+		if !ok {
+			return TypeVoid, fmt.Errorf("%s: undefined: %s", x.tok.pos(), x.name)
+		}
+		if v.keyType != TypeVoid {
+			return TypeVoid, fmt.Errorf("%s: map %s must be indexed", x.tok.pos(), v.name)
+		}
+		if err := c.b.pushBytes([]byte(v.name)); err != nil {
+			return TypeVoid, err
+		}
+		return v.typ, c.storageLoad(v.typ)
+
+	case *indexExpr:
+		v, err := c.mapKey(x)
+		if err != nil {
+			return TypeVoid, err
+		}
+		return v.typ, c.storageLoad(v.typ)
+
+	case *msgExpr:
+		switch x.field {
+		case "sender":
+			c.b.op(OpCaller)
+			return TypeString, nil
+		case "address":
+			c.b.op(OpAddress)
+			return TypeString, nil
+		case "balance":
+			c.b.op(OpBalance)
+			return TypeInt, nil
+		}
+		c.b.op(OpCallValue)
+		return TypeInt, nil
+
+	case *unaryExpr:
+		if x.op == "!" {
+			if err := c.expectType(x.x, TypeBool); err != nil {
+				return TypeVoid, err
+			}
+			c.b.op(OpNot)
+			return TypeBool, nil
+		}
+		c.b.pushInt(0)
+		if err := c.expectType(x.x, TypeInt); err != nil {
+			return TypeVoid, err
+		}
+		c.b.op(OpSub)
+		return TypeInt, nil
+
+	case *binaryExpr:
+		return c.binary(x)
+
+	case *callExpr:
+		return c.call(x)
+	}
+
+	return TypeVoid, fmt.Errorf("unsupported expression %T", x)
+}
+
+// binary compiles a binary operator expression.
+func (c *compiler) binary(x *binaryExpr) (Type, error) {
+	if x.op == "&&" || x.op == "||" {
+		// Short-circuit: skip y if x already decides the result
+		end := c.newLabel()
+		if err := c.expectType(x.x, TypeBool); err != nil {
+			return TypeVoid, err
+		}
+		c.b.opByte(OpDup, 0)
+		if x.op == "&&" {
+			c.b.op(OpNot)
+		}
+		c.b.jump(OpJumpIf, end)
+		c.b.op(OpPop)
+		if err := c.expectType(x.y, TypeBool); err != nil {
+			return TypeVoid, err
+		}
+		return TypeBool, c.b.label(end)
+	}
+
+	left, err := c.expression(x.x)
+	if err != nil {
+		return TypeVoid, err
+	}
+	right, err := c.expression(x.y)
+	if err != nil {
+		return TypeVoid, err
+	}
+	if left != right {
+		return TypeVoid, fmt.Errorf("%s: mismatched types %s and %s for %s", x.tok.pos(), typeName(left), typeName(right), x.op)
+	}
+
+	switch x.op {
+	case "==", "!=":
+		if left == TypeVoid {
+			return TypeVoid, fmt.Errorf("%s: cannot compare values of no type", x.tok.pos())
+		}
+		c.b.op(OpEq)
+		if x.op == "!=" {
+			c.b.op(OpNot)
+		}
+		return TypeBool, nil
+
+	case "+":
+		if left.isByteString() {
+			c.b.op(OpConcat)
+			return left, nil
+		}
+	}
+
+	if left != TypeInt {
+		return TypeVoid, fmt.Errorf("%s: operator %s needs int operands, found %s", x.tok.pos(), x.op, typeName(left))
+	}
+	switch x.op {
+	case "+":
+		c.b.op(OpAdd)
+	case "-":
+		c.b.op(OpSub)
+	case "*":
+		c.b.op(OpMul)
+	case "/":
+		c.b.op(OpDiv)
+	case "%":
+		c.b.op(OpMod)
+	case "<":
+		c.b.op(OpLt)
+		return TypeBool, nil
+	case ">":
+		c.b.op(OpGt)
+		return TypeBool, nil
+	case "<=":
+		c.b.op(OpGt)
+		c.b.op(OpNot)
+		return TypeBool, nil
+	case ">=":
+		c.b.op(OpLt)
+		c.b.op(OpNot)
+		return TypeBool, nil
+	}
+	return TypeInt, nil
+}
+
+// call compiles a call of a builtin or contract function.
+func (c *compiler) call(x *callExpr) (Type, error) {
+	if x.name == "call" || x.name == "callBytes" {
+		return c.contractCall(x)
+	}
+	if x.name == "verifySignature" || x.name == "verifyMerkle" {
+		return c.verifyCall(x)
+	}
+	if x.name == "schedule" {
+		return c.scheduleCall(x)
+	}
+	if builtins[x.name] {
+		if len(x.args) != 1 {
+			return TypeVoid, fmt.Errorf("%s: %s takes one argument", x.tok.pos(), x.name)
+		}
+		typ, err := c.expression(x.args[0])
+		if err != nil {
+			return TypeVoid, err
+		}
+
+		switch x.name {
+		case "sha256", "keccak256":
+			if typ == TypeVoid {
+				return TypeVoid, fmt.Errorf("%s: cannot hash a value of no type", x.tok.pos())
+			}
+			if x.name == "sha256" {
+				c.b.op(OpSHA256)
+			} else {
+				c.b.op(OpKeccak)
+			}
+			return TypeBytes, nil
+		case "fromHex":
+			if !typ.isByteString() {
+				return TypeVoid, fmt.Errorf("%s: fromHex needs a string or bytes", x.tok.pos())
+			}
+			c.b.op(OpUnhex)
+			return TypeBytes, nil
+		case "len":
+			if !typ.isByteString() {
+				return TypeVoid, fmt.Errorf("%s: len needs a string or bytes", x.tok.pos())
+			}
+			c.b.op(OpLen)
+			return TypeInt, nil
+		default:
+			// bytes(s) and string(b) convert between the byte string types
+			if !typ.isByteString() {
+				return TypeVoid, fmt.Errorf("%s: cannot convert %s to %s", x.tok.pos(), typeName(typ), x.name)
+			}
+			return Type(x.name), nil
+		}
+	}
+
+	fn, ok := c.funcs[x.name]
+	if !ok {
+		return TypeVoid, fmt.Errorf("%s: undefined function %s", x.tok.pos(), x.name)
+	}
+	if len(x.args) != len(fn.params) {
+		return TypeVoid, fmt.Errorf("%s: %s takes %d arguments", x.tok.pos(), fn.name, len(fn.params))
+	}
+	for i, arg := range x.args {
+		if err := c.expectType(arg, fn.params[i].typ); err != nil {
+			return TypeVoid, err
+		}
+	}
+	c.b.jump(OpCall, "func_"+fn.name)
+	return fn.returns, nil
+}
+
+// scheduleCall compiles schedule(method, height, interval, runs, gas,
+// args...), which schedules calls of a method of this contract and returns
+// the deposit paid for them. The arguments are checked against the method's
+// declaration at run time.
+func (c *compiler) scheduleCall(x *callExpr) (Type, error) {
+	if len(x.args) < 5 {
+		return TypeVoid, fmt.Errorf("%s: schedule needs a method, a height, an interval, a number of runs and a gas limit", x.tok.pos())
+	}
+	typ, err := c.expression(x.args[0])
+	if err != nil {
+		return TypeVoid, err
+	}
+	if !typ.isByteString() {
+		return TypeVoid, fmt.Errorf("%s: the method of schedule must be a string", exprToken(x.args[0]).pos())
+	}
+	for _, arg := range x.args[1:5] {
+		if err := c.expectType(arg, TypeInt); err != nil {
+			return TypeVoid, err
+		}
+	}
+	for _, arg := range x.args[5:] {
+		typ, err := c.expression(arg)
+		if err != nil {
+			return TypeVoid, err
+		}
+		if typ == TypeVoid {
+			return TypeVoid, fmt.Errorf("%s: cannot pass a value of no type", exprToken(arg).pos())
+		}
+	}
+	if err := c.b.opByte(OpSchedule, len(x.args)-5); err != nil {
+		return TypeVoid, fmt.Errorf("%s: %w", x.tok.pos(), err)
+	}
+	return TypeInt, nil
+}
+
+// contractCall compiles call(contract, method, value, args...), which calls
+// a method of another contract that returns an integer, or callBytes, for
+// methods that return a byte string. The call gets all the gas left; the
+// return value is checked at run time like a public function's arguments.
+func (c *compiler) contractCall(x *callExpr) (Type, error) {
+	if len(x.args) < 3 {
+		return TypeVoid, fmt.Errorf("%s: %s needs a contract, a method and a value", x.tok.pos(), x.name)
+	}
+	for i, arg := range x.args[:2] {
+		typ, err := c.expression(arg)
+		if err != nil {
+			return TypeVoid, err
+		}
+		if !typ.isByteString() {
+			return TypeVoid, fmt.Errorf("%s: argument %d of %s must be a string", exprToken(arg).pos(), i, x.name)
+		}
+	}
+	if err := c.expectType(x.args[2], TypeInt); err != nil {
+		return TypeVoid, err
+	}
+	c.b.pushInt(0)
+	for _, arg := range x.args[3:] {
+		typ, err := c.expression(arg)
+		if err != nil {
+			return TypeVoid, err
+		}
+		if typ == TypeVoid {
+			return TypeVoid, fmt.Errorf("%s: cannot pass a value of no type", exprToken(arg).pos())
+		}
+	}
+	if err := c.b.opByte(OpCallContract, len(x.args)-3); err != nil {
+		return TypeVoid, fmt.Errorf("%s: %w", x.tok.pos(), err)
+	}
+
+	c.b.opByte(OpDup, 0)
+	if x.name == "callBytes" {
+		c.b.op(OpLen)
+		c.b.op(OpPop)
+		return TypeBytes, nil
+	}
+	c.b.pushInt(0)
+	c.b.op(OpAdd)
+	c.b.op(OpPop)
+	return TypeInt, nil
+}
+
+// verifyCall compiles verifySignature(publicKey, digest, signature) and
+// verifyMerkle(id, root, proof), which call the precompiled verifications
+// with byte string arguments and return whether they hold.
+func (c *compiler) verifyCall(x *callExpr) (Type, error) {
+	if len(x.args) != 3 {
+		return TypeVoid, fmt.Errorf("%s: %s takes three arguments", x.tok.pos(), x.name)
+	}
+	for i, arg := range x.args {
+		typ, err := c.expression(arg)
+		if err != nil {
+			return TypeVoid, err
+		}
+		if !typ.isByteString() {
+			return TypeVoid, fmt.Errorf("%s: argument %d of %s must be bytes or a string", exprToken(arg).pos(), i, x.name)
+		}
+	}
+	if x.name == "verifySignature" {
+		c.b.op(OpVerifySig)
+	} else {
+		c.b.op(OpVerifyMerkle)
+	}
+	return TypeBool, nil
+}
+
+// mapKey emits the storage key of a map entry and returns the map.
+func (c *compiler) mapKey(x *indexExpr) (*stateVarDecl, error) {
+	v, ok := c.vars[x.name]
+	if !ok {
+		return nil, fmt.Errorf("%s: undefined: %s", x.tok.pos(), x.name)
+	}
+	if v.keyType == TypeVoid {
+		return nil, fmt.Errorf("%s: %s is not a map", x.tok.pos(), x.name)
+	}
+	if err := c.b.pushBytes([]byte(v.name + "/")); err != nil {
+		return nil, err
+	}
+	if err := c.expectType(x.key, v.keyType); err != nil {
+		return nil, err
+	}
+	c.b.op(OpConcat)
+	return v, nil
+}
+
+// storageLoad emits an SLOAD of the key on the stack. Keys that were never
+// written load as integer 0, which is replaced by an empty byte string for
+// byte string types.
+func (c *compiler) storageLoad(typ Type) error {
+	c.b.op(OpSLoad)
+	if !typ.isByteString() {
+		return nil
+	}
+
+	done := c.newLabel()
+	c.b.opByte(OpDup, 0)
+	c.b.pushInt(0)
+	c.b.op(OpEq)
+	c.b.op(OpNot)
+	c.b.jump(OpJumpIf, done)
+	c.b.op(OpPop)
+	if err := c.b.pushBytes(nil); err != nil {
+		return err
+	}
+	return c.b.label(done)
+}
+
+// zeroValue pushes the default value of a type.
+func (c *compiler) zeroValue(typ Type) error {
+	if typ.isByteString() {
+		return c.b.pushBytes(nil)
+	}
+	c.b.pushInt(0)
+	return nil
+}
+
+// revert emits a REVERT with a constant reason.
+func (c *compiler) revert(reason string) error {
+	if err := c.b.pushBytes([]byte(reason)); err != nil {
+		return err
+	}
+	c.b.op(OpRevert)
+	return nil
+}
+
+// declareLocal allocates a slot for a local variable in the innermost scope.
+// Names may not shadow other locals or contract-level declarations.
+func (c *compiler) declareLocal(tok token, name string, typ Type) error {
+	if _, ok := c.lookupLocal(name); ok {
+		return fmt.Errorf("%s: %s is already declared", tok.pos(), name)
+	}
+	if c.vars[name] != nil || c.events[name] != nil || c.funcs[name] != nil || builtins[name] {
+		return fmt.Errorf("%s: %s shadows a contract declaration", tok.pos(), name)
+	}
+	if c.nextSlot == maxLocals {
+		return fmt.Errorf("%s: too many local variables in %s", tok.pos(), c.fn.name)
+	}
+
+	c.scopes[len(c.scopes)-1][name] = local{slot: c.nextSlot, typ: typ}
+	c.nextSlot++
+	return nil
+}
+
+// lookupLocal finds a local variable visible in the current scope.
+func (c *compiler) lookupLocal(name string) (local, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if l, ok := c.scopes[i][name]; ok {
+			return l, true
+		}
+	}
+	return local{}, false
+}
+
+// newLabel returns a fresh internal label name.
+func (c *compiler) newLabel() string {
+	c.labels++
+	return fmt.Sprintf(".L%d", c.labels)
+}
+
+// typeName returns a printable type name.
+func typeName(t Type) string {
+	if t == TypeVoid {
+		return "no value"
+	}
+	return string(t)
+}
+
+// exprToken returns the token at which an expression starts.
+func exprToken(x expr) token {
+	switch x := x.(type) {
+	case *intLit:
+		return x.tok
+	case *bytesLit:
+		return x.tok
+	case *boolLit:
+		return x.tok
+	case *identExpr:
+		return x.tok
+	case *indexExpr:
+		return x.tok
+	case *msgExpr:
+		return x.tok
+	case *unaryExpr:
+		return x.tok
+	case *binaryExpr:
+		return exprToken(x.x)
+	case *callExpr:
+		return x.tok
+	}
+	return token{}
+}
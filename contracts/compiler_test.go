@@ -0,0 +1,153 @@
+package contracts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the compiler golden files in testdata")
+
+// goldenContract is the compiler output recorded in a golden file.
+type goldenContract struct {
+	Name     string `json:"name"`
+	Compiler string `json:"compiler"`
+	Bytecode string `json:"bytecode"`
+	ABI      *ABI   `json:"abi"`
+}
+
+// TestCompileExamplesGolden compiles every example contract and compares
+// the bytecode and ABI with testdata/<example>.golden. Source verification
+// depends on the compiler producing the same bytes for the same source, so
+// any change to its output must come with a new CompilerVersion.
+// Run with -update to rewrite the golden files.
+func TestCompileExamplesGolden(t *testing.T) {
+	sources, err := filepath.Glob(filepath.Join("examples", "*.ufc"))
+	if err != nil || len(sources) == 0 {
+		t.Fatalf("no example contracts found: %v", err)
+	}
+
+	for _, path := range sources {
+		name := strings.TrimSuffix(filepath.Base(path), ".ufc")
+		t.Run(name, func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compiled, err := Compile(string(source))
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if err := VerifyBytecode(compiled.Bytecode); err != nil {
+				t.Fatalf("compiled bytecode does not verify: %v", err)
+			}
+
+			got, err := json.MarshalIndent(&goldenContract{
+				Name:     compiled.Name,
+				Compiler: CompilerVersion,
+				Bytecode: hex.EncodeToString(compiled.Bytecode),
+				ABI:      compiled.ABI,
+			}, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("compiler output differs from %s:\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+
+			again, err := Compile(string(source))
+			if err != nil || !bytes.Equal(again.Bytecode, compiled.Bytecode) {
+				t.Fatalf("compiling twice gave different bytecode (%v)", err)
+			}
+		})
+	}
+}
+
+func TestCompiledContractRuns(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("examples", "counter.ufc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract, err := NewSmartContractFromSource("counter", string(source))
+	if err != nil {
+		t.Fatalf("NewSmartContractFromSource: %v", err)
+	}
+
+	for i, want := range []int64{5, 8} {
+		amount := []int64{5, 3}[i]
+		result, err := contract.Execute(&CallContext{Caller: "alice", Method: "increment", Args: []Value{IntValue(amount)}, GasLimit: DefaultGasLimit})
+		if err != nil {
+			t.Fatalf("increment(%d): %v", amount, err)
+		}
+		if result.ReturnValue == nil || result.ReturnValue.Int != want {
+			t.Fatalf("increment(%d) = %v, want %d", amount, result.ReturnValue, want)
+		}
+		if len(result.Logs) != 1 || result.Logs[0].Event != "Incremented" {
+			t.Fatalf("increment(%d) emitted %v, want one Incremented event", amount, result.Logs)
+		}
+	}
+
+	result, err := contract.Execute(&CallContext{Method: "contributionOf", Args: []Value{BytesValue([]byte("alice"))}, GasLimit: DefaultGasLimit})
+	if err != nil {
+		t.Fatalf("contributionOf: %v", err)
+	}
+	if result.ReturnValue == nil || result.ReturnValue.Int != 8 {
+		t.Fatalf("contributionOf(alice) = %v, want 8", result.ReturnValue)
+	}
+
+	var revert *RevertError
+	_, err = contract.Execute(&CallContext{Method: "increment", Args: []Value{IntValue(0)}, GasLimit: DefaultGasLimit})
+	if !errors.As(err, &revert) || revert.Reason != "amount must be positive" {
+		t.Fatalf("increment(0) = %v, want the require to revert", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"unterminated string", `contract C { public function f() returns string { return "abc; } }`, "unterminated string"},
+		{"unknown character", `contract C { public function f() returns int { return 1 # 2; } }`, "unexpected character"},
+		{"missing semicolon", `contract C { public function f() returns int { return 1 } }`, `expected ";"`},
+		{"undeclared variable", `contract C { public function f() returns int { return x; } }`, "x"},
+		{"type mismatch", `contract C { public function f() returns int { return "a" + 1; } }`, "mismatched types"},
+		{"wrong return type", `contract C { public function f() returns int { return "a"; } }`, "expected int"},
+		{"duplicate function", `contract C { public function f() { } public function f() { } }`, "already declared"},
+		{"unknown event", `contract C { public function f() { emit Missing(1); } }`, "Missing"},
+		{"schedule without gas", `contract C { public function f() { schedule("f", 10, 0, 1); } }`, "schedule needs"},
+		{"schedule of a number", `contract C { public function f() { schedule(1, 10, 0, 1, 100); } }`, "must be a string"},
+		{"no contract", `int x;`, `expected "contract"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.source)
+			if err == nil {
+				t.Fatal("Compile succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Compile = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
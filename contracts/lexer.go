@@ -0,0 +1,170 @@
+package contracts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind classifies the tokens of the contract language.
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokIdent            // Identifiers and keywords
+	tokInt              // Decimal integer literals
+	tokString           // Double-quoted string literals
+	tokHex              // 0x-prefixed byte string literals
+	tokPunct            // Operators and punctuation
+)
+
+// token is a lexical token with its source position.
+type token struct {
+	kind tokenKind
+	text string // Literal text; unquoted for strings, without 0x for hex
+	line int
+	col  int
+}
+
+// pos returns the token's position for error messages.
+func (t token) pos() string {
+	return fmt.Sprintf("%d:%d", t.line, t.col)
+}
+
+// punctuators lists the operators of the language, longest first.
+var punctuators = []string{
+	"&&", "||", "==", "!=", "<=", ">=", "+=", "-=",
+	"{", "}", "(", ")", "[", "]", ",", ";", ".", "=",
+	"<", ">", "+", "-", "*", "/", "%", "!",
+}
+
+// tokenize splits contract source into tokens. Comments ("//" to the end of
+// the line and "/* ... */") are skipped.
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	line, col := 1, 1
+	i := 0
+
+	advance := func(n int) {
+		for _, r := range source[i : i+n] {
+			if r == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+		}
+		i += n
+	}
+
+	for i < len(source) {
+		c := source[i]
+		rest := source[i:]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			advance(1)
+
+		case strings.HasPrefix(rest, "//"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			advance(end)
+
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("%d:%d: unterminated comment", line, col)
+			}
+			advance(end + 4)
+
+		case strings.HasPrefix(rest, "0x"):
+			n := 2
+			for n < len(rest) && strings.IndexByte("0123456789abcdefABCDEF", rest[n]) >= 0 {
+				n++
+			}
+			if (n-2)%2 != 0 {
+				return nil, fmt.Errorf("%d:%d: hex literal must have an even number of digits", line, col)
+			}
+			tokens = append(tokens, token{kind: tokHex, text: rest[2:n], line: line, col: col})
+			advance(n)
+
+		case c >= '0' && c <= '9':
+			n := 0
+			for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+				n++
+			}
+			tokens = append(tokens, token{kind: tokInt, text: rest[:n], line: line, col: col})
+			advance(n)
+
+		case c == '"':
+			text, n, err := scanString(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%d:%d: %v", line, col, err)
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, line: line, col: col})
+			advance(n)
+
+		case isIdentStart(c):
+			n := 0
+			for n < len(rest) && (isIdentStart(rest[n]) || rest[n] >= '0' && rest[n] <= '9') {
+				n++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: rest[:n], line: line, col: col})
+			advance(n)
+
+		default:
+			matched := false
+			for _, p := range punctuators {
+				if strings.HasPrefix(rest, p) {
+					tokens = append(tokens, token{kind: tokPunct, text: p, line: line, col: col})
+					advance(len(p))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("%d:%d: unexpected character %q", line, col, c)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, line: line, col: col}), nil
+}
+
+// isIdentStart reports whether c may start an identifier.
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// scanString reads a double-quoted string literal supporting the escapes
+// \", \\, \n and \t. Returns the unquoted text and the literal's length.
+func scanString(s string) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			switch s[i] {
+			case '"', '\\':
+				sb.WriteByte(s[i])
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				return "", 0, fmt.Errorf("unknown escape \\%c", s[i])
+			}
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,82 @@
+package contracts
+
+import (
+	"errors"
+	"time"
+)
+
+// Contract execution is sandboxed: a call can only read and change what its
+// CallContext and CallTree give it access to, and every node must compute
+// the same result for it. Contracts therefore see no clock, only the block
+// height; no randomness; no floating point, which the VM does not have and
+// WebAssembly modules may not use; and storage only by key, never by
+// iterating it, so that Go's map iteration order cannot leak into results.
+// Everything the host computes for contracts, such as the order of touched
+// contracts, rent collections and scheduled runs, is sorted.
+//
+// Every resource a call uses is capped by a deterministic limit, reached at
+// the same point on every node:
+//   - gas: at most MaxGasLimit per call tree, which also bounds the steps
+//     executed since every VM instruction and WebAssembly instruction costs
+//     at least one unit, except the VM's halting ones
+//   - memory: MaxStackDepth values of at most MaxValueSize on the VM stack,
+//     and MaxMemory bytes of byte strings created by a VM call; linear
+//     memory of MaxWasmMemoryPages for WebAssembly
+//   - call depth: MaxCallDepth nested contract calls, each with at most
+//     MaxFrames VM frames or MaxWasmCallDepth WebAssembly frames
+//   - state: MaxCallStateSize bytes of state changed by a call tree
+//
+// The wall-clock limit, CallContext.Deadline, is the exception: it stops
+// calls at different points on different nodes, so it only applies to
+// calls whose results are not part of the chain, such as read-only calls
+// and simulations (see MaxCallDuration). Calls executed while applying
+// blocks are bounded by gas instead.
+const (
+	MaxGasLimit      = 10 * DefaultGasLimit // Maximum gas of a call tree
+	MaxMemory        = 16 * 1024 * 1024     // Maximum bytes of byte strings a VM call may create
+	MaxCallStateSize = 128 * 1024           // Maximum bytes of state entries a call tree may write
+)
+
+// MaxCallDuration is the wall-clock time a call outside of consensus, such
+// as a read-only call, may run for (see CallContext.Deadline).
+const MaxCallDuration = 2 * time.Second
+
+// deadlineInterval is the number of VM instructions executed between checks
+// of a call's deadline, so that the clock is not read on every instruction.
+const deadlineInterval = 1024
+
+var (
+	// ErrGasLimitTooHigh is returned when a call is given more than MaxGasLimit gas.
+	ErrGasLimitTooHigh = errors.New("gas limit too high")
+
+	// ErrMemoryLimit is returned when a VM call creates more than MaxMemory
+	// bytes of byte strings.
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+
+	// ErrStateLimit is returned when a call tree writes more than
+	// MaxCallStateSize bytes of state.
+	ErrStateLimit = errors.New("state write limit exceeded")
+
+	// ErrTimeout is returned when a call is still running at its deadline.
+	ErrTimeout = errors.New("execution timed out")
+)
+
+// expired reports whether the call's deadline, if it has one, has passed.
+func (ctx *CallContext) expired() bool {
+	return !ctx.Deadline.IsZero() && time.Now().After(ctx.Deadline)
+}
+
+// stateWriteSize returns the size of the state entries a call wrote to a
+// contract, from its state before the call to its state after: the length
+// of the key and of the encoded value of every changed entry. Removed
+// entries count for their key only.
+func stateWriteSize(before, after map[string]interface{}) int {
+	size := 0
+	for _, change := range DiffState(before, after) {
+		size += len(change.Key)
+		if change.After != nil {
+			size += len(change.After.Encode())
+		}
+	}
+	return size
+}
@@ -0,0 +1,165 @@
+package contracts
+
+// Opcode is a single contract VM instruction.
+// Most instructions take their operands from the stack; the few that carry
+// immediate operands (see operandSize) encode them right after the opcode.
+type Opcode byte
+
+// Instruction set of the contract VM.
+const (
+	// Stack manipulation
+	OpStop      Opcode = 0x00 // Halt successfully without a return value
+	OpPush      Opcode = 0x01 // Push the 8-byte big-endian signed integer operand
+	OpPushBytes Opcode = 0x02 // Push the byte string operand (2-byte big-endian length, then the bytes)
+	OpPop       Opcode = 0x03 // Discard the top of the stack
+	OpDup       Opcode = 0x04 // Push a copy of the item at the 1-byte operand depth (0 = top)
+	OpSwap      Opcode = 0x05 // Swap the top of the stack with the item at the 1-byte operand depth (>= 1)
+	OpLoad      Opcode = 0x06 // Push the local variable in the 1-byte operand slot of the current frame
+	OpStore     Opcode = 0x07 // Pop a value into the local variable in the 1-byte operand slot
+
+	// Arithmetic and comparison on integers; logic on truthiness
+	OpAdd Opcode = 0x10 // a b -> a+b
+	OpSub Opcode = 0x11 // a b -> a-b
+	OpMul Opcode = 0x12 // a b -> a*b
+	OpDiv Opcode = 0x13 // a b -> a/b (truncated)
+	OpMod Opcode = 0x14 // a b -> a%b
+	OpLt  Opcode = 0x15 // a b -> a<b
+	OpGt  Opcode = 0x16 // a b -> a>b
+	OpEq  Opcode = 0x17 // a b -> a==b (same kind and same value)
+	OpNot Opcode = 0x18 // a -> !a
+	OpAnd Opcode = 0x19 // a b -> a&&b
+	OpOr  Opcode = 0x1a // a b -> a||b
+
+	// Control flow
+	OpJump   Opcode = 0x20 // Jump to the 4-byte big-endian operand offset
+	OpJumpIf Opcode = 0x21 // Pop a condition and jump to the operand offset if it is true
+	OpCall   Opcode = 0x22 // Enter a new frame and jump to the 4-byte operand offset
+	OpRet    Opcode = 0x23 // Leave the current frame and continue after the matching CALL
+
+	// Persistent storage
+	OpSLoad  Opcode = 0x30 // key -> value (integer 0 if the key was never written)
+	OpSStore Opcode = 0x31 // key value -> (store value under key)
+
+	// Call context
+	OpCaller    Opcode = 0x40 // Push the caller's address
+	OpCallValue Opcode = 0x41 // Push the value sent with the call
+	OpMethod    Opcode = 0x42 // Push the name of the called method
+	OpArg       Opcode = 0x43 // Push the call argument at the 1-byte operand index
+	OpArgCount  Opcode = 0x44 // Push the number of call arguments
+	OpAddress   Opcode = 0x45 // Push the address of the running contract
+	OpBalance   Opcode = 0x46 // Push the balance of the running contract
+
+	// Byte strings and hashing
+	OpSHA256 Opcode = 0x50 // a -> sha256(a)
+	OpConcat Opcode = 0x51 // a b -> a‖b
+	OpLen    Opcode = 0x52 // a -> length of the byte string a
+	OpKeccak Opcode = 0x53 // a -> keccak256(a)
+	OpUnhex  Opcode = 0x54 // a -> the bytes whose hex text is the byte string a
+
+	// Precompiled verifications
+	OpVerifySig    Opcode = 0x58 // publicKey digest signature -> 1 if signature is a valid P-256 signature of digest by publicKey, else 0
+	OpVerifyMerkle Opcode = 0x59 // id root proof -> 1 if proof links the transaction id to the Merkle root, else 0
+
+	// Termination
+	OpReturn Opcode = 0x60 // Pop the return value and halt successfully
+	OpRevert Opcode = 0x61 // Pop a reason and abort, discarding all state changes
+
+	// Events
+	OpLog        Opcode = 0x70 // Pop the number of arguments given by the 1-byte operand, then an event name, and emit the event
+	OpLogIndexed Opcode = 0x71 // Like LOG with a 2-byte operand: the number of arguments and a bit mask of the indexed ones
+
+	// Contract calls
+	OpCallContract Opcode = 0x80 // Pop the number of arguments given by the 1-byte operand, then contract method value gas; call the contract and push its return value (gas 0 forwards all gas left)
+	OpSchedule     Opcode = 0x81 // Pop the number of arguments given by the 1-byte operand, then method height interval runs gas; schedule calls of this contract's method and push the deposit paid from its balance
+)
+
+// Gas costs. Every instruction costs the gas listed in opcodes; instructions
+// that handle byte strings additionally pay gasPerWord per started 32 bytes.
+const (
+	gasBase    = 1
+	gasPerWord = 3
+)
+
+// opcodeInfo describes an instruction for the interpreter, the verifier and
+// the assembler.
+type opcodeInfo struct {
+	name        string
+	operandSize int // Size of the fixed immediate operand; -1 for OpPushBytes' length-prefixed operand
+	gas         uint64
+}
+
+var opcodes = map[Opcode]opcodeInfo{
+	OpStop:      {"STOP", 0, 0},
+	OpPush:      {"PUSH", 8, 2},
+	OpPushBytes: {"PUSHB", -1, 2},
+	OpPop:       {"POP", 0, gasBase},
+	OpDup:       {"DUP", 1, 2},
+	OpSwap:      {"SWAP", 1, 2},
+	OpLoad:      {"LOAD", 1, 3},
+	OpStore:     {"STORE", 1, 3},
+
+	OpAdd: {"ADD", 0, 3},
+	OpSub: {"SUB", 0, 3},
+	OpMul: {"MUL", 0, 5},
+	OpDiv: {"DIV", 0, 5},
+	OpMod: {"MOD", 0, 5},
+	OpLt:  {"LT", 0, 3},
+	OpGt:  {"GT", 0, 3},
+	OpEq:  {"EQ", 0, 3},
+	OpNot: {"NOT", 0, 3},
+	OpAnd: {"AND", 0, 3},
+	OpOr:  {"OR", 0, 3},
+
+	OpJump:   {"JUMP", 4, 8},
+	OpJumpIf: {"JUMPI", 4, 10},
+	OpCall:   {"CALL", 4, 10},
+	OpRet:    {"RET", 0, 5},
+
+	OpSLoad:  {"SLOAD", 0, 200},
+	OpSStore: {"SSTORE", 0, 5000},
+
+	OpCaller:    {"CALLER", 0, 2},
+	OpCallValue: {"CALLVALUE", 0, 2},
+	OpMethod:    {"METHOD", 0, 2},
+	OpArg:       {"ARG", 1, 3},
+	OpArgCount:  {"ARGC", 0, 2},
+	OpAddress:   {"ADDRESS", 0, 2},
+	OpBalance:   {"BALANCE", 0, 20},
+
+	OpSHA256: {"SHA256", 0, 30},
+	OpConcat: {"CONCAT", 0, 3},
+	OpLen:    {"LEN", 0, 2},
+	OpKeccak: {"KECCAK256", 0, 30},
+	OpUnhex:  {"UNHEX", 0, 3},
+
+	OpVerifySig:    {"VERIFYSIG", 0, gasVerifySignature},
+	OpVerifyMerkle: {"VERIFYMERKLE", 0, 60},
+
+	OpReturn: {"RETURN", 0, 0},
+	OpRevert: {"REVERT", 0, 0},
+
+	OpLog:        {"LOG", 1, 100},
+	OpLogIndexed: {"LOGI", 2, 100},
+
+	OpCallContract: {"CALLC", 1, gasContractCall},
+	OpSchedule:     {"SCHEDULE", 1, gasSchedule},
+}
+
+// String returns the mnemonic of the opcode.
+func (op Opcode) String() string {
+	if info, ok := opcodes[op]; ok {
+		return info.name
+	}
+	return "INVALID"
+}
+
+// gasPerTopic is the gas charged for hashing each indexed event argument.
+const gasPerTopic = 30
+
+// MaxIndexedArgs is the maximum number of indexed arguments of an event.
+const MaxIndexedArgs = 3
+
+// wordGas returns the per-size gas surcharge for handling n bytes.
+func wordGas(n int) uint64 {
+	return uint64((n+31)/32) * gasPerWord
+}
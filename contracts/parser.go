@@ -0,0 +1,761 @@
+package contracts
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+// Type is a type of the contract language.
+type Type string
+
+// Types of the contract language. string and bytes are both byte strings at
+// runtime but are distinct types; bool values are the integers 0 and 1.
+const (
+	TypeVoid   Type = ""
+	TypeInt    Type = "int"
+	TypeBool   Type = "bool"
+	TypeBytes  Type = "bytes"
+	TypeString Type = "string"
+)
+
+// isByteString reports whether values of the type are byte strings at runtime.
+func (t Type) isByteString() bool {
+	return t == TypeBytes || t == TypeString
+}
+
+// Syntax tree of a contract.
+type (
+	contractDecl struct {
+		name   string
+		vars   []*stateVarDecl
+		events []*eventDecl
+		funcs  []*funcDecl
+	}
+
+	stateVarDecl struct {
+		tok     token
+		name    string
+		typ     Type
+		keyType Type // Key type of a map; TypeVoid for plain variables
+	}
+
+	eventDecl struct {
+		tok    token
+		name   string
+		params []param
+	}
+
+	funcDecl struct {
+		tok          token
+		name         string
+		public       bool
+		nonReentrant bool
+		params       []param
+		returns      Type
+		body         []stmt
+	}
+
+	param struct {
+		name    string
+		typ     Type
+		indexed bool // Event parameters only
+	}
+)
+
+// Statements.
+type (
+	stmt interface{}
+
+	declStmt struct {
+		tok   token
+		typ   Type
+		name  string
+		value expr // May be nil
+	}
+
+	assignStmt struct {
+		tok    token
+		target expr // identExpr or indexExpr
+		op     string
+		value  expr
+	}
+
+	ifStmt struct {
+		tok  token
+		cond expr
+		then []stmt
+		els  []stmt
+	}
+
+	whileStmt struct {
+		tok  token
+		cond expr
+		body []stmt
+	}
+
+	returnStmt struct {
+		tok   token
+		value expr // May be nil
+	}
+
+	requireStmt struct {
+		tok     token
+		cond    expr
+		message expr // May be nil
+		assert  bool
+	}
+
+	emitStmt struct {
+		tok   token
+		event string
+		args  []expr
+	}
+
+	exprStmt struct {
+		tok token
+		x   expr
+	}
+)
+
+// Expressions.
+type (
+	expr interface{}
+
+	intLit struct {
+		tok   token
+		value int64
+	}
+
+	bytesLit struct {
+		tok   token
+		value []byte
+		typ   Type // TypeString or TypeBytes
+	}
+
+	boolLit struct {
+		tok   token
+		value bool
+	}
+
+	identExpr struct {
+		tok  token
+		name string
+	}
+
+	indexExpr struct {
+		tok  token
+		name string
+		key  expr
+	}
+
+	msgExpr struct {
+		tok   token
+		field string // "sender" or "value" of msg, "address" or "balance" of this
+	}
+
+	unaryExpr struct {
+		tok token
+		op  string
+		x   expr
+	}
+
+	binaryExpr struct {
+		tok  token
+		op   string
+		x, y expr
+	}
+
+	callExpr struct {
+		tok  token
+		name string
+		args []expr
+	}
+)
+
+// binaryPrecedence lists binary operators from loosest to tightest binding.
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parser is a recursive descent parser for the contract language.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parseContract parses the source of a single contract.
+func parseContract(source string) (*contractDecl, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	if err := p.expectKeyword("contract"); err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	contract := &contractDecl{name: name.text}
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.is("}") {
+		if err := p.member(contract); err != nil {
+			return nil, err
+		}
+	}
+	p.next()
+
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected %q after contract", p.peek().text)
+	}
+	return contract, nil
+}
+
+// member parses a state variable, event or function declaration.
+func (p *parser) member(contract *contractDecl) error {
+	tok := p.peek()
+	switch {
+	case p.isKeyword("event"):
+		p.next()
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		params, err := p.eventParams()
+		if err != nil {
+			return err
+		}
+		contract.events = append(contract.events, &eventDecl{tok: tok, name: name.text, params: params})
+		return p.expect(";")
+
+	case p.isKeyword("public"), p.isKeyword("function"):
+		fn, err := p.function()
+		if err != nil {
+			return err
+		}
+		contract.funcs = append(contract.funcs, fn)
+		return nil
+
+	case p.isKeyword("map"):
+		p.next()
+		if err := p.expect("["); err != nil {
+			return err
+		}
+		keyType, err := p.typeName()
+		if err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+		valueType, err := p.typeName()
+		if err != nil {
+			return err
+		}
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		contract.vars = append(contract.vars, &stateVarDecl{tok: tok, name: name.text, typ: valueType, keyType: keyType})
+		return p.expect(";")
+
+	default:
+		typ, err := p.typeName()
+		if err != nil {
+			return err
+		}
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		contract.vars = append(contract.vars, &stateVarDecl{tok: tok, name: name.text, typ: typ})
+		return p.expect(";")
+	}
+}
+
+// function parses "[public [nonreentrant]] function name(params) [returns type] { body }".
+func (p *parser) function() (*funcDecl, error) {
+	fn := &funcDecl{tok: p.peek()}
+	if p.isKeyword("public") {
+		p.next()
+		fn.public = true
+		if p.isKeyword("nonreentrant") {
+			p.next()
+			fn.nonReentrant = true
+		}
+	}
+	if err := p.expectKeyword("function"); err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	fn.name = name.text
+
+	if fn.params, err = p.params(); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("returns") {
+		p.next()
+		if fn.returns, err = p.typeName(); err != nil {
+			return nil, err
+		}
+	}
+	if fn.body, err = p.block(); err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+// params parses a parenthesized list of typed parameters.
+func (p *parser) params() ([]param, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var params []param
+	for !p.is(")") {
+		if len(params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		typ, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, param{name: name.text, typ: typ})
+	}
+	p.next()
+	return params, nil
+}
+
+// eventParams parses event parameters, which may be marked "indexed".
+// Since a parameter always starts with a type keyword, "indexed" is only a
+// keyword in this position.
+func (p *parser) eventParams() ([]param, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var params []param
+	for !p.is(")") {
+		if len(params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		indexed := p.isKeyword("indexed")
+		if indexed {
+			p.next()
+		}
+		typ, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, param{name: name.text, typ: typ, indexed: indexed})
+	}
+	p.next()
+	return params, nil
+}
+
+// block parses a brace-delimited list of statements.
+func (p *parser) block() ([]stmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var stmts []stmt
+	for !p.is("}") {
+		if p.peek().kind == tokEOF {
+			return nil, p.errorf("unexpected end of source")
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+	p.next()
+	return stmts, nil
+}
+
+// statement parses a single statement.
+func (p *parser) statement() (stmt, error) {
+	tok := p.peek()
+
+	switch {
+	case p.isType():
+		typ, _ := p.typeName()
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		s := &declStmt{tok: tok, typ: typ, name: name.text}
+		if p.is("=") {
+			p.next()
+			if s.value, err = p.expression(0); err != nil {
+				return nil, err
+			}
+		}
+		return s, p.expect(";")
+
+	case p.isKeyword("if"):
+		p.next()
+		cond, err := p.parenthesized()
+		if err != nil {
+			return nil, err
+		}
+		s := &ifStmt{tok: tok, cond: cond}
+		if s.then, err = p.block(); err != nil {
+			return nil, err
+		}
+		if p.isKeyword("else") {
+			p.next()
+			if p.isKeyword("if") {
+				elseIf, err := p.statement()
+				if err != nil {
+					return nil, err
+				}
+				s.els = []stmt{elseIf}
+			} else if s.els, err = p.block(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+
+	case p.isKeyword("while"):
+		p.next()
+		cond, err := p.parenthesized()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &whileStmt{tok: tok, cond: cond, body: body}, nil
+
+	case p.isKeyword("return"):
+		p.next()
+		s := &returnStmt{tok: tok}
+		if !p.is(";") {
+			var err error
+			if s.value, err = p.expression(0); err != nil {
+				return nil, err
+			}
+		}
+		return s, p.expect(";")
+
+	case p.isKeyword("require"), p.isKeyword("assert"):
+		p.next()
+		s := &requireStmt{tok: tok, assert: tok.text == "assert"}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		var err error
+		if s.cond, err = p.expression(0); err != nil {
+			return nil, err
+		}
+		if !s.assert && p.is(",") {
+			p.next()
+			if s.message, err = p.expression(0); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return s, p.expect(";")
+
+	case p.isKeyword("emit"):
+		p.next()
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		return &emitStmt{tok: tok, event: name.text, args: args}, p.expect(";")
+	}
+
+	x, err := p.expression(0)
+	if err != nil {
+		return nil, err
+	}
+	if op := p.peek().text; p.peek().kind == tokPunct && (op == "=" || op == "+=" || op == "-=") {
+		p.next()
+		value, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		return &assignStmt{tok: tok, target: x, op: op, value: value}, p.expect(";")
+	}
+	return &exprStmt{tok: tok, x: x}, p.expect(";")
+}
+
+// parenthesized parses "(expression)".
+func (p *parser) parenthesized() (expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	x, err := p.expression(0)
+	if err != nil {
+		return nil, err
+	}
+	return x, p.expect(")")
+}
+
+// expression parses a binary expression whose operators bind at least as
+// tightly as binaryPrecedence[level].
+func (p *parser) expression(level int) (expr, error) {
+	if level == len(binaryPrecedence) {
+		return p.unary()
+	}
+
+	x, err := p.expression(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokPunct || !contains(binaryPrecedence[level], tok.text) {
+			return x, nil
+		}
+		p.next()
+		y, err := p.expression(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{tok: tok, op: tok.text, x: x, y: y}
+	}
+}
+
+// unary parses a prefix operator expression.
+func (p *parser) unary() (expr, error) {
+	tok := p.peek()
+	if p.is("!") || p.is("-") {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{tok: tok, op: tok.text, x: x}, nil
+	}
+	return p.primary()
+}
+
+// primary parses literals, names, indexing, calls and parenthesized expressions.
+func (p *parser) primary() (expr, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokInt:
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: integer literal out of range", tok.pos())
+		}
+		return &intLit{tok: tok, value: n}, nil
+
+	case tokString:
+		return &bytesLit{tok: tok, value: []byte(tok.text), typ: TypeString}, nil
+
+	case tokHex:
+		data, _ := hex.DecodeString(tok.text)
+		return &bytesLit{tok: tok, value: data, typ: TypeBytes}, nil
+
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return &boolLit{tok: tok, value: tok.text == "true"}, nil
+		case "msg":
+			if err := p.expect("."); err != nil {
+				return nil, err
+			}
+			field, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if field.text != "sender" && field.text != "value" {
+				return nil, fmt.Errorf("%s: unknown field msg.%s", field.pos(), field.text)
+			}
+			return &msgExpr{tok: tok, field: field.text}, nil
+		case "this":
+			if err := p.expect("."); err != nil {
+				return nil, err
+			}
+			field, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if field.text != "address" && field.text != "balance" {
+				return nil, fmt.Errorf("%s: unknown field this.%s", field.pos(), field.text)
+			}
+			return &msgExpr{tok: tok, field: field.text}, nil
+		}
+
+		if p.is("(") {
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			return &callExpr{tok: tok, name: tok.text, args: args}, nil
+		}
+		if p.is("[") {
+			p.next()
+			key, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			return &indexExpr{tok: tok, name: tok.text, key: key}, p.expect("]")
+		}
+		return &identExpr{tok: tok, name: tok.text}, nil
+
+	case tokPunct:
+		if tok.text == "(" {
+			x, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	}
+
+	if tok.kind == tokEOF {
+		return nil, fmt.Errorf("%s: unexpected end of source", tok.pos())
+	}
+	return nil, fmt.Errorf("%s: unexpected %q", tok.pos(), tok.text)
+}
+
+// arguments parses a parenthesized, comma-separated list of expressions.
+func (p *parser) arguments() ([]expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []expr
+	for !p.is(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		x, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, x)
+	}
+	p.next()
+	return args, nil
+}
+
+// typeName parses a type keyword.
+func (p *parser) typeName() (Type, error) {
+	if !p.isType() {
+		return TypeVoid, p.errorf("expected a type, found %q", p.peek().text)
+	}
+	return Type(p.next().text), nil
+}
+
+// isType reports whether the next token is a type keyword.
+func (p *parser) isType() bool {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		return false
+	}
+	switch Type(tok.text) {
+	case TypeInt, TypeBool, TypeBytes, TypeString:
+		return true
+	}
+	return false
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// is reports whether the next token is the punctuator s.
+func (p *parser) is(s string) bool {
+	tok := p.peek()
+	return tok.kind == tokPunct && tok.text == s
+}
+
+// isKeyword reports whether the next token is the identifier kw.
+func (p *parser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && tok.text == kw
+}
+
+func (p *parser) expect(s string) error {
+	if !p.is(s) {
+		return p.errorf("expected %q, found %q", s, p.peek().text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.isKeyword(kw) {
+		return p.errorf("expected %q, found %q", kw, p.peek().text)
+	}
+	p.next()
+	return nil
+}
+
+// ident consumes an identifier that is not a reserved word.
+func (p *parser) ident() (token, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent || reserved[tok.text] {
+		return tok, p.errorf("expected a name, found %q", tok.text)
+	}
+	return p.next(), nil
+}
+
+// errorf returns an error at the position of the next token.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", p.peek().pos(), fmt.Sprintf(format, args...))
+}
+
+// reserved lists the keywords that cannot be used as names.
+var reserved = map[string]bool{
+	"contract": true, "event": true, "public": true, "function": true, "returns": true,
+	"map": true, "if": true, "else": true, "while": true, "return": true,
+	"require": true, "assert": true, "emit": true, "msg": true, "this": true, "nonreentrant": true, "true": true, "false": true,
+	"int": true, "bool": true, "bytes": true, "string": true,
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
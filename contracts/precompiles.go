@@ -0,0 +1,91 @@
+package contracts
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"math/big"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Precompiled cryptographic functions are native implementations that
+// contracts call at a fixed gas price instead of computing them with VM
+// instructions: SHA256, KECCAK256, VERIFYSIG and VERIFYMERKLE in bytecode,
+// sha256, keccak256, verifySignature and verifyMerkle in the language, and
+// the host functions of the same names in WebAssembly. Their gas is listed
+// in opcodes; hashes also pay gasPerWord per started 32 bytes of input and
+// Merkle proofs gasPerMerkleStep per step.
+const (
+	gasVerifySignature = 3000
+	gasPerMerkleStep   = 40
+)
+
+// merkleStepSize is the size of a step of an encoded Merkle proof: a side
+// byte, 1 if the sibling is the left child and 0 otherwise, followed by the
+// sibling's 32-byte hash.
+const merkleStepSize = 1 + sha256.Size
+
+// Domain separation prefixes of the transaction Merkle tree, the same as
+// the blockchain's, so that contracts verify the proofs nodes serve.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// keccak256 returns the Keccak-256 hash of data, with the original Keccak
+// padding rather than that of the standardized SHA3-256.
+func keccak256(data []byte) []byte {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+// verifySignature checks an r || s signature over a digest against a P-256
+// public key encoded as X || Y, the scheme transactions are signed with.
+// Malformed keys and signatures are invalid.
+func verifySignature(publicKeyBytes, digest, signature []byte) bool {
+	if len(publicKeyBytes) != 64 || len(signature) != 64 {
+		return false
+	}
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(publicKeyBytes[:32]),
+		Y:     new(big.Int).SetBytes(publicKeyBytes[32:]),
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(publicKey, digest, r, s)
+}
+
+// merkleSteps returns the number of steps of an encoded Merkle proof, or
+// false if the proof is malformed.
+func merkleSteps(proof []byte) (int, bool) {
+	if len(proof)%merkleStepSize != 0 {
+		return 0, false
+	}
+	return len(proof) / merkleStepSize, true
+}
+
+// verifyMerkleProof reports whether an encoded proof (see merkleStepSize)
+// links a transaction ID to the Merkle root of a block, like
+// blockchain.MerkleProof.Verify.
+func verifyMerkleProof(id, root, proof []byte) bool {
+	if _, ok := merkleSteps(proof); !ok {
+		return false
+	}
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, id...))
+	for i := 0; i < len(proof); i += merkleStepSize {
+		left, sibling := proof[i] == 1, proof[i+1:i+merkleStepSize]
+		if proof[i] > 1 {
+			return false
+		}
+		if left {
+			hash = sha256.Sum256(bytes.Join([][]byte{{merkleNodePrefix}, sibling, hash[:]}, nil))
+		} else {
+			hash = sha256.Sum256(bytes.Join([][]byte{{merkleNodePrefix}, hash[:], sibling}, nil))
+		}
+	}
+	return bytes.Equal(hash[:], root)
+}
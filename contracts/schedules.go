@@ -0,0 +1,85 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxScheduleRuns caps the number of runs of a single schedule.
+const MaxScheduleRuns = 1000
+
+// ScheduledGasPrice is what a contract pays from its balance for each unit
+// of gas of its scheduled runs.
+const ScheduledGasPrice = 1
+
+// gasSchedule is the cost of registering a schedule, which is kept by every
+// node until its last run.
+const gasSchedule = 5000
+
+// ScheduledCall is a schedule a contract registered for itself during a
+// call: calls of Method with Args at Height and then every Interval blocks,
+// Runs times, with at most GasLimit gas each. The chain turns it into a
+// schedule once the call tree succeeds (see CallTree.Scheduled).
+type ScheduledCall struct {
+	Contract string
+	Method   string
+	Args     []Value
+	GasLimit uint64
+	Height   int
+	Interval int
+	Runs     int
+}
+
+// Deposit returns the gas of every run of the schedule at ScheduledGasPrice.
+func (s *ScheduledCall) Deposit() int64 {
+	return int64(s.GasLimit) * int64(s.Runs) * ScheduledGasPrice
+}
+
+// check validates a schedule registered by a call executing at height.
+func (s *ScheduledCall) check(height int) error {
+	switch {
+	case s.Height <= height:
+		return fmt.Errorf("first run at height %d is not above the block's height %d", s.Height, height)
+	case s.Interval < 0:
+		return errors.New("schedule interval cannot be negative")
+	case s.Runs < 1 || s.Runs > MaxScheduleRuns:
+		return fmt.Errorf("schedule runs must be between 1 and %d", MaxScheduleRuns)
+	case s.Interval == 0 && s.Runs != 1:
+		return errors.New("schedules without an interval run once")
+	case s.GasLimit == 0 || s.GasLimit > MaxGasLimit:
+		return fmt.Errorf("schedule gas must be between 1 and %d", MaxGasLimit)
+	}
+	return nil
+}
+
+// schedule registers a schedule of the running call's contract, paying its
+// deposit from the contract's balance. The schedule is discarded with the
+// rest of the tree if anything fails.
+func (t *CallTree) schedule(caller *CallContext, call *ScheduledCall) (int64, error) {
+	call.Contract = caller.Address
+	if err := call.check(caller.BlockHeight); err != nil {
+		return 0, err
+	}
+	contract, err := t.contract(caller.Address)
+	if err != nil {
+		return 0, err
+	}
+	if contract.ABI != nil {
+		if err := contract.ABI.CheckCall(call.Method, call.Args); err != nil {
+			return 0, err
+		}
+	}
+	deposit := call.Deposit()
+	if contract.Balance < deposit {
+		return 0, fmt.Errorf("%w: %s holds %d, the schedule's gas costs %d", ErrInsufficientBalance, contract.ID, contract.Balance, deposit)
+	}
+	contract.Balance -= deposit
+	t.scheduled = append(t.scheduled, call)
+	return deposit, nil
+}
+
+// Scheduled returns the schedules the contracts of the tree registered, in
+// the order they did. They are only valid if Call succeeded.
+func (t *CallTree) Scheduled() []*ScheduledCall {
+	return t.scheduled
+}
@@ -4,82 +4,321 @@
 package contracts
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"time"
 )
 
 // SmartContract represents a programmable contract on the blockchain.
 // Each contract consists of:
 //   - ID: A unique identifier for the contract
-//   - Code: The contract's executable code
+//   - Code: The contract's bytecode for the contract VM or a WebAssembly
+//     module, hex encoded
+//   - Source: The contract language source the code was compiled from, if any
+//   - ABI: The contract's methods and events, if known
 //   - State: A key-value store for the contract's persistent state
+//   - Balance: The value the contract holds, received with calls
+//   - Owner: The address allowed to upgrade or freeze the contract
+//   - Frozen: Whether the contract's code is final
+//   - Versions: Every code the contract has had, the current one last
+//   - Archive: The commitment to the contract's state while it is archived
 //   - CreatedAt: Timestamp of contract creation
 //
 // The contract's state is mutable and persists between executions,
-// allowing for stateful contract behavior.
+// allowing for stateful contract behavior. State values are int64 or []byte,
+// the two kinds of VM values.
+//
+// The owner can replace the contract's code, keeping its state and balance,
+// until the contract is frozen (see Upgrade and Freeze). The owner is an
+// account or a governance contract voting on upgrades.
+//
+// A contract whose state is archived, e.g. because it stopped paying for its
+// storage, keeps only a commitment to its state and cannot be called until
+// the state is restored.
 type SmartContract struct {
 	ID        string                 // Unique identifier for the contract
-	Code      string                 // Contract's executable code
+	Code      string                 // Contract's bytecode or WebAssembly module, hex encoded
+	Source    string                 // Contract language source; empty if deployed as bytecode
+	ABI       *ABI                   // Methods and events; nil if the contract has none
 	State     map[string]interface{} // Contract's persistent state storage
+	Balance   int64                  // Value held by the contract
+	Owner     string                 // Address of the owning account or governance contract
+	Frozen    bool                   // Whether the code can no longer be upgraded
+	Versions  []ContractVersion      // Code history, oldest first; the last entry is current
+	Archive   *StateArchive          // Commitment to the archived state; nil unless the state is archived
 	CreatedAt time.Time              // Contract creation timestamp
 }
 
+// ContractVersion is a code a contract has had, from its deployment or an upgrade.
+type ContractVersion struct {
+	Version  int    `json:"version"`       // 1 for the deployed code, increasing with each upgrade
+	Code     string `json:"code"`          // Bytecode or WebAssembly module, hex encoded
+	CodeHash string `json:"codeHash"`      // See CodeHash
+	ABI      *ABI   `json:"abi,omitempty"` // The ABI that came with the code
+	Height   int    `json:"height"`        // Height of the block that deployed or upgraded to the code
+	TxID     string `json:"txId"`          // Hex ID of the deploying or upgrading transaction
+}
+
+// StateArchive is the commitment a contract keeps to its state while the
+// state is archived: the state can be restored by presenting entries that
+// hash to StateRoot.
+type StateArchive struct {
+	Height    int    `json:"height"`    // Height of the block that archived the state
+	StateRoot string `json:"stateRoot"` // Hex root of a state tree holding only the archived entries
+	Entries   int    `json:"entries"`   // Number of archived entries
+	Size      int    `json:"size"`      // Size of the archived state in bytes
+}
+
+var (
+	// ErrContractNotFound is returned when no contract is deployed at an
+	// address.
+	ErrContractNotFound = errors.New("contract not found")
+
+	// ErrContractFrozen is returned when upgrading a frozen contract.
+	ErrContractFrozen = errors.New("contract is frozen")
+
+	// ErrContractArchived is returned when calling a contract whose state is
+	// archived.
+	ErrContractArchived = errors.New("contract state is archived")
+)
+
+// CodeHash returns the hex encoded SHA-256 hash of hex encoded contract
+// code, which identifies a version of a contract, e.g. in upgrade votes.
+// Returns the hash of the string itself if it is not hex encoded.
+func CodeHash(code string) string {
+	data, err := hex.DecodeString(code)
+	if err != nil {
+		data = []byte(code)
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
 // NewSmartContract creates a new smart contract instance.
 // Parameters:
 //   - id: Unique identifier for the contract
-//   - code: The contract's executable code
+//   - code: The contract's bytecode, hex encoded
 //
 // Returns a new smart contract with:
 //   - Initialized state map
-//   - Creation timestamp
 //   - Provided ID and code
+//
+// CreatedAt is left for the deployment to set from its block's timestamp,
+// so that every node records the same creation time.
 func NewSmartContract(id string, code string) *SmartContract {
 	return &SmartContract{
-		ID:        id,
-		Code:      code,
-		State:     make(map[string]interface{}),
-		CreatedAt: time.Now(),
+		ID:    id,
+		Code:  code,
+		State: make(map[string]interface{}),
 	}
 }
 
-// Execute runs the smart contract with the provided input.
+// NewSmartContractFromSource compiles contract language source and creates
+// a contract holding both the source and the resulting bytecode.
+// Returns an error if the source does not compile.
+func NewSmartContractFromSource(id string, source string) (*SmartContract, error) {
+	compiled, err := Compile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	contract := NewSmartContract(id, hex.EncodeToString(compiled.Bytecode))
+	contract.Source = source
+	contract.ABI = compiled.ABI
+	return contract, nil
+}
+
+// Bytecode decodes the contract's code.
+func (sc *SmartContract) Bytecode() ([]byte, error) {
+	code, err := hex.DecodeString(sc.Code)
+	if err != nil {
+		return nil, fmt.Errorf("contract code is not hex encoded bytecode: %v", err)
+	}
+	return code, nil
+}
+
+// Execute runs the contract's bytecode for a call, on the WebAssembly runtime
+// if the code is a WebAssembly module.
 // Parameters:
-//   - input: Map of input parameters for contract execution
+//   - ctx: The caller, value, method, arguments and gas limit of the call
 //
-// The function:
-// 1. Logs the execution attempt
-// 2. Stores the input in the contract's state
-// 3. Returns the current state of the contract
+// Execution is all or nothing: the contract's state is only updated if the
+// call completes. If it runs out of gas, reverts or fails in any other way,
+// every storage write made during the call is discarded.
 //
-// Returns:
-//   - The contract's state after execution
-//   - Any error that occurred during execution
+// If the contract has an ABI, the call is rejected before execution unless
+// the method is declared and the arguments match its parameters, and fails
+// if the return value is not of the declared type.
 //
-// Note: This is a basic implementation that only stores the input.
-// A full implementation would parse and execute the contract code.
-func (sc *SmartContract) Execute(input map[string]interface{}) (map[string]interface{}, error) {
-	fmt.Println("Executing smart contract", input)
-	sc.State["lastExecution"] = input
-	return sc.State, nil
+// Returns:
+//   - The execution result, with the gas used even if execution failed
+//   - Any error that occurred during execution, ErrContractArchived if the
+//     contract's state is archived
+func (sc *SmartContract) Execute(ctx *CallContext) (*ExecutionResult, error) {
+	if sc.Archive != nil {
+		return &ExecutionResult{}, fmt.Errorf("%w: %s", ErrContractArchived, sc.ID)
+	}
+	code, err := sc.Bytecode()
+	if err != nil {
+		return &ExecutionResult{}, err
+	}
+	if sc.ABI != nil {
+		if err := sc.ABI.CheckCall(ctx.Method, ctx.Args); err != nil {
+			return &ExecutionResult{}, err
+		}
+	}
+
+	run := Run
+	if IsWasm(code) {
+		run = RunWasm
+	}
+	result, writes, err := run(code, sc.State, ctx)
+	if err != nil {
+		return result, err
+	}
+	if sc.ABI != nil {
+		if err := sc.ABI.CheckReturn(ctx.Method, result.ReturnValue); err != nil {
+			result.Logs = nil
+			return result, err
+		}
+	}
+
+	for key, value := range writes {
+		sc.State[key] = value.stateValue()
+	}
+	return result, nil
+}
+
+// Version returns the contract's current version, 0 for a contract without
+// a version history.
+func (sc *SmartContract) Version() int {
+	if len(sc.Versions) == 0 {
+		return 0
+	}
+	return sc.Versions[len(sc.Versions)-1].Version
+}
+
+// Upgrade replaces the contract's code and ABI, keeping its state and
+// balance, and records the new version, deployed in the given block by the
+// given transaction. The caller is responsible for checking that the upgrade
+// is authorized.
+// Returns ErrContractFrozen if the contract is frozen, or an error if the new
+// code is not valid, in which case the contract is unchanged.
+func (sc *SmartContract) Upgrade(code string, abi *ABI, height int, txID string) error {
+	if sc.Frozen {
+		return ErrContractFrozen
+	}
+	upgraded := &SmartContract{Code: code, ABI: abi}
+	if err := upgraded.Validate(); err != nil {
+		return err
+	}
+
+	sc.Code = code
+	sc.ABI = abi
+	sc.Source = ""
+	sc.Versions = append(slices.Clip(sc.Versions), ContractVersion{
+		Version:  sc.Version() + 1,
+		Code:     code,
+		CodeHash: CodeHash(code),
+		ABI:      abi,
+		Height:   height,
+		TxID:     txID,
+	})
+	return nil
+}
+
+// Load returns the value of a state entry, or nil if the entry is unset.
+func (sc *SmartContract) Load(key string) (*Value, error) {
+	raw, ok := sc.State[key]
+	if !ok {
+		return nil, nil
+	}
+	value, err := valueFromState(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// Copy returns a copy of the contract whose state can be changed, e.g. by
+// Execute, without affecting the original.
+func (sc *SmartContract) Copy() *SmartContract {
+	copied := *sc
+	copied.State = make(map[string]interface{}, len(sc.State))
+	for key, value := range sc.State {
+		copied.State[key] = value
+	}
+	return &copied
+}
+
+// StateChange is a change of one entry of a contract's state.
+type StateChange struct {
+	Key    string `json:"key"`
+	Before *Value `json:"before"` // nil if the entry was unset
+	After  *Value `json:"after"`  // nil if the entry was removed
+}
+
+// DiffState returns the entries that differ between two versions of a
+// contract's state, sorted by key.
+func DiffState(before, after map[string]interface{}) []StateChange {
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	var changes []StateChange
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		change := StateChange{Key: key}
+		if raw, ok := before[key]; ok {
+			if v, err := valueFromState(raw); err == nil {
+				change.Before = &v
+			}
+		}
+		if raw, ok := after[key]; ok {
+			if v, err := valueFromState(raw); err == nil {
+				change.After = &v
+			}
+		}
+		if change.Before != nil && change.After != nil && change.Before.Equal(*change.After) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
 }
 
 // Validate performs basic validation of the smart contract.
 // Checks:
 //   - Contract code is not empty
+//   - Contract code is well-formed bytecode (see VerifyBytecode) or a valid
+//     WebAssembly module (see VerifyWasm)
+//   - The ABI, if any, is well-formed (see ABI.Validate)
 //
 // Returns:
 //   - nil if validation passes
 //   - error if validation fails
-//
-// Note: This is a basic validation. A full implementation would:
-//   - Validate contract code syntax
-//   - Check for security vulnerabilities
-//   - Verify resource limits
-//   - Validate state initialization
 func (sc *SmartContract) Validate() error {
 	if sc.Code == "" {
 		return errors.New("smart contract code is required")
 	}
-	return nil
+	code, err := sc.Bytecode()
+	if err != nil {
+		return err
+	}
+	if sc.ABI != nil {
+		if err := sc.ABI.Validate(); err != nil {
+			return err
+		}
+	}
+	if IsWasm(code) {
+		return VerifyWasm(code)
+	}
+	return VerifyBytecode(code)
 }
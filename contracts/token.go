@@ -0,0 +1,138 @@
+package contracts
+
+import (
+	_ "embed"
+)
+
+// FungibleTokenSource is the source of the reference fungible token, which
+// implements the fungible token standard (see IsFungibleToken). It is
+// deployed uninitialized; its deployer then calls
+//
+//	init(string name, string symbol, int decimals, string metadata, int initialSupply)
+//
+// once to become its issuer and receive the initial supply.
+//
+//go:embed examples/fungible_token.ufc
+var FungibleTokenSource string
+
+// NFTCollectionSource is the source of the reference NFT collection, which
+// implements the NFT collection standard (see IsNFTCollection). Like the
+// reference fungible token, it is deployed uninitialized; its deployer then
+// calls
+//
+//	init(string name, string symbol)
+//
+// once to become its issuer.
+//
+//go:embed examples/nft_collection.ufc
+var NFTCollectionSource string
+
+// fungibleTokenMethods are the methods of the fungible token standard.
+var fungibleTokenMethods = []ABIMethod{
+	{Name: "name", Returns: TypeString},
+	{Name: "symbol", Returns: TypeString},
+	{Name: "decimals", Returns: TypeInt},
+	{Name: "metadata", Returns: TypeString},
+	{Name: "issuer", Returns: TypeString},
+	{Name: "totalSupply", Returns: TypeInt},
+	{Name: "balanceOf", Inputs: []ABIParam{{Type: TypeString}}, Returns: TypeInt},
+	{Name: "allowance", Inputs: []ABIParam{{Type: TypeString}, {Type: TypeString}}, Returns: TypeInt},
+	{Name: "transfer", Inputs: []ABIParam{{Type: TypeString}, {Type: TypeInt}}, Returns: TypeBool},
+	{Name: "approve", Inputs: []ABIParam{{Type: TypeString}, {Type: TypeInt}}, Returns: TypeBool},
+	{Name: "transferFrom", Inputs: []ABIParam{{Type: TypeString}, {Type: TypeString}, {Type: TypeInt}}, Returns: TypeBool},
+	{Name: "mint", Inputs: []ABIParam{{Type: TypeString}, {Type: TypeInt}}, Returns: TypeBool},
+	{Name: "burn", Inputs: []ABIParam{{Type: TypeInt}}, Returns: TypeBool},
+}
+
+// IsFungibleToken reports whether a contract's ABI implements the fungible
+// token standard: the methods of the reference token, with the same
+// argument and return types, balances being integers in the token's
+// smallest unit:
+//
+//	name(), symbol(), metadata(), issuer() returns string
+//	decimals(), totalSupply() returns int
+//	balanceOf(string account) returns int
+//	allowance(string owner, string spender) returns int
+//	transfer(string to, int amount) returns bool
+//	approve(string spender, int amount) returns bool
+//	transferFrom(string from, string to, int amount) returns bool
+//	mint(string to, int amount) returns bool   (issuer only)
+//	burn(int amount) returns bool              (issuer only)
+func IsFungibleToken(abi *ABI) bool {
+	return implements(abi, fungibleTokenMethods, nil)
+}
+
+// nftCollectionMethods are the methods of the NFT collection standard.
+var nftCollectionMethods = []ABIMethod{
+	{Name: "name", Returns: TypeString},
+	{Name: "symbol", Returns: TypeString},
+	{Name: "issuer", Returns: TypeString},
+	{Name: "totalSupply", Returns: TypeInt},
+	{Name: "balanceOf", Inputs: []ABIParam{{Type: TypeString}}, Returns: TypeInt},
+	{Name: "ownerOf", Inputs: []ABIParam{{Type: TypeInt}}, Returns: TypeString},
+	{Name: "tokenURI", Inputs: []ABIParam{{Type: TypeInt}}, Returns: TypeString},
+	{Name: "contentHash", Inputs: []ABIParam{{Type: TypeInt}}, Returns: TypeString},
+	{Name: "mint", Inputs: []ABIParam{{Type: TypeString}, {Type: TypeString}, {Type: TypeString}}, Returns: TypeInt},
+	{Name: "transfer", Inputs: []ABIParam{{Type: TypeString}, {Type: TypeInt}}, Returns: TypeBool},
+	{Name: "burn", Inputs: []ABIParam{{Type: TypeInt}}, Returns: TypeBool},
+}
+
+// nftCollectionEvents are the events of the NFT collection standard.
+var nftCollectionEvents = []ABIEvent{
+	{Name: "Transfer", Inputs: []ABIParam{{Type: TypeString}, {Type: TypeString}, {Type: TypeInt}}},
+	{Name: "Mint", Inputs: []ABIParam{{Type: TypeInt}, {Type: TypeString}, {Type: TypeString}}},
+}
+
+// IsNFTCollection reports whether a contract's ABI implements the NFT
+// collection standard: the methods and events of the reference collection,
+// with the same types. Token IDs are positive integers and an unowned
+// token's owner is empty:
+//
+//	name(), symbol(), issuer() returns string
+//	totalSupply() returns int
+//	balanceOf(string account) returns int
+//	ownerOf(int tokenId), tokenURI(int tokenId), contentHash(int tokenId) returns string
+//	mint(string to, string uri, string contentHash) returns int   (issuer only)
+//	transfer(string to, int tokenId) returns bool                 (owner only)
+//	burn(int tokenId) returns bool                                (owner only)
+//	event Transfer(string from, string to, int tokenId)   from or to empty for mints and burns
+//	event Mint(int tokenId, string uri, string contentHash)
+//
+// Nodes index these events to answer ownership and history queries.
+func IsNFTCollection(abi *ABI) bool {
+	return implements(abi, nftCollectionMethods, nftCollectionEvents)
+}
+
+// implements reports whether abi declares methods and events with the given
+// parameter and return types; parameter names are not compared.
+func implements(abi *ABI, methods []ABIMethod, events []ABIEvent) bool {
+	if abi == nil {
+		return false
+	}
+	for _, want := range methods {
+		method, ok := abi.Method(want.Name)
+		if !ok || method.Returns != want.Returns || !sameTypes(method.Inputs, want.Inputs) {
+			return false
+		}
+	}
+	for _, want := range events {
+		event, ok := abi.event(want.Name)
+		if !ok || !sameTypes(event.Inputs, want.Inputs) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameTypes reports whether two parameter lists have the same types.
+func sameTypes(params, want []ABIParam) bool {
+	if len(params) != len(want) {
+		return false
+	}
+	for i := range want {
+		if params[i].Type != want[i].Type {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package contracts
+
+import (
+	"slices"
+
+	"github.com/ignaciocorball/go-blockchain/contracts/wasm"
+)
+
+// MaxTraceSteps is the maximum number of steps a Tracer records. Execution
+// goes on past it, untraced.
+const MaxTraceSteps = 10_000
+
+// Storage access kinds of a TraceStep.
+const (
+	StorageRead  = "read"
+	StorageWrite = "write"
+)
+
+// Tracer records the steps of a contract call and of the calls it makes to
+// other contracts, in execution order. Tracing does not change execution:
+// a traced call uses the same gas and has the same outcome.
+type Tracer struct {
+	Steps     []*TraceStep `json:"steps"`
+	Truncated bool         `json:"truncated"` // Whether steps past MaxTraceSteps were dropped
+}
+
+// NewTracer creates a tracer with no steps.
+func NewTracer() *Tracer {
+	return &Tracer{Steps: []*TraceStep{}}
+}
+
+// TraceStep is a VM instruction, or a host call of a WebAssembly contract.
+// The instructions a WebAssembly contract runs between host calls are not
+// traced, and their gas is not attributed to any step.
+type TraceStep struct {
+	Contract   string          `json:"contract"`             // Address of the running contract
+	Depth      int             `json:"depth"`                // Nesting below the transaction's call
+	PC         int             `json:"pc"`                   // VM: offset of the instruction
+	Op         string          `json:"op"`                   // Instruction mnemonic, or env.<name> for a host call
+	Gas        uint64          `json:"gas"`                  // Gas left before the step
+	GasCost    uint64          `json:"gasCost"`              // Gas used by the step, including by the calls it made
+	Stack      []Value         `json:"stack"`                // VM: the stack before the step, bottom first; host call: its arguments
+	Locals     []Value         `json:"locals,omitempty"`     // VM: the local variables of the current frame
+	MemorySize int             `json:"memorySize,omitempty"` // WebAssembly: size of the linear memory in bytes
+	Storage    []StorageAccess `json:"storage,omitempty"`    // Storage reads and writes, in order
+	Error      string          `json:"error,omitempty"`      // Why the step failed
+}
+
+// StorageAccess is a read or write of a contract storage key.
+type StorageAccess struct {
+	Kind  string `json:"kind"` // StorageRead or StorageWrite
+	Key   string `json:"key"`
+	Value *Value `json:"value"` // Value read or written; nil if a WebAssembly read found the key unset
+}
+
+// record appends a step, unless MaxTraceSteps were already recorded.
+// Returns the step, or nil if it was dropped.
+func (t *Tracer) record(step *TraceStep) *TraceStep {
+	if len(t.Steps) >= MaxTraceSteps {
+		t.Truncated = true
+		return nil
+	}
+	t.Steps = append(t.Steps, step)
+	return step
+}
+
+// access records a storage access of the step. A nil step is ignored.
+func (s *TraceStep) access(kind string, key string, value *Value) {
+	if s == nil {
+		return
+	}
+	s.Storage = append(s.Storage, StorageAccess{Kind: kind, Key: key, Value: value})
+}
+
+// end records the gas the step used and the error it failed with, if any.
+// A nil step is ignored.
+func (s *TraceStep) end(gasCost uint64, err error) {
+	if s == nil {
+		return
+	}
+	s.GasCost = gasCost
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// traceStep records the instruction at the program counter, if the call is
+// traced. Returns the step, or nil.
+func (m *vm) traceStep() *TraceStep {
+	if m.ctx.Tracer == nil {
+		return nil
+	}
+	return m.ctx.Tracer.record(&TraceStep{
+		Contract: m.ctx.Address,
+		Depth:    m.ctx.Depth,
+		PC:       m.pc,
+		Op:       Opcode(m.code[m.pc]).String(),
+		Gas:      m.gas,
+		Stack:    append([]Value{}, m.stack...),
+		Locals:   slices.Clone(m.frames[len(m.frames)-1].locals),
+	})
+}
+
+// traceHost wraps a host function so that its calls are recorded, if the
+// call is traced.
+func (call *wasmCall) traceHost(name string, fn func(inst *wasm.Instance, args []uint64) ([]uint64, error)) func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	if call.ctx.Tracer == nil {
+		return fn
+	}
+	return func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+		stack := make([]Value, len(args))
+		for i, arg := range args {
+			stack[i] = IntValue(int64(arg))
+		}
+		gas := inst.FuelLeft()
+		call.step = call.ctx.Tracer.record(&TraceStep{
+			Contract:   call.ctx.Address,
+			Depth:      call.ctx.Depth,
+			Op:         "env." + name,
+			Gas:        gas,
+			Stack:      stack,
+			MemorySize: inst.MemorySize(),
+		})
+
+		results, err := fn(inst, args)
+		call.step.end(gas-inst.FuelLeft(), err)
+		call.step = nil
+		return results, err
+	}
+}
@@ -0,0 +1,44 @@
+package contracts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnsupportedCompiler is returned when verifying source compiled with
+	// a compiler version other than CompilerVersion.
+	ErrUnsupportedCompiler = errors.New("unsupported compiler version")
+
+	// ErrSourceMismatch is returned when source does not compile to a
+	// contract's code, or to its ABI.
+	ErrSourceMismatch = errors.New("source does not match the deployed contract")
+)
+
+// VerifySource checks that source compiled with the given compiler version
+// is what the contract runs: it is recompiled, and the bytecode must be the
+// contract's current code. If the contract has an ABI, the compiled ABI must
+// be the same, so that the verified source describes the calls the contract
+// accepts.
+//
+// Returns the compiled contract, ErrUnsupportedCompiler if this node does
+// not have the compiler version, a compilation error, or ErrSourceMismatch.
+func (sc *SmartContract) VerifySource(source, compilerVersion string) (*CompiledContract, error) {
+	if compilerVersion != CompilerVersion {
+		return nil, fmt.Errorf("%w %q: this node compiles with %s", ErrUnsupportedCompiler, compilerVersion, CompilerVersion)
+	}
+	compiled, err := Compile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if code := hex.EncodeToString(compiled.Bytecode); code != sc.Code {
+		return nil, fmt.Errorf("%w: the source compiles to code %s, the contract's code is %s", ErrSourceMismatch, CodeHash(code), CodeHash(sc.Code))
+	}
+	if sc.ABI != nil && !bytes.Equal(compiled.ABI.Encode(), sc.ABI.Encode()) {
+		return nil, fmt.Errorf("%w: the source's ABI differs from the contract's", ErrSourceMismatch)
+	}
+	return compiled, nil
+}
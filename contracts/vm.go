@@ -0,0 +1,941 @@
+package contracts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// VM limits.
+const (
+	MaxStackDepth = 1024      // Maximum number of items on the stack
+	MaxValueSize  = 64 * 1024 // Maximum length of a byte string value
+	MaxCodeSize   = 24 * 1024 // Maximum size of contract bytecode
+	MaxFrames     = 256       // Maximum nesting of CALL frames
+	MaxLogs       = 256       // Maximum number of events a call may emit
+)
+
+// DefaultGasLimit is the gas available to a call that does not set a limit.
+const DefaultGasLimit = 1_000_000
+
+var (
+	// ErrOutOfGas is returned when execution needs more gas than the call provided.
+	ErrOutOfGas = errors.New("out of gas")
+
+	// ErrStackOverflow is returned when an instruction would exceed MaxStackDepth.
+	ErrStackOverflow = errors.New("stack overflow")
+
+	// ErrStackUnderflow is returned when an instruction needs more items than the stack holds.
+	ErrStackUnderflow = errors.New("stack underflow")
+
+	// ErrInvalidOpcode is returned when execution reaches an undefined instruction.
+	ErrInvalidOpcode = errors.New("invalid opcode")
+
+	// ErrInvalidJump is returned when a jump targets an offset that is not an instruction.
+	ErrInvalidJump = errors.New("invalid jump destination")
+
+	// ErrTypeMismatch is returned when an instruction receives a value of the wrong kind.
+	ErrTypeMismatch = errors.New("type mismatch")
+
+	// ErrDivisionByZero is returned by DIV and MOD with a zero divisor.
+	ErrDivisionByZero = errors.New("division by zero")
+
+	// ErrIntegerOverflow is returned when an arithmetic result does not fit in 64 bits.
+	ErrIntegerOverflow = errors.New("integer overflow")
+
+	// ErrValueTooLarge is returned when a byte string would exceed MaxValueSize.
+	ErrValueTooLarge = errors.New("value too large")
+)
+
+// RevertError is returned when a contract aborts execution with REVERT.
+type RevertError struct {
+	Reason string
+}
+
+func (e *RevertError) Error() string {
+	return "execution reverted: " + e.Reason
+}
+
+// Value is a VM value: either a 64-bit signed integer or a byte string.
+type Value struct {
+	IsBytes bool   // Whether the value is a byte string
+	Int     int64  // Integer value, when IsBytes is false
+	Bytes   []byte // Byte string value, when IsBytes is true
+}
+
+// IntValue returns an integer value.
+func IntValue(n int64) Value {
+	return Value{Int: n}
+}
+
+// BytesValue returns a byte string value.
+func BytesValue(b []byte) Value {
+	return Value{IsBytes: true, Bytes: b}
+}
+
+// boolValue returns 1 for true and 0 for false.
+func boolValue(b bool) Value {
+	if b {
+		return IntValue(1)
+	}
+	return IntValue(0)
+}
+
+// Truthy reports whether the value counts as true: a non-zero integer or a
+// non-empty byte string.
+func (v Value) Truthy() bool {
+	if v.IsBytes {
+		return len(v.Bytes) > 0
+	}
+	return v.Int != 0
+}
+
+// Equal reports whether two values are of the same kind and hold the same value.
+func (v Value) Equal(o Value) bool {
+	if v.IsBytes != o.IsBytes {
+		return false
+	}
+	if v.IsBytes {
+		return bytes.Equal(v.Bytes, o.Bytes)
+	}
+	return v.Int == o.Int
+}
+
+// Encode returns the canonical byte form of the value, used by hashing and
+// concatenation: byte strings as is, integers as their decimal text.
+func (v Value) Encode() []byte {
+	if v.IsBytes {
+		return v.Bytes
+	}
+	return []byte(strconv.FormatInt(v.Int, 10))
+}
+
+// String returns a human-readable form of the value.
+func (v Value) String() string {
+	if !v.IsBytes {
+		return strconv.FormatInt(v.Int, 10)
+	}
+	if utf8.Valid(v.Bytes) {
+		return string(v.Bytes)
+	}
+	return "0x" + hex.EncodeToString(v.Bytes)
+}
+
+// MarshalJSON encodes integers as JSON numbers and byte strings as JSON
+// strings; byte strings that are not valid UTF-8 are hex encoded with a 0x prefix.
+func (v Value) MarshalJSON() ([]byte, error) {
+	if !v.IsBytes {
+		return []byte(strconv.FormatInt(v.Int, 10)), nil
+	}
+	return json.Marshal(v.String())
+}
+
+// ValueFromJSON converts a decoded JSON value into a VM value.
+// Integral numbers become integers, strings become byte strings and booleans
+// become 1 or 0. Numbers must have been decoded as json.Number.
+func ValueFromJSON(raw interface{}) (Value, error) {
+	switch v := raw.(type) {
+	case json.Number:
+		n, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			return Value{}, fmt.Errorf("%s is not a 64-bit integer", v)
+		}
+		return IntValue(n), nil
+	case string:
+		return BytesValue([]byte(v)), nil
+	case bool:
+		return boolValue(v), nil
+	default:
+		return Value{}, fmt.Errorf("unsupported argument type %T", raw)
+	}
+}
+
+// stateValue converts a value into the form kept in SmartContract.State.
+func (v Value) stateValue() interface{} {
+	if v.IsBytes {
+		return v.Bytes
+	}
+	return v.Int
+}
+
+// valueFromState converts an entry of SmartContract.State into a VM value.
+func valueFromState(raw interface{}) (Value, error) {
+	switch v := raw.(type) {
+	case nil:
+		return IntValue(0), nil
+	case int64:
+		return IntValue(v), nil
+	case []byte:
+		return BytesValue(v), nil
+	default:
+		return Value{}, fmt.Errorf("%w: state holds %T", ErrTypeMismatch, raw)
+	}
+}
+
+// Log is an event emitted by a contract with LOG or LOGI.
+// Topics make logs searchable: the first is the event's topic (see
+// EventTopic), followed by the topics of its indexed arguments (see
+// ValueTopic), in argument order.
+type Log struct {
+	Contract string   `json:"contract"` // Address of the contract that emitted the event
+	Event    string   `json:"event"`    // Name of the event
+	Args     []Value  `json:"args"`     // Event arguments
+	Topics   []string `json:"topics"`   // Hex encoded topics
+}
+
+// EventTopic returns the topic identifying events with the given name.
+func EventTopic(event string) string {
+	return ValueTopic(BytesValue([]byte(event)))
+}
+
+// ValueTopic returns the topic of an indexed event argument: the SHA-256
+// hash of its encoding, hex encoded with a 0x prefix.
+func ValueTopic(v Value) string {
+	hash := sha256.Sum256(v.Encode())
+	return "0x" + hex.EncodeToString(hash[:])
+}
+
+// CallContext describes a single contract invocation.
+type CallContext struct {
+	Caller      string  // Address of the account calling the contract
+	Value       int64   // Amount sent along with the call
+	Method      string  // Name of the method being called
+	Args        []Value // Call arguments
+	GasLimit    uint64  // Maximum gas the call may consume
+	BlockHeight int     // Height of the chain tip the call executes on
+	Address     string  // Address of the called contract, set by CallTree
+	Depth       int     // Nesting below the transaction's call; 0 for the call itself
+	Tracer      *Tracer // Records the steps of the call and the calls it makes; nil if not traced
+
+	// Deadline is the wall-clock time at which the call and the calls it
+	// makes are aborted with ErrTimeout; zero for none. Only set for calls
+	// outside of consensus (see MaxCallDuration).
+	Deadline time.Time
+
+	tree *CallTree // Tree the call runs in; nil if contract calls are not available
+}
+
+// ExecutionResult is the outcome of a contract call.
+type ExecutionResult struct {
+	ReturnValue *Value // Value passed to RETURN, or nil
+	GasUsed     uint64 // Gas consumed, including by a failed call
+	Logs        []Log  // Events emitted, in order; empty if the call failed
+}
+
+// frame is the activation record of a CALL.
+type frame struct {
+	returnPC int     // Offset to continue at after RET
+	locals   []Value // Local variables, grown on demand
+}
+
+// vm executes one contract call.
+// Storage writes go to an overlay and only reach the contract state once the
+// call has completed successfully.
+type vm struct {
+	code    []byte
+	targets map[int]bool // Offsets at which an instruction starts
+	pc      int
+	stack   []Value
+	frames  []*frame // Call frames; the first one is the top-level frame
+	gas     uint64   // Gas left
+	ctx     *CallContext
+	logs    []Log
+	steps   uint64 // Instructions executed
+	memory  int    // Bytes of byte strings created (see MaxMemory)
+
+	state  map[string]interface{} // Committed contract state (read only)
+	writes map[string]Value       // Storage writes of this call
+
+	traced *TraceStep // Step being traced; nil if the call is not traced
+}
+
+// run executes the bytecode from offset 0 until it halts.
+func (m *vm) run() (*Value, error) {
+	for m.pc < len(m.code) {
+		if m.steps++; m.steps%deadlineInterval == 0 && m.ctx.expired() {
+			return nil, ErrTimeout
+		}
+		gas := m.gas
+		m.traced = m.traceStep()
+		ret, halt, err := m.next()
+		m.traced.end(gas-m.gas, err)
+		if err != nil {
+			return nil, err
+		}
+		if halt {
+			return ret, nil
+		}
+	}
+	return nil, nil
+}
+
+// next decodes and executes the instruction at the program counter. It
+// reports whether execution halts and with which return value.
+func (m *vm) next() (*Value, bool, error) {
+	op := Opcode(m.code[m.pc])
+	info, ok := opcodes[op]
+	if !ok {
+		return nil, false, fmt.Errorf("%w 0x%02x at %d", ErrInvalidOpcode, byte(op), m.pc)
+	}
+	if err := m.useGas(info.gas); err != nil {
+		return nil, false, err
+	}
+
+	operand, next, err := readOperand(m.code, m.pc, info)
+	if err != nil {
+		return nil, false, err
+	}
+	m.pc = next
+	return m.step(op, operand)
+}
+
+// step executes a single instruction. It reports whether execution halts and
+// with which return value.
+func (m *vm) step(op Opcode, operand []byte) (*Value, bool, error) {
+	switch op {
+	case OpStop:
+		return nil, true, nil
+
+	case OpPush:
+		return nil, false, m.push(IntValue(int64(binary.BigEndian.Uint64(operand))))
+
+	case OpPushBytes:
+		if err := m.useGas(wordGas(len(operand))); err != nil {
+			return nil, false, err
+		}
+		return nil, false, m.push(BytesValue(operand))
+
+	case OpPop:
+		_, err := m.pop()
+		return nil, false, err
+
+	case OpDup:
+		depth := int(operand[0])
+		if depth >= len(m.stack) {
+			return nil, false, ErrStackUnderflow
+		}
+		return nil, false, m.push(m.stack[len(m.stack)-1-depth])
+
+	case OpSwap:
+		depth := int(operand[0])
+		if depth == 0 || depth >= len(m.stack) {
+			return nil, false, ErrStackUnderflow
+		}
+		top := len(m.stack) - 1
+		m.stack[top], m.stack[top-depth] = m.stack[top-depth], m.stack[top]
+		return nil, false, nil
+
+	case OpLoad:
+		locals := m.frames[len(m.frames)-1].locals
+		slot := int(operand[0])
+		if slot >= len(locals) {
+			return nil, false, m.push(IntValue(0))
+		}
+		return nil, false, m.push(locals[slot])
+
+	case OpStore:
+		value, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		f := m.frames[len(m.frames)-1]
+		slot := int(operand[0])
+		for len(f.locals) <= slot {
+			f.locals = append(f.locals, IntValue(0))
+		}
+		f.locals[slot] = value
+		return nil, false, nil
+
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpLt, OpGt:
+		a, b, err := m.popInts()
+		if err != nil {
+			return nil, false, err
+		}
+		result, err := arithmetic(op, a, b)
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, false, m.push(result)
+
+	case OpEq, OpAnd, OpOr:
+		b, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		a, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		switch op {
+		case OpEq:
+			return nil, false, m.push(boolValue(a.Equal(b)))
+		case OpAnd:
+			return nil, false, m.push(boolValue(a.Truthy() && b.Truthy()))
+		default:
+			return nil, false, m.push(boolValue(a.Truthy() || b.Truthy()))
+		}
+
+	case OpNot:
+		a, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, false, m.push(boolValue(!a.Truthy()))
+
+	case OpJump:
+		m.pc = int(binary.BigEndian.Uint32(operand))
+		return nil, false, m.checkJump()
+
+	case OpJumpIf:
+		cond, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		if cond.Truthy() {
+			m.pc = int(binary.BigEndian.Uint32(operand))
+			return nil, false, m.checkJump()
+		}
+		return nil, false, nil
+
+	case OpCall:
+		if len(m.frames) >= MaxFrames {
+			return nil, false, errors.New("call frame limit exceeded")
+		}
+		m.frames = append(m.frames, &frame{returnPC: m.pc})
+		m.pc = int(binary.BigEndian.Uint32(operand))
+		return nil, false, m.checkJump()
+
+	case OpRet:
+		if len(m.frames) == 1 {
+			return nil, false, errors.New("RET outside of a call")
+		}
+		m.pc = m.frames[len(m.frames)-1].returnPC
+		m.frames = m.frames[:len(m.frames)-1]
+		return nil, false, nil
+
+	case OpSLoad:
+		key, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		value, err := m.load(string(key.Encode()))
+		if err != nil {
+			return nil, false, err
+		}
+		m.traced.access(StorageRead, string(key.Encode()), &value)
+		return nil, false, m.push(value)
+
+	case OpSStore:
+		value, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		key, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		k := key.Encode()
+		if err := m.useGas(wordGas(len(k) + len(value.Encode()))); err != nil {
+			return nil, false, err
+		}
+		m.writes[string(k)] = value
+		m.traced.access(StorageWrite, string(k), &value)
+		return nil, false, nil
+
+	case OpCaller:
+		return nil, false, m.push(BytesValue([]byte(m.ctx.Caller)))
+
+	case OpCallValue:
+		return nil, false, m.push(IntValue(m.ctx.Value))
+
+	case OpMethod:
+		return nil, false, m.push(BytesValue([]byte(m.ctx.Method)))
+
+	case OpArg:
+		index := int(operand[0])
+		if index >= len(m.ctx.Args) {
+			return nil, false, fmt.Errorf("missing argument %d", index)
+		}
+		return nil, false, m.push(m.ctx.Args[index])
+
+	case OpArgCount:
+		return nil, false, m.push(IntValue(int64(len(m.ctx.Args))))
+
+	case OpAddress:
+		return nil, false, m.push(BytesValue([]byte(m.ctx.Address)))
+
+	case OpBalance:
+		if m.ctx.tree == nil {
+			return nil, false, errNoCallTree
+		}
+		return nil, false, m.push(IntValue(m.ctx.tree.balance(m.ctx.Address)))
+
+	case OpCallContract:
+		return nil, false, m.callContract(int(operand[0]))
+
+	case OpSchedule:
+		return nil, false, m.schedule(int(operand[0]))
+
+	case OpSHA256:
+		a, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		data := a.Encode()
+		if err := m.useGas(wordGas(len(data))); err != nil {
+			return nil, false, err
+		}
+		if err := m.allocate(sha256.Size); err != nil {
+			return nil, false, err
+		}
+		sum := sha256.Sum256(data)
+		return nil, false, m.push(BytesValue(sum[:]))
+
+	case OpConcat:
+		b, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		a, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		ab, bb := a.Encode(), b.Encode()
+		if len(ab)+len(bb) > MaxValueSize {
+			return nil, false, ErrValueTooLarge
+		}
+		if err := m.useGas(wordGas(len(ab) + len(bb))); err != nil {
+			return nil, false, err
+		}
+		if err := m.allocate(len(ab) + len(bb)); err != nil {
+			return nil, false, err
+		}
+		joined := make([]byte, 0, len(ab)+len(bb))
+		return nil, false, m.push(BytesValue(append(append(joined, ab...), bb...)))
+
+	case OpLen:
+		a, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		if !a.IsBytes {
+			return nil, false, ErrTypeMismatch
+		}
+		return nil, false, m.push(IntValue(int64(len(a.Bytes))))
+
+	case OpKeccak:
+		a, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		data := a.Encode()
+		if err := m.useGas(wordGas(len(data))); err != nil {
+			return nil, false, err
+		}
+		if err := m.allocate(sha256.Size); err != nil {
+			return nil, false, err
+		}
+		return nil, false, m.push(BytesValue(keccak256(data)))
+
+	case OpUnhex:
+		a, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		if !a.IsBytes {
+			return nil, false, ErrTypeMismatch
+		}
+		if err := m.useGas(wordGas(len(a.Bytes))); err != nil {
+			return nil, false, err
+		}
+		if err := m.allocate(len(a.Bytes) / 2); err != nil {
+			return nil, false, err
+		}
+		data, err := hex.DecodeString(string(a.Bytes))
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid hex: %v", err)
+		}
+		return nil, false, m.push(BytesValue(data))
+
+	case OpVerifySig:
+		operands, err := m.popBytes(3)
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, false, m.push(boolValue(verifySignature(operands[0], operands[1], operands[2])))
+
+	case OpVerifyMerkle:
+		operands, err := m.popBytes(3)
+		if err != nil {
+			return nil, false, err
+		}
+		steps, _ := merkleSteps(operands[2])
+		if err := m.useGas(uint64(steps) * gasPerMerkleStep); err != nil {
+			return nil, false, err
+		}
+		return nil, false, m.push(boolValue(verifyMerkleProof(operands[0], operands[1], operands[2])))
+
+	case OpReturn:
+		ret, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		return &ret, true, nil
+
+	case OpRevert:
+		reason, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, false, &RevertError{Reason: reason.String()}
+
+	case OpLog, OpLogIndexed:
+		n := int(operand[0])
+		var indexed byte
+		if op == OpLogIndexed {
+			indexed = operand[1]
+		}
+		if n >= len(m.stack) {
+			return nil, false, ErrStackUnderflow
+		}
+		if len(m.logs) >= MaxLogs {
+			return nil, false, errors.New("event limit exceeded")
+		}
+		args := append([]Value(nil), m.stack[len(m.stack)-n:]...)
+		name := m.stack[len(m.stack)-n-1]
+		m.stack = m.stack[:len(m.stack)-n-1]
+
+		size := len(name.Encode())
+		for _, arg := range args {
+			size += len(arg.Encode())
+		}
+		if err := m.useGas(wordGas(size)); err != nil {
+			return nil, false, err
+		}
+
+		topics := []string{EventTopic(name.String())}
+		for i, arg := range args {
+			if i < 8 && indexed&(1<<i) != 0 {
+				if err := m.useGas(gasPerTopic); err != nil {
+					return nil, false, err
+				}
+				topics = append(topics, ValueTopic(arg))
+			}
+		}
+		m.logs = append(m.logs, Log{Contract: m.ctx.Address, Event: name.String(), Args: args, Topics: topics})
+		return nil, false, nil
+	}
+
+	return nil, false, ErrInvalidOpcode
+}
+
+// callContract executes CALLC with n arguments: it calls another contract
+// with at most the gas the instruction's gas operand names, or all gas left,
+// and pushes the return value. The called contract's gas and events are
+// charged to and added to this call; if it fails, so does this call.
+func (m *vm) callContract(n int) error {
+	if m.ctx.tree == nil {
+		return errNoCallTree
+	}
+	if n+4 > len(m.stack) {
+		return ErrStackUnderflow
+	}
+	args := append([]Value(nil), m.stack[len(m.stack)-n:]...)
+	operands := m.stack[len(m.stack)-n-4 : len(m.stack)-n]
+	m.stack = m.stack[:len(m.stack)-n-4]
+
+	contract, method, value, gas := operands[0], operands[1], operands[2], operands[3]
+	if !contract.IsBytes || !method.IsBytes || value.IsBytes || gas.IsBytes {
+		return ErrTypeMismatch
+	}
+	if gas.Int < 0 {
+		return errors.New("negative call gas")
+	}
+	forward := m.gas
+	if gas.Int > 0 && uint64(gas.Int) < forward {
+		forward = uint64(gas.Int)
+	}
+
+	address := contract.String()
+	result, err := m.ctx.tree.nestedCall(m.ctx, m.writes, address, method.String(), args, value.Int, forward)
+	if gasErr := m.useGas(result.GasUsed); gasErr != nil {
+		return gasErr
+	}
+	if err != nil {
+		return callError(address, method.String(), err)
+	}
+	if len(m.logs)+len(result.Logs) > MaxLogs {
+		return errors.New("event limit exceeded")
+	}
+	m.logs = append(m.logs, result.Logs...)
+
+	if result.ReturnValue == nil {
+		return m.push(IntValue(0))
+	}
+	return m.push(*result.ReturnValue)
+}
+
+// schedule executes SCHEDULE with n arguments: it schedules calls of a
+// method of the running contract and pushes the deposit paid for their gas.
+func (m *vm) schedule(n int) error {
+	if m.ctx.tree == nil {
+		return errNoCallTree
+	}
+	if n+5 > len(m.stack) {
+		return ErrStackUnderflow
+	}
+	args := append([]Value(nil), m.stack[len(m.stack)-n:]...)
+	operands := m.stack[len(m.stack)-n-5 : len(m.stack)-n]
+	m.stack = m.stack[:len(m.stack)-n-5]
+
+	method, height, interval, runs, gas := operands[0], operands[1], operands[2], operands[3], operands[4]
+	if !method.IsBytes || height.IsBytes || interval.IsBytes || runs.IsBytes || gas.IsBytes {
+		return ErrTypeMismatch
+	}
+	if gas.Int < 0 {
+		return errors.New("negative schedule gas")
+	}
+
+	deposit, err := m.ctx.tree.schedule(m.ctx, &ScheduledCall{
+		Method:   method.String(),
+		Args:     args,
+		GasLimit: uint64(gas.Int),
+		Height:   int(height.Int),
+		Interval: int(interval.Int),
+		Runs:     int(runs.Int),
+	})
+	if err != nil {
+		return err
+	}
+	return m.push(IntValue(deposit))
+}
+
+// arithmetic applies an integer operation, failing instead of wrapping around.
+func arithmetic(op Opcode, a, b int64) (Value, error) {
+	switch op {
+	case OpAdd:
+		if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+			return Value{}, ErrIntegerOverflow
+		}
+		return IntValue(a + b), nil
+	case OpSub:
+		if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+			return Value{}, ErrIntegerOverflow
+		}
+		return IntValue(a - b), nil
+	case OpMul:
+		if a != 0 && b != 0 {
+			c := a * b
+			if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+				return Value{}, ErrIntegerOverflow
+			}
+		}
+		return IntValue(a * b), nil
+	case OpDiv, OpMod:
+		if b == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		if a == math.MinInt64 && b == -1 {
+			return Value{}, ErrIntegerOverflow
+		}
+		if op == OpDiv {
+			return IntValue(a / b), nil
+		}
+		return IntValue(a % b), nil
+	case OpLt:
+		return boolValue(a < b), nil
+	default:
+		return boolValue(a > b), nil
+	}
+}
+
+// load reads a storage key, seeing the writes of the current call.
+func (m *vm) load(key string) (Value, error) {
+	if value, ok := m.writes[key]; ok {
+		return value, nil
+	}
+	return valueFromState(m.state[key])
+}
+
+// allocate accounts for n bytes of byte strings created by the call,
+// failing once the call has created more than MaxMemory.
+func (m *vm) allocate(n int) error {
+	if m.memory += n; m.memory > MaxMemory {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+// useGas charges gas, failing once the call's gas is exhausted.
+func (m *vm) useGas(amount uint64) error {
+	if amount > m.gas {
+		m.gas = 0
+		return ErrOutOfGas
+	}
+	m.gas -= amount
+	return nil
+}
+
+func (m *vm) push(v Value) error {
+	if len(m.stack) >= MaxStackDepth {
+		return ErrStackOverflow
+	}
+	m.stack = append(m.stack, v)
+	return nil
+}
+
+func (m *vm) pop() (Value, error) {
+	if len(m.stack) == 0 {
+		return Value{}, ErrStackUnderflow
+	}
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v, nil
+}
+
+// popInts pops the operands of a binary integer instruction, b on top of a.
+func (m *vm) popInts() (int64, int64, error) {
+	b, err := m.pop()
+	if err != nil {
+		return 0, 0, err
+	}
+	a, err := m.pop()
+	if err != nil {
+		return 0, 0, err
+	}
+	if a.IsBytes || b.IsBytes {
+		return 0, 0, ErrTypeMismatch
+	}
+	return a.Int, b.Int, nil
+}
+
+// popBytes pops the n byte string operands of an instruction, returned in
+// the order they were pushed.
+func (m *vm) popBytes(n int) ([][]byte, error) {
+	if n > len(m.stack) {
+		return nil, ErrStackUnderflow
+	}
+	operands := make([][]byte, n)
+	for i, v := range m.stack[len(m.stack)-n:] {
+		if !v.IsBytes {
+			return nil, ErrTypeMismatch
+		}
+		operands[i] = v.Bytes
+	}
+	m.stack = m.stack[:len(m.stack)-n]
+	return operands, nil
+}
+
+// checkJump verifies that the program counter points at an instruction.
+func (m *vm) checkJump() error {
+	if !m.targets[m.pc] {
+		return ErrInvalidJump
+	}
+	return nil
+}
+
+// readOperand returns the immediate operand of the instruction at pc and the
+// offset of the next instruction.
+func readOperand(code []byte, pc int, info opcodeInfo) ([]byte, int, error) {
+	start := pc + 1
+	size := info.operandSize
+	if size < 0 {
+		if start+2 > len(code) {
+			return nil, 0, fmt.Errorf("truncated %s operand at %d", info.name, pc)
+		}
+		size = int(binary.BigEndian.Uint16(code[start:]))
+		start += 2
+	}
+	if start+size > len(code) {
+		return nil, 0, fmt.Errorf("truncated %s operand at %d", info.name, pc)
+	}
+	return code[start : start+size], start + size, nil
+}
+
+// instructionOffsets returns the set of offsets at which an instruction starts.
+func instructionOffsets(code []byte) (map[int]bool, error) {
+	offsets := make(map[int]bool)
+	for pc := 0; pc < len(code); {
+		info, ok := opcodes[Opcode(code[pc])]
+		if !ok {
+			return nil, fmt.Errorf("%w 0x%02x at %d", ErrInvalidOpcode, code[pc], pc)
+		}
+		offsets[pc] = true
+		_, next, err := readOperand(code, pc, info)
+		if err != nil {
+			return nil, err
+		}
+		pc = next
+	}
+	return offsets, nil
+}
+
+// VerifyBytecode checks that code is well formed: every instruction is
+// defined, no operand is truncated and every jump targets an instruction.
+func VerifyBytecode(code []byte) error {
+	if len(code) == 0 {
+		return errors.New("empty bytecode")
+	}
+	if len(code) > MaxCodeSize {
+		return fmt.Errorf("bytecode exceeds %d bytes", MaxCodeSize)
+	}
+
+	offsets, err := instructionOffsets(code)
+	if err != nil {
+		return err
+	}
+	for pc := range offsets {
+		op := Opcode(code[pc])
+		if op != OpJump && op != OpJumpIf && op != OpCall {
+			continue
+		}
+		target := int(binary.BigEndian.Uint32(code[pc+1:]))
+		if !offsets[target] {
+			return fmt.Errorf("%w %d at %d", ErrInvalidJump, target, pc)
+		}
+	}
+	return nil
+}
+
+// Run executes bytecode against a contract state.
+// Parameters:
+//   - code: Verified contract bytecode
+//   - state: The contract's committed state; it is only read
+//   - ctx: The call being executed
+//
+// Returns:
+//   - The execution result; GasUsed is set even when execution fails
+//   - The storage writes to apply to the state if execution succeeded
+//   - An error if execution failed, in which case the writes must be discarded
+func Run(code []byte, state map[string]interface{}, ctx *CallContext) (*ExecutionResult, map[string]Value, error) {
+	targets, err := instructionOffsets(code)
+	if err != nil {
+		return &ExecutionResult{}, nil, err
+	}
+
+	m := &vm{
+		code:    code,
+		targets: targets,
+		frames:  []*frame{{}},
+		gas:     ctx.GasLimit,
+		ctx:     ctx,
+		state:   state,
+		writes:  make(map[string]Value),
+	}
+
+	ret, err := m.run()
+	result := &ExecutionResult{ReturnValue: ret, GasUsed: ctx.GasLimit - m.gas}
+	if err != nil {
+		return result, nil, err
+	}
+	result.Logs = m.logs
+	return result, m.writes, nil
+}
@@ -0,0 +1,322 @@
+package contracts
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// assemble assembles source, failing the test if it does not assemble.
+func assemble(t *testing.T, source string) []byte {
+	t.Helper()
+	code, err := Assemble(source)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	return code
+}
+
+// runAsm assembles source and runs it against an empty state with the given gas.
+func runAsm(t *testing.T, source string, gas uint64) (*ExecutionResult, map[string]Value, error) {
+	t.Helper()
+	return Run(assemble(t, source), map[string]interface{}{}, &CallContext{GasLimit: gas})
+}
+
+// jumpCode returns PUSH 0 followed by a jump instruction to target, which
+// lands inside the PUSH operand for targets 1 to 8.
+func jumpCode(op Opcode, target uint32) []byte {
+	code := []byte{byte(OpPush), 0, 0, 0, 0, 0, 0, 0, 0}
+	code = append(code, byte(op))
+	code = binary.BigEndian.AppendUint32(code, target)
+	return append(code, byte(OpStop))
+}
+
+// conditionalJumpCode returns PUSH 1 followed by a taken JUMPI to target.
+func conditionalJumpCode(target uint32) []byte {
+	code := []byte{byte(OpPush), 0, 0, 0, 0, 0, 0, 0, 1, byte(OpJumpIf)}
+	code = binary.BigEndian.AppendUint32(code, target)
+	return append(code, byte(OpStop))
+}
+
+func TestVMGasAccounting(t *testing.T) {
+	sstoreGas := 5000 + wordGas(len(BytesValue([]byte("k")).Encode())+len(IntValue(5).Encode()))
+
+	tests := []struct {
+		name   string
+		source string
+		want   uint64
+	}{
+		{"arithmetic", "PUSH 1\nPUSH 2\nADD\nRETURN", 2 + 2 + 3},
+		{"byte string surcharge", "PUSHB \"abc\"\nLEN\nRETURN", 2 + gasPerWord + 2},
+		{"two words", "PUSHB \"" + strings.Repeat("x", 33) + "\"\nPOP\nSTOP", 2 + 2*gasPerWord + gasBase},
+		{"storage write", "PUSHB \"k\"\nPUSH 5\nSSTORE\nSTOP", 2 + gasPerWord + 2 + sstoreGas},
+		{"storage read", "PUSHB \"k\"\nSLOAD\nRETURN", 2 + gasPerWord + 200},
+		{"subroutine", "CALL sub\nSTOP\nsub:\nRET", 10 + 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _, err := runAsm(t, tt.source, DefaultGasLimit)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if result.GasUsed != tt.want {
+				t.Fatalf("GasUsed = %d, want %d", result.GasUsed, tt.want)
+			}
+		})
+	}
+}
+
+func TestVMOutOfGas(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		gas    uint64
+	}{
+		{"instruction", "PUSH 1\nPUSH 2\nADD\nRETURN", 6},
+		{"byte string surcharge", "PUSHB \"abc\"\nRETURN", 4},
+		{"storage write surcharge", "PUSHB \"k\"\nPUSH 5\nSSTORE\nSTOP", 2 + gasPerWord + 2 + 5000},
+		{"infinite loop", "loop:\nJUMP loop", 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, writes, err := runAsm(t, tt.source, tt.gas)
+			if !errors.Is(err, ErrOutOfGas) {
+				t.Fatalf("Run = %v, want %v", err, ErrOutOfGas)
+			}
+			if result.GasUsed != tt.gas {
+				t.Fatalf("GasUsed = %d, want all %d", result.GasUsed, tt.gas)
+			}
+			if writes != nil {
+				t.Fatalf("failed call returned writes %v", writes)
+			}
+		})
+	}
+}
+
+func TestVMArithmetic(t *testing.T) {
+	tests := []struct {
+		a, b int64
+		op   string
+		want int64
+		err  error
+	}{
+		{7, -2, "DIV", -3, nil},
+		{-7, 2, "MOD", -1, nil},
+		{math.MaxInt64, 0, "ADD", math.MaxInt64, nil},
+		{math.MinInt64, 1, "MUL", math.MinInt64, nil},
+		{math.MaxInt64, 1, "ADD", 0, ErrIntegerOverflow},
+		{math.MinInt64, -1, "ADD", 0, ErrIntegerOverflow},
+		{math.MinInt64, 1, "SUB", 0, ErrIntegerOverflow},
+		{math.MaxInt64, -1, "SUB", 0, ErrIntegerOverflow},
+		{math.MaxInt64, 2, "MUL", 0, ErrIntegerOverflow},
+		{math.MinInt64, -1, "MUL", 0, ErrIntegerOverflow},
+		{-1, math.MinInt64, "MUL", 0, ErrIntegerOverflow},
+		{math.MinInt64, -1, "DIV", 0, ErrIntegerOverflow},
+		{math.MinInt64, -1, "MOD", 0, ErrIntegerOverflow},
+		{1, 0, "DIV", 0, ErrDivisionByZero},
+		{1, 0, "MOD", 0, ErrDivisionByZero},
+	}
+	for _, tt := range tests {
+		source := strings.Join([]string{
+			"PUSH " + strconv.FormatInt(tt.a, 10), "PUSH " + strconv.FormatInt(tt.b, 10), tt.op, "RETURN",
+		}, "\n")
+		result, _, err := runAsm(t, source, DefaultGasLimit)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%d %s %d: error %v, want %v", tt.a, tt.op, tt.b, err, tt.err)
+			continue
+		}
+		if tt.err == nil && (result.ReturnValue == nil || result.ReturnValue.Int != tt.want) {
+			t.Errorf("%d %s %d = %v, want %d", tt.a, tt.op, tt.b, result.ReturnValue, tt.want)
+		}
+	}
+}
+
+func TestVMExecutionErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    error
+	}{
+		{"stack underflow", "ADD", ErrStackUnderflow},
+		{"type mismatch", "PUSHB \"a\"\nPUSH 1\nADD", ErrTypeMismatch},
+		{"bytes expected", "PUSH 1\nLEN", ErrTypeMismatch},
+		{"revert", "PUSHB \"no\"\nREVERT", &RevertError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := runAsm(t, tt.source, DefaultGasLimit)
+			var revert *RevertError
+			if _, ok := tt.err.(*RevertError); ok {
+				if !errors.As(err, &revert) {
+					t.Fatalf("Run = %v, want a revert", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Run = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	overflow := strings.Repeat("PUSH 1\n", MaxStackDepth+1)
+	if _, _, err := runAsm(t, overflow, DefaultGasLimit); !errors.Is(err, ErrStackOverflow) {
+		t.Fatalf("Run of %d pushes = %v, want %v", MaxStackDepth+1, err, ErrStackOverflow)
+	}
+
+	if _, _, err := Run([]byte{0xff}, nil, &CallContext{GasLimit: DefaultGasLimit}); !errors.Is(err, ErrInvalidOpcode) {
+		t.Fatalf("Run of an undefined opcode = %v, want %v", err, ErrInvalidOpcode)
+	}
+}
+
+func TestVMInvalidJumps(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+	}{
+		{"jump into an operand", jumpCode(OpJump, 3)},
+		{"conditional jump into an operand", conditionalJumpCode(3)},
+		{"call into an operand", jumpCode(OpCall, 8)},
+		{"jump past the end", jumpCode(OpJump, 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyBytecode(tt.code); !errors.Is(err, ErrInvalidJump) {
+				t.Fatalf("VerifyBytecode = %v, want %v", err, ErrInvalidJump)
+			}
+			if _, _, err := Run(tt.code, nil, &CallContext{GasLimit: DefaultGasLimit}); !errors.Is(err, ErrInvalidJump) {
+				t.Fatalf("Run = %v, want %v", err, ErrInvalidJump)
+			}
+		})
+	}
+
+	if err := VerifyBytecode(jumpCode(OpJump, 14)); err != nil {
+		t.Fatalf("VerifyBytecode of a jump to an instruction: %v", err)
+	}
+	if err := VerifyBytecode([]byte{byte(OpPushBytes), 0, 5, 'a'}); err == nil {
+		t.Fatal("VerifyBytecode accepted a truncated operand")
+	}
+}
+
+func TestVMRevertDiscardsWrites(t *testing.T) {
+	result, writes, err := runAsm(t, "PUSHB \"k\"\nPUSH 1\nSSTORE\nPUSHB \"denied\"\nREVERT", DefaultGasLimit)
+	var revert *RevertError
+	if !errors.As(err, &revert) || revert.Reason != "denied" {
+		t.Fatalf("Run = %v, want a revert with reason denied", err)
+	}
+	if writes != nil {
+		t.Fatalf("reverted call returned writes %v", writes)
+	}
+	if result.GasUsed == 0 {
+		t.Fatal("reverted call used no gas")
+	}
+}
+
+func TestCallTreeRevertRollsBackEveryContract(t *testing.T) {
+	callee := NewSmartContract("callee", hex.EncodeToString(assemble(t, "PUSHB \"b\"\nPUSH 2\nSSTORE\nARG 0\nJUMPI fail\nPUSH 7\nRETURN\nfail:\nPUSHB \"fail\"\nREVERT")))
+	caller := NewSmartContract("caller", hex.EncodeToString(assemble(t, `
+		PUSHB "a"
+		PUSH 1
+		SSTORE
+		PUSHB "callee"
+		PUSHB "run"
+		PUSH 0
+		PUSH 0
+		ARG 0
+		CALLC 1
+		RETURN
+	`)))
+	contracts := map[string]*SmartContract{"caller": caller, "callee": callee}
+	lookup := func(address string) (*SmartContract, bool) {
+		c, ok := contracts[address]
+		return c, ok
+	}
+
+	// The callee reverts: neither contract keeps its write
+	_, err := NewCallTree(lookup).Call("caller", &CallContext{Method: "run", Args: []Value{IntValue(1)}, GasLimit: DefaultGasLimit})
+	var revert *RevertError
+	if !errors.As(err, &revert) {
+		t.Fatalf("Call = %v, want a revert", err)
+	}
+	if len(caller.State) != 0 || len(callee.State) != 0 {
+		t.Fatalf("reverted tree changed state: caller %v, callee %v", caller.State, callee.State)
+	}
+
+	// The callee succeeds: both writes reach the working copies
+	tree := NewCallTree(lookup)
+	result, err := tree.Call("caller", &CallContext{Method: "run", Args: []Value{IntValue(0)}, GasLimit: DefaultGasLimit})
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if result.ReturnValue == nil || result.ReturnValue.Int != 7 {
+		t.Fatalf("return value = %v, want 7", result.ReturnValue)
+	}
+	for _, touched := range tree.Touched() {
+		if len(touched.State) != 1 {
+			t.Fatalf("contract %s state = %v, want one entry", touched.ID, touched.State)
+		}
+	}
+	if len(caller.State) != 0 || len(callee.State) != 0 {
+		t.Fatal("the call tree changed the contracts it was given")
+	}
+}
+
+func TestCallTreeSchedule(t *testing.T) {
+	code := hex.EncodeToString(assemble(t, `
+		PUSHB "tick"
+		ARG 0
+		PUSH 0
+		PUSH 1
+		PUSH 100
+		PUSHB "x"
+		SCHEDULE 1
+		RETURN
+	`))
+	tests := []struct {
+		name    string
+		height  int64
+		balance int64
+		fails   bool
+	}{
+		{"scheduled", 10, 1000, false},
+		{"first run not above the tip", 5, 1000, true},
+		{"deposit above the balance", 10, 99, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contract := NewSmartContract("self", code)
+			contract.Balance = tt.balance
+			lookup := func(address string) (*SmartContract, bool) {
+				return contract, address == "self"
+			}
+
+			tree := NewCallTree(lookup)
+			result, err := tree.Call("self", &CallContext{Method: "run", Args: []Value{IntValue(tt.height)}, GasLimit: DefaultGasLimit, BlockHeight: 5})
+			if tt.fails {
+				if err == nil {
+					t.Fatal("Call succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if result.ReturnValue == nil || result.ReturnValue.Int != 100 {
+				t.Fatalf("deposit = %v, want 100", result.ReturnValue)
+			}
+			scheduled := tree.Scheduled()
+			if len(scheduled) != 1 || scheduled[0].Contract != "self" || scheduled[0].Method != "tick" || scheduled[0].Height != 10 || len(scheduled[0].Args) != 1 {
+				t.Fatalf("Scheduled = %+v, want one run of self's tick at 10", scheduled)
+			}
+			if got := tree.Touched()[0].Balance; got != tt.balance-100 {
+				t.Fatalf("balance after scheduling = %d, want %d", got, tt.balance-100)
+			}
+			if contract.Balance != tt.balance {
+				t.Fatal("the call tree changed the contract it was given")
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Opcodes the interpreter refers to by name. Numeric instructions are
+// dispatched on their raw opcode values.
+const (
+	opUnreachable  = 0x00
+	opNop          = 0x01
+	opBlock        = 0x02
+	opLoop         = 0x03
+	opIf           = 0x04
+	opElse         = 0x05
+	opEnd          = 0x0b
+	opBr           = 0x0c
+	opBrIf         = 0x0d
+	opBrTable      = 0x0e
+	opReturn       = 0x0f
+	opCall         = 0x10
+	opCallIndirect = 0x11
+	opDrop         = 0x1a
+	opSelect       = 0x1b
+	opSelectT      = 0x1c
+	opLocalGet     = 0x20
+	opLocalSet     = 0x21
+	opLocalTee     = 0x22
+	opGlobalGet    = 0x23
+	opGlobalSet    = 0x24
+	opMemorySize   = 0x3f
+	opMemoryGrow   = 0x40
+	opI32Const     = 0x41
+	opI64Const     = 0x42
+	opPrefixFC     = 0xfc
+
+	// 0xFC-prefixed bulk memory instructions
+	subMemoryCopy = 10
+	subMemoryFill = 11
+)
+
+// Limits of the interpreter.
+const (
+	maxPages    = 65536 // Pages addressable with 32-bit pointers
+	maxLocals   = 4096  // Locals (including parameters) per function
+	maxBrTable  = 65536 // Targets per br_table
+	maxBodySize = 1 << 20
+)
+
+// instr is a decoded instruction.
+type instr struct {
+	op  byte
+	sub uint32 // Sub-opcode of 0xFC-prefixed instructions
+	imm uint64 // Constant, index, branch depth or memory offset
+
+	// Structured control: block type arities and the indices of the
+	// matching else (if any) and end instructions.
+	params, results int
+	blockType       int64
+	elseAt, endAt   int
+
+	targets []uint32 // br_table depths; the last one is the default
+}
+
+// decodeInstructions decodes a function body up to and including its final end.
+func decodeInstructions(r *reader) ([]instr, error) {
+	var code []instr
+	var blocks []int // Indices of the open block, loop and if instructions
+
+	for r.err == nil {
+		if len(code) > maxBodySize {
+			return nil, errors.New("function body too large")
+		}
+		in := instr{op: r.byte(), elseAt: -1}
+		at := len(code)
+
+		switch in.op {
+		case opBlock, opLoop, opIf:
+			in.blockType = r.blockType()
+			blocks = append(blocks, at)
+
+		case opElse:
+			if len(blocks) == 0 || code[blocks[len(blocks)-1]].op != opIf || code[blocks[len(blocks)-1]].elseAt >= 0 {
+				return nil, errors.New("else without if")
+			}
+			code[blocks[len(blocks)-1]].elseAt = at
+
+		case opEnd:
+			if len(blocks) == 0 {
+				return append(code, in), nil
+			}
+			code[blocks[len(blocks)-1]].endAt = at
+			blocks = blocks[:len(blocks)-1]
+
+		case opBr, opBrIf:
+			in.imm = uint64(r.u32())
+			if in.imm > uint64(len(blocks)) {
+				return nil, errors.New("branch depth out of range")
+			}
+
+		case opBrTable:
+			n := r.u32()
+			if n >= maxBrTable {
+				return nil, errors.New("br_table too large")
+			}
+			for i := uint32(0); i <= n && r.err == nil; i++ {
+				depth := r.u32()
+				if int(depth) > len(blocks) {
+					return nil, errors.New("branch depth out of range")
+				}
+				in.targets = append(in.targets, depth)
+			}
+
+		case opCall, opLocalGet, opLocalSet, opLocalTee, opGlobalGet, opGlobalSet:
+			in.imm = uint64(r.u32())
+
+		case opCallIndirect:
+			in.imm = uint64(r.u32())
+			if r.u32() != 0 {
+				return nil, errors.New("call_indirect on unknown table")
+			}
+
+		case opSelectT:
+			n := r.u32()
+			for i := uint32(0); i < n && r.err == nil; i++ {
+				if _, err := r.valueType(); err != nil {
+					return nil, err
+				}
+			}
+
+		case opMemorySize, opMemoryGrow:
+			if r.byte() != 0 {
+				return nil, errors.New("unknown memory")
+			}
+
+		case opI32Const:
+			in.imm = uint64(uint32(r.s32()))
+
+		case opI64Const:
+			in.imm = uint64(r.s64())
+
+		case opPrefixFC:
+			in.sub = r.u32()
+			switch in.sub {
+			case subMemoryCopy:
+				if r.byte() != 0 || r.byte() != 0 {
+					return nil, errors.New("unknown memory")
+				}
+			case subMemoryFill:
+				if r.byte() != 0 {
+					return nil, errors.New("unknown memory")
+				}
+			case 0, 1, 2, 3, 4, 5, 6, 7:
+				return nil, ErrFloatingPoint
+			default:
+				return nil, fmt.Errorf("unsupported instruction 0xfc %d", in.sub)
+			}
+
+		default:
+			switch {
+			case in.op >= 0x28 && in.op <= 0x3e: // Loads and stores
+				if isFloatMemoryOp(in.op) {
+					return nil, ErrFloatingPoint
+				}
+				r.u32() // Alignment hint
+				in.imm = uint64(r.u32())
+			case isFloatOp(in.op):
+				return nil, ErrFloatingPoint
+			case !isSimpleOp(in.op):
+				if r.err != nil {
+					return nil, r.err
+				}
+				return nil, fmt.Errorf("unsupported instruction 0x%02x", in.op)
+			}
+		}
+
+		code = append(code, in)
+	}
+	return nil, r.err
+}
+
+// isSimpleOp reports whether op is a supported instruction without immediates.
+func isSimpleOp(op byte) bool {
+	switch {
+	case op == opUnreachable, op == opNop, op == opReturn, op == opDrop, op == opSelect:
+		return true
+	case op >= 0x45 && op <= 0x5a: // Integer comparisons
+		return true
+	case op >= 0x67 && op <= 0x8a: // Integer arithmetic
+		return true
+	case op == 0xa7, op == 0xac, op == 0xad: // Integer conversions
+		return true
+	case op >= 0xc0 && op <= 0xc4: // Sign extension
+		return true
+	}
+	return false
+}
+
+// isFloatOp reports whether op is a floating point instruction.
+func isFloatOp(op byte) bool {
+	switch {
+	case op == 0x43, op == 0x44: // f32.const, f64.const
+		return true
+	case op >= 0x5b && op <= 0x66: // Float comparisons
+		return true
+	case op >= 0x8b && op <= 0xa6: // Float arithmetic
+		return true
+	case op >= 0xa8 && op <= 0xab, op >= 0xae && op <= 0xbf: // Float conversions
+		return true
+	}
+	return false
+}
+
+func isFloatMemoryOp(op byte) bool {
+	return op == 0x2a || op == 0x2b || op == 0x38 || op == 0x39
+}
+
+// blockType reads a block type: empty, a single value type or a type index.
+// Value types are returned as their negative one-byte encoding, -64 is empty.
+func (r *reader) blockType() int64 {
+	return r.leb33()
+}
+
+// leb33 reads the signed 33-bit integer used for block types.
+func (r *reader) leb33() int64 {
+	return int64(r.leb(35, true))
+}
+
+// validateFunction checks a function's immediates against the module and
+// resolves block types.
+func (m *Module) validateFunction(fn *function) error {
+	typ := m.Types[fn.typ]
+	numLocals := uint64(len(typ.Params) + len(fn.locals))
+
+	for i := range fn.body {
+		in := &fn.body[i]
+		switch in.op {
+		case opBlock, opLoop, opIf:
+			params, results, err := m.blockArity(in.blockType)
+			if err != nil {
+				return err
+			}
+			in.params, in.results = params, results
+
+		case opLocalGet, opLocalSet, opLocalTee:
+			if in.imm >= numLocals {
+				return fmt.Errorf("local index %d out of range", in.imm)
+			}
+
+		case opGlobalGet, opGlobalSet:
+			if in.imm >= uint64(len(m.Globals)) {
+				return fmt.Errorf("global index %d out of range", in.imm)
+			}
+			if in.op == opGlobalSet && !m.Globals[in.imm].Mutable {
+				return fmt.Errorf("global %d is immutable", in.imm)
+			}
+
+		case opCall:
+			if in.imm >= uint64(m.numFunctions()) {
+				return fmt.Errorf("function index %d out of range", in.imm)
+			}
+
+		case opCallIndirect:
+			if m.Table == nil {
+				return errors.New("call_indirect without a table")
+			}
+			if in.imm >= uint64(len(m.Types)) {
+				return fmt.Errorf("type index %d out of range", in.imm)
+			}
+
+		case opMemorySize, opMemoryGrow, opPrefixFC:
+			if m.Memory == nil {
+				return errors.New("memory instruction without a memory")
+			}
+
+		default:
+			if in.op >= 0x28 && in.op <= 0x3e && m.Memory == nil {
+				return errors.New("memory instruction without a memory")
+			}
+		}
+	}
+	return nil
+}
+
+// blockArity returns the number of parameters and results of a block type.
+func (m *Module) blockArity(bt int64) (int, int, error) {
+	switch {
+	case bt == -64: // 0x40: empty
+		return 0, 0, nil
+	case bt == -1 || bt == -2: // 0x7f, 0x7e: i32 or i64
+		return 0, 1, nil
+	case bt == -3 || bt == -4: // 0x7d, 0x7c: f32 or f64
+		return 0, 0, ErrFloatingPoint
+	case bt >= 0 && bt < int64(len(m.Types)):
+		t := m.Types[bt]
+		return len(t.Params), len(t.Results), nil
+	}
+	return 0, 0, fmt.Errorf("invalid block type %d", bt)
+}
@@ -0,0 +1,906 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// PageSize is the size of a WebAssembly memory page.
+const PageSize = 65536
+
+// Fuel costs beyond the one unit every instruction costs.
+const (
+	fuelCall     = 10   // Calling a function
+	fuelPerPage  = 1000 // Growing memory by one page, also charged for the initial memory
+	fuelPerBytes = 32   // Bulk memory instructions cost one unit per this many bytes
+)
+
+// deadlineInterval is the number of instructions executed between checks of
+// the deadline, so that the clock is not read on every instruction.
+const deadlineInterval = 1024
+
+var (
+	// ErrOutOfFuel is returned when execution exhausts its fuel.
+	ErrOutOfFuel = errors.New("out of fuel")
+
+	// ErrMemoryLimit is returned when a module needs more memory than allowed.
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+
+	// ErrCallDepth is returned when calls nest deeper than allowed.
+	ErrCallDepth = errors.New("call stack exhausted")
+
+	// ErrDeadline is returned when execution is still running at its deadline.
+	ErrDeadline = errors.New("deadline exceeded")
+)
+
+// Trap is a runtime error raised by the module, such as an out-of-bounds
+// memory access, a division by zero or unreachable.
+type Trap struct {
+	Reason string
+}
+
+func (t *Trap) Error() string {
+	return "wasm trap: " + t.Reason
+}
+
+func trap(format string, args ...interface{}) error {
+	return &Trap{Reason: fmt.Sprintf(format, args...)}
+}
+
+// HostFunction is a function provided by the host to the module.
+// It receives the instance, so it can access memory and charge fuel, and the
+// call arguments; it returns the results or an error that aborts execution.
+type HostFunction struct {
+	Type FuncType
+	Fn   func(inst *Instance, args []uint64) ([]uint64, error)
+}
+
+// HostModule is a set of host functions importable under one module name.
+type HostModule map[string]HostFunction
+
+// Config bounds the resources an instance may use.
+type Config struct {
+	Fuel           uint64 // Fuel available; every instruction costs at least one unit
+	MaxMemoryPages uint32 // Maximum size of linear memory in pages
+	MaxCallDepth   int    // Maximum nesting of function calls
+	MaxStackHeight int    // Maximum number of operands on a function's stack
+
+	// Deadline is the wall-clock time at which execution is aborted with
+	// ErrDeadline; zero for none. Unlike fuel, it does not stop execution
+	// at the same point on every run.
+	Deadline time.Time
+}
+
+// Instance is an instantiated module with its own memory and globals.
+type Instance struct {
+	module  *Module
+	config  Config
+	hosts   []HostFunction // Resolved imports, by function index
+	memory  []byte
+	maxMem  uint32 // Memory limit in pages
+	globals []uint64
+	table   []int64 // Function indices; -1 for uninitialized entries
+	fuel    uint64
+	depth   int
+	steps   uint64 // Instructions executed, for the deadline checks
+}
+
+// Instantiate links a module with host functions, sets up its memory, table
+// and globals and runs its start function.
+func Instantiate(m *Module, imports map[string]HostModule, config Config) (*Instance, error) {
+	inst := &Instance{module: m, config: config, fuel: config.Fuel}
+
+	for _, imp := range m.Imports {
+		host, ok := imports[imp.Module][imp.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown import %s.%s", imp.Module, imp.Name)
+		}
+		if !host.Type.Equal(m.Types[imp.Type]) {
+			return nil, fmt.Errorf("import %s.%s has the wrong signature", imp.Module, imp.Name)
+		}
+		inst.hosts = append(inst.hosts, host)
+	}
+
+	if m.Memory != nil {
+		inst.maxMem = config.MaxMemoryPages
+		if m.Memory.HasMax && m.Memory.Max < inst.maxMem {
+			inst.maxMem = m.Memory.Max
+		}
+		if m.Memory.Min > inst.maxMem {
+			return nil, ErrMemoryLimit
+		}
+		if err := inst.useFuel(uint64(m.Memory.Min) * fuelPerPage); err != nil {
+			return nil, err
+		}
+		inst.memory = make([]byte, int(m.Memory.Min)*PageSize)
+	}
+
+	for _, g := range m.Globals {
+		inst.globals = append(inst.globals, g.Init)
+	}
+
+	if m.Table != nil {
+		if m.Table.Min > maxBrTable {
+			return nil, errors.New("table too large")
+		}
+		inst.table = make([]int64, m.Table.Min)
+		for i := range inst.table {
+			inst.table[i] = -1
+		}
+		for _, e := range m.Elements {
+			if uint64(e.Offset)+uint64(len(e.Indices)) > uint64(len(inst.table)) {
+				return nil, trap("element segment out of bounds")
+			}
+			for i, idx := range e.Indices {
+				inst.table[int(e.Offset)+i] = int64(idx)
+			}
+		}
+	}
+
+	for _, d := range m.Data {
+		if uint64(d.Offset)+uint64(len(d.Bytes)) > uint64(len(inst.memory)) {
+			return nil, trap("data segment out of bounds")
+		}
+		copy(inst.memory[d.Offset:], d.Bytes)
+	}
+
+	if m.Start != nil {
+		if _, err := inst.protectedCall(*m.Start, nil); err != nil {
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+// Call invokes an exported function.
+// Returns the function's results, or an error if it traps, runs out of fuel
+// or a host function fails.
+func (inst *Instance) Call(name string, args ...uint64) ([]uint64, error) {
+	idx, ok := inst.module.Exports[name]
+	if !ok {
+		return nil, fmt.Errorf("no exported function %q", name)
+	}
+	if n := len(inst.module.funcType(idx).Params); n != len(args) {
+		return nil, fmt.Errorf("%s takes %d arguments", name, n)
+	}
+	return inst.protectedCall(idx, args)
+}
+
+// protectedCall invokes a function from outside the module. The interpreter
+// checks every access; a panic means a bug in it, which must not take the
+// node down with it.
+func (inst *Instance) protectedCall(idx uint32, args []uint64) (results []uint64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			results, err = nil, trap("interpreter fault: %v", r)
+		}
+	}()
+	return inst.call(idx, args)
+}
+
+// FuncType returns the signature of an exported function.
+func (inst *Instance) FuncType(name string) (FuncType, bool) {
+	idx, ok := inst.module.Exports[name]
+	if !ok {
+		return FuncType{}, false
+	}
+	return inst.module.funcType(idx), true
+}
+
+// FuelLeft returns the remaining fuel.
+func (inst *Instance) FuelLeft() uint64 {
+	return inst.fuel
+}
+
+// UseFuel charges fuel, typically for the work done by a host function.
+func (inst *Instance) UseFuel(amount uint64) error {
+	return inst.useFuel(amount)
+}
+
+func (inst *Instance) useFuel(amount uint64) error {
+	if amount > inst.fuel {
+		inst.fuel = 0
+		return ErrOutOfFuel
+	}
+	inst.fuel -= amount
+	return nil
+}
+
+// expired reports whether the deadline, if any, has passed.
+func (inst *Instance) expired() bool {
+	return !inst.config.Deadline.IsZero() && time.Now().After(inst.config.Deadline)
+}
+
+// Read copies n bytes of linear memory starting at ptr.
+func (inst *Instance) Read(ptr, n uint32) ([]byte, error) {
+	if uint64(ptr)+uint64(n) > uint64(len(inst.memory)) {
+		return nil, trap("memory read out of bounds")
+	}
+	data := make([]byte, n)
+	copy(data, inst.memory[ptr:])
+	return data, nil
+}
+
+// Write copies data into linear memory starting at ptr.
+func (inst *Instance) Write(ptr uint32, data []byte) error {
+	if uint64(ptr)+uint64(len(data)) > uint64(len(inst.memory)) {
+		return trap("memory write out of bounds")
+	}
+	copy(inst.memory[ptr:], data)
+	return nil
+}
+
+// MemorySize returns the current size of linear memory in bytes.
+func (inst *Instance) MemorySize() int {
+	return len(inst.memory)
+}
+
+// call invokes the function at index idx.
+func (inst *Instance) call(idx uint32, args []uint64) ([]uint64, error) {
+	if inst.depth >= inst.config.MaxCallDepth {
+		return nil, ErrCallDepth
+	}
+	if err := inst.useFuel(fuelCall); err != nil {
+		return nil, err
+	}
+
+	if int(idx) < len(inst.hosts) {
+		host := inst.hosts[idx]
+		results, err := host.Fn(inst, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) != len(host.Type.Results) {
+			return nil, fmt.Errorf("host function %s returned %d results", inst.module.Imports[idx].Name, len(results))
+		}
+		return results, nil
+	}
+
+	inst.depth++
+	defer func() { inst.depth-- }()
+
+	fn := &inst.module.functions[int(idx)-len(inst.hosts)]
+	typ := inst.module.Types[fn.typ]
+	locals := make([]uint64, len(typ.Params)+len(fn.locals))
+	copy(locals, args)
+	return inst.execute(fn, typ, locals)
+}
+
+// label is an entered block, loop or if.
+type label struct {
+	height int  // Stack height below the block's parameters
+	arity  int  // Number of values a branch to the label carries
+	cont   int  // Where a branch to the label continues
+	loop   bool // Whether branches re-enter the block
+	endAt  int  // Index of the block's end instruction
+}
+
+// execute runs a function body.
+func (inst *Instance) execute(fn *function, typ FuncType, locals []uint64) ([]uint64, error) {
+	code := fn.body
+	var stack []uint64
+	var labels []label
+
+	pop := func() (uint64, error) {
+		if len(stack) == 0 {
+			return 0, trap("operand stack underflow")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	push := func(v uint64) error {
+		if len(stack) >= inst.config.MaxStackHeight {
+			return trap("operand stack overflow")
+		}
+		stack = append(stack, v)
+		return nil
+	}
+	// branch unwinds to the label at depth; it reports whether the function returns.
+	branch := func(depth int) (int, bool, error) {
+		var target label
+		arity := len(typ.Results)
+		if depth < len(labels) {
+			target = labels[len(labels)-1-depth]
+			arity = target.arity
+		}
+		if len(stack) < target.height+arity {
+			return 0, false, trap("operand stack underflow")
+		}
+		values := stack[len(stack)-arity:]
+		stack = append(stack[:target.height], values...)
+
+		switch {
+		case depth >= len(labels):
+			return 0, true, nil
+		case target.loop:
+			labels = labels[:len(labels)-depth]
+		default:
+			labels = labels[:len(labels)-1-depth]
+		}
+		return target.cont, false, nil
+	}
+
+	for pc := 0; pc < len(code); {
+		if err := inst.useFuel(1); err != nil {
+			return nil, err
+		}
+		if inst.steps++; inst.steps%deadlineInterval == 0 && inst.expired() {
+			return nil, ErrDeadline
+		}
+		in := &code[pc]
+
+		switch in.op {
+		case opUnreachable:
+			return nil, trap("unreachable executed")
+
+		case opNop:
+
+		case opBlock, opLoop, opIf:
+			height := len(stack) - in.params
+			if in.op == opIf {
+				height--
+			}
+			if height < 0 {
+				return nil, trap("operand stack underflow")
+			}
+			l := label{height: height, arity: in.results, cont: in.endAt + 1, endAt: in.endAt}
+			if in.op == opLoop {
+				l.arity, l.cont, l.loop = in.params, pc+1, true
+			}
+
+			if in.op == opIf {
+				cond, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				if cond == 0 {
+					if in.elseAt < 0 {
+						pc = in.endAt + 1
+						continue
+					}
+					labels = append(labels, l)
+					pc = in.elseAt + 1
+					continue
+				}
+			}
+			labels = append(labels, l)
+
+		case opElse:
+			// End of the then branch: skip the else branch
+			if len(labels) == 0 {
+				return nil, trap("else without if")
+			}
+			pc = labels[len(labels)-1].endAt + 1
+			labels = labels[:len(labels)-1]
+			continue
+
+		case opEnd:
+			if len(labels) == 0 {
+				return results(stack, len(typ.Results))
+			}
+			labels = labels[:len(labels)-1]
+
+		case opBr, opBrIf, opBrTable, opReturn:
+			depth := len(labels)
+			switch in.op {
+			case opBr:
+				depth = int(in.imm)
+			case opBrIf:
+				cond, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				if uint32(cond) == 0 {
+					pc++
+					continue
+				}
+				depth = int(in.imm)
+			case opBrTable:
+				v, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				i := uint64(uint32(v))
+				if i >= uint64(len(in.targets)-1) {
+					i = uint64(len(in.targets) - 1)
+				}
+				depth = int(in.targets[i])
+			}
+
+			next, ret, err := branch(depth)
+			if err != nil {
+				return nil, err
+			}
+			if ret {
+				return results(stack, len(typ.Results))
+			}
+			pc = next
+			continue
+
+		case opCall, opCallIndirect:
+			callee := uint32(in.imm)
+			if in.op == opCallIndirect {
+				v, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				slot := uint64(uint32(v))
+				if slot >= uint64(len(inst.table)) || inst.table[slot] < 0 {
+					return nil, trap("undefined table element %d", slot)
+				}
+				callee = uint32(inst.table[slot])
+				if !inst.module.funcType(callee).Equal(inst.module.Types[in.imm]) {
+					return nil, trap("indirect call signature mismatch")
+				}
+			}
+
+			n := len(inst.module.funcType(callee).Params)
+			if len(stack) < n {
+				return nil, trap("operand stack underflow")
+			}
+			args := append([]uint64(nil), stack[len(stack)-n:]...)
+			stack = stack[:len(stack)-n]
+			out, err := inst.call(callee, args)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range out {
+				if err := push(v); err != nil {
+					return nil, err
+				}
+			}
+
+		case opDrop:
+			if _, err := pop(); err != nil {
+				return nil, err
+			}
+
+		case opSelect, opSelectT:
+			cond, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			b, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			a, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			if uint32(cond) == 0 {
+				a = b
+			}
+			stack = append(stack, a)
+
+		case opLocalGet:
+			if err := push(locals[in.imm]); err != nil {
+				return nil, err
+			}
+
+		case opLocalSet, opLocalTee:
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			locals[in.imm] = v
+			if in.op == opLocalTee {
+				stack = append(stack, v)
+			}
+
+		case opGlobalGet:
+			if err := push(inst.globals[in.imm]); err != nil {
+				return nil, err
+			}
+
+		case opGlobalSet:
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			inst.globals[in.imm] = v
+
+		case opMemorySize:
+			if err := push(uint64(len(inst.memory) / PageSize)); err != nil {
+				return nil, err
+			}
+
+		case opMemoryGrow:
+			delta, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, inst.growMemory(uint32(delta)))
+
+		case opI32Const, opI64Const:
+			if err := push(in.imm); err != nil {
+				return nil, err
+			}
+
+		case opPrefixFC:
+			if err := inst.bulkMemory(in.sub, pop); err != nil {
+				return nil, err
+			}
+
+		default:
+			var err error
+			if in.op >= 0x28 && in.op <= 0x3e {
+				err = inst.memoryAccess(in, pop, push)
+			} else {
+				err = numeric(in.op, pop, push)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		pc++
+	}
+	return nil, trap("function body ended without end")
+}
+
+// results returns the top n values of the stack as a function's results.
+func results(stack []uint64, n int) ([]uint64, error) {
+	if len(stack) < n {
+		return nil, trap("operand stack underflow")
+	}
+	return append([]uint64(nil), stack[len(stack)-n:]...), nil
+}
+
+// growMemory grows memory by delta pages. Returns the previous size in pages,
+// or -1 (as i32) if the memory cannot grow.
+func (inst *Instance) growMemory(delta uint32) uint64 {
+	old := uint32(len(inst.memory) / PageSize)
+	if uint64(old)+uint64(delta) > uint64(inst.maxMem) || inst.module.Memory == nil {
+		return uint64(math.MaxUint32)
+	}
+	if inst.useFuel(uint64(delta)*fuelPerPage) != nil {
+		return uint64(math.MaxUint32)
+	}
+	inst.memory = append(inst.memory, make([]byte, int(delta)*PageSize)...)
+	return uint64(old)
+}
+
+// bulkMemory executes memory.copy and memory.fill.
+func (inst *Instance) bulkMemory(sub uint32, pop func() (uint64, error)) error {
+	n, err := pop()
+	if err != nil {
+		return err
+	}
+	src, err := pop()
+	if err != nil {
+		return err
+	}
+	dst, err := pop()
+	if err != nil {
+		return err
+	}
+	size := uint64(uint32(n))
+	if err := inst.useFuel(size / fuelPerBytes); err != nil {
+		return err
+	}
+
+	end := uint64(uint32(dst)) + size
+	if end > uint64(len(inst.memory)) {
+		return trap("memory access out of bounds")
+	}
+	if sub == subMemoryFill {
+		region := inst.memory[uint32(dst):end]
+		for i := range region {
+			region[i] = byte(src)
+		}
+		return nil
+	}
+
+	if uint64(uint32(src))+size > uint64(len(inst.memory)) {
+		return trap("memory access out of bounds")
+	}
+	copy(inst.memory[uint32(dst):end], inst.memory[uint32(src):uint64(uint32(src))+size])
+	return nil
+}
+
+// memoryAccess executes a load or store instruction.
+func (inst *Instance) memoryAccess(in *instr, pop func() (uint64, error), push func(uint64) error) error {
+	var size uint64
+	store := in.op >= 0x36
+	switch in.op {
+	case 0x28, 0x34, 0x35, 0x36, 0x3e:
+		size = 4
+	case 0x29, 0x37:
+		size = 8
+	case 0x2c, 0x2d, 0x30, 0x31, 0x3a, 0x3c:
+		size = 1
+	default:
+		size = 2
+	}
+
+	var value uint64
+	if store {
+		v, err := pop()
+		if err != nil {
+			return err
+		}
+		value = v
+	}
+	base, err := pop()
+	if err != nil {
+		return err
+	}
+
+	addr := uint64(uint32(base)) + in.imm
+	if addr+size > uint64(len(inst.memory)) {
+		return trap("memory access out of bounds")
+	}
+	mem := inst.memory[addr : addr+size]
+
+	if store {
+		switch size {
+		case 1:
+			mem[0] = byte(value)
+		case 2:
+			binary.LittleEndian.PutUint16(mem, uint16(value))
+		case 4:
+			binary.LittleEndian.PutUint32(mem, uint32(value))
+		case 8:
+			binary.LittleEndian.PutUint64(mem, value)
+		}
+		return nil
+	}
+
+	switch in.op {
+	case 0x28:
+		value = uint64(binary.LittleEndian.Uint32(mem))
+	case 0x29:
+		value = binary.LittleEndian.Uint64(mem)
+	case 0x2c:
+		value = uint64(uint32(int32(int8(mem[0]))))
+	case 0x2d, 0x31:
+		value = uint64(mem[0])
+	case 0x2e:
+		value = uint64(uint32(int32(int16(binary.LittleEndian.Uint16(mem)))))
+	case 0x2f, 0x33:
+		value = uint64(binary.LittleEndian.Uint16(mem))
+	case 0x30:
+		value = uint64(int64(int8(mem[0])))
+	case 0x32:
+		value = uint64(int64(int16(binary.LittleEndian.Uint16(mem))))
+	case 0x34:
+		value = uint64(int64(int32(binary.LittleEndian.Uint32(mem))))
+	case 0x35:
+		value = uint64(binary.LittleEndian.Uint32(mem))
+	}
+	return push(value)
+}
+
+// numeric executes an integer instruction.
+func numeric(op byte, pop func() (uint64, error), push func(uint64) error) error {
+	// Unary instructions
+	switch op {
+	case 0x45, 0x50, 0x67, 0x68, 0x69, 0x79, 0x7a, 0x7b, 0xa7, 0xac, 0xad, 0xc0, 0xc1, 0xc2, 0xc3, 0xc4:
+		a, err := pop()
+		if err != nil {
+			return err
+		}
+		var r uint64
+		switch op {
+		case 0x45:
+			r = b2i(uint32(a) == 0)
+		case 0x50:
+			r = b2i(a == 0)
+		case 0x67:
+			r = uint64(bits.LeadingZeros32(uint32(a)))
+		case 0x68:
+			r = uint64(bits.TrailingZeros32(uint32(a)))
+		case 0x69:
+			r = uint64(bits.OnesCount32(uint32(a)))
+		case 0x79:
+			r = uint64(bits.LeadingZeros64(a))
+		case 0x7a:
+			r = uint64(bits.TrailingZeros64(a))
+		case 0x7b:
+			r = uint64(bits.OnesCount64(a))
+		case 0xa7:
+			r = uint64(uint32(a))
+		case 0xac:
+			r = uint64(int64(int32(a)))
+		case 0xad:
+			r = uint64(uint32(a))
+		case 0xc0:
+			r = uint64(uint32(int32(int8(a))))
+		case 0xc1:
+			r = uint64(uint32(int32(int16(a))))
+		case 0xc2:
+			r = uint64(int64(int8(a)))
+		case 0xc3:
+			r = uint64(int64(int16(a)))
+		case 0xc4:
+			r = uint64(int64(int32(a)))
+		}
+		return push(r)
+	}
+
+	b, err := pop()
+	if err != nil {
+		return err
+	}
+	a, err := pop()
+	if err != nil {
+		return err
+	}
+
+	var r uint64
+	if op <= 0x4f || (op >= 0x6a && op <= 0x78) {
+		r, err = binary32(op, uint32(a), uint32(b))
+	} else {
+		r, err = binary64(op, a, b)
+	}
+	if err != nil {
+		return err
+	}
+	return push(r)
+}
+
+// binary32 executes a binary i32 instruction.
+func binary32(op byte, a, b uint32) (uint64, error) {
+	var r uint32
+	switch op {
+	case 0x46:
+		return b2i(a == b), nil
+	case 0x47:
+		return b2i(a != b), nil
+	case 0x48:
+		return b2i(int32(a) < int32(b)), nil
+	case 0x49:
+		return b2i(a < b), nil
+	case 0x4a:
+		return b2i(int32(a) > int32(b)), nil
+	case 0x4b:
+		return b2i(a > b), nil
+	case 0x4c:
+		return b2i(int32(a) <= int32(b)), nil
+	case 0x4d:
+		return b2i(a <= b), nil
+	case 0x4e:
+		return b2i(int32(a) >= int32(b)), nil
+	case 0x4f:
+		return b2i(a >= b), nil
+	case 0x6a:
+		r = a + b
+	case 0x6b:
+		r = a - b
+	case 0x6c:
+		r = a * b
+	case 0x6d:
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			return 0, trap("integer overflow")
+		}
+		r = uint32(int32(a) / int32(b))
+	case 0x6e:
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		r = a / b
+	case 0x6f:
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		if int32(b) == -1 {
+			r = 0
+		} else {
+			r = uint32(int32(a) % int32(b))
+		}
+	case 0x70:
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		r = a % b
+	case 0x71:
+		r = a & b
+	case 0x72:
+		r = a | b
+	case 0x73:
+		r = a ^ b
+	case 0x74:
+		r = a << (b & 31)
+	case 0x75:
+		r = uint32(int32(a) >> (b & 31))
+	case 0x76:
+		r = a >> (b & 31)
+	case 0x77:
+		r = bits.RotateLeft32(a, int(b&31))
+	case 0x78:
+		r = bits.RotateLeft32(a, -int(b&31))
+	default:
+		return 0, trap("unsupported instruction 0x%02x", op)
+	}
+	return uint64(r), nil
+}
+
+// binary64 executes a binary i64 instruction.
+func binary64(op byte, a, b uint64) (uint64, error) {
+	switch op {
+	case 0x51:
+		return b2i(a == b), nil
+	case 0x52:
+		return b2i(a != b), nil
+	case 0x53:
+		return b2i(int64(a) < int64(b)), nil
+	case 0x54:
+		return b2i(a < b), nil
+	case 0x55:
+		return b2i(int64(a) > int64(b)), nil
+	case 0x56:
+		return b2i(a > b), nil
+	case 0x57:
+		return b2i(int64(a) <= int64(b)), nil
+	case 0x58:
+		return b2i(a <= b), nil
+	case 0x59:
+		return b2i(int64(a) >= int64(b)), nil
+	case 0x5a:
+		return b2i(a >= b), nil
+	case 0x7c:
+		return a + b, nil
+	case 0x7d:
+		return a - b, nil
+	case 0x7e:
+		return a * b, nil
+	case 0x7f:
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			return 0, trap("integer overflow")
+		}
+		return uint64(int64(a) / int64(b)), nil
+	case 0x80:
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		return a / b, nil
+	case 0x81:
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		if int64(b) == -1 {
+			return 0, nil
+		}
+		return uint64(int64(a) % int64(b)), nil
+	case 0x82:
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		return a % b, nil
+	case 0x83:
+		return a & b, nil
+	case 0x84:
+		return a | b, nil
+	case 0x85:
+		return a ^ b, nil
+	case 0x86:
+		return a << (b & 63), nil
+	case 0x87:
+		return uint64(int64(a) >> (b & 63)), nil
+	case 0x88:
+		return a >> (b & 63), nil
+	case 0x89:
+		return bits.RotateLeft64(a, int(b&63)), nil
+	case 0x8a:
+		return bits.RotateLeft64(a, -int(b&63)), nil
+	}
+	return 0, trap("unsupported instruction 0x%02x", op)
+}
+
+func b2i(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
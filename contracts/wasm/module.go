@@ -0,0 +1,621 @@
+// Package wasm implements a sandboxed WebAssembly interpreter for contracts.
+// It supports the integer subset of WebAssembly 1.0 plus the sign-extension
+// and bulk memory copy/fill instructions. Floating point types and
+// instructions are rejected when a module is decoded, so that every node
+// computes bit-identical results. Execution is metered with fuel and memory
+// is capped; a module can only reach the outside world through the host
+// functions it imports.
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ValueType is a WebAssembly value type.
+type ValueType byte
+
+// Supported value types.
+const (
+	I32 ValueType = 0x7f
+	I64 ValueType = 0x7e
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	}
+	return fmt.Sprintf("type(0x%02x)", byte(t))
+}
+
+// FuncType is a function signature.
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+// Equal reports whether two signatures are identical.
+func (t FuncType) Equal(o FuncType) bool {
+	return bytes.Equal(valueTypeBytes(t.Params), valueTypeBytes(o.Params)) &&
+		bytes.Equal(valueTypeBytes(t.Results), valueTypeBytes(o.Results))
+}
+
+func valueTypeBytes(types []ValueType) []byte {
+	b := make([]byte, len(types))
+	for i, t := range types {
+		b[i] = byte(t)
+	}
+	return b
+}
+
+// Limits bound the size of a memory or table.
+type Limits struct {
+	Min    uint32
+	Max    uint32
+	HasMax bool
+}
+
+// Import is a function imported from the host.
+type Import struct {
+	Module string
+	Name   string
+	Type   uint32 // Index into Module.Types
+}
+
+// Global is a module-defined global variable.
+type Global struct {
+	Type    ValueType
+	Mutable bool
+	Init    uint64 // Initial value (constant expressions are evaluated at decode time)
+}
+
+// Element initializes a range of the function table.
+type Element struct {
+	Offset  uint32
+	Indices []uint32
+}
+
+// Data initializes a range of linear memory.
+type Data struct {
+	Offset uint32
+	Bytes  []byte
+}
+
+// function is a module-defined function.
+type function struct {
+	typ    uint32
+	locals []ValueType // Declared locals, after the parameters
+	body   []instr
+}
+
+// Module is a decoded and validated WebAssembly module.
+type Module struct {
+	Types     []FuncType
+	Imports   []Import
+	Exports   map[string]uint32 // Exported functions: name -> function index
+	Memory    *Limits           // Linear memory, if the module declares one
+	Table     *Limits           // Function table, if the module declares one
+	Globals   []Global
+	Elements  []Element
+	Data      []Data
+	Start     *uint32
+	functions []function
+}
+
+// Errors returned while decoding modules.
+var (
+	// ErrInvalidModule is returned for malformed or unsupported modules.
+	ErrInvalidModule = errors.New("invalid wasm module")
+
+	// ErrFloatingPoint is returned for modules using floating point types or
+	// instructions, which are not deterministic across platforms.
+	ErrFloatingPoint = errors.New("floating point is not supported")
+)
+
+// Decode parses and validates a binary WebAssembly module.
+func Decode(bin []byte) (*Module, error) {
+	r := &reader{data: bin}
+	if !bytes.HasPrefix(bin, []byte("\x00asm\x01\x00\x00\x00")) {
+		return nil, fmt.Errorf("%w: bad magic or version", ErrInvalidModule)
+	}
+	r.pos = 8
+
+	m := &Module{Exports: make(map[string]uint32)}
+	var funcTypes []uint32
+	lastID := byte(0)
+	for r.pos < len(r.data) {
+		id := r.byte()
+		size := r.u32()
+		if r.err != nil {
+			break
+		}
+		end := r.pos + int(size)
+		if end > len(r.data) || end < r.pos {
+			return nil, fmt.Errorf("%w: section %d overruns module", ErrInvalidModule, id)
+		}
+		if id != 0 && id != 12 {
+			if id <= lastID {
+				return nil, fmt.Errorf("%w: section %d out of order", ErrInvalidModule, id)
+			}
+			lastID = id
+		}
+
+		section := &reader{data: r.data[:end], pos: r.pos}
+		var err error
+		switch id {
+		case 0, 12: // Custom sections and the data count carry nothing we need
+		case 1:
+			err = m.decodeTypes(section)
+		case 2:
+			err = m.decodeImports(section)
+		case 3:
+			funcTypes, err = decodeFunctionSection(section)
+		case 4:
+			err = m.decodeTables(section)
+		case 5:
+			err = m.decodeMemories(section)
+		case 6:
+			err = m.decodeGlobals(section)
+		case 7:
+			err = m.decodeExports(section)
+		case 8:
+			start := section.u32()
+			m.Start = &start
+		case 9:
+			err = m.decodeElements(section)
+		case 10:
+			err = m.decodeCode(section, funcTypes)
+		case 11:
+			err = m.decodeData(section)
+		default:
+			err = fmt.Errorf("unknown section %d", id)
+		}
+		if err == nil {
+			err = section.err
+		}
+		if err == nil && id != 0 && section.pos != end {
+			err = fmt.Errorf("section %d has trailing bytes", id)
+		}
+		if err != nil {
+			if errors.Is(err, ErrFloatingPoint) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidModule, err)
+		}
+		r.pos = end
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidModule, r.err)
+	}
+	if len(funcTypes) != len(m.functions) {
+		return nil, fmt.Errorf("%w: function and code sections disagree", ErrInvalidModule)
+	}
+
+	if err := m.validate(); err != nil {
+		if errors.Is(err, ErrFloatingPoint) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidModule, err)
+	}
+	return m, nil
+}
+
+// numFunctions returns the size of the function index space.
+func (m *Module) numFunctions() int {
+	return len(m.Imports) + len(m.functions)
+}
+
+// funcType returns the signature of the function at index idx.
+func (m *Module) funcType(idx uint32) FuncType {
+	if int(idx) < len(m.Imports) {
+		return m.Types[m.Imports[idx].Type]
+	}
+	return m.Types[m.functions[int(idx)-len(m.Imports)].typ]
+}
+
+// validate checks the cross-references between sections.
+func (m *Module) validate() error {
+	checkFunc := func(idx uint32) error {
+		if int(idx) >= m.numFunctions() {
+			return fmt.Errorf("function index %d out of range", idx)
+		}
+		return nil
+	}
+
+	for name, idx := range m.Exports {
+		if err := checkFunc(idx); err != nil {
+			return fmt.Errorf("export %q: %v", name, err)
+		}
+	}
+	if m.Start != nil {
+		if err := checkFunc(*m.Start); err != nil {
+			return fmt.Errorf("start: %v", err)
+		}
+		if t := m.funcType(*m.Start); len(t.Params) != 0 || len(t.Results) != 0 {
+			return errors.New("start function must take and return nothing")
+		}
+	}
+	for _, e := range m.Elements {
+		if m.Table == nil {
+			return errors.New("element segment without a table")
+		}
+		for _, idx := range e.Indices {
+			if err := checkFunc(idx); err != nil {
+				return fmt.Errorf("element: %v", err)
+			}
+		}
+	}
+	if len(m.Data) > 0 && m.Memory == nil {
+		return errors.New("data segment without a memory")
+	}
+
+	for i := range m.functions {
+		if err := m.validateFunction(&m.functions[i]); err != nil {
+			return fmt.Errorf("function %d: %w", len(m.Imports)+i, err)
+		}
+	}
+	return nil
+}
+
+func (m *Module) decodeTypes(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		if r.byte() != 0x60 {
+			return errors.New("expected function type")
+		}
+		params, err := r.valueTypes()
+		if err != nil {
+			return err
+		}
+		results, err := r.valueTypes()
+		if err != nil {
+			return err
+		}
+		m.Types = append(m.Types, FuncType{Params: params, Results: results})
+	}
+	return nil
+}
+
+func (m *Module) decodeImports(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		imp := Import{Module: r.name(), Name: r.name()}
+		if kind := r.byte(); kind != 0x00 {
+			return fmt.Errorf("import %s.%s: only function imports are supported", imp.Module, imp.Name)
+		}
+		imp.Type = r.u32()
+		if int(imp.Type) >= len(m.Types) {
+			return fmt.Errorf("import %s.%s: type index out of range", imp.Module, imp.Name)
+		}
+		m.Imports = append(m.Imports, imp)
+	}
+	return nil
+}
+
+func decodeFunctionSection(r *reader) ([]uint32, error) {
+	n := r.u32()
+	var types []uint32
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		types = append(types, r.u32())
+	}
+	return types, nil
+}
+
+func (m *Module) decodeTables(r *reader) error {
+	if n := r.u32(); n > 1 {
+		return errors.New("at most one table is supported")
+	} else if n == 0 {
+		return nil
+	}
+	if r.byte() != 0x70 {
+		return errors.New("only funcref tables are supported")
+	}
+	limits := r.limits()
+	m.Table = &limits
+	return nil
+}
+
+func (m *Module) decodeMemories(r *reader) error {
+	if n := r.u32(); n > 1 {
+		return errors.New("at most one memory is supported")
+	} else if n == 0 {
+		return nil
+	}
+	limits := r.limits()
+	if limits.Min > maxPages || (limits.HasMax && limits.Max < limits.Min) {
+		return errors.New("invalid memory limits")
+	}
+	m.Memory = &limits
+	return nil
+}
+
+func (m *Module) decodeGlobals(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		typ, err := r.valueType()
+		if err != nil {
+			return err
+		}
+		g := Global{Type: typ, Mutable: r.byte() == 1}
+		if g.Init, err = m.constExpr(r, typ); err != nil {
+			return err
+		}
+		m.Globals = append(m.Globals, g)
+	}
+	return nil
+}
+
+func (m *Module) decodeExports(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		name := r.name()
+		kind := r.byte()
+		idx := r.u32()
+		if kind != 0x00 {
+			continue // Only functions can be called; memories, tables and globals stay private
+		}
+		if _, ok := m.Exports[name]; ok {
+			return fmt.Errorf("duplicate export %q", name)
+		}
+		m.Exports[name] = idx
+	}
+	return nil
+}
+
+func (m *Module) decodeElements(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		if flags := r.u32(); flags != 0 {
+			return fmt.Errorf("element segment kind %d is not supported", flags)
+		}
+		offset, err := m.constExpr(r, I32)
+		if err != nil {
+			return err
+		}
+		e := Element{Offset: uint32(offset)}
+		count := r.u32()
+		for j := uint32(0); j < count && r.err == nil; j++ {
+			e.Indices = append(e.Indices, r.u32())
+		}
+		m.Elements = append(m.Elements, e)
+	}
+	return nil
+}
+
+func (m *Module) decodeData(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		switch flags := r.u32(); flags {
+		case 0:
+		case 2:
+			if r.u32() != 0 {
+				return errors.New("data segment for unknown memory")
+			}
+		default:
+			return fmt.Errorf("data segment kind %d is not supported", flags)
+		}
+		offset, err := m.constExpr(r, I32)
+		if err != nil {
+			return err
+		}
+		size := r.u32()
+		m.Data = append(m.Data, Data{Offset: uint32(offset), Bytes: r.bytes(int(size))})
+	}
+	return nil
+}
+
+func (m *Module) decodeCode(r *reader, funcTypes []uint32) error {
+	n := r.u32()
+	if int(n) != len(funcTypes) {
+		return errors.New("function and code sections disagree")
+	}
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		if int(funcTypes[i]) >= len(m.Types) {
+			return fmt.Errorf("function %d: type index out of range", i)
+		}
+		size := r.u32()
+		end := r.pos + int(size)
+		if end > len(r.data) || end < r.pos {
+			return fmt.Errorf("function %d overruns code section", i)
+		}
+		body := &reader{data: r.data[:end], pos: r.pos}
+
+		fn := function{typ: funcTypes[i]}
+		groups := body.u32()
+		total := 0
+		for g := uint32(0); g < groups && body.err == nil; g++ {
+			count := body.u32()
+			typ, err := body.valueType()
+			if err != nil {
+				return err
+			}
+			total += int(count)
+			if total > maxLocals {
+				return fmt.Errorf("function %d declares too many locals", i)
+			}
+			for j := uint32(0); j < count; j++ {
+				fn.locals = append(fn.locals, typ)
+			}
+		}
+
+		code, err := decodeInstructions(body)
+		if err != nil {
+			return fmt.Errorf("function %d: %w", i, err)
+		}
+		if body.err != nil {
+			return body.err
+		}
+		if body.pos != end {
+			return fmt.Errorf("function %d has trailing bytes", i)
+		}
+		fn.body = code
+		m.functions = append(m.functions, fn)
+		r.pos = end
+	}
+	return nil
+}
+
+// constExpr evaluates a constant initializer expression.
+func (m *Module) constExpr(r *reader, typ ValueType) (uint64, error) {
+	var v uint64
+	switch op := r.byte(); op {
+	case opI32Const:
+		if typ != I32 {
+			return 0, errors.New("constant expression type mismatch")
+		}
+		v = uint64(uint32(r.s32()))
+	case opI64Const:
+		if typ != I64 {
+			return 0, errors.New("constant expression type mismatch")
+		}
+		v = uint64(r.s64())
+	case opGlobalGet:
+		idx := r.u32()
+		if int(idx) >= len(m.Globals) || m.Globals[idx].Type != typ {
+			return 0, errors.New("invalid global in constant expression")
+		}
+		v = m.Globals[idx].Init
+	case 0x43, 0x44:
+		return 0, ErrFloatingPoint
+	default:
+		return 0, fmt.Errorf("unsupported constant expression 0x%02x", op)
+	}
+	if r.byte() != opEnd {
+		return 0, errors.New("constant expression not terminated")
+	}
+	return v, nil
+}
+
+// reader decodes the primitive encodings of the binary format. The first
+// error sticks; subsequent reads return zero values.
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos >= len(r.data) {
+		r.fail(errors.New("unexpected end of data"))
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.fail(errors.New("unexpected end of data"))
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// leb reads an LEB128 number of at most the given bit width.
+func (r *reader) leb(bits uint, signed bool) uint64 {
+	var result uint64
+	var shift uint
+	for {
+		b := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		if shift >= bits {
+			r.fail(errors.New("integer representation too long"))
+			return 0
+		}
+		result |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if signed && shift < 64 && b&0x40 != 0 {
+				result |= ^uint64(0) << shift
+			}
+			return result
+		}
+	}
+}
+
+func (r *reader) u32() uint32 {
+	v := r.leb(35, false)
+	if v > 0xffffffff {
+		r.fail(errors.New("integer too large"))
+	}
+	return uint32(v)
+}
+
+func (r *reader) s32() int32 {
+	return int32(r.leb(35, true))
+}
+
+func (r *reader) s64() int64 {
+	return int64(r.leb(70, true))
+}
+
+func (r *reader) name() string {
+	n := r.u32()
+	return string(r.bytes(int(n)))
+}
+
+func (r *reader) valueType() (ValueType, error) {
+	switch t := ValueType(r.byte()); t {
+	case I32, I64:
+		return t, nil
+	case 0x7d, 0x7c:
+		return 0, ErrFloatingPoint
+	default:
+		if r.err != nil {
+			return 0, r.err
+		}
+		return 0, fmt.Errorf("unsupported value type 0x%02x", byte(t))
+	}
+}
+
+func (r *reader) valueTypes() ([]ValueType, error) {
+	n := r.u32()
+	if n > maxLocals {
+		return nil, errors.New("too many values")
+	}
+	var types []ValueType
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		t, err := r.valueType()
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func (r *reader) limits() Limits {
+	var l Limits
+	switch r.byte() {
+	case 0x00:
+		l.Min = r.u32()
+	case 0x01:
+		l.Min = r.u32()
+		l.Max = r.u32()
+		l.HasMax = true
+	default:
+		r.fail(errors.New("invalid limits"))
+	}
+	return l
+}
@@ -0,0 +1,349 @@
+package wasm
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// testConfig bounds the instances created by the tests.
+var testConfig = Config{Fuel: 1000000, MaxMemoryPages: 4, MaxCallDepth: 64, MaxStackHeight: 1024}
+
+// testFunc is a function of a module assembled by buildModule.
+type testFunc struct {
+	params, results []ValueType
+	locals          []ValueType
+	body            []byte // Instructions, without the final end
+	export          string // Export name; empty to keep the function private
+}
+
+// uleb encodes v as an unsigned LEB128 number.
+func uleb(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// sleb encodes v as a signed LEB128 number.
+func sleb(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// vector encodes a count followed by the given items.
+func vector(items ...[]byte) []byte {
+	out := uleb(uint64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+// section encodes a section with its id and size.
+func section(id byte, contents []byte) []byte {
+	return append(append([]byte{id}, uleb(uint64(len(contents)))...), contents...)
+}
+
+// valueTypes encodes a vector of value types.
+func valueTypes(types []ValueType) []byte {
+	out := uleb(uint64(len(types)))
+	for _, t := range types {
+		out = append(out, byte(t))
+	}
+	return out
+}
+
+// i32Const encodes an i32.const instruction.
+func i32Const(v int32) []byte {
+	return append([]byte{opI32Const}, sleb(int64(v))...)
+}
+
+// buildModule assembles a binary module with one type per function and,
+// if memory is not nil, a memory with those limits.
+func buildModule(memory *Limits, funcs ...testFunc) []byte {
+	bin := []byte("\x00asm\x01\x00\x00\x00")
+
+	var types, indices, exports, bodies [][]byte
+	for i, f := range funcs {
+		types = append(types, append(append([]byte{0x60}, valueTypes(f.params)...), valueTypes(f.results)...))
+		indices = append(indices, uleb(uint64(i)))
+		if f.export != "" {
+			exports = append(exports, append(append(uleb(uint64(len(f.export))), f.export...), append([]byte{0x00}, uleb(uint64(i))...)...))
+		}
+
+		var locals [][]byte
+		for _, t := range f.locals {
+			locals = append(locals, []byte{1, byte(t)})
+		}
+		body := append(vector(locals...), f.body...)
+		body = append(body, opEnd)
+		bodies = append(bodies, append(uleb(uint64(len(body))), body...))
+	}
+
+	bin = append(bin, section(1, vector(types...))...)
+	bin = append(bin, section(3, vector(indices...))...)
+	if memory != nil {
+		limits := append([]byte{0x00}, uleb(uint64(memory.Min))...)
+		if memory.HasMax {
+			limits = append(append([]byte{0x01}, uleb(uint64(memory.Min))...), uleb(uint64(memory.Max))...)
+		}
+		bin = append(bin, section(5, vector(limits))...)
+	}
+	bin = append(bin, section(7, vector(exports...))...)
+	return append(bin, section(10, vector(bodies...))...)
+}
+
+// instantiate decodes and instantiates bin, failing the test on errors.
+func instantiate(t *testing.T, bin []byte, config Config) *Instance {
+	t.Helper()
+	m, err := Decode(bin)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	inst, err := Instantiate(m, nil, config)
+	if err != nil {
+		t.Fatalf("Instantiate: %v", err)
+	}
+	return inst
+}
+
+// sumBody returns the body of a function summing the integers from its
+// i32 parameter down to 1 in a loop, using one i32 local as accumulator.
+func sumBody() []byte {
+	var b []byte
+	b = append(b, opBlock, 0x40, opLoop, 0x40)
+	b = append(b, opLocalGet, 0, 0x45, opBrIf, 1) // Leave the block when n == 0
+	b = append(b, opLocalGet, 1, opLocalGet, 0, 0x6a, opLocalSet, 1)
+	b = append(b, opLocalGet, 0)
+	b = append(b, i32Const(1)...)
+	b = append(b, 0x6b, opLocalSet, 0, opBr, 0, opEnd, opEnd)
+	return append(b, opLocalGet, 1)
+}
+
+func TestDecode(t *testing.T) {
+	valid := buildModule(&Limits{Min: 1}, testFunc{
+		params:  []ValueType{I32, I32},
+		results: []ValueType{I32},
+		body:    []byte{opLocalGet, 0, opLocalGet, 1, 0x6a},
+		export:  "add",
+	})
+	m, err := Decode(valid)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if _, ok := m.Exports["add"]; !ok || m.Memory == nil || m.Memory.Min != 1 {
+		t.Fatalf("decoded module = %+v, want an add export and one page of memory", m)
+	}
+
+	tests := []struct {
+		name string
+		bin  []byte
+		err  error
+	}{
+		{"empty", nil, ErrInvalidModule},
+		{"bad magic", append([]byte("\x00wsm"), valid[4:]...), ErrInvalidModule},
+		{"bad version", append([]byte("\x00asm\x02\x00\x00\x00"), valid[8:]...), ErrInvalidModule},
+		{"truncated", valid[:len(valid)-3], ErrInvalidModule},
+		{"section overrun", append(append([]byte(nil), valid...), 1, 0x7f), ErrInvalidModule},
+		{"sections out of order", append(append([]byte(nil), valid...), section(1, vector())...), ErrInvalidModule},
+		{"unknown section", append(append([]byte(nil), valid...), section(13, nil)...), ErrInvalidModule},
+		{"unknown instruction", buildModule(nil, testFunc{body: []byte{0xd2}}), ErrInvalidModule},
+		{"branch out of range", buildModule(nil, testFunc{body: []byte{opBr, 1}}), ErrInvalidModule},
+		{"call out of range", buildModule(nil, testFunc{body: []byte{opCall, 5}}), ErrInvalidModule},
+		{"load without memory", buildModule(nil, testFunc{body: append(i32Const(0), 0x28, 2, 0, opDrop)}), ErrInvalidModule},
+		{"memory over the page limit", buildModule(&Limits{Min: maxPages + 1}), ErrInvalidModule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.bin); !errors.Is(err, tt.err) {
+				t.Fatalf("Decode = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsFloatingPoint(t *testing.T) {
+	const f32, f64 = ValueType(0x7d), ValueType(0x7c)
+
+	tests := []struct {
+		name string
+		fn   testFunc
+	}{
+		{"f32 parameter", testFunc{params: []ValueType{f32}}},
+		{"f64 result", testFunc{results: []ValueType{f64}, body: []byte{opUnreachable}}},
+		{"f64 local", testFunc{locals: []ValueType{f64}}},
+		{"f32.const", testFunc{body: []byte{0x43, 0, 0, 0, 0, opDrop}}},
+		{"f64.const", testFunc{body: []byte{0x44, 0, 0, 0, 0, 0, 0, 0, 0, opDrop}}},
+		{"f64.add", testFunc{body: []byte{0xa0}}},
+		{"f32.convert_i32_s", testFunc{body: append(i32Const(1), 0xb2, opDrop)}},
+		{"f64.load", testFunc{body: append(i32Const(0), 0x2b, 3, 0, opDrop)}},
+		{"i32.trunc_sat_f32_s", testFunc{body: []byte{opPrefixFC, 0}}},
+		{"f64 block type", testFunc{body: []byte{opBlock, 0x7c, opUnreachable, opEnd, opDrop}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(buildModule(&Limits{Min: 1}, tt.fn)); !errors.Is(err, ErrFloatingPoint) {
+				t.Fatalf("Decode = %v, want %v", err, ErrFloatingPoint)
+			}
+		})
+	}
+}
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   testFunc
+		args []uint64
+		want uint64
+	}{
+		{"i32.add", testFunc{params: []ValueType{I32, I32}, results: []ValueType{I32}, body: []byte{opLocalGet, 0, opLocalGet, 1, 0x6a}}, []uint64{2, 3}, 5},
+		{"i32.add wraps", testFunc{params: []ValueType{I32, I32}, results: []ValueType{I32}, body: []byte{opLocalGet, 0, opLocalGet, 1, 0x6a}}, []uint64{math.MaxUint32, 2}, 1},
+		{"i64.mul", testFunc{params: []ValueType{I64, I64}, results: []ValueType{I64}, body: []byte{opLocalGet, 0, opLocalGet, 1, 0x7e}}, []uint64{1 << 40, 3}, 3 << 40},
+		{"i32.div_s", testFunc{params: []ValueType{I32, I32}, results: []ValueType{I32}, body: []byte{opLocalGet, 0, opLocalGet, 1, 0x6d}}, []uint64{uint64(uint32(7)), uint64(math.MaxUint32)}, uint64(uint32(0xfffffff9))},
+		{"loop", testFunc{params: []ValueType{I32}, results: []ValueType{I32}, locals: []ValueType{I32}, body: sumBody()}, []uint64{100}, 5050},
+		{"memory round trip", testFunc{results: []ValueType{I32}, body: append(append(append(i32Const(8), i32Const(-2)...), 0x36, 2, 0), append(i32Const(8), 0x28, 2, 0)...)}, nil, uint64(uint32(0xfffffffe))},
+		{"memory.grow", testFunc{results: []ValueType{I32}, body: append(i32Const(2), opMemoryGrow, 0, opDrop, opMemorySize, 0)}, nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn.export = "f"
+			inst := instantiate(t, buildModule(&Limits{Min: 1}, tt.fn), testConfig)
+			results, err := inst.Call("f", tt.args...)
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if len(results) != 1 || results[0] != tt.want {
+				t.Fatalf("Call = %v, want [%d]", results, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraps(t *testing.T) {
+	binary := func(op byte) testFunc {
+		return testFunc{params: []ValueType{I32, I32}, results: []ValueType{I32}, body: []byte{opLocalGet, 0, opLocalGet, 1, op}}
+	}
+
+	tests := []struct {
+		name string
+		fn   testFunc
+		args []uint64
+	}{
+		{"unreachable", testFunc{body: []byte{opUnreachable}}, nil},
+		{"i32.div_s by zero", binary(0x6d), []uint64{1, 0}},
+		{"i32.div_u by zero", binary(0x6e), []uint64{1, 0}},
+		{"i32.rem_s by zero", binary(0x6f), []uint64{1, 0}},
+		{"i32.div_s overflow", binary(0x6d), []uint64{1 << 31, math.MaxUint32}},
+		{"load out of bounds", testFunc{results: []ValueType{I32}, body: append(i32Const(PageSize-2), 0x28, 2, 0)}, nil},
+		{"store out of bounds", testFunc{body: append(append(i32Const(-1), i32Const(1)...), 0x3a, 0, 0)}, nil},
+		{"memory.fill out of bounds", testFunc{body: append(append(append(i32Const(PageSize-1), i32Const(0)...), i32Const(2)...), opPrefixFC, subMemoryFill, 0)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn.export = "f"
+			inst := instantiate(t, buildModule(&Limits{Min: 1}, tt.fn), testConfig)
+			var trap *Trap
+			if _, err := inst.Call("f", tt.args...); !errors.As(err, &trap) {
+				t.Fatalf("Call = %v, want a trap", err)
+			}
+		})
+	}
+}
+
+func TestFuel(t *testing.T) {
+	loop := buildModule(nil, testFunc{body: []byte{opLoop, 0x40, opBr, 0, opEnd}, export: "spin"})
+	inst := instantiate(t, loop, Config{Fuel: 5000, MaxCallDepth: 8, MaxStackHeight: 16})
+	if _, err := inst.Call("spin"); !errors.Is(err, ErrOutOfFuel) {
+		t.Fatalf("Call of an infinite loop = %v, want %v", err, ErrOutOfFuel)
+	}
+	if inst.FuelLeft() != 0 {
+		t.Fatalf("FuelLeft = %d after running out", inst.FuelLeft())
+	}
+
+	// Execution is metered the same way on every run
+	sum := buildModule(nil, testFunc{params: []ValueType{I32}, results: []ValueType{I32}, locals: []ValueType{I32}, body: sumBody(), export: "sum"})
+	var used []uint64
+	for i := 0; i < 2; i++ {
+		inst := instantiate(t, sum, testConfig)
+		if _, err := inst.Call("sum", 50); err != nil {
+			t.Fatalf("Call: %v", err)
+		}
+		used = append(used, testConfig.Fuel-inst.FuelLeft())
+	}
+	if used[0] != used[1] || used[0] <= 50 {
+		t.Fatalf("fuel used = %v, want the same amount above 50 on both runs", used)
+	}
+	inst = instantiate(t, sum, Config{Fuel: used[0] - 1, MaxCallDepth: 8, MaxStackHeight: 16})
+	if _, err := inst.Call("sum", 50); !errors.Is(err, ErrOutOfFuel) {
+		t.Fatalf("Call with one unit of fuel too little = %v, want %v", err, ErrOutOfFuel)
+	}
+
+	// Initial memory is paid for at instantiation
+	m, err := Decode(buildModule(&Limits{Min: 2}))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if _, err := Instantiate(m, nil, Config{Fuel: 2*fuelPerPage - 1, MaxMemoryPages: 4}); !errors.Is(err, ErrOutOfFuel) {
+		t.Fatalf("Instantiate without fuel for the memory = %v, want %v", err, ErrOutOfFuel)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	m, err := Decode(buildModule(&Limits{Min: 5}))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if _, err := Instantiate(m, nil, testConfig); !errors.Is(err, ErrMemoryLimit) {
+		t.Fatalf("Instantiate of %d pages = %v, want %v", 5, err, ErrMemoryLimit)
+	}
+
+	grow := testFunc{params: []ValueType{I32}, results: []ValueType{I32}, body: []byte{opLocalGet, 0, opMemoryGrow, 0}, export: "grow"}
+	tests := []struct {
+		name   string
+		limits Limits
+		delta  uint64
+		want   uint64
+	}{
+		{"within the config limit", Limits{Min: 1}, 3, 1},
+		{"over the config limit", Limits{Min: 1}, 4, math.MaxUint32},
+		{"over the module maximum", Limits{Min: 1, Max: 2, HasMax: true}, 2, math.MaxUint32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := instantiate(t, buildModule(&tt.limits, grow), testConfig)
+			results, err := inst.Call("grow", tt.delta)
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if results[0] != tt.want {
+				t.Fatalf("memory.grow(%d) = %d, want %d", tt.delta, results[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	recurse := buildModule(nil, testFunc{body: []byte{opCall, 0}, export: "recurse"})
+	inst := instantiate(t, recurse, testConfig)
+	if _, err := inst.Call("recurse"); !errors.Is(err, ErrCallDepth) {
+		t.Fatalf("Call of unbounded recursion = %v, want %v", err, ErrCallDepth)
+	}
+
+	// The depth unwinds, so the instance can be called again
+	if _, err := inst.Call("recurse"); !errors.Is(err, ErrCallDepth) {
+		t.Fatalf("second Call = %v, want %v", err, ErrCallDepth)
+	}
+}
@@ -0,0 +1,578 @@
+package contracts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/ignaciocorball/go-blockchain/contracts/wasm"
+)
+
+// WebAssembly runtime limits.
+const (
+	MaxWasmCodeSize    = 256 * 1024 // Maximum size of a WebAssembly module
+	MaxWasmMemoryPages = 16         // Maximum linear memory: 16 pages of 64 KiB
+	MaxWasmCallDepth   = 128        // Maximum nesting of WebAssembly function calls
+)
+
+// wasmMagic starts every WebAssembly module.
+var wasmMagic = []byte{0x00, 'a', 's', 'm'}
+
+// IsWasm reports whether code is a WebAssembly module rather than VM bytecode.
+func IsWasm(code []byte) bool {
+	return bytes.HasPrefix(code, wasmMagic)
+}
+
+// Host functions available to WebAssembly contracts, imported from module
+// "env". Pointers and lengths are i32 offsets into the contract's memory.
+//
+//	state_get(key_ptr, key_len, val_ptr, val_cap) i32  value length, -1 if unset
+//	state_set(key_ptr, key_len, val_ptr, val_len)
+//	caller(ptr, cap) i32                               address length
+//	call_value() i64
+//	block_height() i64
+//	arg_count() i32
+//	arg(index, ptr, cap) i32                           encoded argument length
+//	arg_i64(index) i64                                 integer argument
+//	emit_event(name_ptr, name_len, data_ptr, data_len)
+//	emit_event_indexed(name_ptr, name_len, topic_ptr, topic_len, data_ptr, data_len)
+//	set_return(ptr, len)
+//	revert(ptr, len)
+//	self_address(ptr, cap) i32                         address length
+//	balance() i64
+//	call_contract(addr_ptr, addr_len, method_ptr, method_len, args_ptr, args_len, value i64, gas i64, ret_ptr, ret_cap) i32
+//	                                                   encoded return value length, -1 if none
+//	schedule_call(method_ptr, method_len, args_ptr, args_len, height i64, interval i64, runs i64, gas i64) i64
+//	                                                   deposit paid from the contract's balance
+//	sha256(ptr, len, out_ptr, out_cap) i32             hash length
+//	keccak256(ptr, len, out_ptr, out_cap) i32          hash length
+//	verify_signature(key_ptr, key_len, digest_ptr, digest_len, sig_ptr, sig_len) i32
+//	                                                   1 if the signature is valid, else 0
+//	verify_merkle(id_ptr, id_len, root_ptr, root_len, proof_ptr, proof_len) i32
+//	                                                   1 if the proof is valid, else 0
+//
+// Functions that copy data out write at most cap bytes and return the full
+// length, so a contract can retry with a larger buffer. Host calls are
+// charged like the equivalent VM instructions. call_contract's arguments are
+// encoded as a sequence of a tag byte, 0 for an integer followed by its
+// 8-byte big-endian value or 1 for a byte string followed by its 4-byte
+// big-endian length and its bytes; gas 0 forwards all fuel left.
+// schedule_call's arguments are encoded the same way, and it schedules
+// calls of a method of the running contract like the VM's SCHEDULE. The hashing
+// and verification functions are the VM's precompiled functions (see
+// verifySignature and verifyMerkleProof), at the same gas.
+var wasmHostTypes = map[string]wasm.FuncType{
+	"state_get":          {Params: []wasm.ValueType{i32, i32, i32, i32}, Results: []wasm.ValueType{i32}},
+	"state_set":          {Params: []wasm.ValueType{i32, i32, i32, i32}},
+	"caller":             {Params: []wasm.ValueType{i32, i32}, Results: []wasm.ValueType{i32}},
+	"call_value":         {Results: []wasm.ValueType{i64}},
+	"block_height":       {Results: []wasm.ValueType{i64}},
+	"arg_count":          {Results: []wasm.ValueType{i32}},
+	"arg":                {Params: []wasm.ValueType{i32, i32, i32}, Results: []wasm.ValueType{i32}},
+	"arg_i64":            {Params: []wasm.ValueType{i32}, Results: []wasm.ValueType{i64}},
+	"emit_event":         {Params: []wasm.ValueType{i32, i32, i32, i32}},
+	"emit_event_indexed": {Params: []wasm.ValueType{i32, i32, i32, i32, i32, i32}},
+	"set_return":         {Params: []wasm.ValueType{i32, i32}},
+	"revert":             {Params: []wasm.ValueType{i32, i32}},
+	"self_address":       {Params: []wasm.ValueType{i32, i32}, Results: []wasm.ValueType{i32}},
+	"balance":            {Results: []wasm.ValueType{i64}},
+	"call_contract":      {Params: []wasm.ValueType{i32, i32, i32, i32, i32, i32, i64, i64, i32, i32}, Results: []wasm.ValueType{i32}},
+	"schedule_call":      {Params: []wasm.ValueType{i32, i32, i32, i32, i64, i64, i64, i64}, Results: []wasm.ValueType{i64}},
+	"sha256":             {Params: []wasm.ValueType{i32, i32, i32, i32}, Results: []wasm.ValueType{i32}},
+	"keccak256":          {Params: []wasm.ValueType{i32, i32, i32, i32}, Results: []wasm.ValueType{i32}},
+	"verify_signature":   {Params: []wasm.ValueType{i32, i32, i32, i32, i32, i32}, Results: []wasm.ValueType{i32}},
+	"verify_merkle":      {Params: []wasm.ValueType{i32, i32, i32, i32, i32, i32}, Results: []wasm.ValueType{i32}},
+}
+
+const (
+	i32 = wasm.I32
+	i64 = wasm.I64
+)
+
+// wasmRevert carries the reason of a revert host call out of the interpreter.
+type wasmRevert struct {
+	reason string
+}
+
+func (e *wasmRevert) Error() string {
+	return "revert: " + e.reason
+}
+
+// wasmCall holds the state of one WebAssembly contract call.
+type wasmCall struct {
+	ctx    *CallContext
+	state  map[string]interface{} // Committed contract state (read only)
+	writes map[string]Value       // Storage writes of this call
+	logs   []Log
+	ret    *Value
+
+	step *TraceStep // Host call being traced; nil if the call is not traced
+}
+
+// VerifyWasm checks that code is a valid WebAssembly module within the size
+// limit that only imports known host functions with their expected signatures.
+func VerifyWasm(code []byte) error {
+	_, err := decodeWasm(code)
+	return err
+}
+
+func decodeWasm(code []byte) (*wasm.Module, error) {
+	if len(code) > MaxWasmCodeSize {
+		return nil, fmt.Errorf("module exceeds %d bytes", MaxWasmCodeSize)
+	}
+	module, err := wasm.Decode(code)
+	if err != nil {
+		return nil, err
+	}
+	for _, imp := range module.Imports {
+		typ, ok := wasmHostTypes[imp.Name]
+		if imp.Module != "env" || !ok {
+			return nil, fmt.Errorf("unknown import %s.%s", imp.Module, imp.Name)
+		}
+		if !typ.Equal(module.Types[imp.Type]) {
+			return nil, fmt.Errorf("import env.%s has the wrong signature", imp.Name)
+		}
+	}
+	return module, nil
+}
+
+// RunWasm executes a call to a WebAssembly contract.
+// The method is the name of an exported function taking no parameters and
+// returning nothing, an i32 or an i64; arguments are read through host
+// functions. The call's gas limit is the interpreter's fuel.
+//
+// Like Run, it returns the execution result with GasUsed always set, and the
+// storage writes to apply if (and only if) the call succeeded.
+func RunWasm(code []byte, state map[string]interface{}, ctx *CallContext) (*ExecutionResult, map[string]Value, error) {
+	module, err := decodeWasm(code)
+	if err != nil {
+		return &ExecutionResult{}, nil, err
+	}
+
+	call := &wasmCall{ctx: ctx, state: state, writes: make(map[string]Value)}
+	config := wasm.Config{
+		Fuel:           ctx.GasLimit,
+		MaxMemoryPages: MaxWasmMemoryPages,
+		MaxCallDepth:   MaxWasmCallDepth,
+		MaxStackHeight: MaxStackDepth,
+		Deadline:       ctx.Deadline,
+	}
+
+	inst, err := wasm.Instantiate(module, map[string]wasm.HostModule{"env": call.hostModule()}, config)
+	if err != nil {
+		return &ExecutionResult{GasUsed: ctx.GasLimit}, nil, wasmError(err)
+	}
+
+	typ, ok := inst.FuncType(ctx.Method)
+	if !ok {
+		return &ExecutionResult{GasUsed: ctx.GasLimit - inst.FuelLeft()}, nil, &RevertError{Reason: "unknown method"}
+	}
+	if len(typ.Params) != 0 || len(typ.Results) > 1 {
+		return &ExecutionResult{GasUsed: ctx.GasLimit - inst.FuelLeft()}, nil, fmt.Errorf("method %s does not have a callable signature", ctx.Method)
+	}
+
+	results, err := inst.Call(ctx.Method)
+	result := &ExecutionResult{GasUsed: ctx.GasLimit - inst.FuelLeft()}
+	if err != nil {
+		return result, nil, wasmError(err)
+	}
+
+	result.ReturnValue = call.ret
+	if result.ReturnValue == nil && len(results) == 1 {
+		v := IntValue(int64(results[0]))
+		if typ.Results[0] == wasm.I32 {
+			v = IntValue(int64(int32(results[0])))
+		}
+		result.ReturnValue = &v
+	}
+	result.Logs = call.logs
+	return result, call.writes, nil
+}
+
+// wasmError converts interpreter errors into the errors the VM reports.
+func wasmError(err error) error {
+	var revert *wasmRevert
+	switch {
+	case errors.As(err, &revert):
+		return &RevertError{Reason: revert.reason}
+	case errors.Is(err, wasm.ErrOutOfFuel):
+		return ErrOutOfGas
+	case errors.Is(err, wasm.ErrDeadline):
+		return ErrTimeout
+	}
+	return err
+}
+
+// load reads a storage key, seeing the writes of the current call.
+func (call *wasmCall) load(key string) (Value, bool, error) {
+	if value, ok := call.writes[key]; ok {
+		return value, true, nil
+	}
+	raw, ok := call.state[key]
+	if !ok {
+		return Value{}, false, nil
+	}
+	value, err := valueFromState(raw)
+	return value, true, err
+}
+
+// readBytes reads a pointer and length pair from memory, bounded by MaxValueSize.
+func readBytes(inst *wasm.Instance, ptr, n uint64) ([]byte, error) {
+	if uint32(n) > MaxValueSize {
+		return nil, ErrValueTooLarge
+	}
+	return inst.Read(uint32(ptr), uint32(n))
+}
+
+// writeBytes copies at most cap bytes of data to memory and returns the full
+// length of data as an i32 result.
+func writeBytes(inst *wasm.Instance, data []byte, ptr, cap uint64) ([]uint64, error) {
+	n := len(data)
+	if uint64(n) > uint64(uint32(cap)) {
+		n = int(uint32(cap))
+	}
+	if err := inst.Write(uint32(ptr), data[:n]); err != nil {
+		return nil, err
+	}
+	return []uint64{uint64(len(data))}, nil
+}
+
+// argument returns the call argument at index.
+func (call *wasmCall) argument(index uint64) (Value, error) {
+	if uint64(uint32(index)) >= uint64(len(call.ctx.Args)) {
+		return Value{}, fmt.Errorf("missing argument %d", uint32(index))
+	}
+	return call.ctx.Args[uint32(index)], nil
+}
+
+// emit records an event with an optional indexed argument, which precedes
+// the data in the event's arguments.
+func (call *wasmCall) emit(inst *wasm.Instance, name string, indexed *Value, data Value) error {
+	if len(call.logs) >= MaxLogs {
+		return fmt.Errorf("more than %d events", MaxLogs)
+	}
+
+	log := Log{Contract: call.ctx.Address, Event: name, Topics: []string{EventTopic(name)}}
+	gas := opcodes[OpLog].gas
+	size := len(name) + len(data.Bytes)
+	if indexed != nil {
+		log.Args = append(log.Args, *indexed)
+		log.Topics = append(log.Topics, ValueTopic(*indexed))
+		gas += gasPerTopic
+		size += len(indexed.Bytes)
+	}
+	log.Args = append(log.Args, data)
+
+	if err := inst.UseFuel(gas + wordGas(size)); err != nil {
+		return err
+	}
+	call.logs = append(call.logs, log)
+	return nil
+}
+
+// callContract calls another contract for call_contract, charging the
+// called contract's gas and adding its events to this call.
+func (call *wasmCall) callContract(inst *wasm.Instance, args []uint64) (*Value, error) {
+	if call.ctx.tree == nil {
+		return nil, errNoCallTree
+	}
+	address, err := readBytes(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	method, err := readBytes(inst, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := readBytes(inst, args[4], args[5])
+	if err != nil {
+		return nil, err
+	}
+	if err := inst.UseFuel(gasContractCall + wordGas(len(address)+len(method)+len(encoded))); err != nil {
+		return nil, err
+	}
+	callArgs, err := decodeCallArgs(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	forward := inst.FuelLeft()
+	if gas := args[7]; gas != 0 && gas < forward {
+		forward = gas
+	}
+	result, err := call.ctx.tree.nestedCall(call.ctx, call.writes, string(address), string(method), callArgs, int64(args[6]), forward)
+	if fuelErr := inst.UseFuel(result.GasUsed); fuelErr != nil {
+		return nil, fuelErr
+	}
+	if err != nil {
+		return nil, callError(string(address), string(method), err)
+	}
+	if len(call.logs)+len(result.Logs) > MaxLogs {
+		return nil, fmt.Errorf("more than %d events", MaxLogs)
+	}
+	call.logs = append(call.logs, result.Logs...)
+	return result.ReturnValue, nil
+}
+
+// scheduleCall schedules calls of a method of the running contract for
+// schedule_call, and returns the deposit paid for them.
+func (call *wasmCall) scheduleCall(inst *wasm.Instance, args []uint64) (int64, error) {
+	if call.ctx.tree == nil {
+		return 0, errNoCallTree
+	}
+	method, err := readBytes(inst, args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	encoded, err := readBytes(inst, args[2], args[3])
+	if err != nil {
+		return 0, err
+	}
+	if err := inst.UseFuel(gasSchedule + wordGas(len(method)+len(encoded))); err != nil {
+		return 0, err
+	}
+	callArgs, err := decodeCallArgs(encoded)
+	if err != nil {
+		return 0, err
+	}
+	if int64(args[4]) < 0 || int64(args[5]) < 0 || int64(args[6]) < 0 || int64(args[7]) < 0 {
+		return 0, errors.New("invalid schedule")
+	}
+
+	return call.ctx.tree.schedule(call.ctx, &ScheduledCall{
+		Method:   string(method),
+		Args:     callArgs,
+		GasLimit: args[7],
+		Height:   int(args[4]),
+		Interval: int(args[5]),
+		Runs:     int(args[6]),
+	})
+}
+
+// hostHash runs a precompiled hash for a sha256 or keccak256 host call,
+// charging the gas of its VM instruction.
+func hostHash(inst *wasm.Instance, args []uint64, op Opcode, fn func([]byte) []byte) ([]uint64, error) {
+	data, err := readBytes(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if err := inst.UseFuel(opcodes[op].gas + wordGas(len(data))); err != nil {
+		return nil, err
+	}
+	return writeBytes(inst, fn(data), args[2], args[3])
+}
+
+// verifyOperands reads the three byte string operands of a
+// verify_signature or verify_merkle host call.
+func verifyOperands(inst *wasm.Instance, args []uint64) ([][]byte, error) {
+	operands := make([][]byte, 3)
+	for i := range operands {
+		data, err := readBytes(inst, args[2*i], args[2*i+1])
+		if err != nil {
+			return nil, err
+		}
+		operands[i] = data
+	}
+	return operands, nil
+}
+
+// hostModule binds the host functions to this call.
+func (call *wasmCall) hostModule() wasm.HostModule {
+	host := func(name string, fn func(inst *wasm.Instance, args []uint64) ([]uint64, error)) wasm.HostFunction {
+		return wasm.HostFunction{Type: wasmHostTypes[name], Fn: call.traceHost(name, fn)}
+	}
+
+	return wasm.HostModule{
+		"state_get": host("state_get", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			key, err := readBytes(inst, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			value, ok, err := call.load(string(key))
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				call.step.access(StorageRead, string(key), &value)
+			} else {
+				call.step.access(StorageRead, string(key), nil)
+			}
+			data := value.Encode()
+			if err := inst.UseFuel(opcodes[OpSLoad].gas + wordGas(len(key)+len(data))); err != nil {
+				return nil, err
+			}
+			if !ok {
+				return []uint64{uint64(uint32(0xffffffff))}, nil
+			}
+			return writeBytes(inst, data, args[2], args[3])
+		}),
+
+		"state_set": host("state_set", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			key, err := readBytes(inst, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			value, err := readBytes(inst, args[2], args[3])
+			if err != nil {
+				return nil, err
+			}
+			if err := inst.UseFuel(opcodes[OpSStore].gas + wordGas(len(key)+len(value))); err != nil {
+				return nil, err
+			}
+			written := BytesValue(value)
+			call.writes[string(key)] = written
+			call.step.access(StorageWrite, string(key), &written)
+			return nil, nil
+		}),
+
+		"caller": host("caller", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			return writeBytes(inst, []byte(call.ctx.Caller), args[0], args[1])
+		}),
+
+		"call_value": host("call_value", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			return []uint64{uint64(call.ctx.Value)}, nil
+		}),
+
+		"block_height": host("block_height", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			return []uint64{uint64(call.ctx.BlockHeight)}, nil
+		}),
+
+		"arg_count": host("arg_count", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			return []uint64{uint64(len(call.ctx.Args))}, nil
+		}),
+
+		"arg": host("arg", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			arg, err := call.argument(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return writeBytes(inst, arg.Encode(), args[1], args[2])
+		}),
+
+		"arg_i64": host("arg_i64", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			arg, err := call.argument(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if arg.IsBytes {
+				return nil, fmt.Errorf("%w: argument %d is not an integer", ErrTypeMismatch, uint32(args[0]))
+			}
+			return []uint64{uint64(arg.Int)}, nil
+		}),
+
+		"emit_event": host("emit_event", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			name, err := readBytes(inst, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			data, err := readBytes(inst, args[2], args[3])
+			if err != nil {
+				return nil, err
+			}
+			return nil, call.emit(inst, string(name), nil, BytesValue(data))
+		}),
+
+		"emit_event_indexed": host("emit_event_indexed", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			name, err := readBytes(inst, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			topic, err := readBytes(inst, args[2], args[3])
+			if err != nil {
+				return nil, err
+			}
+			data, err := readBytes(inst, args[4], args[5])
+			if err != nil {
+				return nil, err
+			}
+			indexed := BytesValue(topic)
+			return nil, call.emit(inst, string(name), &indexed, BytesValue(data))
+		}),
+
+		"set_return": host("set_return", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			data, err := readBytes(inst, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			value := BytesValue(data)
+			call.ret = &value
+			return nil, nil
+		}),
+
+		"revert": host("revert", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			reason, err := readBytes(inst, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			return nil, &wasmRevert{reason: string(reason)}
+		}),
+
+		"self_address": host("self_address", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			return writeBytes(inst, []byte(call.ctx.Address), args[0], args[1])
+		}),
+
+		"balance": host("balance", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			if call.ctx.tree == nil {
+				return nil, errNoCallTree
+			}
+			if err := inst.UseFuel(opcodes[OpBalance].gas); err != nil {
+				return nil, err
+			}
+			return []uint64{uint64(call.ctx.tree.balance(call.ctx.Address))}, nil
+		}),
+
+		"call_contract": host("call_contract", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			ret, err := call.callContract(inst, args)
+			if err != nil {
+				return nil, err
+			}
+			if ret == nil {
+				return []uint64{uint64(uint32(0xffffffff))}, nil
+			}
+			return writeBytes(inst, ret.Encode(), args[8], args[9])
+		}),
+
+		"schedule_call": host("schedule_call", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			deposit, err := call.scheduleCall(inst, args)
+			if err != nil {
+				return nil, err
+			}
+			return []uint64{uint64(deposit)}, nil
+		}),
+
+		"sha256": host("sha256", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			return hostHash(inst, args, OpSHA256, func(data []byte) []byte {
+				sum := sha256.Sum256(data)
+				return sum[:]
+			})
+		}),
+
+		"keccak256": host("keccak256", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			return hostHash(inst, args, OpKeccak, keccak256)
+		}),
+
+		"verify_signature": host("verify_signature", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			operands, err := verifyOperands(inst, args)
+			if err != nil {
+				return nil, err
+			}
+			if err := inst.UseFuel(opcodes[OpVerifySig].gas); err != nil {
+				return nil, err
+			}
+			return []uint64{uint64(boolValue(verifySignature(operands[0], operands[1], operands[2])).Int)}, nil
+		}),
+
+		"verify_merkle": host("verify_merkle", func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			operands, err := verifyOperands(inst, args)
+			if err != nil {
+				return nil, err
+			}
+			steps, _ := merkleSteps(operands[2])
+			if err := inst.UseFuel(opcodes[OpVerifyMerkle].gas + uint64(steps)*gasPerMerkleStep); err != nil {
+				return nil, err
+			}
+			return []uint64{uint64(boolValue(verifyMerkleProof(operands[0], operands[1], operands[2])).Int)}, nil
+		}),
+	}
+}
@@ -5,6 +5,7 @@ go 1.24.3
 require (
 	github.com/dgraph-io/badger v1.6.2
 	github.com/labstack/echo/v4 v4.13.3
+	golang.org/x/crypto v0.31.0
 )
 
 require (
@@ -19,7 +20,6 @@ require (
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
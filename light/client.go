@@ -0,0 +1,228 @@
+// Package light implements a light client for the UFChain blockchain.
+// A light client stores only block headers. It verifies that every header
+// links to its predecessor and is signed by a trusted validator, and it checks
+// that a transaction is part of the chain with a Merkle proof served by a full
+// node, without ever downloading blocks or the UTXO set. When the full node
+// reorganizes, the client follows it to the better branch.
+package light
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// DefaultBatchSize is the number of headers requested from the full node at once.
+const DefaultBatchSize = 500
+
+// ErrUnknownBlock is returned when a proof refers to a block the light client
+// has not synced (yet).
+var ErrUnknownBlock = errors.New("proof refers to a block that is not in the synced header chain")
+
+// Source provides headers and Merkle proofs, typically from a full node.
+// Nothing a Source returns is trusted: the client verifies all of it.
+type Source interface {
+	Headers(from int, limit int) ([]*blockchain.BlockHeader, error)
+	TransactionProof(txID []byte) (*blockchain.MerkleProof, error)
+	Filters(from int, limit int) ([]*blockchain.BlockFilter, error)
+	FilterHeaders(from int, limit int) ([][]byte, error)
+	BlockAt(height int) (*blockchain.Block, error)
+}
+
+// Client follows the chain by headers only.
+type Client struct {
+	source     Source
+	validators map[string]bool // Trusted validator public keys, key = hex public key; empty trusts any signer
+
+	mu      sync.RWMutex
+	headers []*blockchain.BlockHeader // Verified header chain, indexed by height
+}
+
+// NewClient creates a light client anchored at the given genesis header.
+// Parameters:
+//   - source: Where headers and proofs are fetched from
+//   - genesis: The trusted genesis header the chain must start from
+//   - validators: Public keys allowed to sign blocks; if empty, any correctly
+//     signed header is accepted
+func NewClient(source Source, genesis *blockchain.BlockHeader, validators [][]byte) *Client {
+	trusted := make(map[string]bool, len(validators))
+	for _, v := range validators {
+		trusted[hex.EncodeToString(v)] = true
+	}
+
+	return &Client{
+		source:     source,
+		validators: trusted,
+		headers:    []*blockchain.BlockHeader{genesis},
+	}
+}
+
+// Tip returns the highest verified header.
+func (c *Client) Tip() *blockchain.BlockHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.headers[len(c.headers)-1]
+}
+
+// Header returns the verified header at height, or nil if it has not been synced.
+func (c *Client) Header(height int) *blockchain.BlockHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if height < 0 || height >= len(c.headers) {
+		return nil
+	}
+	return c.headers[height]
+}
+
+// Sync downloads and verifies headers until the source has no more.
+// If the source's chain forks off the synced one, the client reorganizes to
+// the source's branch when it is better (see blockchain.IsBetterTip) and
+// forks off at most blockchain.MaxReorgDepth headers deep.
+// Returns the number of new headers appended to the chain, including those
+// replacing disconnected ones. Headers verified before an invalid one is
+// encountered are kept.
+func (c *Client) Sync() (int, error) {
+	synced := 0
+	for {
+		// Ask for the tip again, to notice when the source no longer has it
+		tip := c.Tip()
+		batch, err := c.source.Headers(tip.Height, DefaultBatchSize)
+		if err != nil {
+			return synced, err
+		}
+		if len(batch) == 0 {
+			return synced, nil
+		}
+		if !bytes.Equal(batch[0].Hash, tip.Hash) {
+			adopted, err := c.reorganize()
+			synced += adopted
+			if err != nil {
+				return synced, err
+			}
+			continue
+		}
+		if len(batch) == 1 {
+			return synced, nil
+		}
+
+		for _, header := range batch[1:] {
+			if err := c.appendHeader(header); err != nil {
+				return synced, err
+			}
+			synced++
+		}
+	}
+}
+
+// reorganize looks for the most recent header the synced chain has in common
+// with the source's, at most blockchain.MaxReorgDepth headers below the tip,
+// downloads and verifies the source's branch from there and switches to it
+// if it is better than the synced one.
+// Returns the number of headers of the adopted branch.
+func (c *Client) reorganize() (int, error) {
+	tip := c.Tip()
+	from := max(tip.Height-blockchain.MaxReorgDepth, 0)
+	recent, err := c.source.Headers(from, tip.Height-from+1)
+	if err != nil {
+		return 0, err
+	}
+	fork := -1
+	for _, header := range recent {
+		if local := c.Header(header.Height); local != nil && bytes.Equal(local.Hash, header.Hash) {
+			fork = header.Height
+		}
+	}
+	if fork < 0 {
+		return 0, fmt.Errorf("source: %w", blockchain.ErrReorgTooDeep)
+	}
+
+	prev := c.Header(fork)
+	var branch []*blockchain.BlockHeader
+	for {
+		batch, err := c.source.Headers(prev.Height+1, DefaultBatchSize)
+		if err != nil {
+			return 0, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, header := range batch {
+			if err := c.verifyHeader(header, prev); err != nil {
+				return 0, err
+			}
+			branch = append(branch, header)
+			prev = header
+		}
+	}
+	if len(branch) == 0 || !blockchain.IsBetterTip(prev.Height, prev.Hash, tip.Height, tip.Hash) {
+		return 0, fmt.Errorf("source follows a fork from height %d that is not better than the synced chain", fork)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.headers = append(c.headers[:fork+1], branch...)
+	return len(branch), nil
+}
+
+// appendHeader verifies a header against the current tip and appends it.
+func (c *Client) appendHeader(header *blockchain.BlockHeader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.verifyHeader(header, c.headers[len(c.headers)-1]); err != nil {
+		return err
+	}
+	c.headers = append(c.headers, header)
+	return nil
+}
+
+// verifyHeader checks that a header follows prev and is signed by a trusted
+// validator.
+func (c *Client) verifyHeader(header, prev *blockchain.BlockHeader) error {
+	if header.Height != prev.Height+1 {
+		return fmt.Errorf("header at height %d does not follow %d", header.Height, prev.Height)
+	}
+	if !bytes.Equal(header.PrevHash, prev.Hash) {
+		return fmt.Errorf("header %x does not link to %x", header.Hash, prev.Hash)
+	}
+	if !bytes.Equal(header.Hash, header.CalculateHash()) {
+		return fmt.Errorf("header %x has an invalid hash", header.Hash)
+	}
+	if len(c.validators) > 0 && !c.validators[hex.EncodeToString(header.Validator)] {
+		return fmt.Errorf("header %x is signed by an untrusted validator", header.Hash)
+	}
+	if !header.VerifySignature() {
+		return fmt.Errorf("header %x has an invalid validator signature", header.Hash)
+	}
+	return nil
+}
+
+// VerifyTransaction checks that a transaction is included in the synced chain.
+// The Merkle proof is fetched from the source and checked against the
+// Merkle root of the locally verified header it refers to.
+// Returns the verified proof, or an error if inclusion cannot be proven.
+func (c *Client) VerifyTransaction(txID []byte) (*blockchain.MerkleProof, error) {
+	proof, err := c.source.TransactionProof(txID)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(proof.TxID, txID) {
+		return nil, fmt.Errorf("proof is for transaction %x, not %x", proof.TxID, txID)
+	}
+
+	header := c.Header(proof.Height)
+	if header == nil || !bytes.Equal(header.Hash, proof.BlockHash) {
+		return nil, ErrUnknownBlock
+	}
+	if !proof.Verify(header.MerkleRoot) {
+		return nil, fmt.Errorf("invalid merkle proof for transaction %x", txID)
+	}
+	return proof, nil
+}
@@ -0,0 +1,100 @@
+package light
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// chainSource serves the headers of a full node's chain.
+type chainSource struct {
+	Source
+	chain *blockchain.Blockchain
+}
+
+func (s *chainSource) Headers(from int, limit int) ([]*blockchain.BlockHeader, error) {
+	return s.chain.Headers(from, limit), nil
+}
+
+// addBlocks appends n empty blocks signed by validator to chain.
+func addBlocks(t *testing.T, chain *blockchain.Blockchain, validator *blockchain.Wallet, n int) {
+	t.Helper()
+	for range n {
+		if _, err := chain.AddBlock(nil, validator); err != nil {
+			t.Fatalf("AddBlock: %v", err)
+		}
+	}
+}
+
+func TestSyncFollowsBetterFork(t *testing.T) {
+	alice := blockchain.NewWallet()
+	bob := blockchain.NewWallet()
+	genesis := blockchain.NewGenesisBlock()
+	chain := blockchain.NewBlockchain(genesis)
+	addBlocks(t, chain, alice, 3)
+
+	source := &chainSource{chain: chain}
+	client := NewClient(source, genesis.Header(), [][]byte{alice.PublicKey, bob.PublicKey})
+	if synced, err := client.Sync(); synced != 3 || err != nil {
+		t.Fatalf("Sync = %d, %v, want 3", synced, err)
+	}
+
+	// A longer fork from height 1 replaces the last two headers
+	fork := blockchain.NewBlockchain(genesis)
+	if _, _, err := fork.ImportBlocks(chain.AllBlocks()[1:2]); err != nil {
+		t.Fatalf("ImportBlocks: %v", err)
+	}
+	addBlocks(t, fork, bob, 3)
+	source.chain = fork
+	if synced, err := client.Sync(); synced != 3 || err != nil {
+		t.Fatalf("Sync of a better fork = %d, %v, want 3", synced, err)
+	}
+	for height := 0; height <= 4; height++ {
+		if got, want := client.Header(height), fork.AllBlocks()[height]; got == nil || !bytes.Equal(got.Hash, want.Hash) {
+			t.Fatalf("header at height %d is not the fork's", height)
+		}
+	}
+
+	// The former chain, now shorter, is ignored
+	source.chain = chain
+	if synced, err := client.Sync(); synced != 0 || err != nil {
+		t.Fatalf("Sync of a worse fork = %d, %v, want 0", synced, err)
+	}
+	if !bytes.Equal(client.Tip().Hash, fork.LastBlock().Hash) {
+		t.Fatal("the client left the better fork")
+	}
+
+	// A fork signed by an untrusted validator is rejected
+	carol := blockchain.NewWallet()
+	untrusted := blockchain.NewBlockchain(genesis)
+	addBlocks(t, untrusted, carol, 6)
+	source.chain = untrusted
+	if synced, err := client.Sync(); synced != 0 || err == nil {
+		t.Fatalf("Sync of an untrusted fork = %d, %v, want an error", synced, err)
+	}
+	if !bytes.Equal(client.Tip().Hash, fork.LastBlock().Hash) {
+		t.Fatal("the client adopted an untrusted fork")
+	}
+}
+
+func TestSyncRejectsDeepFork(t *testing.T) {
+	alice := blockchain.NewWallet()
+	bob := blockchain.NewWallet()
+	genesis := blockchain.NewGenesisBlock()
+	chain := blockchain.NewBlockchain(genesis)
+	addBlocks(t, chain, alice, blockchain.MaxReorgDepth+1)
+	source := &chainSource{chain: chain}
+	client := NewClient(source, genesis.Header(), nil)
+	if _, err := client.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	fork := blockchain.NewBlockchain(genesis)
+	addBlocks(t, fork, bob, blockchain.MaxReorgDepth+2)
+	source.chain = fork
+	if _, err := client.Sync(); !errors.Is(err, blockchain.ErrReorgTooDeep) {
+		t.Fatalf("Sync of a fork from genesis = %v, want %v", err, blockchain.ErrReorgTooDeep)
+	}
+}
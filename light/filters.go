@@ -0,0 +1,140 @@
+package light
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// Match is a transaction touching one of the scanned public keys.
+type Match struct {
+	Height      int
+	BlockHash   []byte
+	Transaction *blockchain.Transaction
+}
+
+// Scan finds the transactions touching any of the given public keys in the
+// synced chain, starting at height from, without revealing the keys.
+//
+// The client downloads the compact filter of every block, checks each one
+// against the filter header chain and the verified block header, and tests
+// the keys locally. Only blocks whose filter matches are downloaded in full;
+// their transactions are checked against the header's Merkle root before
+// being returned. Filter false positives simply yield no match.
+func (c *Client) Scan(keys [][]byte, from int) ([]*Match, error) {
+	var matches []*Match
+
+	tip := c.Tip().Height
+	for from <= tip {
+		filters, err := c.source.Filters(from, DefaultBatchSize)
+		if err != nil {
+			return matches, err
+		}
+		if len(filters) == 0 {
+			return matches, fmt.Errorf("source returned no filters at height %d", from)
+		}
+
+		// Fetch the filter headers from one before the batch so the first
+		// filter can be chained to its predecessor
+		headerFrom := from - 1
+		if headerFrom < 0 {
+			headerFrom = 0
+		}
+		filterHeaders, err := c.source.FilterHeaders(headerFrom, len(filters)+1)
+		if err != nil {
+			return matches, err
+		}
+
+		for i, filter := range filters {
+			if err := c.checkFilter(filter, from+i, headerFrom, filterHeaders); err != nil {
+				return matches, err
+			}
+
+			matched, err := filter.MatchAny(keys)
+			if err != nil {
+				return matches, err
+			}
+			if !matched {
+				continue
+			}
+
+			found, err := c.fetchMatches(filter.Height, keys)
+			if err != nil {
+				return matches, err
+			}
+			matches = append(matches, found...)
+		}
+		from += len(filters)
+	}
+	return matches, nil
+}
+
+// checkFilter verifies that a filter belongs to the verified header at height
+// and is consistent with the filter header chain.
+func (c *Client) checkFilter(filter *blockchain.BlockFilter, height int, headerFrom int, filterHeaders [][]byte) error {
+	header := c.Header(height)
+	if header == nil || filter.Height != height || !bytes.Equal(filter.BlockHash, header.Hash) {
+		return fmt.Errorf("filter at height %d does not match the verified header", height)
+	}
+
+	index := height - headerFrom
+	if index >= len(filterHeaders) {
+		return fmt.Errorf("missing filter header at height %d", height)
+	}
+	prev := make([]byte, 32)
+	if height > 0 {
+		prev = filterHeaders[index-1]
+	}
+	if !bytes.Equal(blockchain.FilterHeader(filter, prev), filterHeaders[index]) {
+		return fmt.Errorf("filter at height %d does not match its filter header", height)
+	}
+	return nil
+}
+
+// fetchMatches downloads the block at height, verifies it against the synced
+// header and returns its transactions touching any of the keys.
+func (c *Client) fetchMatches(height int, keys [][]byte) ([]*Match, error) {
+	header := c.Header(height)
+	block, err := c.source.BlockAt(height)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([][]byte, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		ids[i] = tx.ID
+	}
+	if !bytes.Equal(block.Hash, header.Hash) || !bytes.Equal(blockchain.MerkleRoot(ids), header.MerkleRoot) {
+		return nil, fmt.Errorf("block at height %d does not match the verified header", height)
+	}
+
+	var matches []*Match
+	for _, tx := range block.Transactions {
+		// The Merkle root only commits to IDs, so check each ID matches its contents
+		if !bytes.Equal(tx.HashTransaction(), tx.ID) {
+			return nil, fmt.Errorf("transaction %x at height %d does not match its ID", tx.ID, height)
+		}
+		if touchesAny(tx, keys) {
+			matches = append(matches, &Match{Height: height, BlockHash: block.Hash, Transaction: tx})
+		}
+	}
+	return matches, nil
+}
+
+// touchesAny reports whether a transaction spends from or pays to any of the keys.
+func touchesAny(tx *blockchain.Transaction, keys [][]byte) bool {
+	for _, key := range keys {
+		for _, input := range tx.Input {
+			if bytes.Equal(input.PublicKey, key) {
+				return true
+			}
+		}
+		for _, output := range tx.Output {
+			if bytes.Equal(output.PublicKey, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
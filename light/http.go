@@ -0,0 +1,96 @@
+package light
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// HTTPSource fetches headers and proofs from a full node's REST API.
+type HTTPSource struct {
+	BaseURL string       // Full node API root, e.g. "http://localhost:1323"
+	Client  *http.Client // HTTP client used for requests
+}
+
+// NewHTTPSource creates a source backed by the full node API at baseURL.
+func NewHTTPSource(baseURL string) *HTTPSource {
+	return &HTTPSource{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Headers requests up to limit headers starting at height from.
+func (s *HTTPSource) Headers(from int, limit int) ([]*blockchain.BlockHeader, error) {
+	var resp struct {
+		Headers []*blockchain.BlockHeader `json:"headers"`
+	}
+	err := s.get(fmt.Sprintf("%s/headers?from=%d&limit=%d", s.BaseURL, from, limit), &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Headers, nil
+}
+
+// TransactionProof requests the Merkle inclusion proof of a transaction.
+func (s *HTTPSource) TransactionProof(txID []byte) (*blockchain.MerkleProof, error) {
+	var proof blockchain.MerkleProof
+	err := s.get(fmt.Sprintf("%s/tx/%s/proof", s.BaseURL, hex.EncodeToString(txID)), &proof)
+	if err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
+// Filters requests up to limit compact block filters starting at height from.
+func (s *HTTPSource) Filters(from int, limit int) ([]*blockchain.BlockFilter, error) {
+	var resp struct {
+		Filters []*blockchain.BlockFilter `json:"filters"`
+	}
+	err := s.get(fmt.Sprintf("%s/filters?from=%d&limit=%d", s.BaseURL, from, limit), &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Filters, nil
+}
+
+// FilterHeaders requests up to limit filter headers starting at height from.
+func (s *HTTPSource) FilterHeaders(from int, limit int) ([][]byte, error) {
+	var resp struct {
+		Headers [][]byte `json:"headers"`
+	}
+	err := s.get(fmt.Sprintf("%s/filters/headers?from=%d&limit=%d", s.BaseURL, from, limit), &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Headers, nil
+}
+
+// BlockAt requests the full block at the given height.
+func (s *HTTPSource) BlockAt(height int) (*blockchain.Block, error) {
+	var block blockchain.Block
+	err := s.get(fmt.Sprintf("%s/blocks/%d", s.BaseURL, height), &block)
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// get performs a GET request and decodes the JSON response into out.
+func (s *HTTPSource) get(url string, out interface{}) error {
+	resp, err := s.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("full node returned %s for %s", resp.Status, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
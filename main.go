@@ -4,48 +4,87 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ignaciocorball/go-blockchain/api"
 	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/ignaciocorball/go-blockchain/light"
+	"github.com/ignaciocorball/go-blockchain/p2p"
 	"github.com/ignaciocorball/go-blockchain/storage"
 )
 
+// Command line flags controlling the API, storage and peer-to-peer layer
+var (
+	p2pListen   = flag.String("p2p", "", "address to accept peer connections on (e.g. :3000); empty disables networking")
+	peerList    = flag.String("peers", "", "comma separated peers to connect to, as <node id>@<host:port>")
+	apiAddr     = flag.String("api", ":1323", "address the REST API listens on")
+	dbPath      = flag.String("db", "./storage/badger", "directory of the Badger database")
+	nodeKeyPath = flag.String("nodekey", "./storage/nodekey", "file holding the node's persistent identity key")
+	lightSource = flag.String("light", "", "run as a light node that follows the full node API at this URL (e.g. http://localhost:1323)")
+	validators  = flag.String("validators", "", "comma separated hex public keys of the validators whose blocks are trusted, besides a full node's own validator")
+)
+
+// lightSyncInterval is how often a light node polls its full node for new headers.
+const lightSyncInterval = 5 * time.Second
+
 // main initializes and starts the UFChain blockchain node.
 // The function performs the following steps in order:
-// 1. Creates a genesis block to initialize the blockchain
+// 1. Creates the genesis block to initialize the blockchain
 // 2. Initializes the blockchain with the genesis block
 // 3. Sets up the Badger database for persistent storage
-// 4. Persists the genesis block to the database
-// 5. Starts the API server to handle external requests
+// 4. Replays the blocks persisted by a previous run, or persists the genesis block
+// 5. Trusts blocks signed by the node's validator and the -validators keys
+// 6. Starts the p2p node, if enabled, to gossip blocks with peers
+// 7. Starts the API server to handle external requests
+//
+// With -light the node instead runs as a light client: see runLightNode.
 //
 // The genesis block is special as it:
 //   - Has no transactions
 //   - Has no previous block hash
 //   - Is created by a special genesis validator
+//   - Has a fixed timestamp, so every node shares the same genesis hash
 //
 // The database is configured to store blocks in "./storage/badger"
 // and is properly closed when the application exits.
 //
-// The API server runs on the default port (1323) and provides
+// Peer connections are authenticated with the node key stored at -nodekey
+// and encrypted; peers are addressed by their node ID, not their IP.
+//
+// The API server runs on the -api address (port 1323 by default) and provides
 // endpoints for blockchain operations.
 func main() {
+	flag.Parse()
+
+	if *lightSource != "" {
+		runLightNode()
+		return
+	}
+
 	// Create the genesis block with:
 	// - Empty transaction list
 	// - Empty previous hash
 	// - Special genesis validator
-	genesisBlock := blockchain.NewBlock([]*blockchain.Transaction{}, []byte{}, []byte("genesis-validator"))
+	genesisBlock := blockchain.NewGenesisBlock()
 
 	// Initialize the blockchain with the genesis block
 	bc := blockchain.NewBlockchain(genesisBlock)
 
 	// Initialize the Badger database for persistent storage
-	// The database will be stored in the ./storage/badger directory
-	db := storage.OpenDB("./storage/badger")
+	// The database will be stored in the -db directory (./storage/badger by default)
+	db := storage.OpenDB(*dbPath)
+
+	// Keep the contract state tree in the database
+	bc.SetStateStore(db.StateNodes())
 
 	// Configurar el manejo de señales para un cierre limpio
 	sigChan := make(chan os.Signal, 1)
@@ -59,17 +98,185 @@ func main() {
 		os.Exit(0)
 	}()
 
-	// Persist the genesis block to the database
+	// Restore the chain saved by a previous run, or persist the genesis block
 	// This ensures the blockchain can be recovered if the application restarts
-	err := db.SaveBlock(genesisBlock)
+	err := loadChain(bc, db)
 	if err != nil {
-		log.Printf("Error saving genesis block: %v", err)
+		log.Printf("Error loading blockchain: %v", err)
+		db.CloseDB()
+		os.Exit(1)
+	}
+
+	// Load the wallet this node signs its blocks with
+	validator, err := loadValidator(db)
+	if err != nil {
+		log.Printf("Error loading validator wallet: %v", err)
+		db.CloseDB()
+		os.Exit(1)
+	}
+	fmt.Printf("Validador %x\n", validator.PublicKey)
+
+	// Only accept blocks signed by this node's validator and the trusted ones
+	trusted, err := trustedValidators()
+	if err != nil {
+		log.Printf("Error parsing -validators: %v", err)
+		db.CloseDB()
+		os.Exit(1)
+	}
+	bc.SetValidators(append(trusted, validator.PublicKey))
+
+	// Start the p2p node so blocks are gossiped with the configured peers
+	node, err := startNode(bc, db)
+	if err != nil {
+		log.Printf("Error starting p2p node: %v", err)
 		db.CloseDB()
 		os.Exit(1)
 	}
 
 	// Start the API server with the blockchain and database instances
 	// This will begin listening for incoming requests
-	fmt.Println("Iniciando servidor en http://localhost:1323")
-	api.StartServer(bc, db)
+	fmt.Printf("Iniciando servidor en http://localhost%s\n", *apiAddr)
+	api.StartServer(*apiAddr, bc, db, node, validator)
+}
+
+// loadChain replays the blocks saved by a previous run on top of the genesis
+// block, which rebuilds the UTXO set, the contracts with their state and
+// schedules, and the state root, then rebuilds the NFT indexes from the
+// replayed receipts. On first start it saves the genesis block.
+//
+// The stored blocks are replayed before the validator set is configured:
+// they were validated when they were added, and the node must be able to
+// restart with a different -validators list.
+func loadChain(bc *blockchain.Blockchain, db *storage.BlockchainDB) error {
+	blocks, err := db.LoadChain()
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		return db.SaveBlock(bc.LastBlock())
+	}
+	if !bytes.Equal(blocks[0].Hash, bc.LastBlock().Hash) {
+		return fmt.Errorf("stored chain starts at block %x, not at the genesis block", blocks[0].Hash)
+	}
+
+	if _, _, err := bc.ImportBlocks(blocks[1:]); err != nil {
+		return fmt.Errorf("replaying stored blocks: %v", err)
+	}
+	if err := db.RebuildNFTIndexes(bc); err != nil {
+		return fmt.Errorf("rebuilding NFT indexes: %v", err)
+	}
+	fmt.Printf("Cadena restaurada, altura %d\n", bc.LastBlock().Height)
+	return nil
+}
+
+// loadValidator returns the wallet used to sign blocks, creating and
+// persisting it on first start.
+func loadValidator(db *storage.BlockchainDB) (*blockchain.Wallet, error) {
+	wallet, err := db.GetWallet("validator")
+	if err == nil {
+		return wallet, nil
+	}
+
+	wallet = blockchain.NewWallet()
+	if err := db.SaveWallet("validator", wallet); err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// runLightNode runs the node as a light client.
+// A light node keeps no database: it syncs block headers from the full node
+// given with -light, verifies their linkage and their signatures by the
+// -validators, which are required, and serves transaction inclusion checks backed by
+// Merkle proofs on the -api address.
+func runLightNode() {
+	trusted, err := trustedValidators()
+	if err != nil {
+		log.Fatalf("Error parsing -validators: %v", err)
+	}
+	if len(trusted) == 0 {
+		log.Fatalf("A light node needs -validators: without them headers signed by any key would be accepted")
+	}
+
+	client := light.NewClient(light.NewHTTPSource(*lightSource), blockchain.NewGenesisBlock().Header(), trusted)
+
+	// Keep following the full node in the background
+	go func() {
+		for {
+			synced, err := client.Sync()
+			if err != nil {
+				log.Printf("Error syncing headers: %v", err)
+			} else if synced > 0 {
+				fmt.Printf("Sincronizados %d encabezados, altura %d\n", synced, client.Tip().Height)
+			}
+			time.Sleep(lightSyncInterval)
+		}
+	}()
+
+	fmt.Printf("Nodo ligero iniciando servidor en http://localhost%s\n", *apiAddr)
+	api.StartLightServer(*apiAddr, client)
+}
+
+// trustedValidators parses the public keys given with -validators.
+func trustedValidators() ([][]byte, error) {
+	var trusted [][]byte
+	for _, v := range strings.Split(*validators, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		key, err := hex.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validator public key %q: %v", v, err)
+		}
+		trusted = append(trusted, key)
+	}
+	return trusted, nil
+}
+
+// startNode loads the node identity and connects to the configured peers.
+// Returns nil (and no error) when networking is disabled.
+func startNode(bc *blockchain.Blockchain, db *storage.BlockchainDB) (*p2p.Node, error) {
+	if *p2pListen == "" && *peerList == "" {
+		return nil, nil
+	}
+
+	key, err := p2p.LoadOrCreateNodeKey(*nodeKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	node := p2p.NewNode(key, p2p.NewTCPTransport(key), bc)
+	node.OnBlock = func(block *blockchain.Block) {
+		if err := db.SaveBlock(block); err != nil {
+			log.Printf("Error saving block from peer: %v", err)
+		}
+		if err := db.IndexBlockNFTs(bc, block); err != nil {
+			log.Printf("Error indexing NFTs of block from peer: %v", err)
+		}
+	}
+	node.OnDisconnect = func(block *blockchain.Block) {
+		if err := db.UndoBlockNFTs(block); err != nil {
+			log.Printf("Error undoing NFTs of disconnected block: %v", err)
+		}
+	}
+
+	if *p2pListen != "" {
+		if err := node.Listen(*p2pListen); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Nodo p2p %s@%s\n", node.ID(), node.Addr())
+	}
+
+	for _, peer := range strings.Split(*peerList, ",") {
+		peer = strings.TrimSpace(peer)
+		if peer == "" {
+			continue
+		}
+		if err := node.Connect(peer); err != nil {
+			log.Printf("Error connecting to peer %s: %v", peer, err)
+		}
+	}
+
+	return node, nil
 }
@@ -0,0 +1,175 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"time"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// ShortIDSize is the length in bytes of a short transaction ID.
+const ShortIDSize = 6
+
+// ShortID is an abbreviated transaction ID used in compact blocks.
+// Short IDs are salted with the block hash, so an attacker cannot precompute
+// transactions whose short IDs collide across every block.
+type ShortID [ShortIDSize]byte
+
+// shortTxID computes the short ID of a transaction within a given block.
+func shortTxID(blockHash []byte, txID []byte) ShortID {
+	var id ShortID
+	sum := sha256.Sum256(append(append([]byte{}, blockHash...), txID...))
+	copy(id[:], sum[:ShortIDSize])
+	return id
+}
+
+// CompactBlock announces a block without the transactions peers most likely
+// already hold in their mempools.
+// It contains:
+//   - Header: The block with its transaction list stripped
+//   - ShortIDs: One short ID per transaction, in block order
+//   - Prefilled: Transactions sent in full because peers cannot have them
+//     (e.g. token generation transactions, which are never gossiped)
+type CompactBlock struct {
+	Header    *blockchain.Block
+	ShortIDs  []ShortID
+	Prefilled []PrefilledTransaction
+}
+
+// PrefilledTransaction is a transaction sent in full inside a compact block.
+type PrefilledTransaction struct {
+	Index int                     // Position of the transaction in the block
+	Tx    *blockchain.Transaction // The full transaction
+}
+
+// BlockTxnRequest asks a peer for the transactions of a compact block that
+// could not be found in the local mempool.
+type BlockTxnRequest struct {
+	BlockHash []byte
+	Indexes   []int
+}
+
+// BlockTxn answers a BlockTxnRequest with the requested transactions, in
+// the order they were requested.
+type BlockTxn struct {
+	BlockHash    []byte
+	Transactions []*blockchain.Transaction
+}
+
+// NewCompactBlock builds the compact announcement of a block.
+// Transactions without inputs are prefilled; every other transaction is
+// replaced by its short ID.
+func NewCompactBlock(block *blockchain.Block) *CompactBlock {
+	header := *block
+	header.Transactions = nil
+
+	cb := &CompactBlock{Header: &header}
+	for i, tx := range block.Transactions {
+		if len(tx.Input) == 0 {
+			cb.Prefilled = append(cb.Prefilled, PrefilledTransaction{Index: i, Tx: tx})
+			continue
+		}
+		cb.ShortIDs = append(cb.ShortIDs, shortTxID(block.Hash, tx.ID))
+	}
+	return cb
+}
+
+// partialBlock is a compact block being reconstructed.
+type partialBlock struct {
+	header   *blockchain.Block
+	txs      []*blockchain.Transaction // Block transactions; nil entries are still missing
+	missing  []int                     // Indexes of the missing transactions
+	from     NodeID                    // Peer that announced the block
+	received time.Time                 // When the block was announced, by the chain's clock
+}
+
+// reconstruct fills in as many transactions of the compact block as possible
+// from the mempool. Short IDs matching more than one pending transaction are
+// treated as missing rather than guessed.
+func (cb *CompactBlock) reconstruct(pool *blockchain.Mempool) *partialBlock {
+	total := len(cb.ShortIDs) + len(cb.Prefilled)
+	pb := &partialBlock{
+		header: cb.Header,
+		txs:    make([]*blockchain.Transaction, total),
+	}
+
+	prefilled := make(map[int]bool, len(cb.Prefilled))
+	for _, p := range cb.Prefilled {
+		if p.Index < 0 || p.Index >= total || p.Tx == nil {
+			continue
+		}
+		pb.txs[p.Index] = p.Tx
+		prefilled[p.Index] = true
+	}
+
+	// Index the mempool by short ID for this block
+	candidates := make(map[ShortID]*blockchain.Transaction)
+	ambiguous := make(map[ShortID]bool)
+	if pool != nil {
+		for _, tx := range pool.Transactions() {
+			id := shortTxID(cb.Header.Hash, tx.ID)
+			if _, ok := candidates[id]; ok {
+				ambiguous[id] = true
+			}
+			candidates[id] = tx
+		}
+	}
+
+	next := 0
+	for i := 0; i < total; i++ {
+		if prefilled[i] {
+			continue
+		}
+		if next >= len(cb.ShortIDs) {
+			pb.missing = append(pb.missing, i)
+			continue
+		}
+		id := cb.ShortIDs[next]
+		next++
+		if tx, ok := candidates[id]; ok && !ambiguous[id] {
+			pb.txs[i] = tx
+		} else {
+			pb.missing = append(pb.missing, i)
+		}
+	}
+	return pb
+}
+
+// fill inserts transactions received in a BlockTxn message.
+// Returns false if the response does not match the outstanding request.
+func (pb *partialBlock) fill(txs []*blockchain.Transaction) bool {
+	if len(txs) != len(pb.missing) {
+		return false
+	}
+	for i, index := range pb.missing {
+		if txs[i] == nil {
+			return false
+		}
+		pb.txs[index] = txs[i]
+	}
+	pb.missing = nil
+	return true
+}
+
+// block assembles the full block once no transaction is missing.
+func (pb *partialBlock) block() *blockchain.Block {
+	block := *pb.header
+	block.Transactions = pb.txs
+	return &block
+}
+
+// encodePayload gob-encodes a message payload.
+func encodePayload(v interface{}) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// decodePayload gob-decodes a message payload received from a peer.
+func decodePayload(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
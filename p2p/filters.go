@@ -0,0 +1,73 @@
+package p2p
+
+import (
+	"fmt"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// maxFiltersPerMessage caps the number of filters or filtered blocks
+// returned in a single message.
+const maxFiltersPerMessage = 1000
+
+// FiltersRequest asks a peer for compact block filters and their headers.
+type FiltersRequest struct {
+	From  int // Height of the first filter
+	Limit int // Maximum number of filters
+}
+
+// Filters answers a FiltersRequest. Headers[i] is the filter header of Filters[i].
+type Filters struct {
+	Filters []*blockchain.BlockFilter
+	Headers [][]byte
+}
+
+// FilteredBlocksRequest asks a peer for the transactions of a height range
+// that match a Bloom filter.
+type FilteredBlocksRequest struct {
+	From   int
+	Limit  int
+	Filter *blockchain.BloomFilter
+}
+
+// FilteredBlocks answers a FilteredBlocksRequest.
+type FilteredBlocks struct {
+	Blocks []*blockchain.FilteredBlock
+}
+
+// clampLimit bounds the number of items a peer may request at once.
+func clampLimit(limit int) int {
+	if limit <= 0 || limit > maxFiltersPerMessage {
+		return maxFiltersPerMessage
+	}
+	return limit
+}
+
+// handleGetFilters serves compact block filters to a peer.
+func (n *Node) handleGetFilters(from Conn, msg *Message) error {
+	var req FiltersRequest
+	if err := decodePayload(msg.Payload, &req); err != nil {
+		return fmt.Errorf("invalid filters request: %v", err)
+	}
+
+	limit := clampLimit(req.Limit)
+	resp := &Filters{
+		Filters: n.Chain.Filters(req.From, limit),
+		Headers: n.Chain.FilterHeaders(req.From, limit),
+	}
+	return from.Send(&Message{Type: MsgFilters, Payload: encodePayload(resp)})
+}
+
+// handleGetFilteredBlocks serves blocks filtered by a peer's Bloom filter.
+func (n *Node) handleGetFilteredBlocks(from Conn, msg *Message) error {
+	var req FilteredBlocksRequest
+	if err := decodePayload(msg.Payload, &req); err != nil || req.Filter == nil {
+		return fmt.Errorf("invalid filtered blocks request: %v", err)
+	}
+	if err := req.Filter.Validate(); err != nil {
+		return err
+	}
+
+	resp := &FilteredBlocks{Blocks: n.Chain.FilteredBlocks(req.From, clampLimit(req.Limit), req.Filter)}
+	return from.Send(&Message{Type: MsgFilteredBlocks, Payload: encodePayload(resp)})
+}
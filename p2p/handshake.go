@@ -0,0 +1,275 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// protocolName is mixed into every key derivation and signature so that
+// handshake material can never be replayed against another protocol.
+const protocolName = "ufchain-p2p-v1"
+
+// maxFrameSize bounds the size of a single encrypted frame.
+const maxFrameSize = 16 << 20 // 16MB
+
+// maxHandshakeFrameSize bounds the size of the frames read before the remote
+// side is authenticated, which only carry its identity proof.
+const maxHandshakeFrameSize = 1 << 10 // 1KB
+
+// handshakeTimeout bounds how long a peer may take to complete the handshake.
+const handshakeTimeout = 10 * time.Second
+
+// Handshake roles, signed alongside the transcript so that a signature
+// produced by one side can never be reflected back as the other side's.
+const (
+	roleInitiator byte = 'I'
+	roleResponder byte = 'R'
+)
+
+var (
+	// ErrUnexpectedPeer is returned when the remote node proves ownership of
+	// a different key than the one pinned for the connection.
+	ErrUnexpectedPeer = errors.New("remote node key does not match pinned id")
+
+	// ErrHandshakeFailed is returned when the remote side cannot prove
+	// ownership of the identity key it presented.
+	ErrHandshakeFailed = errors.New("handshake authentication failed")
+)
+
+// secureConn is an authenticated, encrypted connection to a peer.
+// Every frame is sealed with AES-256-GCM using a per-direction key and a
+// monotonically increasing nonce, so frames cannot be forged, reordered or replayed.
+type secureConn struct {
+	conn     net.Conn
+	remoteID NodeID
+
+	sendMu    sync.Mutex
+	sendAEAD  cipher.AEAD
+	sendNonce uint64
+
+	recvMu    sync.Mutex
+	recvAEAD  cipher.AEAD
+	recvNonce uint64
+	recvLimit int // Largest frame accepted; maxHandshakeFrameSize until the handshake completes
+}
+
+// Handshake authenticates a raw connection and upgrades it to an encrypted one.
+// Parameters:
+//   - conn: The freshly established network connection
+//   - key: The local node's identity key
+//   - initiator: Whether the local node dialed the connection
+//   - pinned: The node ID the remote side must prove, or nil to accept any identity
+//
+// The protocol is a signed ephemeral Diffie-Hellman exchange:
+//  1. Both sides exchange ephemeral X25519 public keys
+//  2. Session keys are derived from the shared secret with HKDF-SHA256
+//  3. Each side sends, encrypted, its static Ed25519 key and a signature over
+//     the transcript hash and its role
+//  4. Each side verifies the signature and, if requested, the pinned node ID
+//
+// Returns the encrypted connection, or an error if the remote side could not be
+// authenticated. The underlying connection is left open on error.
+func Handshake(conn net.Conn, key *NodeKey, initiator bool, pinned *NodeID) (Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exchange ephemeral keys, initiator first
+	localEph := ephemeral.PublicKey().Bytes()
+	remoteEph := make([]byte, len(localEph))
+	if initiator {
+		if _, err := conn.Write(localEph); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, remoteEph); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := io.ReadFull(conn, remoteEph); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(localEph); err != nil {
+			return nil, err
+		}
+	}
+
+	remotePub, err := ecdh.X25519().NewPublicKey(remoteEph)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %v", err)
+	}
+	shared, err := ephemeral.ECDH(remotePub)
+	if err != nil {
+		return nil, err
+	}
+
+	initEph, respEph := localEph, remoteEph
+	localRole, remoteRole := roleInitiator, roleResponder
+	if !initiator {
+		initEph, respEph = remoteEph, localEph
+		localRole, remoteRole = roleResponder, roleInitiator
+	}
+
+	transcript := sha256.Sum256(bytes.Join([][]byte{[]byte(protocolName), initEph, respEph}, nil))
+	keys, err := hkdf.Key(sha256.New, shared, transcript[:], protocolName, 64)
+	if err != nil {
+		return nil, err
+	}
+	initKey, respKey := keys[:32], keys[32:]
+
+	sc := &secureConn{conn: conn, recvLimit: maxHandshakeFrameSize}
+	if initiator {
+		sc.sendAEAD, err = newAEAD(initKey)
+		if err == nil {
+			sc.recvAEAD, err = newAEAD(respKey)
+		}
+	} else {
+		sc.sendAEAD, err = newAEAD(respKey)
+		if err == nil {
+			sc.recvAEAD, err = newAEAD(initKey)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Prove ownership of the static identity key
+	localID := key.ID()
+	proof := append(localID[:], key.Sign(append(transcript[:], localRole))...)
+	if err := sc.writeFrame(proof); err != nil {
+		return nil, err
+	}
+
+	remoteProof, err := sc.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if len(remoteProof) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return nil, ErrHandshakeFailed
+	}
+	copy(sc.remoteID[:], remoteProof[:ed25519.PublicKeySize])
+	signature := remoteProof[ed25519.PublicKeySize:]
+	if !ed25519.Verify(sc.remoteID[:], append(transcript[:], remoteRole), signature) {
+		return nil, ErrHandshakeFailed
+	}
+	if pinned != nil && sc.remoteID != *pinned {
+		return nil, ErrUnexpectedPeer
+	}
+
+	sc.recvLimit = maxFrameSize
+	return sc, nil
+}
+
+// newAEAD creates an AES-256-GCM cipher from a 32-byte key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// frameNonce converts a frame counter into a GCM nonce.
+func frameNonce(counter uint64, size int) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-8:], counter)
+	return nonce
+}
+
+// writeFrame encrypts and writes a single length-prefixed frame. The nonce
+// is only consumed by a frame that is written, so that a frame too large to
+// send does not desynchronize the peer's nonce.
+func (sc *secureConn) writeFrame(plaintext []byte) error {
+	sc.sendMu.Lock()
+	defer sc.sendMu.Unlock()
+
+	if size := len(plaintext) + sc.sendAEAD.Overhead(); size > maxFrameSize {
+		return fmt.Errorf("frame too large: %d bytes", size)
+	}
+	sealed := sc.sendAEAD.Seal(nil, frameNonce(sc.sendNonce, sc.sendAEAD.NonceSize()), plaintext, nil)
+
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(sealed)))
+	if _, err := sc.conn.Write(append(header, sealed...)); err != nil {
+		return err
+	}
+	sc.sendNonce++
+	return nil
+}
+
+// readFrame reads and decrypts a single length-prefixed frame.
+func (sc *secureConn) readFrame() ([]byte, error) {
+	sc.recvMu.Lock()
+	defer sc.recvMu.Unlock()
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(sc.conn, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > uint32(sc.recvLimit) {
+		return nil, fmt.Errorf("frame too large: %d bytes", size)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(sc.conn, sealed); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := sc.recvAEAD.Open(nil, frameNonce(sc.recvNonce, sc.recvAEAD.NonceSize()), sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting frame: %v", err)
+	}
+	sc.recvNonce++
+	return plaintext, nil
+}
+
+// Send encrypts and writes a message to the peer.
+func (sc *secureConn) Send(msg *Message) error {
+	return sc.writeFrame(append([]byte{byte(msg.Type)}, msg.Payload...))
+}
+
+// Receive reads and decrypts the next message from the peer.
+func (sc *secureConn) Receive() (*Message, error) {
+	frame, err := sc.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) == 0 {
+		return nil, errors.New("empty message frame")
+	}
+	return &Message{Type: MessageType(frame[0]), Payload: frame[1:]}, nil
+}
+
+// RemoteID returns the authenticated identity of the peer.
+func (sc *secureConn) RemoteID() NodeID {
+	return sc.remoteID
+}
+
+// RemoteAddr returns the network address of the peer.
+func (sc *secureConn) RemoteAddr() string {
+	return sc.conn.RemoteAddr().String()
+}
+
+// Close closes the underlying connection.
+func (sc *secureConn) Close() error {
+	return sc.conn.Close()
+}
@@ -0,0 +1,107 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// tcpPair returns both ends of a loopback TCP connection, which, unlike
+// net.Pipe, buffers writes as the handshake expects.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	a, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	b, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	t.Cleanup(func() { a.Close(); b.Close() })
+	return a, b
+}
+
+// handshakePair authenticates both ends of a loopback connection.
+func handshakePair(t *testing.T) (Conn, Conn) {
+	t.Helper()
+	initKey, err := GenerateNodeKey()
+	if err != nil {
+		t.Fatalf("GenerateNodeKey: %v", err)
+	}
+	respKey, err := GenerateNodeKey()
+	if err != nil {
+		t.Fatalf("GenerateNodeKey: %v", err)
+	}
+	a, b := tcpPair(t)
+
+	type result struct {
+		conn Conn
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		conn, err := Handshake(b, respKey, false, nil)
+		done <- result{conn, err}
+	}()
+	initiator, err := Handshake(a, initKey, true, nil)
+	if err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+	resp := <-done
+	if resp.err != nil {
+		t.Fatalf("Handshake: %v", resp.err)
+	}
+	return initiator, resp.conn
+}
+
+func TestHandshakeRejectsLargeFramesBeforeAuthentication(t *testing.T) {
+	key, err := GenerateNodeKey()
+	if err != nil {
+		t.Fatalf("GenerateNodeKey: %v", err)
+	}
+	a, b := tcpPair(t)
+
+	done := make(chan error)
+	go func() {
+		_, err := Handshake(b, key, false, nil)
+		done <- err
+	}()
+
+	// An unauthenticated peer announces a frame far above the proof's size
+	// right after the key exchange
+	eph := make([]byte, 32)
+	eph[0] = 9
+	if _, err := a.Write(eph); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, maxFrameSize)
+	a.Write(header)
+
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "frame too large") {
+		t.Fatalf("Handshake = %v, want a frame too large", err)
+	}
+}
+
+func TestSecureConnKeepsNonceOnOversizedFrame(t *testing.T) {
+	sender, receiver := handshakePair(t)
+
+	if err := sender.Send(&Message{Type: MsgTx, Payload: make([]byte, maxFrameSize)}); err == nil {
+		t.Fatal("Send of an oversized frame succeeded")
+	}
+	go sender.Send(&Message{Type: MsgTx, Payload: []byte("next")})
+	msg, err := receiver.Receive()
+	if err != nil {
+		t.Fatalf("Receive after an oversized frame: %v", err)
+	}
+	if msg.Type != MsgTx || string(msg.Payload) != "next" {
+		t.Fatalf("Receive = %+v, want the next frame", msg)
+	}
+}
@@ -0,0 +1,119 @@
+// Package p2p implements the node-to-node networking layer for the UFChain blockchain.
+// Every node owns a persistent Ed25519 identity key; peers are identified by the
+// public half of that key rather than by their network address, and every
+// connection is authenticated and encrypted before any blockchain data is exchanged.
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NodeID identifies a node on the network. It is the node's Ed25519 public key.
+type NodeID [ed25519.PublicKeySize]byte
+
+// String returns the hex encoding of the node ID.
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// ParseNodeID decodes a hex-encoded node ID.
+func ParseNodeID(s string) (NodeID, error) {
+	var id NodeID
+
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return id, fmt.Errorf("invalid node id: %v", err)
+	}
+	if len(raw) != len(id) {
+		return id, fmt.Errorf("invalid node id length: %d", len(raw))
+	}
+	copy(id[:], raw)
+	return id, nil
+}
+
+// ParsePeerAddress splits a peer address of the form "<node id>@<host:port>".
+// The node ID is pinned: a connection to the address only succeeds if the
+// remote side proves ownership of that key during the handshake.
+func ParsePeerAddress(s string) (NodeID, string, error) {
+	idStr, addr, ok := strings.Cut(s, "@")
+	if !ok || addr == "" {
+		return NodeID{}, "", fmt.Errorf("invalid peer address %q: expected <node id>@<host:port>", s)
+	}
+	id, err := ParseNodeID(idStr)
+	if err != nil {
+		return NodeID{}, "", err
+	}
+	return id, addr, nil
+}
+
+// NodeKey is the long-term identity key of a node.
+type NodeKey struct {
+	PrivateKey ed25519.PrivateKey
+}
+
+// GenerateNodeKey creates a fresh random identity key.
+func GenerateNodeKey() (*NodeKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeKey{PrivateKey: priv}, nil
+}
+
+// LoadOrCreateNodeKey reads the identity key stored at path, generating and
+// persisting a new one if the file does not exist yet. The key is stored as
+// the hex encoding of the Ed25519 seed with owner-only permissions.
+func LoadOrCreateNodeKey(path string) (*NodeKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid node key file %s", path)
+		}
+		return &NodeKey{PrivateKey: ed25519.NewKeyFromSeed(seed)}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading node key: %v", err)
+	}
+
+	key, err := GenerateNodeKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("error creating node key directory: %v", err)
+	}
+	seed := hex.EncodeToString(key.PrivateKey.Seed())
+	if err := os.WriteFile(path, []byte(seed), 0o600); err != nil {
+		return nil, fmt.Errorf("error saving node key: %v", err)
+	}
+	return key, nil
+}
+
+// ID returns the node ID derived from the key.
+func (k *NodeKey) ID() NodeID {
+	var id NodeID
+	copy(id[:], k.PrivateKey.Public().(ed25519.PublicKey))
+	return id
+}
+
+// Sign signs data with the identity key.
+func (k *NodeKey) Sign(data []byte) []byte {
+	return ed25519.Sign(k.PrivateKey, data)
+}
+
+// NodeKeyFromSeed derives an identity key from a 32-byte seed. It is meant
+// for reproducible setups such as simulated networks, not for real nodes.
+func NodeKeyFromSeed(seed []byte) (*NodeKey, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid seed length: %d", len(seed))
+	}
+	return &NodeKey{PrivateKey: ed25519.NewKeyFromSeed(seed)}, nil
+}
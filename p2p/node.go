@@ -0,0 +1,502 @@
+package p2p
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// Limits on compact blocks waiting for transactions. A peer can announce
+// blocks whose transactions it never sends, so the entries are bounded per
+// peer and in total, expire, and are dropped when their peer disconnects.
+const (
+	maxPendingPerPeer = 8                // Pending compact blocks per announcing peer
+	maxPendingBlocks  = 64               // Pending compact blocks in total
+	pendingBlockTTL   = 30 * time.Second // How long a compact block may wait for its transactions
+)
+
+// Node gossips blocks and transactions with its peers over an authenticated
+// Transport. Peers are tracked by node ID, so a peer that reconnects from a
+// different address is still recognized as the same node.
+//
+// Blocks are relayed as compact blocks: receivers rebuild them from their
+// mempool and only request the transactions they have not seen.
+type Node struct {
+	Key       *NodeKey
+	Transport Transport
+	Chain     *blockchain.Blockchain
+	Mempool   *blockchain.Mempool
+
+	// OnBlock, if set, is called for every block accepted from a peer,
+	// e.g. to persist it.
+	OnBlock func(block *blockchain.Block)
+
+	// OnDisconnect, if set, is called for every block a reorganization
+	// removes from the chain, tip first, before OnBlock is called for the
+	// blocks replacing them.
+	OnDisconnect func(block *blockchain.Block)
+
+	mu       sync.Mutex
+	peers    map[NodeID]Conn
+	pending  map[string]*partialBlock // Compact blocks waiting for transactions, key = hex block hash
+	listener Listener
+	closed   bool
+}
+
+// NewNode creates a node that gossips blocks for chain over transport.
+func NewNode(key *NodeKey, transport Transport, chain *blockchain.Blockchain) *Node {
+	return &Node{
+		Key:       key,
+		Transport: transport,
+		Chain:     chain,
+		Mempool:   blockchain.NewMempool(chain, blockchain.DefaultMempoolSize),
+		peers:     make(map[NodeID]Conn),
+		pending:   make(map[string]*partialBlock),
+	}
+}
+
+// ID returns the node's identity.
+func (n *Node) ID() NodeID {
+	return n.Key.ID()
+}
+
+// Listen starts accepting inbound peer connections on addr.
+func (n *Node) Listen(addr string) error {
+	ln, err := n.Transport.Listen(addr)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.listener = ln
+	n.mu.Unlock()
+
+	go n.acceptLoop(ln)
+	return nil
+}
+
+// Addr returns the address the node is listening on, or "" if it is not listening.
+func (n *Node) Addr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.listener == nil {
+		return ""
+	}
+	return n.listener.Addr()
+}
+
+// acceptLoop registers every authenticated inbound connection as a peer.
+func (n *Node) acceptLoop(ln Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var hsErr *HandshakeError
+			if errors.As(err, &hsErr) {
+				log.Printf("p2p: %v", err)
+				continue
+			}
+			return
+		}
+		n.addPeer(conn)
+	}
+}
+
+// Connect dials a peer given as "<node id>@<host:port>".
+// The connection is rejected unless the remote node proves ownership of the given ID.
+func (n *Node) Connect(peerAddr string) error {
+	id, addr, err := ParsePeerAddress(peerAddr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := n.Transport.Dial(addr, id)
+	if err != nil {
+		return err
+	}
+	n.addPeer(conn)
+	return nil
+}
+
+// addPeer registers a connection and starts reading from it.
+// A second connection to an already connected node replaces the first one.
+func (n *Node) addPeer(conn Conn) {
+	id := conn.RemoteID()
+	if id == n.ID() {
+		conn.Close()
+		return
+	}
+
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		conn.Close()
+		return
+	}
+	if old, ok := n.peers[id]; ok {
+		old.Close()
+	}
+	n.peers[id] = conn
+	n.mu.Unlock()
+
+	// Exchange chain tips so whichever side is behind catches up
+	if err := n.sendStatus(conn); err != nil {
+		log.Printf("p2p: error sending status to %s: %v", id, err)
+	}
+
+	go n.readLoop(conn)
+}
+
+// removePeer forgets a connection if it is still the registered one for its node.
+func (n *Node) removePeer(conn Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.peers[conn.RemoteID()] == conn {
+		delete(n.peers, conn.RemoteID())
+		n.dropPending(conn.RemoteID())
+	}
+	conn.Close()
+}
+
+// Peers returns the IDs of all connected peers.
+func (n *Node) Peers() []NodeID {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ids := make([]NodeID, 0, len(n.peers))
+	for id := range n.peers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// readLoop dispatches messages from a peer until the connection fails.
+func (n *Node) readLoop(conn Conn) {
+	defer n.removePeer(conn)
+
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			return
+		}
+		n.handleMessage(conn, msg)
+	}
+}
+
+// handleMessage processes a single message received from a peer.
+func (n *Node) handleMessage(from Conn, msg *Message) {
+	var err error
+
+	switch msg.Type {
+	case MsgBlock:
+		err = n.handleBlock(from, msg)
+	case MsgTx:
+		err = n.handleTx(from, msg)
+	case MsgCompactBlock:
+		err = n.handleCompactBlock(from, msg)
+	case MsgGetBlockTxn:
+		err = n.handleGetBlockTxn(from, msg)
+	case MsgBlockTxn:
+		err = n.handleBlockTxn(from, msg)
+	case MsgGetBlock:
+		err = n.handleGetBlock(from, msg)
+	case MsgGetFilters:
+		err = n.handleGetFilters(from, msg)
+	case MsgGetFilteredBlocks:
+		err = n.handleGetFilteredBlocks(from, msg)
+	case MsgStatus:
+		err = n.handleStatus(from, msg)
+	case MsgGetBlocks:
+		err = n.handleGetBlocks(from, msg)
+	case MsgBlocks:
+		err = n.handleBlocks(from, msg)
+	default:
+		err = fmt.Errorf("unknown message type %d", msg.Type)
+	}
+
+	if err != nil {
+		log.Printf("p2p: message from %s: %v", from.RemoteID(), err)
+	}
+}
+
+// handleBlock processes a full block.
+func (n *Node) handleBlock(from Conn, msg *Message) error {
+	var block blockchain.Block
+	if err := decodePayload(msg.Payload, &block); err != nil {
+		return fmt.Errorf("invalid block: %v", err)
+	}
+
+	n.mu.Lock()
+	delete(n.pending, fmt.Sprintf("%x", block.Hash))
+	n.mu.Unlock()
+
+	return n.acceptBlock(from, &block)
+}
+
+// handleTx adds a gossiped transaction to the mempool and relays it if it is new.
+func (n *Node) handleTx(from Conn, msg *Message) error {
+	var tx blockchain.Transaction
+	if err := decodePayload(msg.Payload, &tx); err != nil {
+		return fmt.Errorf("invalid transaction: %v", err)
+	}
+
+	added, err := n.Mempool.Add(&tx)
+	if err != nil || !added {
+		return err
+	}
+	n.broadcast(msg, from.RemoteID())
+	return nil
+}
+
+// handleCompactBlock rebuilds an announced block from the mempool, asking
+// the announcing peer for any transaction that is missing.
+func (n *Node) handleCompactBlock(from Conn, msg *Message) error {
+	var cb CompactBlock
+	if err := decodePayload(msg.Payload, &cb); err != nil || cb.Header == nil {
+		return fmt.Errorf("invalid compact block: %v", err)
+	}
+	if _, err := n.Chain.GetBlock(cb.Header.Hash); err == nil {
+		return nil
+	}
+
+	// A block on another fork, or further ahead than the next block:
+	// download the peer's chain if it is better than ours
+	tip := n.Chain.LastBlock()
+	if !bytes.Equal(cb.Header.PrevHash, tip.Hash) {
+		if blockchain.IsBetterTip(cb.Header.Height, cb.Header.Hash, tip.Height, tip.Hash) {
+			return n.requestBlocks(from)
+		}
+		return nil
+	}
+
+	pb := cb.reconstruct(n.Mempool)
+	if len(pb.missing) == 0 {
+		return n.acceptReconstructed(from, pb)
+	}
+
+	pb.from = from.RemoteID()
+	pb.received = n.Chain.Clock()
+	n.mu.Lock()
+	n.addPending(fmt.Sprintf("%x", cb.Header.Hash), pb)
+	n.mu.Unlock()
+
+	return from.Send(&Message{
+		Type:    MsgGetBlockTxn,
+		Payload: encodePayload(&BlockTxnRequest{BlockHash: cb.Header.Hash, Indexes: pb.missing}),
+	})
+}
+
+// addPending records a compact block waiting for transactions. Expired
+// entries are dropped first; if the announcing peer or the node is still at
+// its limit, the oldest entry of the peer, or of all peers, is evicted.
+// The caller must hold n.mu.
+func (n *Node) addPending(key string, pb *partialBlock) {
+	for k, other := range n.pending {
+		if pb.received.Sub(other.received) > pendingBlockTTL {
+			delete(n.pending, k)
+		}
+	}
+
+	delete(n.pending, key)
+	fromPeer := 0
+	for _, other := range n.pending {
+		if other.from == pb.from {
+			fromPeer++
+		}
+	}
+	if fromPeer >= maxPendingPerPeer {
+		n.evictOldestPending(func(other *partialBlock) bool { return other.from == pb.from })
+	}
+	if len(n.pending) >= maxPendingBlocks {
+		n.evictOldestPending(func(*partialBlock) bool { return true })
+	}
+
+	n.pending[key] = pb
+}
+
+// evictOldestPending removes the oldest pending compact block matching match.
+// Ties are broken by block hash so eviction does not depend on map order.
+// The caller must hold n.mu.
+func (n *Node) evictOldestPending(match func(*partialBlock) bool) {
+	oldest := ""
+	for k, pb := range n.pending {
+		if !match(pb) {
+			continue
+		}
+		if oldest == "" || pb.received.Before(n.pending[oldest].received) ||
+			(pb.received.Equal(n.pending[oldest].received) && k < oldest) {
+			oldest = k
+		}
+	}
+	if oldest != "" {
+		delete(n.pending, oldest)
+	}
+}
+
+// dropPending forgets every compact block announced by a peer.
+// The caller must hold n.mu.
+func (n *Node) dropPending(id NodeID) {
+	for k, pb := range n.pending {
+		if pb.from == id {
+			delete(n.pending, k)
+		}
+	}
+}
+
+// handleGetBlockTxn answers a request for transactions of a block we announced.
+func (n *Node) handleGetBlockTxn(from Conn, msg *Message) error {
+	var req BlockTxnRequest
+	if err := decodePayload(msg.Payload, &req); err != nil {
+		return fmt.Errorf("invalid block transactions request: %v", err)
+	}
+
+	blocks, err := n.Chain.GetBlock(req.BlockHash)
+	if err != nil {
+		return fmt.Errorf("requested transactions of unknown block %x", req.BlockHash)
+	}
+	block := blocks[0]
+
+	resp := &BlockTxn{BlockHash: req.BlockHash}
+	for _, index := range req.Indexes {
+		if index < 0 || index >= len(block.Transactions) {
+			return fmt.Errorf("requested transaction %d out of range for block %x", index, req.BlockHash)
+		}
+		resp.Transactions = append(resp.Transactions, block.Transactions[index])
+	}
+
+	return from.Send(&Message{Type: MsgBlockTxn, Payload: encodePayload(resp)})
+}
+
+// handleBlockTxn completes a pending compact block with the transactions sent by a peer.
+func (n *Node) handleBlockTxn(from Conn, msg *Message) error {
+	var resp BlockTxn
+	if err := decodePayload(msg.Payload, &resp); err != nil {
+		return fmt.Errorf("invalid block transactions: %v", err)
+	}
+
+	key := fmt.Sprintf("%x", resp.BlockHash)
+	n.mu.Lock()
+	pb, ok := n.pending[key]
+	if ok && pb.from == from.RemoteID() {
+		delete(n.pending, key)
+	}
+	n.mu.Unlock()
+
+	if !ok || pb.from != from.RemoteID() {
+		return fmt.Errorf("unsolicited transactions for block %x", resp.BlockHash)
+	}
+	if !pb.fill(resp.Transactions) {
+		return n.requestFullBlock(from, resp.BlockHash)
+	}
+	return n.acceptReconstructed(from, pb)
+}
+
+// handleGetBlock answers a request for a full block.
+func (n *Node) handleGetBlock(from Conn, msg *Message) error {
+	blocks, err := n.Chain.GetBlock(msg.Payload)
+	if err != nil {
+		return fmt.Errorf("requested unknown block %x", msg.Payload)
+	}
+	return from.Send(&Message{Type: MsgBlock, Payload: blocks[0].Serialize()})
+}
+
+// acceptReconstructed accepts a fully reconstructed compact block.
+// If the reconstructed transactions do not match the Merkle root a short ID
+// collision picked the wrong transaction, so the full block is requested instead.
+func (n *Node) acceptReconstructed(from Conn, pb *partialBlock) error {
+	block := pb.block()
+	if _, err := n.Chain.GetBlock(block.Hash); err == nil {
+		return nil
+	}
+
+	err := n.acceptBlock(from, block)
+	if err != nil && errors.Is(err, blockchain.ErrInvalidMerkleRoot) {
+		return n.requestFullBlock(from, block.Hash)
+	}
+	return err
+}
+
+// requestFullBlock falls back to downloading a block in full.
+func (n *Node) requestFullBlock(from Conn, hash []byte) error {
+	return from.Send(&Message{Type: MsgGetBlock, Payload: hash})
+}
+
+// acceptBlock appends a block received from a peer and relays it to the others.
+// A block that does not extend our tip but would make a better chain
+// triggers a download of the peer's chain.
+func (n *Node) acceptBlock(from Conn, block *blockchain.Block) error {
+	err := n.Chain.AcceptBlock(block)
+	if err != nil {
+		if errors.Is(err, blockchain.ErrKnownBlock) {
+			return nil
+		}
+		if errors.Is(err, blockchain.ErrNotExtendingTip) {
+			tip := n.Chain.LastBlock()
+			if blockchain.IsBetterTip(block.Height, block.Hash, tip.Height, tip.Hash) {
+				return n.requestBlocks(from)
+			}
+			return nil
+		}
+		return fmt.Errorf("rejected block %x: %w", block.Hash, err)
+	}
+
+	n.Mempool.RemoveBlock(block)
+	if n.OnBlock != nil {
+		n.OnBlock(block)
+	}
+
+	// Relay newly accepted blocks so they propagate across the network
+	n.broadcast(&Message{Type: MsgCompactBlock, Payload: encodePayload(NewCompactBlock(block))}, from.RemoteID())
+	return nil
+}
+
+// BroadcastBlock announces a locally created block to every peer as a compact block.
+func (n *Node) BroadcastBlock(block *blockchain.Block) {
+	n.Mempool.RemoveBlock(block)
+	n.broadcast(&Message{Type: MsgCompactBlock, Payload: encodePayload(NewCompactBlock(block))}, NodeID{})
+}
+
+// BroadcastTransaction gossips a transaction so peers hold it in their mempool
+// before the block including it is announced.
+func (n *Node) BroadcastTransaction(tx *blockchain.Transaction) {
+	n.broadcast(&Message{Type: MsgTx, Payload: tx.Serialize()}, NodeID{})
+}
+
+// broadcast sends a message to every peer except the one identified by skip.
+func (n *Node) broadcast(msg *Message, skip NodeID) {
+	n.mu.Lock()
+	conns := make([]Conn, 0, len(n.peers))
+	for id, conn := range n.peers {
+		if id != skip {
+			conns = append(conns, conn)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, conn := range conns {
+		if err := conn.Send(msg); err != nil {
+			log.Printf("p2p: error sending to %s: %v", conn.RemoteID(), err)
+		}
+	}
+}
+
+// Close disconnects every peer and stops listening.
+func (n *Node) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.closed = true
+	if n.listener != nil {
+		n.listener.Close()
+	}
+	for id, conn := range n.peers {
+		conn.Close()
+		delete(n.peers, id)
+	}
+}
@@ -0,0 +1,503 @@
+package p2p
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// SimEpoch is the wall-clock time at which every SimNetwork starts.
+var SimEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// maxIdleRun bounds RunUntilIdle so a scenario that never quiesces terminates.
+const maxIdleRun = 24 * time.Hour
+
+// ErrSimUnreachable is returned when dialing a node that is down, partitioned
+// away or not listening.
+var ErrSimUnreachable = errors.New("simulated node unreachable")
+
+// SimNetwork is an in-memory, deterministic network for testing nodes.
+// It implements Transport for any number of nodes and lets a test control
+// latency, message loss, partitions, crashed nodes and per-node clock skew.
+//
+// Time is virtual: messages are only delivered inside Run, in order of
+// delivery time, one at a time, and the simulator waits for the receiving
+// node to finish handling each message before delivering the next. Random
+// decisions (latency, loss) are drawn from per-link generators derived from
+// the seed, so a scenario replays identically for a given seed.
+type SimNetwork struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	seed int64
+	now  time.Duration // Virtual time elapsed since SimEpoch
+
+	minLatency time.Duration
+	maxLatency time.Duration
+	lossRate   float64
+	groups     map[NodeID]int // Partition group of each node; nil when the network is whole
+	down       map[NodeID]bool
+	skew       map[NodeID]time.Duration
+
+	listeners map[string]*simListener
+	conns     map[*simConn]bool
+	links     map[[2]NodeID]*simLink
+	queue     simQueue
+}
+
+// NewSimNetwork creates a fully connected network with 10ms latency and no loss.
+func NewSimNetwork(seed int64) *SimNetwork {
+	s := &SimNetwork{
+		seed:       seed,
+		minLatency: 10 * time.Millisecond,
+		maxLatency: 10 * time.Millisecond,
+		down:       make(map[NodeID]bool),
+		skew:       make(map[NodeID]time.Duration),
+		listeners:  make(map[string]*simListener),
+		conns:      make(map[*simConn]bool),
+		links:      make(map[[2]NodeID]*simLink),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Transport returns the transport used by the node owning key.
+func (s *SimNetwork) Transport(key *NodeKey) Transport {
+	return &simTransport{net: s, id: key.ID()}
+}
+
+// SetLatency makes every message take between min and max to be delivered.
+// Messages on the same link are still delivered in order.
+func (s *SimNetwork) SetLatency(min, max time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if max < min {
+		max = min
+	}
+	s.minLatency, s.maxLatency = min, max
+}
+
+// SetLossRate makes every message be dropped with probability rate.
+func (s *SimNetwork) SetLossRate(rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lossRate = rate
+}
+
+// Partition splits the network into groups that cannot reach each other.
+// Nodes not listed in any group are isolated. Messages in flight across
+// groups are dropped when they would have been delivered.
+func (s *SimNetwork) Partition(groups ...[]NodeID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.groups = make(map[NodeID]int)
+	for i, group := range groups {
+		for _, id := range group {
+			s.groups[id] = i
+		}
+	}
+}
+
+// Heal removes any partition.
+func (s *SimNetwork) Heal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.groups = nil
+}
+
+// Crash takes a node off the network: every message to or from it is
+// dropped until Recover is called. Its connections stay open, as they would
+// for a peer that stopped responding.
+func (s *SimNetwork) Crash(id NodeID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.down[id] = true
+}
+
+// Recover brings a crashed node back on the network.
+func (s *SimNetwork) Recover(id NodeID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.down, id)
+}
+
+// SetClockSkew shifts the clock returned by Clock for a node.
+func (s *SimNetwork) SetClockSkew(id NodeID, skew time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.skew[id] = skew
+}
+
+// Now returns the current virtual time.
+func (s *SimNetwork) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SimEpoch.Add(s.now)
+}
+
+// Clock returns the clock of a node: the virtual time plus the node's skew.
+// Assign it to Blockchain.Clock so block timestamps follow virtual time.
+func (s *SimNetwork) Clock(id NodeID) func() time.Time {
+	return func() time.Time {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return SimEpoch.Add(s.now + s.skew[id])
+	}
+}
+
+// Run delivers every message due within the next d of virtual time, then
+// advances the clock by d.
+func (s *SimNetwork) Run(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runUntil(s.now + d)
+	s.now += d
+}
+
+// RunUntilIdle delivers messages until none are left in flight.
+func (s *SimNetwork) RunUntilIdle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadline := s.now + maxIdleRun
+	s.runUntil(deadline)
+}
+
+// runUntil delivers messages due at or before deadline. The caller must hold the lock.
+func (s *SimNetwork) runUntil(deadline time.Duration) {
+	for {
+		s.waitIdle()
+		if len(s.queue) == 0 || s.queue[0].at > deadline {
+			return
+		}
+
+		ev := heap.Pop(&s.queue).(*simEvent)
+		s.now = ev.at
+		if ev.to.closed || !s.reachable(ev.from, ev.to.local) {
+			continue
+		}
+
+		ev.to.ready = false
+		ev.to.inbox <- ev.msg
+	}
+}
+
+// waitIdle blocks until every open connection is waiting in Receive, i.e.
+// every node has finished handling the messages delivered so far.
+// The caller must hold the lock.
+func (s *SimNetwork) waitIdle() {
+	for {
+		idle := true
+		for c := range s.conns {
+			if !c.ready {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			return
+		}
+		s.cond.Wait()
+	}
+}
+
+// reachable reports whether a message can currently travel from one node to
+// another. The caller must hold the lock.
+func (s *SimNetwork) reachable(from, to NodeID) bool {
+	if s.down[from] || s.down[to] {
+		return false
+	}
+	if s.groups == nil {
+		return true
+	}
+	fromGroup, ok1 := s.groups[from]
+	toGroup, ok2 := s.groups[to]
+	return ok1 && ok2 && fromGroup == toGroup
+}
+
+// link returns the state of the directed link between two nodes.
+// The caller must hold the lock.
+func (s *SimNetwork) link(from, to NodeID) *simLink {
+	key := [2]NodeID{from, to}
+	l, ok := s.links[key]
+	if !ok {
+		h := fnv.New64a()
+		binary.Write(h, binary.BigEndian, s.seed)
+		h.Write(from[:])
+		h.Write(to[:])
+		l = &simLink{rng: rand.New(rand.NewSource(int64(h.Sum64())))}
+		s.links[key] = l
+	}
+	return l
+}
+
+// enqueue schedules a message for delivery. The caller must hold the lock.
+func (s *SimNetwork) enqueue(from *simConn, msg *Message) {
+	l := s.link(from.local, from.remote)
+
+	// Always draw both values so a link's random sequence does not depend
+	// on the configuration
+	drop := l.rng.Float64() < s.lossRate
+	latency := s.minLatency
+	if span := s.maxLatency - s.minLatency; span > 0 {
+		latency += time.Duration(l.rng.Int63n(int64(span) + 1))
+	} else {
+		l.rng.Int63()
+	}
+	if drop {
+		return
+	}
+
+	// Deliver in order on each link, like a stream connection
+	at := s.now + latency
+	if at < l.last {
+		at = l.last
+	}
+	l.last = at
+	l.seq++
+
+	payload := append([]byte{}, msg.Payload...)
+	heap.Push(&s.queue, &simEvent{
+		at:   at,
+		from: from.local,
+		to:   from.peer,
+		seq:  l.seq,
+		msg:  &Message{Type: msg.Type, Payload: payload},
+	})
+}
+
+// ChainsConverged reports whether every chain has the same tip.
+func ChainsConverged(chains ...*blockchain.Blockchain) bool {
+	for _, chain := range chains[1:] {
+		if !bytes.Equal(chain.LastBlock().Hash, chains[0].LastBlock().Hash) {
+			return false
+		}
+	}
+	return true
+}
+
+// simLink holds the per-direction state of a link between two nodes.
+type simLink struct {
+	rng  *rand.Rand
+	last time.Duration // Delivery time of the last message, to keep links ordered
+	seq  uint64
+}
+
+// simEvent is a message in flight.
+type simEvent struct {
+	at   time.Duration
+	from NodeID
+	to   *simConn
+	seq  uint64
+	msg  *Message
+}
+
+// simQueue orders events by delivery time, breaking ties deterministically
+// by sender, receiver and per-link sequence number.
+type simQueue []*simEvent
+
+func (q simQueue) Len() int { return len(q) }
+
+func (q simQueue) Less(i, j int) bool {
+	a, b := q[i], q[j]
+	if a.at != b.at {
+		return a.at < b.at
+	}
+	if c := bytes.Compare(a.from[:], b.from[:]); c != 0 {
+		return c < 0
+	}
+	if c := bytes.Compare(a.to.local[:], b.to.local[:]); c != 0 {
+		return c < 0
+	}
+	return a.seq < b.seq
+}
+
+func (q simQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *simQueue) Push(x interface{}) { *q = append(*q, x.(*simEvent)) }
+
+func (q *simQueue) Pop() interface{} {
+	old := *q
+	ev := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ev
+}
+
+// simTransport is the Transport of a single node on a SimNetwork.
+// Node identities are taken from the keys given to SimNetwork.Transport,
+// so no handshake is performed.
+type simTransport struct {
+	net *SimNetwork
+	id  NodeID
+}
+
+// Listen registers the node under addr; any string can be used as an address.
+func (t *simTransport) Listen(addr string) (Listener, error) {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+
+	if _, ok := t.net.listeners[addr]; ok {
+		return nil, errors.New("simulated address already in use")
+	}
+	l := &simListener{net: t.net, id: t.id, addr: addr, accept: make(chan *simConn, 64), done: make(chan struct{})}
+	t.net.listeners[addr] = l
+	return l, nil
+}
+
+// Dial connects to the node listening on addr, which must own id.
+func (t *simTransport) Dial(addr string, id NodeID) (Conn, error) {
+	s := t.net
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.listeners[addr]
+	if !ok || !s.reachable(t.id, l.id) {
+		return nil, ErrSimUnreachable
+	}
+	if l.id != id {
+		return nil, ErrUnexpectedPeer
+	}
+
+	local := &simConn{net: s, local: t.id, remote: l.id, inbox: make(chan *Message, 1), done: make(chan struct{})}
+	remote := &simConn{net: s, local: l.id, remote: t.id, inbox: make(chan *Message, 1), done: make(chan struct{})}
+	local.peer, remote.peer = remote, local
+	s.conns[local] = true
+	s.conns[remote] = true
+
+	select {
+	case l.accept <- remote:
+	default:
+		local.closeLocked()
+		return nil, errors.New("simulated accept backlog full")
+	}
+	return local, nil
+}
+
+// simListener hands out the server side of simulated connections.
+type simListener struct {
+	net    *SimNetwork
+	id     NodeID
+	addr   string
+	accept chan *simConn
+	done   chan struct{}
+}
+
+func (l *simListener) Accept() (Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, io.EOF
+	}
+}
+
+func (l *simListener) Addr() string {
+	return l.addr
+}
+
+func (l *simListener) Close() error {
+	l.net.mu.Lock()
+	defer l.net.mu.Unlock()
+
+	if l.net.listeners[l.addr] == l {
+		delete(l.net.listeners, l.addr)
+		close(l.done)
+
+		// Connections never accepted would block the simulator forever
+		for {
+			select {
+			case c := <-l.accept:
+				c.closeLocked()
+			default:
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// simConn is one end of a simulated connection.
+type simConn struct {
+	net    *SimNetwork
+	local  NodeID
+	remote NodeID
+	peer   *simConn
+	inbox  chan *Message
+	done   chan struct{}
+	ready  bool // Whether the owner is blocked in Receive
+	closed bool
+}
+
+func (c *simConn) Send(msg *Message) error {
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	c.net.enqueue(c, msg)
+	return nil
+}
+
+func (c *simConn) Receive() (*Message, error) {
+	c.net.mu.Lock()
+	if c.closed {
+		c.net.mu.Unlock()
+		return nil, io.EOF
+	}
+	c.ready = true
+	c.net.cond.Broadcast()
+	c.net.mu.Unlock()
+
+	select {
+	case msg := <-c.inbox:
+		return msg, nil
+	case <-c.done:
+		return nil, io.EOF
+	}
+}
+
+func (c *simConn) RemoteID() NodeID {
+	return c.remote
+}
+
+func (c *simConn) RemoteAddr() string {
+	return "sim:" + c.remote.String()
+}
+
+func (c *simConn) Close() error {
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+
+	c.closeLocked()
+	return nil
+}
+
+// closeLocked closes both ends of the connection. The caller must hold the lock.
+func (c *simConn) closeLocked() {
+	for _, end := range []*simConn{c, c.peer} {
+		if !end.closed {
+			end.closed = true
+			close(end.done)
+			delete(c.net.conns, end)
+		}
+	}
+	c.net.cond.Broadcast()
+}
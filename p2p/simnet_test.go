@@ -0,0 +1,261 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// simSeeds are the seeds every scenario is replayed with.
+var simSeeds = []int64{1, 7, 42}
+
+// simPeer is a node of a simulated network with the validator it signs blocks with.
+type simPeer struct {
+	node      *Node
+	chain     *blockchain.Blockchain
+	validator *blockchain.Wallet
+}
+
+// mint adds a block minting amount to a wallet on top of the peer's chain
+// and announces it.
+func (p *simPeer) mint(t *testing.T, to *blockchain.Wallet, amount int) *blockchain.Block {
+	t.Helper()
+	tx := &blockchain.Transaction{
+		Output:     []blockchain.TxOutput{{Value: amount, PublicKey: to.PublicKey}},
+		MintHeight: p.chain.LastBlock().Height + 1,
+	}
+	tx.ID = tx.HashTransaction()
+	return p.addBlock(t, tx)
+}
+
+// addBlock adds a block with the given transactions on top of the peer's
+// chain and announces it.
+func (p *simPeer) addBlock(t *testing.T, txs ...*blockchain.Transaction) *blockchain.Block {
+	t.Helper()
+	block, err := p.chain.AddBlock(txs, p.validator)
+	if err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	p.node.BroadcastBlock(block)
+	return block
+}
+
+// newSimPeers creates n fully connected nodes on a simulated network. Every
+// chain trusts the validators of the first trusted nodes only.
+func newSimPeers(t *testing.T, net *SimNetwork, n int, trusted int) []*simPeer {
+	t.Helper()
+
+	peers := make([]*simPeer, n)
+	var validators [][]byte
+	for i := range peers {
+		seed := make([]byte, 32)
+		seed[0] = byte(i + 1)
+		key, err := NodeKeyFromSeed(seed)
+		if err != nil {
+			t.Fatalf("NodeKeyFromSeed: %v", err)
+		}
+
+		chain := blockchain.NewBlockchain(blockchain.NewGenesisBlock())
+		chain.Clock = net.Clock(key.ID())
+		node := NewNode(key, net.Transport(key), chain)
+		if err := node.Listen(fmt.Sprintf("node%d", i)); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		t.Cleanup(node.Close)
+
+		peers[i] = &simPeer{node: node, chain: chain, validator: blockchain.NewWallet()}
+		if i < trusted {
+			validators = append(validators, peers[i].validator.PublicKey)
+		}
+	}
+
+	for i, p := range peers {
+		p.chain.SetValidators(validators)
+		for _, other := range peers[:i] {
+			if err := p.node.Connect(other.node.ID().String() + "@" + other.node.Addr()); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+		}
+	}
+	net.RunUntilIdle()
+	return peers
+}
+
+// chains returns the chains of the given peers.
+func chains(peers ...*simPeer) []*blockchain.Blockchain {
+	var chains []*blockchain.Blockchain
+	for _, p := range peers {
+		chains = append(chains, p.chain)
+	}
+	return chains
+}
+
+// requireConverged fails the test unless every peer has the same tip at the given height.
+func requireConverged(t *testing.T, height int, peers ...*simPeer) {
+	t.Helper()
+	if !ChainsConverged(chains(peers...)...) {
+		for i, p := range peers {
+			tip := p.chain.LastBlock()
+			t.Logf("peer %d: height %d, tip %x", i, tip.Height, tip.Hash)
+		}
+		t.Fatal("chains did not converge")
+	}
+	if got := peers[0].chain.LastBlock().Height; got != height {
+		t.Fatalf("converged at height %d, want %d", got, height)
+	}
+}
+
+func TestSimPartitionHeals(t *testing.T) {
+	for _, seed := range simSeeds {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			net := NewSimNetwork(seed)
+			net.SetLatency(5*time.Millisecond, 50*time.Millisecond)
+			peers := newSimPeers(t, net, 3, 3)
+			alice, bob := blockchain.NewWallet(), blockchain.NewWallet()
+
+			peers[0].mint(t, alice, 100)
+			net.RunUntilIdle()
+			requireConverged(t, 1, peers...)
+
+			// The minority spends alice's coins on a fork that loses
+			net.Partition([]NodeID{peers[0].node.ID()}, []NodeID{peers[1].node.ID(), peers[2].node.ID()})
+			spend, err := blockchain.NewTransaction(alice, string(bob.PublicKey), 30, peers[0].chain.GetUTXOsForAddress(alice.PublicKey))
+			if err != nil {
+				t.Fatalf("NewTransaction: %v", err)
+			}
+			peers[0].addBlock(t, spend)
+			peers[0].mint(t, alice, 1)
+			for i := 0; i < 3; i++ {
+				peers[1+i%2].mint(t, bob, 5)
+				net.RunUntilIdle()
+			}
+			requireConverged(t, 4, peers[1], peers[2])
+			if ChainsConverged(chains(peers...)...) {
+				t.Fatal("partitioned chains converged")
+			}
+
+			net.Heal()
+			for _, p := range peers {
+				p.node.SyncWithPeers()
+			}
+			net.RunUntilIdle()
+			requireConverged(t, 4, peers...)
+
+			for i, p := range peers {
+				if got := p.chain.GetBalance(bob.PublicKey); got != 15 {
+					t.Fatalf("peer %d: bob's balance = %d, want 15", i, got)
+				}
+				if got := p.chain.GetBalance(alice.PublicKey); got != 100 {
+					t.Fatalf("peer %d: alice's balance = %d, want 100", i, got)
+				}
+			}
+			if peers[0].node.Mempool.Get(spend.ID) == nil {
+				t.Fatal("the orphaned transaction did not return to the mempool")
+			}
+		})
+	}
+}
+
+func TestSimValidatorCrash(t *testing.T) {
+	for _, seed := range simSeeds {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			net := NewSimNetwork(seed)
+			net.SetLatency(5*time.Millisecond, 50*time.Millisecond)
+			peers := newSimPeers(t, net, 3, 3)
+			alice := blockchain.NewWallet()
+
+			peers[2].mint(t, alice, 10)
+			net.RunUntilIdle()
+			requireConverged(t, 1, peers...)
+
+			// The crashed validator keeps producing blocks nobody hears about
+			net.Crash(peers[2].node.ID())
+			peers[2].mint(t, alice, 1000)
+			for i := 0; i < 4; i++ {
+				peers[i%2].mint(t, alice, 10)
+				net.Run(time.Second)
+			}
+			net.RunUntilIdle()
+			requireConverged(t, 5, peers[0], peers[1])
+
+			net.Recover(peers[2].node.ID())
+			peers[2].node.SyncWithPeers()
+			net.RunUntilIdle()
+			requireConverged(t, 5, peers...)
+
+			// The recovered validator produces on the common chain again
+			peers[2].mint(t, alice, 10)
+			net.RunUntilIdle()
+			requireConverged(t, 6, peers...)
+			for i, p := range peers {
+				if got := p.chain.GetBalance(alice.PublicKey); got != 60 {
+					t.Fatalf("peer %d: balance = %d, want 60", i, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSimLossyNetworkConverges(t *testing.T) {
+	for _, seed := range simSeeds {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			net := NewSimNetwork(seed)
+			net.SetLatency(5*time.Millisecond, 200*time.Millisecond)
+			peers := newSimPeers(t, net, 4, 4)
+			alice := blockchain.NewWallet()
+
+			net.SetLossRate(0.3)
+			for i := 0; i < 12; i++ {
+				peers[i%len(peers)].mint(t, alice, 1)
+				net.Run(100 * time.Millisecond)
+			}
+			net.RunUntilIdle()
+
+			net.SetLossRate(0)
+			for _, p := range peers {
+				p.node.SyncWithPeers()
+			}
+			net.RunUntilIdle()
+
+			if !ChainsConverged(chains(peers...)...) {
+				t.Fatal("chains did not converge after the loss stopped")
+			}
+			tip := peers[0].chain.LastBlock()
+			if tip.Height < 3 {
+				t.Fatalf("converged at height %d, want at least 3", tip.Height)
+			}
+		})
+	}
+}
+
+func TestSimUntrustedValidatorIgnored(t *testing.T) {
+	for _, seed := range simSeeds {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			net := NewSimNetwork(seed)
+			peers := newSimPeers(t, net, 4, 3)
+			honest, rogue := peers[:3], peers[3]
+			alice := blockchain.NewWallet()
+
+			honest[0].mint(t, alice, 10)
+			net.RunUntilIdle()
+			requireConverged(t, 1, peers...)
+
+			// A longer chain signed by an untrusted key mints coins for itself
+			rogue.chain.SetValidators(nil)
+			for i := 0; i < 5; i++ {
+				rogue.mint(t, rogue.validator, 1000)
+			}
+			rogue.node.SyncWithPeers()
+			net.RunUntilIdle()
+
+			requireConverged(t, 1, honest...)
+			for i, p := range honest {
+				if got := p.chain.GetBalance(rogue.validator.PublicKey); got != 0 {
+					t.Fatalf("peer %d: balance minted by the untrusted validator = %d", i, got)
+				}
+			}
+		})
+	}
+}
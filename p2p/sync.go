@@ -0,0 +1,139 @@
+package p2p
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// maxBlocksPerMessage caps the number of blocks sent in a single MsgBlocks.
+const maxBlocksPerMessage = 500
+
+// A peer answers a locator with the blocks after the most recent hash it
+// shares with us, which the locator's exponential spacing places at most
+// twice the fork depth below our tip. A full batch starting there always
+// ends above our tip, so every fork within blockchain.MaxReorgDepth that is
+// longer than our chain is adopted from its first batch. This fails to
+// compile if the constants break that.
+const _ uint = maxBlocksPerMessage - 2*blockchain.MaxReorgDepth - 1
+
+// Status describes a node's chain tip. Peers exchange it when they connect
+// so that the one behind can catch up.
+type Status struct {
+	Height  int
+	TipHash []byte
+}
+
+// BlocksRequest asks a peer for the blocks following the most recent
+// block of the locator it has.
+type BlocksRequest struct {
+	Locator [][]byte
+}
+
+// Blocks answers a BlocksRequest with consecutive blocks.
+type Blocks struct {
+	Blocks []*blockchain.Block
+}
+
+// status returns the local chain tip.
+func (n *Node) status() *Status {
+	tip := n.Chain.LastBlock()
+	return &Status{Height: tip.Height, TipHash: tip.Hash}
+}
+
+// sendStatus tells a peer about the local chain tip.
+func (n *Node) sendStatus(conn Conn) error {
+	return conn.Send(&Message{Type: MsgStatus, Payload: encodePayload(n.status())})
+}
+
+// SyncWithPeers announces the local chain tip to every peer, prompting any
+// peer with a worse chain to download ours and any peer with a better one
+// to announce itself back.
+func (n *Node) SyncWithPeers() {
+	n.broadcast(&Message{Type: MsgStatus, Payload: encodePayload(n.status())}, NodeID{})
+}
+
+// requestBlocks asks a peer for the blocks we are missing.
+func (n *Node) requestBlocks(conn Conn) error {
+	req := &BlocksRequest{Locator: n.Chain.Locator()}
+	return conn.Send(&Message{Type: MsgGetBlocks, Payload: encodePayload(req)})
+}
+
+// handleStatus compares a peer's tip with ours and downloads its chain if it is better.
+// If ours is better, we answer with our own status so the peer can catch up.
+func (n *Node) handleStatus(from Conn, msg *Message) error {
+	var status Status
+	if err := decodePayload(msg.Payload, &status); err != nil {
+		return fmt.Errorf("invalid status: %v", err)
+	}
+
+	local := n.status()
+	switch {
+	case bytes.Equal(status.TipHash, local.TipHash):
+		return nil
+	case blockchain.IsBetterTip(status.Height, status.TipHash, local.Height, local.TipHash):
+		return n.requestBlocks(from)
+	default:
+		return n.sendStatus(from)
+	}
+}
+
+// handleGetBlocks serves the blocks following a peer's locator.
+func (n *Node) handleGetBlocks(from Conn, msg *Message) error {
+	var req BlocksRequest
+	if err := decodePayload(msg.Payload, &req); err != nil {
+		return fmt.Errorf("invalid blocks request: %v", err)
+	}
+
+	resp := &Blocks{Blocks: n.Chain.BlocksAfter(req.Locator, maxBlocksPerMessage)}
+	return from.Send(&Message{Type: MsgBlocks, Payload: encodePayload(resp)})
+}
+
+// handleBlocks imports a batch of blocks, reorganizing onto the peer's fork
+// if it is better, and asks for more if the batch was full.
+// Transactions of disconnected blocks that the new chain does not include
+// go back to the mempool.
+func (n *Node) handleBlocks(from Conn, msg *Message) error {
+	var resp Blocks
+	if err := decodePayload(msg.Payload, &resp); err != nil {
+		return fmt.Errorf("invalid blocks: %v", err)
+	}
+	if len(resp.Blocks) == 0 {
+		return nil
+	}
+
+	imported, disconnected, err := n.Chain.ImportBlocks(resp.Blocks)
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		if n.OnDisconnect != nil {
+			n.OnDisconnect(disconnected[i])
+		}
+		for _, tx := range disconnected[i].Transactions {
+			// Token generation transactions are only valid at their block's height
+			if len(tx.Input) == 0 && tx.Contract == nil {
+				continue
+			}
+			n.Mempool.Add(tx)
+		}
+	}
+	for _, block := range imported {
+		n.Mempool.RemoveBlock(block)
+		if n.OnBlock != nil {
+			n.OnBlock(block)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("rejected blocks: %v", err)
+	}
+
+	if len(resp.Blocks) == maxBlocksPerMessage {
+		return n.requestBlocks(from)
+	}
+
+	// Let the rest of the network know about our new tip
+	if len(imported) > 0 {
+		tip := imported[len(imported)-1]
+		n.broadcast(&Message{Type: MsgCompactBlock, Payload: encodePayload(NewCompactBlock(tip))}, from.RemoteID())
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package p2p
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// MessageType identifies the kind of payload carried by a Message.
+type MessageType uint8
+
+// Message types exchanged between peers.
+const (
+	MsgBlock             MessageType = iota + 1 // Payload: a serialized full block
+	MsgTx                                       // Payload: a serialized transaction for the mempool
+	MsgCompactBlock                             // Payload: a CompactBlock announcing a new block
+	MsgGetBlockTxn                              // Payload: a BlockTxnRequest for missing transactions
+	MsgBlockTxn                                 // Payload: a BlockTxn answering MsgGetBlockTxn
+	MsgGetBlock                                 // Payload: the hash of a block requested in full
+	MsgGetFilters                               // Payload: a FiltersRequest for compact block filters
+	MsgFilters                                  // Payload: Filters answering MsgGetFilters
+	MsgGetFilteredBlocks                        // Payload: a FilteredBlocksRequest carrying a Bloom filter
+	MsgFilteredBlocks                           // Payload: FilteredBlocks answering MsgGetFilteredBlocks
+	MsgStatus                                   // Payload: the sender's chain Status
+	MsgGetBlocks                                // Payload: a BlocksRequest carrying a block locator
+	MsgBlocks                                   // Payload: Blocks answering MsgGetBlocks
+)
+
+// Message is a single unit of communication between two peers.
+type Message struct {
+	Type    MessageType // Kind of message
+	Payload []byte      // Type-specific encoded payload
+}
+
+// Conn is an authenticated connection to a single peer.
+// Implementations must allow Send to be called concurrently with Receive.
+type Conn interface {
+	Send(msg *Message) error
+	Receive() (*Message, error)
+	RemoteID() NodeID
+	RemoteAddr() string
+	Close() error
+}
+
+// Listener accepts authenticated inbound connections.
+type Listener interface {
+	Accept() (Conn, error)
+	Addr() string
+	Close() error
+}
+
+// Transport establishes authenticated connections between nodes.
+// Dial only succeeds if the remote side proves ownership of the pinned node ID.
+type Transport interface {
+	Listen(addr string) (Listener, error)
+	Dial(addr string, id NodeID) (Conn, error)
+}
+
+// TCPTransport is the production Transport: plain TCP sockets upgraded with
+// the encrypted handshake before any message is exchanged.
+type TCPTransport struct {
+	Key         *NodeKey      // Local identity key
+	DialTimeout time.Duration // Maximum time to establish a TCP connection
+}
+
+// NewTCPTransport creates a TCP transport authenticated with the given key.
+func NewTCPTransport(key *NodeKey) *TCPTransport {
+	return &TCPTransport{
+		Key:         key,
+		DialTimeout: 5 * time.Second,
+	}
+}
+
+// Dial connects to addr and authenticates the remote node against id.
+func (t *TCPTransport) Dial(addr string, id NodeID) (Conn, error) {
+	raw, err := net.DialTimeout("tcp", addr, t.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := Handshake(raw, t.Key, true, &id)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Listen starts accepting TCP connections on addr.
+func (t *TCPTransport) Listen(addr string) (Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &tcpListener{
+		ln:      ln,
+		key:     t.Key,
+		results: make(chan acceptResult),
+		slots:   make(chan struct{}, maxPendingHandshakes),
+		done:    make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+// maxPendingHandshakes bounds how many inbound handshakes run at once.
+// Sockets accepted beyond it are closed immediately.
+const maxPendingHandshakes = 64
+
+// tcpListener performs the responder side of the handshake on every accepted socket.
+// Each handshake runs in its own goroutine, so a peer that connects and stays
+// silent does not delay other inbound connections.
+type tcpListener struct {
+	ln      net.Listener
+	key     *NodeKey
+	results chan acceptResult // Authenticated connections and handshake failures
+	slots   chan struct{}     // One token per running handshake
+	done    chan struct{}     // Closed by Close
+
+	closeOnce sync.Once
+}
+
+// acceptResult is the outcome of one inbound handshake, or of the listener itself.
+type acceptResult struct {
+	conn Conn
+	err  error
+}
+
+// acceptLoop accepts raw sockets and hands each one to its own handshake goroutine.
+func (l *tcpListener) acceptLoop() {
+	for {
+		raw, err := l.ln.Accept()
+		if err != nil {
+			l.deliver(acceptResult{err: err})
+			return
+		}
+
+		select {
+		case l.slots <- struct{}{}:
+			go l.handshake(raw)
+		default:
+			raw.Close()
+		}
+	}
+}
+
+// handshake authenticates one inbound socket and delivers the result to Accept.
+func (l *tcpListener) handshake(raw net.Conn) {
+	defer func() { <-l.slots }()
+
+	conn, err := Handshake(raw, l.key, false, nil)
+	if err != nil {
+		raw.Close()
+		l.deliver(acceptResult{err: &HandshakeError{Addr: raw.RemoteAddr().String(), Err: err}})
+		return
+	}
+	if !l.deliver(acceptResult{conn: conn}) {
+		conn.Close()
+	}
+}
+
+// deliver passes a result to Accept. It reports false if the listener was closed first.
+func (l *tcpListener) deliver(res acceptResult) bool {
+	select {
+	case l.results <- res:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// Accept waits for the next authenticated connection.
+// Connections whose handshake fails are closed and reported as errors,
+// so the caller can keep accepting.
+func (l *tcpListener) Accept() (Conn, error) {
+	select {
+	case res := <-l.results:
+		return res.conn, res.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Addr returns the listening address.
+func (l *tcpListener) Addr() string {
+	return l.ln.Addr().String()
+}
+
+// Close stops listening. Handshakes still in progress are abandoned.
+func (l *tcpListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.ln.Close()
+}
+
+// HandshakeError reports an inbound connection that failed authentication.
+// It does not indicate a problem with the listener itself.
+type HandshakeError struct {
+	Addr string
+	Err  error
+}
+
+func (e *HandshakeError) Error() string {
+	return "handshake with " + e.Addr + " failed: " + e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
@@ -6,6 +6,7 @@ package storage
 import (
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/dgraph-io/badger"
 	"github.com/ignaciocorball/go-blockchain/blockchain"
@@ -13,11 +14,12 @@ import (
 
 // BlockchainDB wraps the Badger database instance and provides
 // blockchain-specific storage operations. It handles:
-//   - Block storage and retrieval
+//   - Block storage and retrieval, and reloading the chain (see LoadChain)
 //   - Transaction management
 //   - Database lifecycle
 type BlockchainDB struct {
-	DB *badger.DB // Badger database instance
+	DB    *badger.DB // Badger database instance
+	tipMu sync.Mutex // Serializes the tip updates of SaveBlock
 }
 
 // OpenDB initializes and opens a new Badger database instance.
@@ -49,6 +51,9 @@ func OpenDB(path string) *BlockchainDB {
 	return &BlockchainDB{DB: db}
 }
 
+// tipKey is the key holding the hash of the tip of the stored chain.
+const tipKey = "chain_tip"
+
 // SaveBlock stores a block in the database.
 // Parameters:
 //   - block: The block to be stored
@@ -57,12 +62,19 @@ func OpenDB(path string) *BlockchainDB {
 // 1. Starts a new transaction
 // 2. Serializes the block
 // 3. Stores it using the block's hash as the key
-// 4. Commits the transaction
+// 4. Moves the stored chain's tip to it if it is better (see blockchain.IsBetterTip)
+// 5. Commits the transaction
+//
+// Blocks saved out of order or during a reorganization therefore leave the
+// best of them as the tip that LoadChain starts from.
 //
 // Returns:
 //   - nil if storage is successful
 //   - error if storage fails
 func (bdb *BlockchainDB) SaveBlock(block *blockchain.Block) error {
+	bdb.tipMu.Lock()
+	defer bdb.tipMu.Unlock()
+
 	txn := bdb.DB.NewTransaction(true)
 	defer txn.Discard()
 
@@ -75,6 +87,17 @@ func (bdb *BlockchainDB) SaveBlock(block *blockchain.Block) error {
 		return fmt.Errorf("error saving block: %v", err)
 	}
 
+	// Move the tip if the block is better
+	tip, err := getTip(txn)
+	if err != nil {
+		return err
+	}
+	if tip == nil || blockchain.IsBetterTip(block.Height, block.Hash, tip.Height, tip.Hash) {
+		if err := txn.Set([]byte(tipKey), block.Hash); err != nil {
+			return fmt.Errorf("error saving chain tip: %v", err)
+		}
+	}
+
 	// Commit the transaction
 	err = txn.Commit()
 	if err != nil {
@@ -84,6 +107,65 @@ func (bdb *BlockchainDB) SaveBlock(block *blockchain.Block) error {
 	return nil
 }
 
+// LoadChain returns the stored chain, from the genesis block to the tip,
+// by following the tip back through the previous block hashes.
+// Returns no blocks if none were saved yet.
+func (bdb *BlockchainDB) LoadChain() ([]*blockchain.Block, error) {
+	var blocks []*blockchain.Block
+
+	err := bdb.DB.View(func(txn *badger.Txn) error {
+		block, err := getTip(txn)
+		for block != nil && err == nil {
+			blocks = append(blocks, block)
+			if block.Height == 0 {
+				break
+			}
+			block, err = getBlock(txn, block.PrevHash)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading chain: %v", err)
+	}
+
+	// Reverse into height order
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return blocks, nil
+}
+
+// getTip reads the tip of the stored chain within an open transaction.
+// Returns nil if no block was saved yet.
+func getTip(txn *badger.Txn) (*blockchain.Block, error) {
+	item, err := txn.Get([]byte(tipKey))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting chain tip: %v", err)
+	}
+	hash, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chain tip: %v", err)
+	}
+	return getBlock(txn, hash)
+}
+
+// getBlock reads a block within an open transaction.
+func getBlock(txn *badger.Txn, hash []byte) (*blockchain.Block, error) {
+	item, err := txn.Get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("error getting block %x: %v", hash, err)
+	}
+	var block *blockchain.Block
+	err = item.Value(func(val []byte) error {
+		block = blockchain.DeserializeBlock(val)
+		return nil
+	})
+	return block, err
+}
+
 // GetBlock retrieves a block from the database by its hash.
 // Parameters:
 //   - hash: The hash of the block to retrieve
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// openTestDB opens a database in a temporary directory.
+func openTestDB(t *testing.T, dir string) *BlockchainDB {
+	t.Helper()
+	db := OpenDB(dir)
+	t.Cleanup(db.CloseDB)
+	return db
+}
+
+// newTestChain creates a chain keeping its state tree in db, with the
+// genesis block saved.
+func newTestChain(t *testing.T, db *BlockchainDB) *blockchain.Blockchain {
+	t.Helper()
+	bc := blockchain.NewBlockchain(blockchain.NewGenesisBlock())
+	bc.SetStateStore(db.StateNodes())
+	if err := db.SaveBlock(bc.LastBlock()); err != nil {
+		t.Fatalf("SaveBlock: %v", err)
+	}
+	return bc
+}
+
+// addBlock adds a block with the given transactions to bc and saves it.
+func addBlock(t *testing.T, bc *blockchain.Blockchain, db *BlockchainDB, validator *blockchain.Wallet, txs ...*blockchain.Transaction) *blockchain.Block {
+	t.Helper()
+	block, err := bc.AddBlock(txs, validator)
+	if err != nil {
+		t.Fatalf("AddBlock: %v", err)
+	}
+	if err := db.SaveBlock(block); err != nil {
+		t.Fatalf("SaveBlock: %v", err)
+	}
+	return block
+}
+
+// mintTx creates a token generation transaction for the block at height.
+func mintTx(to *blockchain.Wallet, amount int, height int) *blockchain.Transaction {
+	tx := &blockchain.Transaction{
+		Output:     []blockchain.TxOutput{{Value: amount, PublicKey: to.PublicKey}},
+		MintHeight: height,
+	}
+	tx.ID = tx.HashTransaction()
+	return tx
+}
+
+func TestLoadChainRestoresState(t *testing.T) {
+	dir := t.TempDir()
+	validator := blockchain.NewWallet()
+	issuer := blockchain.NewWallet()
+
+	db := OpenDB(dir)
+	bc := newTestChain(t, db)
+	addBlock(t, bc, db, validator, mintTx(issuer, 10_000_000, 1))
+	txs, err := blockchain.NewCreateTokenTransactions(issuer, 0, "Test", "TST", 2, "", 1000, bc.GetUTXOsForAddress(issuer.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCreateTokenTransactions: %v", err)
+	}
+	addBlock(t, bc, db, validator, txs...)
+	tip := bc.LastBlock()
+	db.CloseDB()
+
+	// A restarted node replays the stored blocks
+	db = openTestDB(t, dir)
+	blocks, err := db.LoadChain()
+	if err != nil {
+		t.Fatalf("LoadChain: %v", err)
+	}
+	if len(blocks) != 3 || !bytes.Equal(blocks[2].Hash, tip.Hash) {
+		t.Fatalf("LoadChain returned %d blocks, want 3 ending at %x", len(blocks), tip.Hash)
+	}
+	restored := blockchain.NewBlockchain(blockchain.NewGenesisBlock())
+	restored.SetStateStore(db.StateNodes())
+	if _, _, err := restored.ImportBlocks(blocks[1:]); err != nil {
+		t.Fatalf("ImportBlocks: %v", err)
+	}
+
+	if !bytes.Equal(restored.LastBlock().StateRoot, tip.StateRoot) {
+		t.Fatalf("restored state root = %x, want %x", restored.LastBlock().StateRoot, tip.StateRoot)
+	}
+	tokens := restored.FungibleTokens()
+	if len(tokens) != 1 || tokens[0].TotalSupply != 1000 {
+		t.Fatalf("restored tokens = %+v, want one with a supply of 1000", tokens)
+	}
+	if got := restored.ContractNonce(issuer.Address); got != 2 {
+		t.Fatalf("restored nonce = %d, want 2", got)
+	}
+	if got, want := restored.GetBalance(validator.PublicKey), bc.GetBalance(validator.PublicKey); got != want || got == 0 {
+		t.Fatalf("restored fees of the validator = %d, want %d", got, want)
+	}
+}
+
+func TestSaveBlockKeepsBestTip(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	validator := blockchain.NewWallet()
+	alice, bob := blockchain.NewWallet(), blockchain.NewWallet()
+
+	local := newTestChain(t, db)
+	addBlock(t, local, db, validator, mintTx(alice, 1, 1))
+	addBlock(t, local, db, validator, mintTx(alice, 1, 2))
+
+	// A longer fork, saved from the tip down as a concurrent writer could
+	remote := blockchain.NewBlockchain(blockchain.NewGenesisBlock())
+	var fork []*blockchain.Block
+	for height := 1; height <= 3; height++ {
+		block, err := remote.AddBlock([]*blockchain.Transaction{mintTx(bob, 1, height)}, validator)
+		if err != nil {
+			t.Fatalf("AddBlock: %v", err)
+		}
+		fork = append(fork, block)
+	}
+	for i := len(fork) - 1; i >= 0; i-- {
+		if err := db.SaveBlock(fork[i]); err != nil {
+			t.Fatalf("SaveBlock: %v", err)
+		}
+	}
+
+	blocks, err := db.LoadChain()
+	if err != nil {
+		t.Fatalf("LoadChain: %v", err)
+	}
+	if len(blocks) != 4 {
+		t.Fatalf("LoadChain returned %d blocks, want 4", len(blocks))
+	}
+	for i, block := range blocks[1:] {
+		if !bytes.Equal(block.Hash, fork[i].Hash) {
+			t.Fatalf("block %d = %x, want the fork's %x", i+1, block.Hash, fork[i].Hash)
+		}
+	}
+}
+
+func TestPruneStateNodes(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	nodes := db.StateNodes()
+	if err := nodes.PutNodes(map[string][]byte{"live": []byte("a"), "stale": []byte("b")}); err != nil {
+		t.Fatalf("PutNodes: %v", err)
+	}
+
+	if err := nodes.PruneNodes(func(hash []byte) bool { return string(hash) == "live" }); err != nil {
+		t.Fatalf("PruneNodes: %v", err)
+	}
+	if _, ok, err := nodes.GetNode([]byte("live")); !ok || err != nil {
+		t.Fatalf("GetNode of a kept node = %v, %v", ok, err)
+	}
+	if _, ok, err := nodes.GetNode([]byte("stale")); ok || err != nil {
+		t.Fatalf("GetNode of a pruned node = %v, %v, want it gone", ok, err)
+	}
+}
@@ -0,0 +1,459 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// Key prefixes of the NFT indexes:
+//   - nft_token_<collection>_<token ID>: the token (see NFT)
+//   - nft_owner_<hex owner>_<collection>_<token ID>: empty, one per owned
+//     token; owners are hex encoded as they can be any string
+//   - nft_history_<collection>_<token ID>_<height>_<receipt index>_<log index>:
+//     a transfer of the token (see NFTTransfer); the receipt index is the
+//     position of the receipt in the block (see Blockchain.BlockReceipts)
+//   - nft_undo_<height>_<hex block hash>: the previous values of the keys
+//     indexing the block changed (see UndoBlockNFTs), kept for the last
+//     blockchain.MaxReorgDepth blocks
+//
+// Token IDs, heights and positions are zero-padded so that keys sort
+// numerically.
+const (
+	nftPrefix        = "nft_"
+	nftTokenPrefix   = "nft_token_"
+	nftOwnerPrefix   = "nft_owner_"
+	nftHistoryPrefix = "nft_history_"
+	nftUndoPrefix    = "nft_undo_"
+)
+
+// ErrNFTNotFound is returned when no token with an ID has been minted in a collection.
+var ErrNFTNotFound = errors.New("NFT not found")
+
+// NFT is a token of an NFT collection (see contracts.IsNFTCollection), as
+// indexed from the collection's events.
+type NFT struct {
+	Collection  string `json:"collection"`  // Address of the collection contract
+	TokenID     int64  `json:"tokenId"`     // ID of the token within its collection
+	Owner       string `json:"owner"`       // Current owner; empty if the token was burned
+	URI         string `json:"uri"`         // Metadata URI
+	ContentHash string `json:"contentHash"` // Hash of the content the metadata describes
+	MintHeight  int    `json:"mintHeight"`  // Height of the block that minted the token
+	Burned      bool   `json:"burned"`
+}
+
+// NFTTransfer is a transfer of an NFT. Mints have no sender and burns no recipient.
+type NFTTransfer struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	TxID        string `json:"txId"`
+	BlockHeight int    `json:"blockHeight"`
+}
+
+// GetNFT returns a token of an NFT collection.
+// Returns ErrNFTNotFound if the token was never minted.
+func (bdb *BlockchainDB) GetNFT(collection string, tokenID int64) (*NFT, error) {
+	var nft *NFT
+	err := bdb.DB.View(func(txn *badger.Txn) error {
+		var err error
+		nft, err = getNFT(txn, collection, tokenID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nft, nil
+}
+
+// GetNFTsByOwner returns the tokens an address owns in every NFT
+// collection, sorted by collection and token ID.
+func (bdb *BlockchainDB) GetNFTsByOwner(owner string) ([]*NFT, error) {
+	var nfts []*NFT
+	err := bdb.DB.View(func(txn *badger.Txn) error {
+		prefix := []byte(nftOwnerPrefix + hex.EncodeToString([]byte(owner)) + "_")
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			suffix := string(it.Item().Key()[len(prefix):])
+			sep := strings.LastIndex(suffix, "_")
+			if sep < 0 {
+				return fmt.Errorf("invalid NFT owner index key %q", it.Item().Key())
+			}
+			tokenID, err := strconv.ParseInt(suffix[sep+1:], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid NFT owner index key %q", it.Item().Key())
+			}
+			nft, err := getNFT(txn, suffix[:sep], tokenID)
+			if err != nil {
+				return err
+			}
+			nfts = append(nfts, nft)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nfts, nil
+}
+
+// GetNFTHistory returns the transfers of a token, oldest first, from its
+// mint to its burn.
+// Returns ErrNFTNotFound if the token was never minted.
+func (bdb *BlockchainDB) GetNFTHistory(collection string, tokenID int64) ([]NFTTransfer, error) {
+	var transfers []NFTTransfer
+	err := bdb.DB.View(func(txn *badger.Txn) error {
+		if _, err := getNFT(txn, collection, tokenID); err != nil {
+			return err
+		}
+
+		prefix := []byte(nftHistoryPrefix + nftKey(collection, tokenID) + "_")
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var transfer NFTTransfer
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &transfer)
+			})
+			if err != nil {
+				return fmt.Errorf("error reading NFT transfer: %v", err)
+			}
+			transfers = append(transfers, transfer)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// IndexBlockNFTs updates the NFT indexes with the Mint and Transfer events
+// that NFT collections emitted in a block's successful transactions, in a
+// single database transaction (see GetNFT). It must be called for every
+// block added to the chain, in order; blocks already indexed are skipped.
+func (bdb *BlockchainDB) IndexBlockNFTs(bc *blockchain.Blockchain, block *blockchain.Block) error {
+	return bdb.DB.Update(func(txn *badger.Txn) error {
+		return indexBlockNFTs(txn, bc, block)
+	})
+}
+
+// UndoBlockNFTs reverts the changes indexing a block made to the NFT
+// indexes. It must be called for every block a reorganization disconnects,
+// tip first. Blocks that were not indexed, or are deeper than
+// blockchain.MaxReorgDepth, are ignored.
+func (bdb *BlockchainDB) UndoBlockNFTs(block *blockchain.Block) error {
+	return bdb.DB.Update(func(txn *badger.Txn) error {
+		key := nftUndoKey(block)
+		item, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error getting NFT undo record: %v", err)
+		}
+
+		var undo []nftUndo
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &undo)
+		})
+		if err != nil {
+			return fmt.Errorf("error reading NFT undo record: %v", err)
+		}
+		for _, entry := range undo {
+			if entry.Existed {
+				err = txn.Set(entry.Key, entry.Value)
+			} else {
+				err = txn.Delete(entry.Key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return txn.Delete(key)
+	})
+}
+
+// RebuildNFTIndexes drops the NFT indexes and indexes every block of bc
+// again. A node calls it at startup, after replaying the stored chain, so
+// that the indexes hold no entries of blocks that left the chain while it
+// was not running.
+func (bdb *BlockchainDB) RebuildNFTIndexes(bc *blockchain.Blockchain) error {
+	// Delete in batches, as a single transaction could grow too big
+	for {
+		deleted := 0
+		err := bdb.DB.Update(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(nftPrefix)})
+			var keys [][]byte
+			for it.Rewind(); it.Valid() && len(keys) < 1000; it.Next() {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+			it.Close()
+
+			for _, key := range keys {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			deleted = len(keys)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error deleting NFT indexes: %v", err)
+		}
+		if deleted == 0 {
+			break
+		}
+	}
+
+	for _, block := range bc.AllBlocks() {
+		if err := bdb.IndexBlockNFTs(bc, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexBlockNFTs updates the NFT indexes within an open transaction, and
+// records how to undo the changes.
+func indexBlockNFTs(txn *badger.Txn, bc *blockchain.Blockchain, block *blockchain.Block) error {
+	undoKey := nftUndoKey(block)
+	if _, err := txn.Get(undoKey); err == nil {
+		return nil
+	} else if err != badger.ErrKeyNotFound {
+		return fmt.Errorf("error getting NFT undo record: %v", err)
+	}
+
+	collections := make(map[string]bool)
+	isCollection := func(address string) bool {
+		is, ok := collections[address]
+		if !ok {
+			contract, found := bc.GetContract(address)
+			is = found && contracts.IsNFTCollection(contract.ABI)
+			collections[address] = is
+		}
+		return is
+	}
+
+	batch := &nftBatch{txn: txn, seen: make(map[string]bool)}
+	for i, receipt := range bc.BlockReceipts(block) {
+		if !receipt.Succeeded() {
+			continue
+		}
+		for j, log := range receipt.Logs {
+			if !isCollection(log.Contract) || len(log.Args) != 3 {
+				continue
+			}
+			var err error
+			switch log.Event {
+			case "Mint":
+				err = indexNFTMint(batch, log, block.Height)
+			case "Transfer":
+				err = indexNFTTransfer(batch, log, NFTTransfer{
+					From:        string(log.Args[0].Bytes),
+					To:          string(log.Args[1].Bytes),
+					TxID:        receipt.TxID,
+					BlockHeight: block.Height,
+				}, fmt.Sprintf("%012d_%06d_%04d", block.Height, i, j))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := json.Marshal(batch.undo)
+	if err != nil {
+		return err
+	}
+	if err := txn.Set(undoKey, data); err != nil {
+		return err
+	}
+	return pruneNFTUndo(txn, block.Height-blockchain.MaxReorgDepth)
+}
+
+// pruneNFTUndo deletes the undo records of blocks below height, which no
+// reorganization can disconnect.
+func pruneNFTUndo(txn *badger.Txn, height int) error {
+	if height <= 0 {
+		return nil
+	}
+	limit := []byte(fmt.Sprintf("%s%012d", nftUndoPrefix, height))
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(nftUndoPrefix)})
+	var keys [][]byte
+	for it.Rewind(); it.Valid() && bytes.Compare(it.Item().Key(), limit) < 0; it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nftUndo is the value a key had before a block was indexed.
+type nftUndo struct {
+	Key     []byte `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Existed bool   `json:"existed"` // False if the key must be deleted
+}
+
+// nftBatch writes index keys within an open transaction, recording the
+// value each key had before its first change.
+type nftBatch struct {
+	txn  *badger.Txn
+	undo []nftUndo
+	seen map[string]bool
+}
+
+// set writes a key.
+func (b *nftBatch) set(key, value []byte) error {
+	if err := b.record(key); err != nil {
+		return err
+	}
+	return b.txn.Set(key, value)
+}
+
+// delete removes a key.
+func (b *nftBatch) delete(key []byte) error {
+	if err := b.record(key); err != nil {
+		return err
+	}
+	return b.txn.Delete(key)
+}
+
+// record saves the current value of a key the first time it is changed.
+func (b *nftBatch) record(key []byte) error {
+	if b.seen[string(key)] {
+		return nil
+	}
+	b.seen[string(key)] = true
+
+	item, err := b.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		b.undo = append(b.undo, nftUndo{Key: key})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	b.undo = append(b.undo, nftUndo{Key: key, Value: value, Existed: true})
+	return nil
+}
+
+// indexNFTMint records a token minted by a Mint event. Its owner is set by
+// the Transfer event that follows. Token IDs that were already minted are
+// ignored, as they cannot be minted twice.
+func indexNFTMint(batch *nftBatch, log contracts.Log, height int) error {
+	_, err := getNFT(batch.txn, log.Contract, log.Args[0].Int)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrNFTNotFound) {
+		return err
+	}
+	return putNFT(batch, &NFT{
+		Collection:  log.Contract,
+		TokenID:     log.Args[0].Int,
+		URI:         string(log.Args[1].Bytes),
+		ContentHash: string(log.Args[2].Bytes),
+		MintHeight:  height,
+	})
+}
+
+// indexNFTTransfer moves a token to the recipient of a Transfer event, or
+// marks it burned, and records the transfer in the token's history at
+// position. Transfers of tokens without a Mint event are ignored.
+func indexNFTTransfer(batch *nftBatch, log contracts.Log, transfer NFTTransfer, position string) error {
+	tokenID := log.Args[2].Int
+	nft, err := getNFT(batch.txn, log.Contract, tokenID)
+	if errors.Is(err, ErrNFTNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if nft.Owner != "" {
+		if err := batch.delete(nftOwnerKey(nft.Owner, nft.Collection, tokenID)); err != nil {
+			return err
+		}
+	}
+	nft.Owner = transfer.To
+	nft.Burned = transfer.To == ""
+	if !nft.Burned {
+		if err := batch.set(nftOwnerKey(nft.Owner, nft.Collection, tokenID), nil); err != nil {
+			return err
+		}
+	}
+	if err := putNFT(batch, nft); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+	return batch.set([]byte(nftHistoryPrefix+nftKey(nft.Collection, tokenID)+"_"+position), data)
+}
+
+// nftKey identifies a token in index keys.
+func nftKey(collection string, tokenID int64) string {
+	return fmt.Sprintf("%s_%020d", collection, tokenID)
+}
+
+// nftUndoKey is the key of a block's undo record.
+func nftUndoKey(block *blockchain.Block) []byte {
+	return []byte(fmt.Sprintf("%s%012d_%x", nftUndoPrefix, block.Height, block.Hash))
+}
+
+// nftOwnerKey is the owner index key of a token.
+func nftOwnerKey(owner string, collection string, tokenID int64) []byte {
+	return []byte(nftOwnerPrefix + hex.EncodeToString([]byte(owner)) + "_" + nftKey(collection, tokenID))
+}
+
+// getNFT reads a token within an open transaction.
+func getNFT(txn *badger.Txn, collection string, tokenID int64) (*NFT, error) {
+	item, err := txn.Get([]byte(nftTokenPrefix + nftKey(collection, tokenID)))
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return nil, ErrNFTNotFound
+		}
+		return nil, fmt.Errorf("error getting NFT: %v", err)
+	}
+
+	var nft NFT
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &nft)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading NFT data: %v", err)
+	}
+	return &nft, nil
+}
+
+// putNFT writes a token in a batch.
+func putNFT(batch *nftBatch, nft *NFT) error {
+	data, err := json.Marshal(nft)
+	if err != nil {
+		return fmt.Errorf("error serializing NFT: %v", err)
+	}
+	return batch.set([]byte(nftTokenPrefix+nftKey(nft.Collection, nft.TokenID)), data)
+}
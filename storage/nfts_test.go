@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// addIndexedBlock adds a block with the given transactions to bc, saves it
+// and indexes its NFTs.
+func addIndexedBlock(t *testing.T, bc *blockchain.Blockchain, db *BlockchainDB, validator *blockchain.Wallet, txs ...*blockchain.Transaction) *blockchain.Block {
+	t.Helper()
+	block := addBlock(t, bc, db, validator, txs...)
+	if err := db.IndexBlockNFTs(bc, block); err != nil {
+		t.Fatalf("IndexBlockNFTs: %v", err)
+	}
+	return block
+}
+
+// callTx creates a call of a contract method paid from the wallet's UTXOs.
+func callTx(t *testing.T, bc *blockchain.Blockchain, wallet *blockchain.Wallet, contract, method string, args ...contracts.Value) *blockchain.Transaction {
+	t.Helper()
+	tx, err := blockchain.NewCallTransaction(wallet, bc.ContractNonce(wallet.Address), contract, method, args, contracts.DefaultGasLimit, 0, bc.GetUTXOsForAddress(wallet.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCallTransaction: %v", err)
+	}
+	return tx
+}
+
+// checkOwner fails the test unless the token is owned by owner and has
+// the given number of transfers.
+func checkOwner(t *testing.T, db *BlockchainDB, collection string, tokenID int64, owner string, transfers int) {
+	t.Helper()
+	nft, err := db.GetNFT(collection, tokenID)
+	if err != nil {
+		t.Fatalf("GetNFT: %v", err)
+	}
+	history, err := db.GetNFTHistory(collection, tokenID)
+	if err != nil {
+		t.Fatalf("GetNFTHistory: %v", err)
+	}
+	owned, err := db.GetNFTsByOwner(owner)
+	if err != nil {
+		t.Fatalf("GetNFTsByOwner: %v", err)
+	}
+	if nft.Owner != owner || len(history) != transfers || len(owned) != 1 {
+		t.Fatalf("NFT owned by %q with %d transfers, want %q with %d; owner has %d NFTs", nft.Owner, len(history), owner, transfers, len(owned))
+	}
+}
+
+func TestNFTIndexesFollowTheChain(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	validator := blockchain.NewWallet()
+	issuer, bob := blockchain.NewWallet(), blockchain.NewWallet()
+
+	bc := newTestChain(t, db)
+	addIndexedBlock(t, bc, db, validator, mintTx(issuer, 10_000_000, 1))
+	nonce := bc.ContractNonce(issuer.Address)
+	txs, err := blockchain.NewCreateCollectionTransactions(issuer, nonce, "Art", "ART", bc.GetUTXOsForAddress(issuer.PublicKey))
+	if err != nil {
+		t.Fatalf("NewCreateCollectionTransactions: %v", err)
+	}
+	addIndexedBlock(t, bc, db, validator, txs...)
+	collection := blockchain.ContractAddress(issuer.Address, nonce)
+
+	mint := addIndexedBlock(t, bc, db, validator, callTx(t, bc, issuer, collection, "mint",
+		contracts.BytesValue([]byte(issuer.Address)), contracts.BytesValue([]byte("ipfs://art")), contracts.BytesValue([]byte("hash"))))
+	owned, err := db.GetNFTsByOwner(issuer.Address)
+	if err != nil || len(owned) != 1 {
+		t.Fatalf("GetNFTsByOwner = %v, %v, want the minted NFT", owned, err)
+	}
+	tokenID := owned[0].TokenID
+	transfer := addIndexedBlock(t, bc, db, validator, callTx(t, bc, issuer, collection, "transfer",
+		contracts.BytesValue([]byte(bob.Address)), contracts.IntValue(tokenID)))
+	checkOwner(t, db, collection, tokenID, bob.Address, 2)
+
+	// Indexing a block twice changes nothing
+	if err := db.IndexBlockNFTs(bc, transfer); err != nil {
+		t.Fatalf("IndexBlockNFTs: %v", err)
+	}
+	checkOwner(t, db, collection, tokenID, bob.Address, 2)
+
+	// Disconnected blocks are undone, tip first
+	if err := db.UndoBlockNFTs(transfer); err != nil {
+		t.Fatalf("UndoBlockNFTs: %v", err)
+	}
+	checkOwner(t, db, collection, tokenID, issuer.Address, 1)
+	if owned, _ := db.GetNFTsByOwner(bob.Address); len(owned) != 0 {
+		t.Fatalf("bob owns %d NFTs after the undo, want 0", len(owned))
+	}
+	if err := db.UndoBlockNFTs(mint); err != nil {
+		t.Fatalf("UndoBlockNFTs: %v", err)
+	}
+	if _, err := db.GetNFT(collection, tokenID); !errors.Is(err, ErrNFTNotFound) {
+		t.Fatalf("GetNFT after undoing the mint = %v, want %v", err, ErrNFTNotFound)
+	}
+
+	// Rebuilding indexes the blocks still in the chain
+	if err := db.RebuildNFTIndexes(bc); err != nil {
+		t.Fatalf("RebuildNFTIndexes: %v", err)
+	}
+	checkOwner(t, db, collection, tokenID, bob.Address, 2)
+}
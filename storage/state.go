@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	"github.com/ignaciocorball/go-blockchain/blockchain"
+)
+
+// stateNodePrefix is the key prefix of contract state tree nodes.
+const stateNodePrefix = "state_node_"
+
+// stateNodes keeps the nodes of the contract state tree in the database.
+type stateNodes struct {
+	db *badger.DB
+}
+
+// StateNodes returns a node store that keeps the contract state tree in the
+// database (see blockchain.Blockchain.SetStateStore).
+func (bdb *BlockchainDB) StateNodes() blockchain.NodeStore {
+	return &stateNodes{db: bdb.DB}
+}
+
+// GetNode implements blockchain.NodeStore.
+func (s *stateNodes) GetNode(hash []byte) ([]byte, bool, error) {
+	var data []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append([]byte(stateNodePrefix), hash...))
+		if err != nil {
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting state node: %v", err)
+	}
+	return data, true, nil
+}
+
+// PutNodes implements blockchain.NodeStore. Nodes are written in batches, as
+// a block can create more than fit in a single transaction.
+func (s *stateNodes) PutNodes(nodes map[string][]byte) error {
+	batch := s.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	for hash, data := range nodes {
+		if err := batch.Set(append([]byte(stateNodePrefix), hash...), data); err != nil {
+			return fmt.Errorf("error saving state node: %v", err)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return fmt.Errorf("error saving state nodes: %v", err)
+	}
+	return nil
+}
+
+// PruneNodes implements blockchain.NodeStore. The value log is then
+// garbage collected, so that the space of the removed nodes is reclaimed.
+func (s *stateNodes) PruneNodes(keep func(hash []byte) bool) error {
+	var pruned [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(stateNodePrefix)})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if key := it.Item().KeyCopy(nil); !keep(key[len(stateNodePrefix):]) {
+				pruned = append(pruned, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error listing state nodes: %v", err)
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	batch := s.db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range pruned {
+		if err := batch.Delete(key); err != nil {
+			return fmt.Errorf("error pruning state node: %v", err)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return fmt.Errorf("error pruning state nodes: %v", err)
+	}
+	for s.db.RunValueLogGC(0.5) == nil {
+	}
+	return nil
+}
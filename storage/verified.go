@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/ignaciocorball/go-blockchain/contracts"
+)
+
+// verifiedPrefix is the key prefix of verified contract sources:
+// verified_<contract>_<code hash>. Sources are kept per code, so that the
+// source of each version of an upgraded contract stays available.
+const verifiedPrefix = "verified_"
+
+// ErrSourceNotVerified is returned when no source has been verified for a
+// contract's code.
+var ErrSourceNotVerified = errors.New("contract source not verified")
+
+// VerifiedSource is contract source that this node recompiled to a
+// contract's code (see contracts.SmartContract.VerifySource), with the ABI
+// it compiles to and metadata submitted with it, e.g. a license.
+type VerifiedSource struct {
+	Contract        string            `json:"contract"`           // Address of the contract
+	Version         int               `json:"version"`            // Contract version the source was verified against
+	CodeHash        string            `json:"codeHash"`           // Hash of the verified code (see contracts.CodeHash)
+	Name            string            `json:"name"`               // Contract name from the source
+	Source          string            `json:"source"`             // Contract language source
+	CompilerVersion string            `json:"compilerVersion"`    // Compiler version the source was compiled with
+	ABI             *contracts.ABI    `json:"abi"`                // ABI the source compiles to
+	Metadata        map[string]string `json:"metadata,omitempty"` // Metadata submitted with the source
+	VerifiedAt      time.Time         `json:"verifiedAt"`
+}
+
+// SaveVerifiedSource stores verified source, replacing any source
+// previously verified for the same code.
+func (bdb *BlockchainDB) SaveVerifiedSource(verified *VerifiedSource) error {
+	data, err := json.Marshal(verified)
+	if err != nil {
+		return fmt.Errorf("error serializing verified source: %v", err)
+	}
+
+	return bdb.DB.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(verifiedPrefix+verified.Contract+"_"+verified.CodeHash), data)
+	})
+}
+
+// GetVerifiedSource returns the source verified for a code of a contract.
+// Returns ErrSourceNotVerified if none was.
+func (bdb *BlockchainDB) GetVerifiedSource(contract, codeHash string) (*VerifiedSource, error) {
+	var verified VerifiedSource
+	err := bdb.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(verifiedPrefix + contract + "_" + codeHash))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrSourceNotVerified
+			}
+			return fmt.Errorf("error getting verified source: %v", err)
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &verified)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &verified, nil
+}